@@ -3,12 +3,16 @@ package dnsserver
 import (
 	"context"
 	"crypto/tls"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sync"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/pb"
+	"github.com/coredns/coredns/plugin/pkg/log"
 	"github.com/coredns/coredns/plugin/pkg/reuseport"
 	"github.com/coredns/coredns/plugin/pkg/transport"
 
@@ -16,6 +20,8 @@ import (
 	"github.com/miekg/dns"
 	"github.com/opentracing/opentracing-go"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/peer"
 )
 
@@ -23,6 +29,7 @@ import (
 type ServergRPC struct {
 	*Server
 	grpcServer *grpc.Server
+	health     *health.Server
 	listenAddr net.Addr
 	tlsConfig  *tls.Config
 }
@@ -58,13 +65,19 @@ func (s *ServergRPC) Serve(l net.Listener) error {
 			return parentSpanCtx != nil
 		}
 		intercept := otgrpc.OpenTracingServerInterceptor(s.Tracer(), otgrpc.IncludingSpans(onlyIfParent))
-		s.grpcServer = grpc.NewServer(grpc.UnaryInterceptor(intercept))
+		streamIntercept := otgrpc.OpenTracingStreamServerInterceptor(s.Tracer(), otgrpc.IncludingSpans(onlyIfParent))
+		s.grpcServer = grpc.NewServer(grpc.UnaryInterceptor(intercept), grpc.StreamInterceptor(streamIntercept))
 	} else {
 		s.grpcServer = grpc.NewServer()
 	}
 
 	pb.RegisterDnsServiceServer(s.grpcServer, s)
 
+	// Serve the standard health checking protocol, so load balancers can check us.
+	s.health = health.NewServer()
+	s.health.SetServingStatus(dnsServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s.grpcServer, s.health)
+
 	if s.tlsConfig != nil {
 		l = tls.NewListener(l, s.tlsConfig)
 	}
@@ -105,6 +118,9 @@ func (s *ServergRPC) OnStartupComplete() {
 func (s *ServergRPC) Stop() (err error) {
 	s.m.Lock()
 	defer s.m.Unlock()
+	if s.health != nil {
+		s.health.Shutdown()
+	}
 	if s.grpcServer != nil {
 		s.grpcServer.GracefulStop()
 	}
@@ -115,6 +131,56 @@ func (s *ServergRPC) Stop() (err error) {
 // any normal server. We use a custom responseWriter to pick up the bytes we need to write
 // back to the client as a protobuf.
 func (s *ServergRPC) Query(ctx context.Context, in *pb.DnsPacket) (*pb.DnsPacket, error) {
+	return s.serve(ctx, in)
+}
+
+// Stream is the streaming entry-point into the gRPC server. Many queries can be sent on one stream,
+// they are served concurrently and each reply is sent as soon as it is ready. Replies may therefore
+// be out-of-order, the client matches them with the queries by message ID.
+func (s *ServergRPC) Stream(stream pb.DnsService_StreamServer) error {
+	ctx := stream.Context()
+
+	var (
+		mu sync.Mutex // serializes Send
+		wg sync.WaitGroup
+	)
+	defer wg.Wait()
+	sem := make(chan struct{}, maxStreamInflight)
+
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+
+			out, err := s.serve(ctx, in)
+			if err != nil {
+				// Don't leave the client waiting for this query, tell it we failed.
+				log.Debugf("Failed to serve query on gRPC stream: %s", err)
+				if out = servfail(in); out == nil {
+					return
+				}
+			}
+			mu.Lock()
+			err = stream.Send(out)
+			mu.Unlock()
+			if err != nil {
+				log.Debugf("Failed to send reply on gRPC stream: %s", err)
+			}
+		}()
+	}
+}
+
+// serve unpacks in, runs it through the plugin chain and returns the packed reply.
+func (s *ServergRPC) serve(ctx context.Context, in *pb.DnsPacket) (*pb.DnsPacket, error) {
 	msg := new(dns.Msg)
 	err := msg.Unpack(in.Msg)
 	if err != nil {
@@ -145,8 +211,29 @@ func (s *ServergRPC) Query(ctx context.Context, in *pb.DnsPacket) (*pb.DnsPacket
 	return &pb.DnsPacket{Msg: packed}, nil
 }
 
+// servfail returns a packed SERVFAIL reply to in, or nil if in is too short to hold a message ID.
+func servfail(in *pb.DnsPacket) *pb.DnsPacket {
+	if len(in.Msg) < 2 {
+		return nil
+	}
+	req := new(dns.Msg)
+	if err := req.Unpack(in.Msg); err != nil {
+		req = &dns.Msg{MsgHdr: dns.MsgHdr{Id: binary.BigEndian.Uint16(in.Msg)}}
+	}
+	m := new(dns.Msg)
+	m.SetRcode(req, dns.RcodeServerFailure)
+	packed, err := m.Pack()
+	if err != nil {
+		return nil
+	}
+	return &pb.DnsPacket{Msg: packed}
+}
+
 // Shutdown stops the server (non gracefully).
 func (s *ServergRPC) Shutdown() error {
+	if s.health != nil {
+		s.health.Shutdown()
+	}
 	if s.grpcServer != nil {
 		s.grpcServer.Stop()
 	}
@@ -175,3 +262,10 @@ func (r *gRPCresponse) Hijack()                   {}
 func (r *gRPCresponse) LocalAddr() net.Addr       { return r.localAddr }
 func (r *gRPCresponse) RemoteAddr() net.Addr      { return r.remoteAddr }
 func (r *gRPCresponse) WriteMsg(m *dns.Msg) error { r.Msg = m; return nil }
+
+const (
+	// dnsServiceName is the name of the DNS service in the gRPC health checking protocol.
+	dnsServiceName = "coredns.dns.DnsService"
+	// maxStreamInflight is the maximum number of queries served concurrently per stream.
+	maxStreamInflight = 256
+)
@@ -31,10 +31,14 @@ var Directives = []string{
 	"local",
 	"dns64",
 	"acl",
+	"rpz",
+	"rebind",
+	"tunnel",
 	"any",
 	"chaos",
 	"loadbalance",
 	"cache",
+	"nxguard",
 	"rewrite",
 	"header",
 	"dnssec",
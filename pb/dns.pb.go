@@ -72,15 +72,16 @@ func init() {
 func init() { proto.RegisterFile("dns.proto", fileDescriptor_638ff8d8aaf3d8ae) }
 
 var fileDescriptor_638ff8d8aaf3d8ae = []byte{
-	// 120 bytes of a gzipped FileDescriptorProto
+	// 135 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x4c, 0xc9, 0x2b, 0xd6,
 	0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0x4e, 0xce, 0x2f, 0x4a, 0x05, 0x71, 0x53, 0xf2, 0x8a,
 	0x95, 0x64, 0xb9, 0x38, 0x5d, 0xf2, 0x8a, 0x03, 0x12, 0x93, 0xb3, 0x53, 0x4b, 0x84, 0x04, 0xb8,
-	0x98, 0x73, 0x8b, 0xd3, 0x25, 0x18, 0x15, 0x18, 0x35, 0x78, 0x82, 0x40, 0x4c, 0x23, 0x57, 0x2e,
-	0x2e, 0x97, 0xbc, 0xe2, 0xe0, 0xd4, 0xa2, 0xb2, 0xcc, 0xe4, 0x54, 0x21, 0x73, 0x2e, 0xd6, 0xc0,
-	0xd2, 0xd4, 0xa2, 0x4a, 0x21, 0x31, 0x3d, 0x24, 0x33, 0xf4, 0xe0, 0x06, 0x48, 0xe1, 0x10, 0x77,
-	0x62, 0x89, 0x62, 0x2a, 0x48, 0x4a, 0x62, 0x03, 0xdb, 0x6f, 0x0c, 0x08, 0x00, 0x00, 0xff, 0xff,
-	0xf5, 0xd1, 0x3f, 0x26, 0x8c, 0x00, 0x00, 0x00,
+	0x98, 0x73, 0x8b, 0xd3, 0x25, 0x18, 0x15, 0x18, 0x35, 0x78, 0x82, 0x40, 0x4c, 0xa3, 0x66, 0x46,
+	0x2e, 0x2e, 0x97, 0xbc, 0xe2, 0xe0, 0xd4, 0xa2, 0xb2, 0xcc, 0xe4, 0x54, 0x21, 0x73, 0x2e, 0xd6,
+	0xc0, 0xd2, 0xd4, 0xa2, 0x4a, 0x21, 0x31, 0x3d, 0x24, 0x43, 0xf4, 0xe0, 0x26, 0x48, 0xe1, 0x10,
+	0x17, 0xb2, 0xe1, 0x62, 0x0b, 0x2e, 0x29, 0x4a, 0x4d, 0xcc, 0x25, 0x55, 0xa7, 0x06, 0xa3, 0x01,
+	0xa3, 0x13, 0x4b, 0x14, 0x53, 0x41, 0x52, 0x12, 0x1b, 0xd8, 0xf9, 0xc6, 0x80, 0x01, 0x00, 0x5f,
+	0x17, 0x40, 0xe3, 0xcb, 0x00, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -96,6 +97,7 @@ const _ = grpc.SupportPackageIsVersion4
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
 type DnsServiceClient interface {
 	Query(ctx context.Context, in *DnsPacket, opts ...grpc.CallOption) (*DnsPacket, error)
+	Stream(ctx context.Context, opts ...grpc.CallOption) (DnsService_StreamClient, error)
 }
 
 type dnsServiceClient struct {
@@ -115,9 +117,41 @@ func (c *dnsServiceClient) Query(ctx context.Context, in *DnsPacket, opts ...grp
 	return out, nil
 }
 
+func (c *dnsServiceClient) Stream(ctx context.Context, opts ...grpc.CallOption) (DnsService_StreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_DnsService_serviceDesc.Streams[0], "/coredns.dns.DnsService/Stream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &dnsServiceStreamClient{stream}
+	return x, nil
+}
+
+type DnsService_StreamClient interface {
+	Send(*DnsPacket) error
+	Recv() (*DnsPacket, error)
+	grpc.ClientStream
+}
+
+type dnsServiceStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *dnsServiceStreamClient) Send(m *DnsPacket) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *dnsServiceStreamClient) Recv() (*DnsPacket, error) {
+	m := new(DnsPacket)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // DnsServiceServer is the server API for DnsService service.
 type DnsServiceServer interface {
 	Query(context.Context, *DnsPacket) (*DnsPacket, error)
+	Stream(DnsService_StreamServer) error
 }
 
 func RegisterDnsServiceServer(s *grpc.Server, srv DnsServiceServer) {
@@ -142,6 +176,32 @@ func _DnsService_Query_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DnsService_Stream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(DnsServiceServer).Stream(&dnsServiceStreamServer{stream})
+}
+
+type DnsService_StreamServer interface {
+	Send(*DnsPacket) error
+	Recv() (*DnsPacket, error)
+	grpc.ServerStream
+}
+
+type dnsServiceStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *dnsServiceStreamServer) Send(m *DnsPacket) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *dnsServiceStreamServer) Recv() (*DnsPacket, error) {
+	m := new(DnsPacket)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 var _DnsService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "coredns.dns.DnsService",
 	HandlerType: (*DnsServiceServer)(nil),
@@ -151,6 +211,13 @@ var _DnsService_serviceDesc = grpc.ServiceDesc{
 			Handler:    _DnsService_Query_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Stream",
+			Handler:       _DnsService_Stream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
 	Metadata: "dns.proto",
 }
@@ -3,6 +3,7 @@ package acl
 import (
 	"context"
 	"net"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/metrics"
@@ -17,6 +18,9 @@ type ACL struct {
 	Next plugin.Handler
 
 	Rules []rule
+
+	lists  []*list // all the lists of the policies
+	reload time.Duration
 }
 
 // rule defines a list of Zones and some ACL policies which will be
@@ -31,11 +35,16 @@ type action int
 
 // policy defines the ACL policy for DNS queries.
 // A policy performs the specified action (block/allow) on all DNS queries
-// matched by source IP or QTYPE.
+// matched by source IP, QTYPE, the lists of domains, and its conditions.
 type policy struct {
 	action action
 	qtypes map[uint16]struct{}
 	filter *iptree.Tree
+	lists  []*list
+	conds  []condition
+
+	// negations of the type, net and list sections.
+	notType, notNet, notList bool
 }
 
 const (
@@ -47,6 +56,8 @@ const (
 	actionBlock
 	// actionFilter returns empty sets for queries towards protected DNS zones.
 	actionFilter
+	// actionDrop doesn't reply to queries towards protected DNS zones.
+	actionDrop
 )
 
 // ServeDNS implements the plugin.Handler interface.
@@ -61,7 +72,10 @@ RulesCheckLoop:
 			continue
 		}
 
-		action := matchWithPolicies(rule.policies, w, r)
+		action, l := matchWithPolicies(ctx, rule.policies, w, state.Name(), state.QType())
+		if l != nil {
+			ListHitCount.WithLabelValues(metrics.WithServer(ctx), l.path).Inc()
+		}
 		switch action {
 		case actionBlock:
 			{
@@ -83,6 +97,11 @@ RulesCheckLoop:
 				RequestFilterCount.WithLabelValues(metrics.WithServer(ctx), zone).Inc()
 				return dns.RcodeSuccess, nil
 			}
+		case actionDrop:
+			{
+				RequestDropCount.WithLabelValues(metrics.WithServer(ctx), zone).Inc()
+				return dns.RcodeSuccess, nil
+			}
 		}
 
 	}
@@ -92,29 +111,87 @@ RulesCheckLoop:
 }
 
 // matchWithPolicies matches the DNS query with a list of ACL polices and returns suitable
-// action against the query.
-func matchWithPolicies(policies []policy, w dns.ResponseWriter, r *dns.Msg) action {
-	state := request.Request{W: w, Req: r}
-
-	ip := net.ParseIP(state.IP())
-	qtype := state.QType()
+// action against the query, and the list that matched the query name, if any. Allow policies
+// with lists take precedence over the other policies.
+func matchWithPolicies(ctx context.Context, policies []policy, w dns.ResponseWriter, qname string, qtype uint16) (action, *list) {
+	ip := remoteIP(w)
 	for _, policy := range policies {
-		// dns.TypeNone matches all query types.
-		_, matchAll := policy.qtypes[dns.TypeNone]
-		_, match := policy.qtypes[qtype]
-		if !matchAll && !match {
+		if policy.action != actionAllow || len(policy.lists) == 0 {
 			continue
 		}
-
-		_, contained := policy.filter.GetByIP(ip)
-		if !contained {
+		if l, ok := policy.match(ctx, ip, qtype, qname); ok {
+			return policy.action, l
+		}
+	}
+	for _, policy := range policies {
+		if policy.action == actionAllow && len(policy.lists) > 0 {
 			continue
 		}
+		if l, ok := policy.match(ctx, ip, qtype, qname); ok {
+			return policy.action, l
+		}
+	}
+	return actionNone, nil
+}
+
+// match returns true if the query from ip for qname and qtype matches p, and the list that matched qname.
+func (p policy) match(ctx context.Context, ip net.IP, qtype uint16, qname string) (*list, bool) {
+	// dns.TypeNone matches all query types.
+	_, matchAll := p.qtypes[dns.TypeNone]
+	_, match := p.qtypes[qtype]
+	if (matchAll || match) == p.notType {
+		return nil, false
+	}
+
+	if contains(p.filter, ip) == p.notNet {
+		return nil, false
+	}
+
+	for _, c := range p.conds {
+		if !c.match(ctx, qname) {
+			return nil, false
+		}
+	}
+
+	if len(p.lists) == 0 {
+		return nil, true
+	}
+	for _, l := range p.lists {
+		if l.match(qname) {
+			if p.notList {
+				return nil, false
+			}
+			return l, true
+		}
+	}
+	return nil, p.notList
+}
+
+// contains returns true if ip is in one of the networks in t. Unlike t.GetByIP it doesn't allocate.
+func contains(t *iptree.Tree, ip net.IP) bool {
+	n := net.IPNet{IP: ip.To4(), Mask: ipv4Mask}
+	if n.IP == nil {
+		n.IP, n.Mask = ip.To16(), ipv6Mask
+	}
+	_, ok := t.GetByNet(&n)
+	return ok
+}
+
+var (
+	ipv4Mask = net.CIDRMask(32, 32)
+	ipv6Mask = net.CIDRMask(128, 128)
+)
 
-		// matched.
-		return policy.action
+// remoteIP returns the IP address of the client, without allocating for UDP and TCP clients.
+func remoteIP(w dns.ResponseWriter) net.IP {
+	switch addr := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
 	}
-	return actionNone
+	state := request.Request{W: w}
+	return net.ParseIP(state.IP())
 }
 
 // Name implements the plugin.Handler interface.
@@ -0,0 +1,69 @@
+package acl
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/coredns/coredns/plugin/metadata"
+)
+
+// condition is a condition on a DNS query, other than its type and source IP, that a policy can match on.
+// Conditions are evaluated for every query, so match must not allocate.
+type condition interface {
+	match(ctx context.Context, qname string) bool
+}
+
+// nameCondition matches query names in, or below, one of its fully qualified, lower cased names.
+type nameCondition []string
+
+func (c nameCondition) match(_ context.Context, qname string) bool {
+	for _, n := range c {
+		if n == "." || qname == n {
+			return true
+		}
+		if len(qname) > len(n) && qname[len(qname)-len(n)-1] == '.' && strings.HasSuffix(qname, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// regexCondition matches query names that match one of its regular expressions.
+type regexCondition []*regexp.Regexp
+
+func (c regexCondition) match(_ context.Context, qname string) bool {
+	for _, re := range c {
+		if re.MatchString(qname) {
+			return true
+		}
+	}
+	return false
+}
+
+// metaCondition matches queries that have one of its metadata values.
+type metaCondition []metaValue
+
+// metaValue is a value of a metadata label.
+type metaValue struct {
+	label string
+	value string
+}
+
+func (c metaCondition) match(ctx context.Context, _ string) bool {
+	for _, mv := range c {
+		if f := metadata.ValueFunc(ctx, mv.label); f != nil && f() == mv.value {
+			return true
+		}
+	}
+	return false
+}
+
+// notCondition matches queries its condition doesn't match.
+type notCondition struct {
+	condition
+}
+
+func (c notCondition) match(ctx context.Context, qname string) bool {
+	return !c.condition.match(ctx, qname)
+}
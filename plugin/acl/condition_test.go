@@ -0,0 +1,152 @@
+package acl
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestACLServeDNSConditions(t *testing.T) {
+	const config = `acl {
+		allow name good.example.org
+		block name example.org not meta geoip/country/code=NL
+		filter regex ^ads[0-9]*\. type A AAAA
+		drop meta kubernetes/client-namespace=untrusted not net 10.0.0.0/8
+		block not type A AAAA MX name example.net
+	}`
+
+	tests := []struct {
+		name      string
+		domain    string
+		qtype     uint16
+		sourceIP  string
+		meta      map[string]string
+		wantRcode int
+		wantDrop  bool
+	}{
+		{"allowed before block", "www.good.example.org.", dns.TypeA, "192.168.0.1", nil, dns.RcodeSuccess, false},
+		{"blocked outside NL", "www.example.org.", dns.TypeA, "192.168.0.1", map[string]string{"geoip/country/code": "DE"}, dns.RcodeRefused, false},
+		{"blocked without country", "www.example.org.", dns.TypeA, "192.168.0.1", nil, dns.RcodeRefused, false},
+		{"allowed in NL", "www.example.org.", dns.TypeA, "192.168.0.1", map[string]string{"geoip/country/code": "NL"}, dns.RcodeSuccess, false},
+		{"name suffix only on label", "wwwexample.org.", dns.TypeA, "192.168.0.1", nil, dns.RcodeSuccess, false},
+		{"filtered by regex", "ads12.example.com.", dns.TypeAAAA, "192.168.0.1", nil, dns.RcodeSuccess, false},
+		{"regex with other type", "ads12.example.com.", dns.TypeTXT, "192.168.0.1", nil, dns.RcodeSuccess, false},
+		{"dropped", "example.com.", dns.TypeA, "192.168.0.1", map[string]string{"kubernetes/client-namespace": "untrusted"}, 0, true},
+		{"not dropped from 10/8", "example.com.", dns.TypeA, "10.0.0.1", map[string]string{"kubernetes/client-namespace": "untrusted"}, dns.RcodeSuccess, false},
+		{"blocked other type", "example.net.", dns.TypeTXT, "192.168.0.1", nil, dns.RcodeRefused, false},
+		{"allowed type", "example.net.", dns.TypeMX, "192.168.0.1", nil, dns.RcodeSuccess, false},
+	}
+
+	a, err := parse(NewTestControllerWithZones(config, []string{"."}))
+	if err != nil {
+		t.Fatalf("Cannot parse acl from config: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a.Next = test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+				m := new(dns.Msg)
+				m.SetReply(r)
+				w.WriteMsg(m)
+				return dns.RcodeSuccess, nil
+			})
+
+			ctx := metadata.ContextWithMetadata(context.Background())
+			for label, value := range tt.meta {
+				value := value
+				metadata.SetValueFunc(ctx, label, func() string { return value })
+			}
+
+			w := &writeRecorder{}
+			w.setRemoteIP(tt.sourceIP)
+			m := new(dns.Msg)
+			m.SetQuestion(tt.domain, tt.qtype)
+			if _, err := a.ServeDNS(ctx, w, m); err != nil {
+				t.Fatalf("Expected no error, got %s", err)
+			}
+			if tt.wantDrop {
+				if w.written {
+					t.Errorf("Expected no reply")
+				}
+				return
+			}
+			if !w.written {
+				t.Fatalf("Expected a reply")
+			}
+			if w.Rcode != tt.wantRcode {
+				t.Errorf("Expected rcode %d, got %d", tt.wantRcode, w.Rcode)
+			}
+		})
+	}
+}
+
+type writeRecorder struct {
+	testResponseWriter
+	written bool
+}
+
+func (w *writeRecorder) WriteMsg(m *dns.Msg) error {
+	w.written = true
+	return w.testResponseWriter.WriteMsg(m)
+}
+
+// fixedWriter returns the same remote address every time, like the writers of the server do.
+type fixedWriter struct {
+	test.ResponseWriter
+	addr net.Addr
+}
+
+func (w *fixedWriter) RemoteAddr() net.Addr { return w.addr }
+
+func TestMatchWithPoliciesAllocs(t *testing.T) {
+	a, err := parse(NewTestControllerWithZones(`acl {
+		block type AAAA net 10.0.0.0/8 name example.org
+		allow net 192.168.0.0/16
+		drop not net 192.168.0.0/16 regex ^www meta kubernetes/client-namespace=untrusted
+	}`, []string{"."}))
+	if err != nil {
+		t.Fatalf("Cannot parse acl from config: %v", err)
+	}
+
+	ctx := metadata.ContextWithMetadata(context.Background())
+	metadata.SetValueFunc(ctx, "kubernetes/client-namespace", func() string { return "untrusted" })
+	w := &fixedWriter{addr: &net.UDPAddr{IP: net.ParseIP("10.1.1.1"), Port: 53}}
+
+	var act action
+	allocs := testing.AllocsPerRun(100, func() {
+		act, _ = matchWithPolicies(ctx, a.Rules[0].policies, w, "www.example.org.", dns.TypeA)
+	})
+	if act != actionDrop {
+		t.Errorf("Expected the query to be dropped, got action %d", act)
+	}
+	if allocs != 0 {
+		t.Errorf("Expected no allocations, got %f", allocs)
+	}
+}
+
+func BenchmarkMatchWithPolicies(b *testing.B) {
+	a, err := parse(NewTestControllerWithZones(`acl {
+		allow name good.example.org
+		block name example.org not meta geoip/country/code=NL
+		filter regex ^ads[0-9]*\. type A AAAA
+		block net 192.168.0.0/16
+	}`, []string{"."}))
+	if err != nil {
+		b.Fatalf("Cannot parse acl from config: %v", err)
+	}
+
+	ctx := metadata.ContextWithMetadata(context.Background())
+	metadata.SetValueFunc(ctx, "geoip/country/code", func() string { return "NL" })
+	w := &fixedWriter{addr: &net.UDPAddr{IP: net.ParseIP("10.1.1.1"), Port: 53}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matchWithPolicies(ctx, a.Rules[0].policies, w, "www.example.org.", dns.TypeA)
+	}
+}
@@ -0,0 +1,186 @@
+package acl
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// list is a list of domains read from a file. A name matches the list when it, or one of its parent domains,
+// is in the list.
+type list struct {
+	path string
+
+	sync.RWMutex
+	names suffixes
+
+	// mtime and size are only read and modified by a single goroutine
+	mtime time.Time
+	size  int64
+}
+
+// suffixes holds domain names with their labels reversed, e.g. "org.example." for "example.org.", sorted. Names that
+// are below another name in the list are left out, so a name matches when its reversed form starts with the
+// entry right before it in sort order.
+type suffixes []string
+
+// match returns true if name or one of its parents is in s.
+func (s suffixes) match(name string) bool {
+	var buf [256]byte
+	key := reverse(buf[:0], name)
+	i := sort.Search(len(s), func(i int) bool { return s[i] > string(key) })
+	if i == 0 {
+		return false
+	}
+	e := s[i-1]
+	return len(e) <= len(key) && e == string(key[:len(e)])
+}
+
+// newSuffixes returns the suffixes for the domain names in names.
+func newSuffixes(names []string) suffixes {
+	s := make(suffixes, 0, len(names))
+	for _, n := range names {
+		s = append(s, string(reverse(nil, n)))
+	}
+	sort.Strings(s)
+
+	// Leave out the names below the previous name that is kept, and duplicates.
+	j := 0
+	for i := range s {
+		if j > 0 && strings.HasPrefix(s[i], s[j-1]) {
+			continue
+		}
+		s[j] = s[i]
+		j++
+	}
+	return s[:j:j]
+}
+
+// reverse appends the labels of the lower cased, fully qualified name to buf in reverse order.
+func reverse(buf []byte, name string) []byte {
+	end := len(name)
+	if end > 0 && name[end-1] == '.' {
+		end--
+	}
+	for end > 0 {
+		start := strings.LastIndexByte(name[:end], '.') + 1
+		for i := start; i < end; i++ {
+			c := name[i]
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			buf = append(buf, c)
+		}
+		buf = append(buf, '.')
+		end = start - 1
+	}
+	if len(buf) == 0 {
+		buf = append(buf, '.')
+	}
+	return buf
+}
+
+// match returns true if name is in l.
+func (l *list) match(name string) bool {
+	l.RLock()
+	defer l.RUnlock()
+	return l.names.match(name)
+}
+
+// read reads the list from its file, if the file has changed.
+func (l *list) read() error {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if l.mtime.Equal(stat.ModTime()) && l.size == stat.Size() {
+		return nil
+	}
+
+	names, err := parseList(file)
+	if err != nil {
+		return err
+	}
+	s := newSuffixes(names)
+
+	l.Lock()
+	l.names = s
+	l.Unlock()
+	l.mtime = stat.ModTime()
+	l.size = stat.Size()
+
+	listEntries.WithLabelValues(l.path).Set(float64(len(s)))
+	log.Debugf("Parsed list %s into %d entries", l.path, len(s))
+	return nil
+}
+
+// parseList parses the domains in r. Each line holds a hosts file entry, e.g. "0.0.0.0 ads.example.org", a
+// domain, e.g. "ads.example.org", or an adblock rule for a domain, e.g. "||ads.example.org^". Comments start
+// with '#', or with '!' for adblock lists. Other adblock rules, and invalid names, are skipped.
+func parseList(r io.Reader) ([]string, error) {
+	names := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "!") || strings.HasPrefix(fields[0], "[") {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(fields[0], "||"):
+			if len(fields) > 1 || !strings.HasSuffix(fields[0], "^") {
+				continue
+			}
+			fields = []string{fields[0][2 : len(fields[0])-1]}
+		case net.ParseIP(fields[0]) != nil:
+			fields = fields[1:]
+		case len(fields) > 1:
+			continue
+		}
+
+		for _, f := range fields {
+			f = strings.TrimPrefix(f, "*.")
+			if _, ok := hostsLocal[f]; ok || f == "." {
+				continue
+			}
+			if _, ok := dns.IsDomainName(f); !ok || strings.ContainsAny(f, "*/^$|") {
+				continue
+			}
+			names = append(names, dns.Fqdn(strings.ToLower(f)))
+		}
+	}
+	return names, scanner.Err()
+}
+
+// hostsLocal holds the names for the local host that hosts files list.
+var hostsLocal = map[string]struct{}{
+	"localhost":             {},
+	"localhost.localdomain": {},
+	"local":                 {},
+	"broadcasthost":         {},
+	"ip6-localhost":         {},
+	"ip6-loopback":          {},
+	"ip6-localnet":          {},
+	"ip6-mcastprefix":       {},
+	"ip6-allnodes":          {},
+	"ip6-allrouters":        {},
+	"ip6-allhosts":          {},
+	"0.0.0.0":               {},
+}
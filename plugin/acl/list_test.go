@@ -0,0 +1,159 @@
+package acl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseList(t *testing.T) {
+	const input = `# hosts format
+127.0.0.1 localhost
+::1 localhost ip6-localhost
+0.0.0.0 ads.example.org tracker.example.org # inline comment
+0.0.0.0 0.0.0.0
+
+! adblock format
+[Adblock Plus 2.0]
+||telemetry.example.net^
+||images.example.net^$third-party
+@@||good.example.net^
+||example.com/path
+
+# domain format
+Malware.Example.COM
+*.wild.example.com
+not a domain
+bad..name
+`
+	names, err := parseList(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	expected := []string{
+		"ads.example.org.",
+		"tracker.example.org.",
+		"telemetry.example.net.",
+		"malware.example.com.",
+		"wild.example.com.",
+	}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], names[i])
+		}
+	}
+}
+
+func TestSuffixes(t *testing.T) {
+	s := newSuffixes([]string{"example.org.", "ads.example.org.", "example.org.", "tracker.example.net.", "ORG.example.com."})
+	if len(s) != 3 {
+		t.Errorf("Expected 3 entries, got %v", s)
+	}
+
+	tests := []struct {
+		name  string
+		match bool
+	}{
+		{"example.org.", true},
+		{"www.example.org.", true},
+		{"a.b.ads.Example.ORG.", true},
+		{"example.net.", false},
+		{"tracker.example.net.", true},
+		{"x.tracker.example.net.", true},
+		{"xtracker.example.net.", false},
+		{"org.example.com.", true},
+		{"example.com.", false},
+		{"org.", false},
+		{"aexample.org.", false},
+		{".", false},
+	}
+	for i, tc := range tests {
+		if got := s.match(tc.name); got != tc.match {
+			t.Errorf("Test %d: expected match %t for %s, got %t", i, tc.match, tc.name, got)
+		}
+	}
+
+	if (suffixes{}).match("example.org.") {
+		t.Errorf("Expected no match in an empty list")
+	}
+}
+
+func TestACLServeDNSList(t *testing.T) {
+	dir := t.TempDir()
+	block := filepath.Join(dir, "block.txt")
+	allow := filepath.Join(dir, "allow.txt")
+	if err := os.WriteFile(block, []byte("0.0.0.0 example.org\n||ads.example.net^\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(allow, []byte("good.example.org\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctr := NewTestControllerWithZones(fmt.Sprintf(`acl {
+		block list %s
+		filter type AAAA list %s
+		allow list %s
+	}`, block, block, allow), []string{"."})
+	a, err := parse(ctr)
+	if err != nil {
+		t.Fatalf("Cannot parse acl from config: %v", err)
+	}
+	a.Next = test.NextHandler(dns.RcodeSuccess, nil)
+	if len(a.lists) != 2 {
+		t.Errorf("Expected 2 lists, got %d", len(a.lists))
+	}
+
+	tests := []struct {
+		domain    string
+		wantRcode int
+	}{
+		{"example.org.", dns.RcodeRefused},
+		{"www.example.org.", dns.RcodeRefused},
+		{"good.example.org.", dns.RcodeSuccess}, // the allow list takes precedence
+		{"www.good.example.org.", dns.RcodeSuccess},
+		{"x.ads.example.net.", dns.RcodeRefused},
+		{"example.net.", dns.RcodeSuccess},
+	}
+	for i, tc := range tests {
+		w := &testResponseWriter{}
+		m := new(dns.Msg)
+		m.SetQuestion(tc.domain, dns.TypeA)
+		if _, err := a.ServeDNS(context.Background(), w, m); err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		if w.Rcode != tc.wantRcode {
+			t.Errorf("Test %d: expected rcode %d for %s, got %d", i, tc.wantRcode, tc.domain, w.Rcode)
+		}
+	}
+
+	if hits := testutil.ToFloat64(ListHitCount.WithLabelValues("", block)); hits != 3 {
+		t.Errorf("Expected 3 hits for the block list, got %f", hits)
+	}
+	if hits := testutil.ToFloat64(ListHitCount.WithLabelValues("", allow)); hits != 2 {
+		t.Errorf("Expected 2 hits for the allow list, got %f", hits)
+	}
+
+	// Reading a changed list.
+	if err := os.WriteFile(block, []byte("example.net\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l := a.lists[0]
+	l.size = 0 // the modification time may not have changed
+	if err := l.read(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if l.match("example.org.") || !l.match("example.net.") {
+		t.Errorf("Expected the list to be reloaded")
+	}
+}
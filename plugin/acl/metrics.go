@@ -22,6 +22,13 @@ var (
 		Name:      "filtered_requests_total",
 		Help:      "Counter of DNS requests being filtered.",
 	}, []string{"server", "zone"})
+	// RequestDropCount is the number of DNS requests being dropped.
+	RequestDropCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "dropped_requests_total",
+		Help:      "Counter of DNS requests being dropped.",
+	}, []string{"server", "zone"})
 	// RequestAllowCount is the number of DNS requests being Allowed.
 	RequestAllowCount = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: plugin.Namespace,
@@ -29,4 +36,18 @@ var (
 		Name:      "allowed_requests_total",
 		Help:      "Counter of DNS requests being allowed.",
 	}, []string{"server"})
+	// ListHitCount is the number of DNS requests whose name matched a list.
+	ListHitCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "list_hits_total",
+		Help:      "Counter of DNS requests whose name matched a list.",
+	}, []string{"server", "list"})
+
+	listEntries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "list_entries",
+		Help:      "The number of domains in a list.",
+	}, []string{"list"})
 )
@@ -2,11 +2,16 @@ package acl
 
 import (
 	"net"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metadata"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
 
 	"github.com/infobloxopen/go-trees/iptree"
 	"github.com/miekg/dns"
@@ -14,6 +19,8 @@ import (
 
 const pluginName = "acl"
 
+var log = clog.NewWithPlugin(pluginName)
+
 func init() { plugin.Register(pluginName, setup) }
 
 func newDefaultFilter() *iptree.Tree {
@@ -31,6 +38,18 @@ func setup(c *caddy.Controller) error {
 		return plugin.Error(pluginName, err)
 	}
 
+	if len(a.lists) > 0 && a.reload > 0 {
+		stop := make(chan struct{})
+		c.OnStartup(func() error {
+			go periodicListUpdate(a.lists, a.reload, stop)
+			return nil
+		})
+		c.OnShutdown(func() error {
+			close(stop)
+			return nil
+		})
+	}
+
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		a.Next = next
 		return a
@@ -39,14 +58,50 @@ func setup(c *caddy.Controller) error {
 	return nil
 }
 
+// periodicListUpdate rereads the lists that changed every reload interval, until stop is closed.
+func periodicListUpdate(lists []*list, reload time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(reload)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			for _, l := range lists {
+				if err := l.read(); err != nil {
+					log.Warningf("Failed to reload list %s: %s", l.path, err)
+				}
+			}
+		}
+	}
+}
+
 func parse(c *caddy.Controller) (ACL, error) {
-	a := ACL{}
+	a := ACL{reload: defaultReload}
+	config := dnsserver.GetConfig(c)
+	lists := map[string]*list{}
 	for c.Next() {
 		r := rule{}
 		args := c.RemainingArgs()
 		r.zones = plugin.OriginsFromArgsOrServerBlock(args, c.ServerBlockKeys)
 
 		for c.NextBlock() {
+			if strings.ToLower(c.Val()) == "reload" {
+				remaining := c.RemainingArgs()
+				if len(remaining) != 1 {
+					return a, c.Errf("reload needs a duration (zero seconds to disable)")
+				}
+				reload, err := time.ParseDuration(remaining[0])
+				if err != nil {
+					return a, c.Errf("invalid duration for reload '%s'", remaining[0])
+				}
+				if reload < 0 {
+					return a, c.Errf("invalid negative duration for reload '%s'", remaining[0])
+				}
+				a.reload = reload
+				continue
+			}
+
 			p := policy{}
 
 			action := strings.ToLower(c.Val())
@@ -56,8 +111,10 @@ func parse(c *caddy.Controller) (ACL, error) {
 				p.action = actionBlock
 			} else if action == "filter" {
 				p.action = actionFilter
+			} else if action == "drop" {
+				p.action = actionDrop
 			} else {
-				return a, c.Errf("unexpected token %q; expect 'allow', 'block', or 'filter'", c.Val())
+				return a, c.Errf("unexpected token %q; expect 'allow', 'block', 'filter', 'drop', or 'reload'", c.Val())
 			}
 
 			p.qtypes = make(map[uint16]struct{})
@@ -68,8 +125,15 @@ func parse(c *caddy.Controller) (ACL, error) {
 
 			remainingTokens := c.RemainingArgs()
 			for len(remainingTokens) > 0 {
-				if !isPreservedIdentifier(remainingTokens[0]) {
-					return a, c.Errf("unexpected token %q; expect 'type | net'", remainingTokens[0])
+				not := strings.ToLower(remainingTokens[0]) == "not"
+				if not {
+					remainingTokens = remainingTokens[1:]
+					if len(remainingTokens) == 0 {
+						return a, c.Errf("no section specified after 'not'")
+					}
+				}
+				if !isPreservedIdentifier(remainingTokens[0]) || strings.ToLower(remainingTokens[0]) == "not" {
+					return a, c.Errf("unexpected token %q; expect 'type | net | list | name | regex | meta'", remainingTokens[0])
 				}
 				section := strings.ToLower(remainingTokens[0])
 
@@ -84,9 +148,11 @@ func parse(c *caddy.Controller) (ACL, error) {
 					return a, c.Errf("no token specified in %q section", section)
 				}
 
+				var cond condition
 				switch section {
 				case "type":
 					hasTypeSection = true
+					p.notType = not
 					for _, token := range tokens {
 						if token == "*" {
 							p.qtypes[dns.TypeNone] = struct{}{}
@@ -100,6 +166,7 @@ func parse(c *caddy.Controller) (ACL, error) {
 					}
 				case "net":
 					hasNetSection = true
+					p.notNet = not
 					for _, token := range tokens {
 						if token == "*" {
 							p.filter = newDefaultFilter()
@@ -112,8 +179,58 @@ func parse(c *caddy.Controller) (ACL, error) {
 						}
 						p.filter.InplaceInsertNet(source, struct{}{})
 					}
+				case "list":
+					p.notList = not
+					for _, token := range tokens {
+						path := token
+						if !filepath.IsAbs(path) && config.Root != "" {
+							path = filepath.Join(config.Root, path)
+						}
+						l, ok := lists[path]
+						if !ok {
+							l = &list{path: path}
+							if err := l.read(); err != nil {
+								return a, c.Errf("unable to read list %q: %s", path, err)
+							}
+							lists[path] = l
+							a.lists = append(a.lists, l)
+						}
+						p.lists = append(p.lists, l)
+					}
+				case "name":
+					names := nameCondition{}
+					for _, token := range tokens {
+						names = append(names, plugin.Host(token).NormalizeExact()...)
+					}
+					cond = names
+				case "regex":
+					res := regexCondition{}
+					for _, token := range tokens {
+						re, err := regexp.Compile(token)
+						if err != nil {
+							return a, c.Errf("illegal regular expression %q: %s", token, err)
+						}
+						res = append(res, re)
+					}
+					cond = res
+				case "meta":
+					values := metaCondition{}
+					for _, token := range tokens {
+						i := strings.Index(token, "=")
+						if i < 0 || !metadata.IsLabel(token[:i]) {
+							return a, c.Errf("unexpected token %q; expect 'LABEL=VALUE'", token)
+						}
+						values = append(values, metaValue{label: token[:i], value: token[i+1:]})
+					}
+					cond = values
 				default:
-					return a, c.Errf("unexpected token %q; expect 'type | net'", section)
+					return a, c.Errf("unexpected token %q; expect 'type | net | list | name | regex | meta'", section)
+				}
+				if cond != nil {
+					if not {
+						cond = notCondition{cond}
+					}
+					p.conds = append(p.conds, cond)
 				}
 			}
 
@@ -136,9 +253,16 @@ func parse(c *caddy.Controller) (ACL, error) {
 
 func isPreservedIdentifier(token string) bool {
 	identifier := strings.ToLower(token)
-	return identifier == "type" || identifier == "net"
+	switch identifier {
+	case "type", "net", "list", "name", "regex", "meta", "not":
+		return true
+	}
+	return false
 }
 
+// defaultReload is the default interval at which the lists are checked for changes.
+const defaultReload = time.Minute
+
 // normalize appends '/32' for any single IPv4 address and '/128' for IPv6.
 func normalize(rawNet string) string {
 	if idx := strings.IndexAny(rawNet, "/"); idx >= 0 {
@@ -199,6 +199,42 @@ func TestSetup(t *testing.T) {
 			}`,
 			false,
 		},
+		{
+			"List 1",
+			`acl {
+				block list testdata/missing.txt
+			}`,
+			true,
+		},
+		{
+			"List 2",
+			`acl {
+				block list
+			}`,
+			true,
+		},
+		{
+			"Reload 1",
+			`acl {
+				reload 1x
+			}`,
+			true,
+		},
+		{
+			"Reload 2",
+			`acl {
+				reload -1s
+			}`,
+			true,
+		},
+		{
+			"Reload 3",
+			`acl {
+				reload 10s
+				block net 192.168.0.0/16
+			}`,
+			false,
+		},
 		{
 			"Illegal argument 1 IPv6",
 			`acl {
@@ -0,0 +1,326 @@
+package cache
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/coredns/coredns/plugin/pkg/reuseport"
+	"github.com/coredns/coredns/plugin/pkg/uniq"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// instances holds the caches of all server blocks, so they can be inspected with the admin API.
+	instances = &registry{caches: make(map[string]*Cache)}
+	uniqAddr  = uniq.New()
+)
+
+// registry holds the caches by server block.
+type registry struct {
+	sync.RWMutex
+	caches map[string]*Cache
+}
+
+func (r *registry) add(server string, c *Cache) {
+	r.Lock()
+	defer r.Unlock()
+	r.caches[server] = c
+}
+
+func (r *registry) remove(server string, c *Cache) {
+	r.Lock()
+	defer r.Unlock()
+	if r.caches[server] == c {
+		delete(r.caches, server)
+	}
+}
+
+// get returns the caches for server, or all caches when server is empty, sorted by server.
+func (r *registry) get(server string) ([]string, []*Cache) {
+	r.RLock()
+	defer r.RUnlock()
+	servers := []string{}
+	for s := range r.caches {
+		if server == "" || s == server {
+			servers = append(servers, s)
+		}
+	}
+	sort.Strings(servers)
+	caches := make([]*Cache, len(servers))
+	for i, s := range servers {
+		caches[i] = r.caches[s]
+	}
+	return servers, caches
+}
+
+// admin serves the HTTP admin API for the caches.
+type admin struct {
+	Addr  string
+	token string
+
+	sync.Mutex
+	ln   net.Listener
+	done bool
+}
+
+func (a *admin) onStartup() error {
+	ln, err := reuseport.Listen("tcp", a.Addr)
+	if err != nil {
+		return err
+	}
+
+	a.Lock()
+	a.ln = ln
+	a.done = true
+	a.Unlock()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cache", a.auth(a.list))
+	mux.HandleFunc("/cache/entries", a.auth(a.entries))
+	mux.HandleFunc("/cache/lookup", a.auth(a.lookup))
+	mux.HandleFunc("/cache/purge", a.auth(a.purge))
+
+	go func() { http.Serve(ln, mux) }()
+	return nil
+}
+
+func (a *admin) onFinalShutdown() error {
+	a.Lock()
+	defer a.Unlock()
+	if !a.done {
+		return nil
+	}
+
+	uniqAddr.Unset(a.Addr)
+
+	a.ln.Close()
+	a.done = false
+	return nil
+}
+
+// auth checks the bearer token, if one is configured.
+func (a *admin) auth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// cacheInfo is the JSON description of the cache of a server block.
+type cacheInfo struct {
+	Server  string   `json:"server"`
+	Name    string   `json:"name,omitempty"`
+	Zones   []string `json:"zones"`
+	Success int      `json:"success"`
+	Denial  int      `json:"denial"`
+}
+
+// entry is the JSON description of a cached item.
+type entry struct {
+	Server string   `json:"server"`
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Cache  string   `json:"cache"`
+	Rcode  string   `json:"rcode"`
+	TTL    int      `json:"ttl"`
+	Subnet string   `json:"subnet,omitempty"`
+	CD     bool     `json:"cd,omitempty"`
+	Answer []string `json:"answer,omitempty"`
+}
+
+func (a *admin) list(w http.ResponseWriter, r *http.Request) {
+	servers, caches := instances.get(r.URL.Query().Get("server"))
+	infos := make([]cacheInfo, len(caches))
+	for i, c := range caches {
+		infos[i] = cacheInfo{Server: servers[i], Name: c.name, Zones: c.Zones, Success: c.pcache.Len(), Denial: c.ncache.Len()}
+	}
+	writeJSON(w, infos)
+}
+
+// entries lists the cached items, optionally only those at or below the name parameter.
+func (a *admin) entries(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := defaultAdminLimit
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	zone := ""
+	if n := q.Get("name"); n != "" {
+		zone = plugin.Name(n).Normalize()
+	}
+
+	entries := []entry{}
+	servers, caches := instances.get(q.Get("server"))
+	for i, c := range caches {
+		c.walk(func(t string, it *item) bool {
+			if zone != "" && !dns.IsSubDomain(zone, it.Name) {
+				return true
+			}
+			entries = append(entries, it.entry(servers[i], t, c.now(), false))
+			return len(entries) < limit
+		})
+		if len(entries) >= limit {
+			break
+		}
+	}
+	writeJSON(w, entries)
+}
+
+// lookup returns the cached items for the name and type parameters, including the records.
+func (a *admin) lookup(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("name")
+	qtype, ok := dns.StringToType[strings.ToUpper(q.Get("type"))]
+	if name == "" || !ok {
+		http.Error(w, "name and type are required", http.StatusBadRequest)
+		return
+	}
+	name = plugin.Name(name).Normalize()
+
+	entries := []entry{}
+	servers, caches := instances.get(q.Get("server"))
+	for i, c := range caches {
+		c.walk(func(t string, it *item) bool {
+			if it.Name == name && it.QType == qtype {
+				entries = append(entries, it.entry(servers[i], t, c.now(), true))
+			}
+			return true
+		})
+	}
+	writeJSON(w, entries)
+}
+
+// purge removes items from the caches: with the name parameter all items for that name, with zone all
+// items at or below the zone, and with all=true everything.
+func (a *admin) purge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+
+	var (
+		kind  string
+		name  string // the name or zone the NSEC records are purged for
+		below bool   // whether the names below name are purged too
+		match func(*item) bool
+	)
+	switch {
+	case q.Get("name") != "":
+		kind = "name"
+		name = plugin.Name(q.Get("name")).Normalize()
+		match = func(it *item) bool { return it.Name == name }
+	case q.Get("zone") != "":
+		kind, below = "zone", true
+		name = plugin.Name(q.Get("zone")).Normalize()
+		match = func(it *item) bool { return dns.IsSubDomain(name, it.Name) }
+	case q.Get("all") == "true":
+		kind, name, below = "all", ".", true
+		match = func(*item) bool { return true }
+	default:
+		http.Error(w, "one of name, zone or all=true is required", http.StatusBadRequest)
+		return
+	}
+
+	total := 0
+	servers, caches := instances.get(q.Get("server"))
+	for i, c := range caches {
+		n := c.purge(match, name, below)
+		purges.WithLabelValues(servers[i], kind, c.name).Inc()
+		purgedEntries.WithLabelValues(servers[i], c.name).Add(float64(n))
+		log.Infof("Purged %d items (%s) from the cache of %s", n, kind, servers[i])
+		total += n
+	}
+	writeJSON(w, map[string]int{"purged": total})
+}
+
+// walk calls f for each item in the cache, with the cache type. It stops when f returns false.
+func (c *Cache) walk(f func(string, *item) bool) {
+	for _, ca := range []struct {
+		t string
+		c *cache.Cache
+	}{{Success, c.pcache}, {Denial, c.ncache}} {
+		ok := true
+		ca.c.Walk(func(items map[uint64]interface{}, key uint64) bool {
+			// Walk only stops the current shard, so keep returning false.
+			if !ok {
+				return false
+			}
+			if el, found := items[key]; found {
+				ok = f(ca.t, el.(*item))
+			}
+			return ok
+		})
+		if !ok {
+			return
+		}
+	}
+}
+
+// purge removes the items for which match returns true and returns the number of items removed. The NSEC records
+// that can deny name, or with below the names below it too, are removed as well, so they don't keep answering.
+func (c *Cache) purge(match func(*item) bool, name string, below bool) int {
+	if c.nsec != nil {
+		c.nsec.purge(name, below)
+	}
+	n := 0
+	for _, ca := range []*cache.Cache{c.pcache, c.ncache} {
+		ca.Walk(func(items map[uint64]interface{}, key uint64) bool {
+			if el, found := items[key]; found && match(el.(*item)) {
+				delete(items, key)
+				n++
+			}
+			return true
+		})
+	}
+	return n
+}
+
+func (i *item) entry(server, t string, now time.Time, records bool) entry {
+	e := entry{
+		Server: server,
+		Name:   i.Name,
+		Type:   dns.TypeToString[i.QType],
+		Cache:  t,
+		Rcode:  dns.RcodeToString[i.Rcode],
+		TTL:    i.ttl(now),
+		CD:     i.CheckingDisabled,
+	}
+	if i.subnet != nil {
+		e.Subnet = i.subnet.ip.String() + "/" + strconv.Itoa(int(i.subnet.scope))
+	}
+	if records {
+		for _, rr := range i.Answer {
+			e.Answer = append(e.Answer, rr.String())
+		}
+	}
+	return e
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+const defaultAdminLimit = 1000
@@ -0,0 +1,205 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newAdminTestCache(server string, names ...string) *Cache {
+	c := New()
+	c.Next = BackendHandler()
+	for _, name := range names {
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			req := new(dns.Msg)
+			req.SetQuestion(name, qtype)
+			c.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
+		}
+	}
+	instances.add(server, c)
+	return c
+}
+
+func adminRequest(t *testing.T, a *admin, method, url, token string, v interface{}) int {
+	req := httptest.NewRequest(method, url, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cache", a.auth(a.list))
+	mux.HandleFunc("/cache/entries", a.auth(a.entries))
+	mux.HandleFunc("/cache/lookup", a.auth(a.lookup))
+	mux.HandleFunc("/cache/purge", a.auth(a.purge))
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code == http.StatusOK && v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("Failed to decode %s: %s", rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestAdmin(t *testing.T) {
+	c1 := newAdminTestCache("example.org:53", "a.example.org.", "b.example.org.", "c.example.net.")
+	c2 := newAdminTestCache(".:53", "a.example.org.")
+	defer instances.remove("example.org:53", c1)
+	defer instances.remove(".:53", c2)
+
+	a := &admin{token: "secret"}
+
+	if code := adminRequest(t, a, "GET", "/cache", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected %d without token, got %d", http.StatusUnauthorized, code)
+	}
+	if code := adminRequest(t, a, "GET", "/cache", "wrong", nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected %d with wrong token, got %d", http.StatusUnauthorized, code)
+	}
+
+	infos := []cacheInfo{}
+	adminRequest(t, a, "GET", "/cache", "secret", &infos)
+	if len(infos) != 2 || infos[0].Server != ".:53" || infos[1].Success != 6 {
+		t.Errorf("Unexpected cache list: %v", infos)
+	}
+
+	entries := []entry{}
+	adminRequest(t, a, "GET", "/cache/entries?server=example.org:53&name=example.org", "secret", &entries)
+	if len(entries) != 4 {
+		t.Errorf("Expected 4 entries for example.org, got %v", entries)
+	}
+	adminRequest(t, a, "GET", "/cache/entries?limit=3", "secret", &entries)
+	if len(entries) != 3 {
+		t.Errorf("Expected 3 entries with limit, got %d", len(entries))
+	}
+
+	adminRequest(t, a, "GET", "/cache/lookup?name=A.example.org&type=aaaa", "secret", &entries)
+	if len(entries) != 2 || entries[0].Type != "AAAA" || len(entries[0].Answer) != 1 {
+		t.Errorf("Expected 2 AAAA entries with records, got %v", entries)
+	}
+	if code := adminRequest(t, a, "GET", "/cache/lookup?name=a.example.org", "secret", nil); code != http.StatusBadRequest {
+		t.Errorf("Expected %d without type, got %d", http.StatusBadRequest, code)
+	}
+
+	if code := adminRequest(t, a, "GET", "/cache/purge?all=true", "secret", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected %d for GET purge, got %d", http.StatusMethodNotAllowed, code)
+	}
+
+	tests := []struct {
+		query    string
+		expected int
+	}{
+		{"name=a.example.org.", 4},
+		{"server=example.org:53&zone=example.org", 2},
+		{"all=true", 2},
+		{"all=true", 0},
+	}
+	for i, tc := range tests {
+		purged := map[string]int{}
+		if code := adminRequest(t, a, "POST", "/cache/purge?"+tc.query, "secret", &purged); code != http.StatusOK {
+			t.Errorf("Test %d: expected %d, got %d", i, http.StatusOK, code)
+		}
+		if purged["purged"] != tc.expected {
+			t.Errorf("Test %d: expected %d items purged, got %d", i, tc.expected, purged["purged"])
+		}
+	}
+
+	if x := testutil.ToFloat64(purges.WithLabelValues("example.org:53", "all", "")); x != 2 {
+		t.Errorf("Expected 2 purges of all, got %f", x)
+	}
+	if x := testutil.ToFloat64(purgedEntries.WithLabelValues("example.org:53", "")); x != 6 {
+		t.Errorf("Expected 6 purged entries, got %f", x)
+	}
+}
+
+func TestAdminPurgeNSEC(t *testing.T) {
+	c := New()
+	c.nsec = newNSECStore()
+	instances.add("nsec:53", c)
+	defer instances.remove("nsec:53", c)
+	a := &admin{token: "secret"}
+
+	now := time.Now()
+	fill := func() {
+		for _, zone := range []string{"example.org.", "sub.example.org.", "example.net."} {
+			c.nsec.add(&dns.Msg{Ns: []dns.RR{
+				test.SOA(zone + " 3600 IN SOA ns.example.org. hostmaster.example.org. 1 7200 3600 1209600 3600"),
+				test.NSEC("a." + zone + " 3600 IN NSEC d." + zone + " A RRSIG NSEC"),
+			}}, now, time.Hour)
+		}
+	}
+
+	tests := []struct {
+		query   string
+		remains []string // the zones left in the NSEC store
+	}{
+		{"name=b.example.org", []string{"example.net.", "sub.example.org."}},
+		{"name=b.sub.example.org", []string{"example.net.", "example.org."}},
+		{"zone=example.org", []string{"example.net."}},
+		{"zone=b.example.org", []string{"example.net.", "sub.example.org."}},
+		{"all=true", []string{}},
+	}
+	for i, tc := range tests {
+		fill()
+		if code := adminRequest(t, a, "POST", "/cache/purge?server=nsec:53&"+tc.query, "secret", nil); code != http.StatusOK {
+			t.Errorf("Test %d: expected %d, got %d", i, http.StatusOK, code)
+		}
+		zones := []string{}
+		for zone := range c.nsec.zones {
+			zones = append(zones, zone)
+		}
+		sort.Strings(zones)
+		if fmt.Sprint(zones) != fmt.Sprint(tc.remains) {
+			t.Errorf("Test %d: expected the zones %v to remain, got %v", i, tc.remains, zones)
+		}
+		if tc.query == "name=b.example.org" {
+			if syn := c.nsec.synthesize("b.example.org.", dns.TypeA, now); syn != nil {
+				t.Errorf("Test %d: expected no synthesis after the purge, got %v", i, syn)
+			}
+		}
+	}
+}
+
+func TestSetupAdmin(t *testing.T) {
+	tests := []struct {
+		input         string
+		shouldErr     bool
+		expectedAddr  string
+		expectedToken string
+	}{
+		{"admin :8053", false, ":8053", ""},
+		{"admin localhost:8053 secret", false, "localhost:8053", "secret"},
+		// fails
+		{"admin", true, "", ""},
+		{"admin 8053", true, "", ""},
+		{"admin :8053 secret more", true, "", ""},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %v: Expected error but found nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if ca.admin.Addr != test.expectedAddr || ca.admin.token != test.expectedToken {
+			t.Errorf("Test %v: Expected %s %q, got %s %q", i, test.expectedAddr, test.expectedToken, ca.admin.Addr, ca.admin.token)
+		}
+	}
+}
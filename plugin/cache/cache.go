@@ -21,22 +21,53 @@ type Cache struct {
 	Next  plugin.Handler
 	Zones []string
 
+	// Name of the cache, server blocks with a cache of the same name share its items.
+	name string
+
 	ncache  *cache.Cache
 	ncap    int
+	nbytes  int
 	nttl    time.Duration
 	minnttl time.Duration
 
 	pcache  *cache.Cache
 	pcap    int
+	pbytes  int
 	pttl    time.Duration
 	minpttl time.Duration
 
+	// Eviction policy for both caches.
+	policy cache.Policy
+
+	// TTL bounds and prefetch settings by name and type, overriding the ones above.
+	rules rules
+
 	// Prefetch.
 	prefetch   int
 	duration   time.Duration
 	percentage int
 
-	staleUpTo time.Duration
+	// Serving stale items (RFC 8767).
+	staleUpTo    time.Duration
+	staleVerify  bool          // ask the next plugin first, and only serve a stale item when that fails
+	staleOnError bool          // only a SERVFAIL or no answer is a failure
+	staleTimeout time.Duration // how long to wait for the next plugin with staleVerify
+	staleRecheck time.Duration // how long to not ask the next plugin again after a failure
+	staleTTL     time.Duration // TTL of stale answers
+	failures     *cache.Cache  // time of the last failed refresh, per key
+
+	// Subnets for which responses with an EDNS0 Client Subnet scope are cached, per qname and qtype.
+	variants    *cache.Cache
+	maxVariants int
+
+	// Saving the cache to disk, nil when disabled.
+	persist *persist
+
+	// Aggressive use of NSEC(3) records (RFC 8198), nil when disabled.
+	nsec *nsecStore
+
+	// HTTP admin API, nil when not enabled in this server block.
+	admin *admin
 
 	// Testing.
 	now func() time.Time
@@ -46,26 +77,32 @@ type Cache struct {
 // caller to set the Next handler.
 func New() *Cache {
 	return &Cache{
-		Zones:      []string{"."},
-		pcap:       defaultCap,
-		pcache:     cache.New(defaultCap),
-		pttl:       maxTTL,
-		minpttl:    minTTL,
-		ncap:       defaultCap,
-		ncache:     cache.New(defaultCap),
-		nttl:       maxNTTL,
-		minnttl:    minNTTL,
-		prefetch:   0,
-		duration:   1 * time.Minute,
-		percentage: 10,
-		now:        time.Now,
+		Zones:        []string{"."},
+		pcap:         defaultCap,
+		pcache:       cache.New(defaultCap),
+		pttl:         maxTTL,
+		minpttl:      minTTL,
+		ncap:         defaultCap,
+		ncache:       cache.New(defaultCap),
+		nttl:         maxNTTL,
+		minnttl:      minNTTL,
+		prefetch:     0,
+		duration:     1 * time.Minute,
+		percentage:   10,
+		variants:     cache.New(defaultCap),
+		maxVariants:  defaultMaxVariants,
+		staleTimeout: defaultStaleTimeout,
+		staleRecheck: defaultStaleRecheck,
+		staleTTL:     defaultStaleTTL,
+		failures:     cache.New(defaultCap),
+		now:          time.Now,
 	}
 }
 
 // key returns key under which we store the item, -1 will be returned if we don't store the message.
 // Currently we do not cache Truncated, errors zone transfers or dynamic update messages.
-// qname holds the already lowercased qname.
-func key(qname string, m *dns.Msg, t response.Type) (bool, uint64) {
+// qname holds the already lowercased qname, cd is the CD bit of the request.
+func key(qname string, m *dns.Msg, t response.Type, cd bool) (bool, uint64) {
 	// We don't store truncated responses.
 	if m.Truncated {
 		return false, 0
@@ -75,13 +112,18 @@ func key(qname string, m *dns.Msg, t response.Type) (bool, uint64) {
 		return false, 0
 	}
 
-	return true, hash(qname, m.Question[0].Qtype)
+	return true, hash(qname, m.Question[0].Qtype, cd)
 }
 
-func hash(qname string, qtype uint16) uint64 {
+// hash returns the key for qname and qtype. Responses to queries with the CD bit set are not validated by the
+// backend, and may be bogus, so they are stored under their own key.
+func hash(qname string, qtype uint16, cd bool) uint64 {
 	h := fnv.New64()
 	h.Write([]byte{byte(qtype >> 8)})
 	h.Write([]byte{byte(qtype)})
+	if cd {
+		h.Write([]byte{1})
+	}
 	h.Write([]byte(qname))
 	return h.Sum64()
 }
@@ -106,6 +148,7 @@ type ResponseWriter struct {
 
 	do         bool // When true the original request had the DO bit set.
 	prefetch   bool // When true write nothing back to the client.
+	stale      bool // When true this refreshes a stale item, failures are not cached.
 	remoteAddr net.Addr
 }
 
@@ -143,49 +186,84 @@ func (w *ResponseWriter) RemoteAddr() net.Addr {
 
 // WriteMsg implements the dns.ResponseWriter interface.
 func (w *ResponseWriter) WriteMsg(res *dns.Msg) error {
+	if w.stale && w.refreshFailed(res) {
+		// Don't replace the stale item with the failure.
+		if w.prefetch {
+			return nil
+		}
+		return w.ResponseWriter.WriteMsg(res)
+	}
+	reply := res
+	if w.stale {
+		// Stale items are refreshed in the background, tailor a copy of the cached records for the reply.
+		reply = res.Copy()
+	}
+
 	mt, _ := response.Typify(res, w.now().UTC())
 
 	// key returns empty string for anything we don't want to cache.
-	hasKey, key := key(w.state.Name(), res, mt)
+	cd := w.state.Req.CheckingDisabled
+	hasKey, key := key(w.state.Name(), res, mt, cd)
+	// A response with an ECS scope is only valid for that client subnet, and is stored under its own key.
+	if s := responseSubnet(res); s != nil {
+		key = s.hash(w.state.Name(), w.state.QType(), cd)
+	}
 
-	msgTTL := dnsutil.MinimalTTL(res, mt)
+	rule := w.rules.match(w.state.Name(), w.state.QType())
+	msgTTL := rule.minimalTTL(res, mt)
 	var duration time.Duration
 	if mt == response.NameError || mt == response.NoData {
-		duration = computeTTL(msgTTL, w.minnttl, w.nttl)
+		duration = rule.computeTTL(msgTTL, w.minnttl, w.nttl)
 	} else if mt == response.ServerError {
 		// use default ttl which is 5s
 		duration = minTTL
 	} else {
-		duration = computeTTL(msgTTL, w.minpttl, w.pttl)
+		duration = rule.computeTTL(msgTTL, w.minpttl, w.pttl)
 	}
 
 	if hasKey && duration > 0 {
 		if w.state.Match(res) {
 			w.set(res, key, mt, duration)
-			cacheSize.WithLabelValues(w.server, Success).Set(float64(w.pcache.Len()))
-			cacheSize.WithLabelValues(w.server, Denial).Set(float64(w.ncache.Len()))
+			cacheSize.WithLabelValues(w.server, Success, w.name).Set(float64(w.pcache.Len()))
+			cacheSize.WithLabelValues(w.server, Denial, w.name).Set(float64(w.ncache.Len()))
+			if w.pbytes > 0 || w.nbytes > 0 {
+				cacheBytes.WithLabelValues(w.server, Success, w.name).Set(float64(w.pcache.Bytes()))
+				cacheBytes.WithLabelValues(w.server, Denial, w.name).Set(float64(w.ncache.Bytes()))
+			}
 		} else {
 			// Don't log it, but increment counter
-			cacheDrops.WithLabelValues(w.server).Inc()
+			cacheDrops.WithLabelValues(w.server, w.name).Inc()
 		}
 	}
 
 	if w.prefetch {
 		return nil
 	}
-
 	// Apply capped TTL to this reply to avoid jarring TTL experience 1799 -> 8 (e.g.)
 	// We also may need to filter out DNSSEC records, see toMsg() for similar code.
 	ttl := uint32(duration.Seconds())
-	res.Answer = filterRRSlice(res.Answer, ttl, w.do, false)
-	res.Ns = filterRRSlice(res.Ns, ttl, w.do, false)
-	res.Extra = filterRRSlice(res.Extra, ttl, w.do, false)
+	reply.Answer = filterRRSlice(reply.Answer, ttl, w.do, false)
+	reply.Ns = filterRRSlice(reply.Ns, ttl, w.do, false)
+	reply.Extra = filterRRSlice(reply.Extra, ttl, w.do, false)
 
-	if !w.do {
-		res.AuthenticatedData = false // unset AD bit if client is not OK with DNSSEC
+	if !w.do && !w.state.Req.AuthenticatedData {
+		reply.AuthenticatedData = false // unset AD bit if client is not OK with DNSSEC (RFC 6840, Section 5.7)
 	}
 
-	return w.ResponseWriter.WriteMsg(res)
+	return w.ResponseWriter.WriteMsg(reply)
+}
+
+// countEvictions increments the evictions counter for each reason in ev.
+func countEvictions(server, t, name string, ev cache.Evicted) {
+	if ev.Capacity > 0 {
+		evictions.WithLabelValues(server, t, "capacity", name).Add(float64(ev.Capacity))
+	}
+	if ev.Bytes > 0 {
+		evictions.WithLabelValues(server, t, "bytes", name).Add(float64(ev.Bytes))
+	}
+	if ev.Admission > 0 {
+		evictions.WithLabelValues(server, t, "admission", name).Add(float64(ev.Admission))
+	}
 }
 
 func (w *ResponseWriter) set(m *dns.Msg, key uint64, mt response.Type, duration time.Duration) {
@@ -194,18 +272,29 @@ func (w *ResponseWriter) set(m *dns.Msg, key uint64, mt response.Type, duration
 	switch mt {
 	case response.NoError, response.Delegation:
 		i := newItem(m, w.now(), duration)
-		if w.pcache.Add(key, i) {
-			evictions.WithLabelValues(w.server, Success).Inc()
+		i.CheckingDisabled = w.state.Req.CheckingDisabled
+		countEvictions(w.server, Success, w.name, w.pcache.AddSize(key, i, i.size))
+		if i.subnet != nil {
+			w.addVariant(i.Name, i.QType, i.CheckingDisabled, i.subnet, key)
 		}
-		// when pre-fetching, remove the negative cache entry if it exists
-		if w.prefetch {
+		// Only what the backend validated is used to synthesize answers.
+		if w.nsec != nil && m.AuthenticatedData && !i.CheckingDisabled {
+			w.setWildcard(m, duration)
+		}
+		// when pre-fetching or refreshing a stale item, remove the negative cache entry if it exists
+		if w.prefetch || w.stale {
 			w.ncache.Remove(key)
 		}
 
 	case response.NameError, response.NoData, response.ServerError:
 		i := newItem(m, w.now(), duration)
-		if w.ncache.Add(key, i) {
-			evictions.WithLabelValues(w.server, Denial).Inc()
+		i.CheckingDisabled = w.state.Req.CheckingDisabled
+		countEvictions(w.server, Denial, w.name, w.ncache.AddSize(key, i, i.size))
+		if i.subnet != nil {
+			w.addVariant(i.Name, i.QType, i.CheckingDisabled, i.subnet, key)
+		}
+		if w.nsec != nil && m.AuthenticatedData && !i.CheckingDisabled && mt != response.ServerError {
+			w.nsec.add(m, w.now(), duration)
 		}
 
 	case response.OtherError:
@@ -7,12 +7,14 @@ import (
 	"time"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/cache"
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
 	"github.com/coredns/coredns/plugin/pkg/response"
 	"github.com/coredns/coredns/plugin/test"
 	"github.com/coredns/coredns/request"
 
 	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 type cacheTestCase struct {
@@ -198,9 +200,10 @@ func TestCache(t *testing.T) {
 		state := request.Request{W: &test.ResponseWriter{}, Req: m}
 
 		mt, _ := response.Typify(m, utc)
-		valid, k := key(state.Name(), m, mt)
+		valid, k := key(state.Name(), m, mt, false)
 
 		if valid {
+			crr.state = state
 			crr.set(m, k, mt, c.pttl)
 		}
 
@@ -469,3 +472,38 @@ func TestComputeTTL(t *testing.T) {
 		}
 	}
 }
+
+func TestCacheMaxBytes(t *testing.T) {
+	c := New()
+	c.pbytes = 256 * 100 // 100 bytes per shard, room for one answer
+	c.pcache = cache.NewWithPolicy(c.pcap, c.pbytes, cache.LRU)
+	c.Next = BackendHandler()
+
+	// Find two names that end up in the same shard.
+	names := []string{}
+	shard := hash("a0.example.org.", dns.TypeA, false) & 255
+	for i := 0; len(names) < 2; i++ {
+		name := fmt.Sprintf("a%d.example.org.", i)
+		if hash(name, dns.TypeA, false)&255 == shard {
+			names = append(names, name)
+		}
+	}
+
+	crr := &ResponseWriter{ResponseWriter: &test.ResponseWriter{}, Cache: c, server: "dns://:53"}
+	for _, name := range names {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		crr.state = request.Request{W: &test.ResponseWriter{}, Req: req}
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Answer = []dns.RR{test.A(name + " 303 IN A 127.0.0.53")}
+		crr.WriteMsg(resp)
+	}
+
+	if c.pcache.Len() != 1 {
+		t.Errorf("Expected 1 item in the cache, got %d", c.pcache.Len())
+	}
+	if x := testutil.ToFloat64(evictions.WithLabelValues("dns://:53", Success, "bytes", "")); x != 1 {
+		t.Errorf("Expected 1 eviction for bytes, got %f", x)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
 	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
 
 	"github.com/miekg/dns"
 )
@@ -79,6 +80,87 @@ func dnssecHandler() plugin.Handler {
 	})
 }
 
+// cdHandler answers like a validating resolver: with a validated answer (AD set) when the CD bit is clear,
+// and with an answer it didn't validate, which might be bogus, when it's set. It counts the queries per CD bit.
+func cdHandler(calls *[2]int) plugin.Handler {
+	return plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		ip := "127.0.0.1"
+		if r.CheckingDisabled {
+			calls[1]++
+			ip = "127.0.0.66"
+		} else {
+			calls[0]++
+			m.AuthenticatedData = true
+		}
+		m.Answer = []dns.RR{
+			test.A("example.org. 300 IN A " + ip),
+			test.RRSIG("example.org. 300 IN RRSIG A 8 2 300 20300101000000 20200101000000 12345 example.org. c2ln"),
+		}
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+}
+
+func TestCacheDNSSECBits(t *testing.T) {
+	c := New()
+	calls := [2]int{}
+	c.Next = cdHandler(&calls)
+
+	tests := []struct {
+		do, cd, ad bool
+	}{
+		// CD set first, so we know a CD=1 answer is never served to a CD=0 query.
+		{false, true, false},
+		{false, true, true},
+		{true, true, false},
+		{true, true, true},
+		{false, false, false},
+		{false, false, true},
+		{true, false, false},
+		{true, false, true},
+	}
+
+	for i, tc := range tests {
+		// The first query fills the cache (for the first query with this CD bit), the second is a cache hit.
+		for j := 0; j < 2; j++ {
+			req := new(dns.Msg)
+			req.SetQuestion("example.org.", dns.TypeA)
+			req.CheckingDisabled = tc.cd
+			req.AuthenticatedData = tc.ad
+			if tc.do {
+				req.SetEdns0(4096, true)
+			}
+			rec := &wireRecorder{}
+			c.ServeDNS(context.TODO(), request.NewScrubWriter(req, rec), req)
+			m := rec.reply(t)
+
+			expectedIP := "127.0.0.1"
+			if tc.cd {
+				expectedIP = "127.0.0.66"
+			}
+			if ip := m.Answer[0].(*dns.A).A.String(); ip != expectedIP {
+				t.Errorf("Test %d, query %d: expected %s, got %s", i, j, expectedIP, ip)
+			}
+			// RRSIGs only for DO.
+			if sigs := len(m.Answer) - 1; (sigs == 1) != tc.do {
+				t.Errorf("Test %d, query %d: expected RRSIG %t, got %d", i, j, tc.do, sigs)
+			}
+			// AD only for validated answers, and only when the client set DO or AD (RFC 6840, Section 5.7).
+			if ad := !tc.cd && (tc.do || tc.ad); m.AuthenticatedData != ad {
+				t.Errorf("Test %d, query %d: expected AD %t, got %t", i, j, ad, m.AuthenticatedData)
+			}
+			if m.CheckingDisabled != tc.cd {
+				t.Errorf("Test %d, query %d: expected CD %t, got %t", i, j, tc.cd, m.CheckingDisabled)
+			}
+		}
+	}
+	if calls != [2]int{1, 1} {
+		t.Errorf("Expected 1 query with and 1 without CD to the backend, got %v", calls)
+	}
+}
+
 func TestFliterRRSlice(t *testing.T) {
 	rrs := []dns.RR{
 		test.CNAME("invent.example.org.		1781	IN	CNAME	leptone.example.org."),
@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// subnet is the client subnet a response is valid for, as given by the SCOPE PREFIX-LENGTH of the EDNS0
+// Client Subnet (ECS) option in the response (RFC 7871, Section 7.3).
+type subnet struct {
+	family uint16 // 1 for IPv4, 2 for IPv6
+	scope  uint8
+	ip     net.IP // truncated to scope bits
+}
+
+// responseSubnet returns the subnet m is valid for, or nil if m is valid for all clients: when it has no ECS
+// option or the scope is 0. A scope longer than the source prefix is clamped to the source prefix; we don't know
+// anything about the bits that were not sent.
+func responseSubnet(m *dns.Msg) *subnet {
+	ecs := ecsOption(m)
+	if ecs == nil || ecs.SourceScope == 0 {
+		return nil
+	}
+	scope := ecs.SourceScope
+	if scope > ecs.SourceNetmask {
+		scope = ecs.SourceNetmask
+	}
+	return newSubnet(ecs.Family, scope, ecs.Address)
+}
+
+func newSubnet(family uint16, scope uint8, ip net.IP) *subnet {
+	bits := 32
+	if family == 2 {
+		bits = 128
+	} else {
+		ip = ip.To4()
+	}
+	if ip == nil || int(scope) > bits {
+		return nil
+	}
+	return &subnet{family: family, scope: scope, ip: ip.Mask(net.CIDRMask(int(scope), bits))}
+}
+
+// clientSubnet returns the family, source prefix length and address of the client: from the ECS option in
+// the request when there is one, otherwise the client's address is used.
+func clientSubnet(state request.Request) (uint16, uint8, net.IP) {
+	if ecs := ecsOption(state.Req); ecs != nil {
+		return ecs.Family, ecs.SourceNetmask, ecs.Address
+	}
+	ip := net.ParseIP(state.IP())
+	if ip4 := ip.To4(); ip4 != nil {
+		return 1, 32, ip4
+	}
+	return 2, 128, ip
+}
+
+func ecsOption(m *dns.Msg) *dns.EDNS0_SUBNET {
+	o := m.IsEdns0()
+	if o == nil {
+		return nil
+	}
+	for _, e := range o.Option {
+		if ecs, ok := e.(*dns.EDNS0_SUBNET); ok {
+			return ecs
+		}
+	}
+	return nil
+}
+
+// hash returns the key under which the response for qname and qtype for this subnet is stored.
+func (s *subnet) hash(qname string, qtype uint16, cd bool) uint64 {
+	h := fnv.New64()
+	h.Write([]byte{byte(qtype >> 8)})
+	h.Write([]byte{byte(qtype)})
+	if cd {
+		h.Write([]byte{1})
+	}
+	h.Write([]byte(qname))
+	var b [3]byte
+	binary.BigEndian.PutUint16(b[:], s.family)
+	b[2] = s.scope
+	h.Write(b[:])
+	h.Write(s.ip)
+	return h.Sum64()
+}
+
+// option returns the ECS option for s, to be stored in the cache snapshot.
+func (s *subnet) option() *dns.EDNS0_SUBNET {
+	return &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: s.family, SourceNetmask: s.scope, SourceScope: s.scope, Address: s.ip}
+}
+
+// variants holds the subnets for which a response for a qname and qtype has been cached, oldest first.
+type variants struct {
+	sync.Mutex
+	subnets []*subnet
+	keys    []uint64
+}
+
+// addVariant records that the response for qname, qtype and cd is cached for s under key. If this makes more
+// than c.maxVariants subnets for this qname, qtype and cd, the oldest is removed from the cache.
+func (c *Cache) addVariant(qname string, qtype uint16, cd bool, s *subnet, key uint64) {
+	k := hash(qname, qtype, cd)
+	el, ok := c.variants.Get(k)
+	if !ok {
+		el = &variants{}
+		c.variants.Add(k, el)
+	}
+	v := el.(*variants)
+
+	v.Lock()
+	defer v.Unlock()
+	for _, k := range v.keys {
+		if k == key {
+			return
+		}
+	}
+	v.subnets = append(v.subnets, s)
+	v.keys = append(v.keys, key)
+	if len(v.keys) > c.maxVariants {
+		c.pcache.Remove(v.keys[0])
+		c.ncache.Remove(v.keys[0])
+		v.subnets = v.subnets[1:]
+		v.keys = v.keys[1:]
+	}
+}
+
+// variantKeys returns the keys of the cached responses for qname and qtype whose subnet contains the client's
+// subnet, most specific subnet first.
+func (c *Cache) variantKeys(state request.Request) []uint64 {
+	el, ok := c.variants.Get(hash(state.Name(), state.QType(), state.Req.CheckingDisabled))
+	if !ok {
+		return nil
+	}
+	v := el.(*variants)
+
+	family, source, ip := clientSubnet(state)
+
+	v.Lock()
+	defer v.Unlock()
+	var match []int
+	for i, s := range v.subnets {
+		// The scope must not be longer than what the client sent, otherwise we can't tell the subnets apart.
+		if s.family != family || s.scope > source {
+			continue
+		}
+		if cs := newSubnet(family, s.scope, ip); cs != nil && cs.ip.Equal(s.ip) {
+			match = append(match, i)
+		}
+	}
+	sort.Slice(match, func(i, j int) bool { return v.subnets[match[i]].scope > v.subnets[match[j]].scope })
+
+	keys := make([]uint64, len(match))
+	for i := range match {
+		keys[i] = v.keys[match[i]]
+	}
+	return keys
+}
+
+const defaultMaxVariants = 16
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// ecsBackend answers with the client's subnet as the address, and echoes the ECS option with the given scope.
+func ecsBackend(scope uint8, calls *int) plugin.Handler {
+	return plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		*calls++
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Response, m.RecursionAvailable = true, true
+
+		ecs := ecsOption(r)
+		ip := ecs.Address.Mask(net.CIDRMask(int(scope), 32))
+		m.Answer = []dns.RR{test.A("example.org. 300 IN A " + ip.String())}
+
+		o := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+		o.Option = []dns.EDNS0{&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: ecs.Family, SourceNetmask: ecs.SourceNetmask, SourceScope: scope, Address: ecs.Address}}
+		m.Extra = append(m.Extra, o)
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+}
+
+func ecsQuery(subnet string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	if subnet == "" {
+		return m
+	}
+	_, n, _ := net.ParseCIDR(subnet)
+	ones, _ := n.Mask.Size()
+	o := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	o.Option = []dns.EDNS0{&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: uint8(ones), Address: n.IP}}
+	m.Extra = append(m.Extra, o)
+	return m
+}
+
+func TestCacheECS(t *testing.T) {
+	c := New()
+	calls := 0
+	c.Next = ecsBackend(24, &calls)
+
+	tests := []struct {
+		subnet   string
+		expected string
+		cached   bool
+	}{
+		{"10.0.0.0/32", "10.0.0.0", false},
+		{"10.0.0.77/32", "10.0.0.0", true},       // same /24
+		{"10.0.0.0/24", "10.0.0.0", true},        // same /24
+		{"10.0.1.0/24", "10.0.1.0", false},       // other /24
+		{"192.168.1.1/32", "192.168.1.0", false}, // other /24
+		{"10.0.0.0/16", "10.0.0.0", false},       // source prefix shorter than the scope
+		{"10.0.1.1/32", "10.0.1.0", true},
+	}
+
+	for i, tc := range tests {
+		before := calls
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		c.ServeDNS(context.TODO(), rec, ecsQuery(tc.subnet))
+
+		if x := rec.Msg.Answer[0].(*dns.A).A.String(); x != tc.expected {
+			t.Errorf("Test %d: expected %s for %s, got %s", i, tc.expected, tc.subnet, x)
+		}
+		if cached := calls == before; cached != tc.cached {
+			t.Errorf("Test %d: expected cached to be %t for %s", i, tc.cached, tc.subnet)
+		}
+	}
+}
+
+func TestCacheECSScopeZero(t *testing.T) {
+	c := New()
+	calls := 0
+	c.Next = ecsBackend(0, &calls)
+
+	c.ServeDNS(context.TODO(), &test.ResponseWriter{}, ecsQuery("10.0.0.0/24"))
+	c.ServeDNS(context.TODO(), &test.ResponseWriter{}, ecsQuery("192.168.0.0/24"))
+	c.ServeDNS(context.TODO(), &test.ResponseWriter{}, ecsQuery(""))
+	if calls != 1 {
+		t.Errorf("Expected a scope 0 answer to be cached for all clients, got %d backend calls", calls)
+	}
+}
+
+func TestCacheECSNoClientSubnet(t *testing.T) {
+	c := New()
+	calls := 0
+	c.Next = ecsBackend(24, &calls)
+
+	// test.ResponseWriter's client address is 10.240.0.1, this is used when the query has no ECS option.
+	c.ServeDNS(context.TODO(), &test.ResponseWriter{}, ecsQuery("10.240.0.0/24"))
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, ecsQuery(""))
+	if calls != 1 {
+		t.Errorf("Expected the answer for the client's address to be cached, got %d backend calls", calls)
+	}
+	if x := rec.Msg.Answer[0].(*dns.A).A.String(); x != "10.240.0.0" {
+		t.Errorf("Expected %s, got %s", "10.240.0.0", x)
+	}
+}
+
+func TestCacheECSVariants(t *testing.T) {
+	c := New()
+	c.maxVariants = 2
+	calls := 0
+	c.Next = ecsBackend(24, &calls)
+
+	for _, s := range []string{"10.0.1.0/24", "10.0.2.0/24", "10.0.3.0/24", "10.0.1.0/24"} {
+		c.ServeDNS(context.TODO(), &test.ResponseWriter{}, ecsQuery(s))
+	}
+	// 10.0.1.0/24 was removed to make room for 10.0.3.0/24, and fetched again.
+	if calls != 4 {
+		t.Errorf("Expected 4 backend calls, got %d", calls)
+	}
+	if c.pcache.Len() != 2 {
+		t.Errorf("Expected 2 items in the cache, got %d", c.pcache.Len())
+	}
+}
+
+func TestSnapshotECS(t *testing.T) {
+	c := New()
+	calls := 0
+	c.Next = ecsBackend(24, &calls)
+	c.ServeDNS(context.TODO(), &test.ResponseWriter{}, ecsQuery("10.0.1.0/24"))
+
+	buf := &bytes.Buffer{}
+	if _, err := c.writeSnapshot(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	c1 := New()
+	c1.Next = ecsBackend(24, &calls)
+	if _, err := c1.readSnapshot(buf); err != nil {
+		t.Fatal(err)
+	}
+	c1.ServeDNS(context.TODO(), &test.ResponseWriter{}, ecsQuery("10.0.1.1/32"))
+	c1.ServeDNS(context.TODO(), &test.ResponseWriter{}, ecsQuery("10.0.2.1/32"))
+	if calls != 2 {
+		t.Errorf("Expected the subnet to be restored from the snapshot, got %d backend calls", calls)
+	}
+}
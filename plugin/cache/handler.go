@@ -22,6 +22,9 @@ func (c *Cache) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 	if zone == "" {
 		return plugin.NextOrFailure(c.Name(), c.Next, ctx, w, rc)
 	}
+	if r := c.rules.match(state.Name(), state.QType()); r != nil && r.nocache {
+		return plugin.NextOrFailure(c.Name(), c.Next, ctx, w, rc)
+	}
 
 	now := c.now().UTC()
 	server := metrics.WithServer(ctx)
@@ -38,28 +41,34 @@ func (c *Cache) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 	if i != nil {
 		ttl = i.ttl(now)
 	}
+	// A client that sets the CD bit validates the answers itself, and gets the answers of the backend.
+	if i == nil && c.nsec != nil && !r.CheckingDisabled {
+		if resp := c.synthesize(state, now, do, server); resp != nil {
+			w.WriteMsg(resp)
+			return dns.RcodeSuccess, nil
+		}
+	}
 	if i == nil {
 		crr := &ResponseWriter{ResponseWriter: w, Cache: c, state: state, server: server, do: do}
 		return c.doRefresh(ctx, state, crr)
 	}
 	if ttl < 0 {
-		servedStale.WithLabelValues(server).Inc()
-		// Adjust the time to get a 0 TTL in the reply built from a stale item.
-		now = now.Add(time.Duration(ttl) * time.Second)
-		cw := newPrefetchResponseWriter(server, state, c)
-		go c.doPrefetch(ctx, state, cw, i, now)
-	} else if c.shouldPrefetch(i, now) {
+		return c.serveStale(ctx, w, r, state, server, i, now, do)
+	}
+	if c.shouldPrefetch(i, now) {
 		cw := newPrefetchResponseWriter(server, state, c)
 		go c.doPrefetch(ctx, state, cw, i, now)
 	}
-	resp := i.toMsg(r, now, do)
-	w.WriteMsg(resp)
+	if !c.writeWire(w, r, i, now, do) {
+		resp := i.toMsg(r, now, do)
+		w.WriteMsg(resp)
+	}
 
 	return dns.RcodeSuccess, nil
 }
 
 func (c *Cache) doPrefetch(ctx context.Context, state request.Request, cw *ResponseWriter, i *item, now time.Time) {
-	cachePrefetches.WithLabelValues(cw.server).Inc()
+	cachePrefetches.WithLabelValues(cw.server, c.name).Inc()
 	c.doRefresh(ctx, state, cw)
 
 	// When prefetching we loose the item i, and with it the frequency
@@ -78,68 +87,79 @@ func (c *Cache) doRefresh(ctx context.Context, state request.Request, cw *Respon
 }
 
 func (c *Cache) shouldPrefetch(i *item, now time.Time) bool {
-	if c.prefetch <= 0 {
+	amount := c.prefetch
+	if r := c.rules.match(i.Name, i.QType); r != nil && r.prefetch >= 0 {
+		amount = r.prefetch
+	}
+	if amount <= 0 {
 		return false
 	}
 	i.Freq.Update(c.duration, now)
 	threshold := int(math.Ceil(float64(c.percentage) / 100 * float64(i.origTTL)))
-	return i.Freq.Hits() >= c.prefetch && i.ttl(now) <= threshold
+	return i.Freq.Hits() >= amount && i.ttl(now) <= threshold
 }
 
 // Name implements the Handler interface.
 func (c *Cache) Name() string { return "cache" }
 
 func (c *Cache) get(now time.Time, state request.Request, server string) (*item, bool) {
-	k := hash(state.Name(), state.QType())
-	cacheRequests.WithLabelValues(server).Inc()
+	cacheRequests.WithLabelValues(server, c.name).Inc()
 
-	if i, ok := c.ncache.Get(k); ok && i.(*item).ttl(now) > 0 {
-		cacheHits.WithLabelValues(server, Denial).Inc()
-		return i.(*item), true
-	}
+	for _, k := range c.keys(state) {
+		if i, ok := c.ncache.Get(k); ok && i.(*item).ttl(now) > 0 {
+			cacheHits.WithLabelValues(server, Denial, c.name).Inc()
+			return i.(*item), true
+		}
 
-	if i, ok := c.pcache.Get(k); ok && i.(*item).ttl(now) > 0 {
-		cacheHits.WithLabelValues(server, Success).Inc()
-		return i.(*item), true
+		if i, ok := c.pcache.Get(k); ok && i.(*item).ttl(now) > 0 {
+			cacheHits.WithLabelValues(server, Success, c.name).Inc()
+			return i.(*item), true
+		}
 	}
-	cacheMisses.WithLabelValues(server).Inc()
+	cacheMisses.WithLabelValues(server, c.name).Inc()
 	return nil, false
 }
 
 // getIgnoreTTL unconditionally returns an item if it exists in the cache.
 func (c *Cache) getIgnoreTTL(now time.Time, state request.Request, server string) *item {
-	k := hash(state.Name(), state.QType())
-	cacheRequests.WithLabelValues(server).Inc()
-
-	if i, ok := c.ncache.Get(k); ok {
-		ttl := i.(*item).ttl(now)
-		if ttl > 0 || (c.staleUpTo > 0 && -ttl < int(c.staleUpTo.Seconds())) {
-			cacheHits.WithLabelValues(server, Denial).Inc()
-			return i.(*item)
+	cacheRequests.WithLabelValues(server, c.name).Inc()
+
+	for _, k := range c.keys(state) {
+		if i, ok := c.ncache.Get(k); ok {
+			if c.servable(i.(*item), now) {
+				cacheHits.WithLabelValues(server, Denial, c.name).Inc()
+				return i.(*item)
+			}
 		}
-	}
-	if i, ok := c.pcache.Get(k); ok {
-		ttl := i.(*item).ttl(now)
-		if ttl > 0 || (c.staleUpTo > 0 && -ttl < int(c.staleUpTo.Seconds())) {
-			cacheHits.WithLabelValues(server, Success).Inc()
-			return i.(*item)
+		if i, ok := c.pcache.Get(k); ok {
+			if c.servable(i.(*item), now) {
+				cacheHits.WithLabelValues(server, Success, c.name).Inc()
+				return i.(*item)
+			}
 		}
 	}
-	cacheMisses.WithLabelValues(server).Inc()
+	cacheMisses.WithLabelValues(server, c.name).Inc()
 	return nil
 }
 
 func (c *Cache) exists(state request.Request) *item {
-	k := hash(state.Name(), state.QType())
-	if i, ok := c.ncache.Get(k); ok {
-		return i.(*item)
-	}
-	if i, ok := c.pcache.Get(k); ok {
-		return i.(*item)
+	for _, k := range c.keys(state) {
+		if i, ok := c.ncache.Get(k); ok {
+			return i.(*item)
+		}
+		if i, ok := c.pcache.Get(k); ok {
+			return i.(*item)
+		}
 	}
 	return nil
 }
 
+// keys returns the keys an answer for state may be stored under: first the keys of the answers for the
+// client's subnet, most specific first, and then the key of the answer that is valid for all clients.
+func (c *Cache) keys(state request.Request) []uint64 {
+	return append(c.variantKeys(state), hash(state.Name(), state.QType(), state.Req.CheckingDisabled))
+}
+
 // setDo sets the DO bit and UDP buffer size in the message m.
 func setDo(m *dns.Msg) {
 	o := m.IsEdns0()
@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/coredns/coredns/plugin/cache/freq"
@@ -9,24 +11,35 @@ import (
 )
 
 type item struct {
+	Name               string
+	QType              uint16
 	Rcode              int
 	AuthenticatedData  bool
 	RecursionAvailable bool
+	CheckingDisabled   bool // the answer is for a query with the CD bit set
 	Answer             []dns.RR
 	Ns                 []dns.RR
 	Extra              []dns.RR
 
 	origTTL uint32
 	stored  time.Time
+	size    int     // estimated size in bytes
+	subnet  *subnet // the client subnet this item is valid for, nil for all clients
+
+	packed [2]atomic.Value // the packed replies, without and with DO bit, built on first use
 
 	*freq.Freq
 }
 
 func newItem(m *dns.Msg, now time.Time, d time.Duration) *item {
 	i := new(item)
+	i.Name = strings.ToLower(m.Question[0].Name)
+	i.QType = m.Question[0].Qtype
+	i.subnet = responseSubnet(m)
 	i.Rcode = m.Rcode
 	i.AuthenticatedData = m.AuthenticatedData
 	i.RecursionAvailable = m.RecursionAvailable
+	i.CheckingDisabled = m.CheckingDisabled
 	i.Answer = m.Answer
 	i.Ns = m.Ns
 	i.Extra = make([]dns.RR, len(m.Extra))
@@ -41,6 +54,7 @@ func newItem(m *dns.Msg, now time.Time, d time.Duration) *item {
 	}
 	i.Extra = i.Extra[:j]
 
+	i.size = m.Len()
 	i.origTTL = uint32(d.Seconds())
 	i.stored = now.UTC()
 
@@ -64,10 +78,8 @@ func (i *item) toMsg(m *dns.Msg, now time.Time, do bool) *dns.Msg {
 	// This is probably not according to spec, but the bit itself is not super useful as this point, so
 	// just set it to true.
 	m1.Authoritative = true
-	m1.AuthenticatedData = i.AuthenticatedData
-	if !do {
-		m1.AuthenticatedData = false // when DNSSEC was not wanted, it can't be authenticated data.
-	}
+	// The AD bit is only set for clients that asked for DNSSEC or for the AD bit itself (RFC 6840, Section 5.7).
+	m1.AuthenticatedData = i.AuthenticatedData && (do || m.AuthenticatedData)
 	m1.RecursionAvailable = i.RecursionAvailable
 	m1.Rcode = i.Rcode
 
@@ -83,6 +95,14 @@ func (i *item) toMsg(m *dns.Msg, now time.Time, do bool) *dns.Msg {
 	return m1
 }
 
+// key returns the key under which i is stored.
+func (i *item) key() uint64 {
+	if i.subnet != nil {
+		return i.subnet.hash(i.Name, i.QType, i.CheckingDisabled)
+	}
+	return hash(i.Name, i.QType, i.CheckingDisabled)
+}
+
 func (i *item) ttl(now time.Time) int {
 	ttl := int(i.origTTL) - int(now.UTC().Sub(i.stored).Seconds())
 	return ttl
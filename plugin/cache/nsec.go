@@ -0,0 +1,481 @@
+package cache
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// nsecStore holds the NSEC and NSEC3 records from authenticated negative answers, per zone. These are used to
+// synthesize negative (and wildcard) answers for other names they cover, as described in RFC 8198.
+type nsecStore struct {
+	sync.RWMutex
+	zones map[string]*nsecZone
+}
+
+// nsecZone holds the denial of existence records of a zone, sorted in canonical order (NSEC) or in hash order
+// (NSEC3), and the zone's SOA record with its signatures.
+type nsecZone struct {
+	soa       []dns.RR
+	soaExpire time.Time
+	denials   []*denial
+}
+
+// denial is an NSEC or NSEC3 record and its signatures.
+type denial struct {
+	rr     dns.RR
+	sigs   []dns.RR
+	key    string // canonical order key of the owner name, or the owner hash for NSEC3
+	expire time.Time
+}
+
+func newNSECStore() *nsecStore { return &nsecStore{zones: make(map[string]*nsecZone)} }
+
+// add stores the NSEC and NSEC3 records in m, valid for duration. The zone is taken from the SOA record in the
+// authority section, or from the signer name of the signatures.
+func (s *nsecStore) add(m *dns.Msg, now time.Time, duration time.Duration) {
+	var soa []dns.RR
+	zone := ""
+	sigs := map[string][]dns.RR{}
+	for _, r := range m.Ns {
+		switch x := r.(type) {
+		case *dns.SOA:
+			zone = strings.ToLower(x.Hdr.Name)
+			soa = append(soa, x)
+		case *dns.RRSIG:
+			if zone == "" {
+				zone = strings.ToLower(x.SignerName)
+			}
+			switch x.TypeCovered {
+			case dns.TypeNSEC, dns.TypeNSEC3:
+				owner := strings.ToLower(x.Hdr.Name)
+				sigs[owner] = append(sigs[owner], x)
+			case dns.TypeSOA:
+				soa = append(soa, x)
+			}
+		}
+	}
+	if zone == "" {
+		return
+	}
+	expire := now.Add(duration)
+
+	s.Lock()
+	defer s.Unlock()
+
+	z, ok := s.zones[zone]
+	if !ok {
+		if len(s.zones) >= maxNSECZones {
+			for k := range s.zones {
+				delete(s.zones, k)
+				break
+			}
+		}
+		z = &nsecZone{}
+		s.zones[zone] = z
+	}
+	if len(soa) > 0 && soa[0].Header().Rrtype == dns.TypeSOA {
+		z.soa = soa
+		z.soaExpire = expire
+	}
+
+	for _, r := range m.Ns {
+		var key string
+		switch x := r.(type) {
+		case *dns.NSEC:
+			key = canonicalKey(x.Hdr.Name)
+		case *dns.NSEC3:
+			key = strings.ToLower(strings.SplitN(x.Hdr.Name, ".", 2)[0])
+		default:
+			continue
+		}
+		z.insert(&denial{rr: r, sigs: sigs[strings.ToLower(r.Header().Name)], key: key, expire: expire}, now)
+	}
+}
+
+// insert adds d to z, replacing a record with the same owner. Expired records are removed, and when z is full the
+// record that expires first is evicted.
+func (z *nsecZone) insert(d *denial, now time.Time) {
+	j := 0
+	for _, x := range z.denials {
+		if x.expire.After(now) && x.key != d.key && x.rr.Header().Rrtype == d.rr.Header().Rrtype {
+			z.denials[j] = x
+			j++
+		}
+	}
+	z.denials = z.denials[:j]
+	if len(z.denials) >= maxNSECRecords {
+		first := 0
+		for i, x := range z.denials {
+			if x.expire.Before(z.denials[first].expire) {
+				first = i
+			}
+		}
+		z.denials = append(z.denials[:first], z.denials[first+1:]...)
+	}
+	i := sort.Search(len(z.denials), func(i int) bool { return z.denials[i].key >= d.key })
+	z.denials = append(z.denials, nil)
+	copy(z.denials[i+1:], z.denials[i:])
+	z.denials[i] = d
+}
+
+// find returns the record whose owner (key) matches exactly, and the record that precedes key, i.e. the one that
+// may cover it. When key precedes all owners, the last record is returned, as it covers the wrap around.
+func (z *nsecZone) find(key string, now time.Time) (match, prev *denial) {
+	i := sort.Search(len(z.denials), func(i int) bool { return z.denials[i].key > key })
+	if i > 0 && z.denials[i-1].key == key {
+		match = z.denials[i-1]
+		i--
+	}
+	if i == 0 {
+		i = len(z.denials)
+	}
+	if i > 0 {
+		prev = z.denials[i-1]
+	}
+	if match != nil && !match.expire.After(now) {
+		match = nil
+	}
+	if prev != nil && !prev.expire.After(now) {
+		prev = nil
+	}
+	return match, prev
+}
+
+// synthesis is a negative or wildcard answer synthesized from the NSEC(3) records.
+type synthesis struct {
+	rcode    int
+	ns       []dns.RR      // SOA and denial records, with signatures
+	wildcard string        // for a wildcard answer: the wildcard name
+	ttl      time.Duration // remaining TTL of the records used
+}
+
+// synthesize returns a synthesized answer for qname and qtype, or nil if the stored records don't prove
+// anything about qname.
+func (s *nsecStore) synthesize(qname string, qtype uint16, now time.Time) *synthesis {
+	s.RLock()
+	defer s.RUnlock()
+
+	zone, z := s.zone(qname)
+	if z == nil || len(z.denials) == 0 {
+		return nil
+	}
+	if z.denials[0].rr.Header().Rrtype == dns.TypeNSEC3 {
+		return z.synthesizeNSEC3(zone, qname, qtype, now)
+	}
+	return z.synthesizeNSEC(zone, qname, qtype, now)
+}
+
+// purge removes the records of the zones that can deny name: its closest enclosing zone, and with below the zones
+// at or below name too.
+func (s *nsecStore) purge(name string, below bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if zone, _ := s.zone(name); zone != "" {
+		delete(s.zones, zone)
+	}
+	if !below {
+		return
+	}
+	for zone := range s.zones {
+		if dns.IsSubDomain(name, zone) {
+			delete(s.zones, zone)
+		}
+	}
+}
+
+// zone returns the closest enclosing zone of qname that has records stored, looked up label by label.
+func (s *nsecStore) zone(qname string) (string, *nsecZone) {
+	name := strings.ToLower(dns.Fqdn(qname))
+	for {
+		if z, ok := s.zones[name]; ok {
+			return name, z
+		}
+		if name == "." {
+			return "", nil
+		}
+		name = parent(name)
+	}
+}
+
+func (z *nsecZone) synthesizeNSEC(zone, qname string, qtype uint16, now time.Time) *synthesis {
+	match, prev := z.find(canonicalKey(qname), now)
+	if match != nil {
+		if hasType(match.rr, qtype) || hasType(match.rr, dns.TypeCNAME) || (delegation(match.rr) && qtype != dns.TypeDS) {
+			return nil
+		}
+		return z.negative(dns.RcodeSuccess, now, match)
+	}
+	if prev == nil || !coversNSEC(prev.rr.(*dns.NSEC), qname) {
+		return nil
+	}
+	// Names below a delegation or DNAME are not in this zone.
+	if (delegation(prev.rr) || hasType(prev.rr, dns.TypeDNAME)) && dns.IsSubDomain(prev.rr.Header().Name, qname) {
+		return nil
+	}
+
+	// The closest encloser is the longest common ancestor of qname and the owner or next name of the NSEC.
+	nsec := prev.rr.(*dns.NSEC)
+	ce := commonAncestor(qname, nsec.Hdr.Name)
+	if x := commonAncestor(qname, nsec.NextDomain); dns.CountLabel(x) > dns.CountLabel(ce) {
+		ce = x
+	}
+	if !dns.IsSubDomain(zone, ce) {
+		return nil
+	}
+	// The next name is below qname: qname is an empty non-terminal, it exists without any data.
+	if strings.EqualFold(ce, qname) {
+		return z.negative(dns.RcodeSuccess, now, prev)
+	}
+	wildcard := "*." + ce
+
+	wmatch, wprev := z.find(canonicalKey(wildcard), now)
+	if wmatch != nil && !hasType(wmatch.rr, qtype) {
+		if hasType(wmatch.rr, dns.TypeCNAME) {
+			return nil
+		}
+		return z.negative(dns.RcodeSuccess, now, prev, wmatch)
+	}
+	if wmatch == nil && wprev != nil && coversNSEC(wprev.rr.(*dns.NSEC), wildcard) {
+		return z.negative(dns.RcodeNameError, now, prev, wprev)
+	}
+	// The wildcard may exist: the answer can be synthesized if its expansion for qtype is cached.
+	return &synthesis{rcode: dns.RcodeSuccess, ns: prev.records(), wildcard: wildcard, ttl: prev.expire.Sub(now)}
+}
+
+func (z *nsecZone) synthesizeNSEC3(zone, qname string, qtype uint16, now time.Time) *synthesis {
+	params := z.denials[0].rr.(*dns.NSEC3)
+	hash := func(name string) string {
+		return strings.ToLower(dns.HashName(name, params.Hash, params.Iterations, params.Salt))
+	}
+
+	match, _ := z.find(hash(qname), now)
+	if match != nil {
+		if hasType(match.rr, qtype) || hasType(match.rr, dns.TypeCNAME) || (delegation(match.rr) && qtype != dns.TypeDS) {
+			return nil
+		}
+		return z.negative(dns.RcodeSuccess, now, match)
+	}
+
+	// Find the closest encloser, and the next closer name below it. An empty non-terminal has an NSEC3 record of its
+	// own, matched above, unless it's in an opt-out span, which is never used for a NXDOMAIN. So the closest encloser
+	// is never qname here.
+	nc := qname
+	for ce := parent(qname); dns.IsSubDomain(zone, ce); nc, ce = ce, parent(ce) {
+		cmatch, _ := z.find(hash(ce), now)
+		if cmatch == nil {
+			continue
+		}
+		if delegation(cmatch.rr) || hasType(cmatch.rr, dns.TypeDNAME) {
+			return nil
+		}
+		_, ncprev := z.find(hash(nc), now)
+		if ncprev == nil || !coversNSEC3(ncprev.rr.(*dns.NSEC3), nc) {
+			return nil
+		}
+		_, wprev := z.find(hash("*."+ce), now)
+		if wprev == nil || !coversNSEC3(wprev.rr.(*dns.NSEC3), "*."+ce) {
+			return nil
+		}
+		return z.negative(dns.RcodeNameError, now, cmatch, ncprev, wprev)
+	}
+	return nil
+}
+
+// negative returns a negative answer with the zone's SOA and the records ds.
+func (z *nsecZone) negative(rcode int, now time.Time, ds ...*denial) *synthesis {
+	if len(z.soa) == 0 || !z.soaExpire.After(now) {
+		return nil
+	}
+	syn := &synthesis{rcode: rcode, ttl: z.soaExpire.Sub(now)}
+	syn.ns = append(syn.ns, z.soa...)
+	seen := map[*denial]bool{}
+	for _, d := range ds {
+		if seen[d] {
+			continue
+		}
+		seen[d] = true
+		syn.ns = append(syn.ns, d.records()...)
+		if ttl := d.expire.Sub(now); ttl < syn.ttl {
+			syn.ttl = ttl
+		}
+	}
+	return syn
+}
+
+func (d *denial) records() []dns.RR { return append([]dns.RR{d.rr}, d.sigs...) }
+
+// coversNSEC returns true if name falls between the owner and the next name of nsec.
+func coversNSEC(nsec *dns.NSEC, name string) bool {
+	owner, next, key := canonicalKey(nsec.Hdr.Name), canonicalKey(nsec.NextDomain), canonicalKey(name)
+	if owner < next {
+		return owner < key && key < next
+	}
+	// The last NSEC in the zone, next is the apex.
+	return owner < key || key < next
+}
+
+// coversNSEC3 returns true if the hash of name falls between the owner and the next hash of nsec3, and the
+// record doesn't have the opt-out flag set: then we can't tell if name exists.
+func coversNSEC3(nsec3 *dns.NSEC3, name string) bool {
+	return nsec3.Flags&1 == 0 && nsec3.Cover(name)
+}
+
+// delegation returns true if rr is the NSEC(3) record of a delegation point: it has NS but no SOA in its bitmap.
+func delegation(rr dns.RR) bool {
+	return hasType(rr, dns.TypeNS) && !hasType(rr, dns.TypeSOA)
+}
+
+func hasType(rr dns.RR, qtype uint16) bool {
+	var bitmap []uint16
+	switch x := rr.(type) {
+	case *dns.NSEC:
+		bitmap = x.TypeBitMap
+	case *dns.NSEC3:
+		bitmap = x.TypeBitMap
+	}
+	for _, t := range bitmap {
+		if t == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalKey returns a string for name that sorts in the canonical DNS name order of RFC 4034, Section 6.1:
+// the lowercased labels in reverse order.
+func canonicalKey(name string) string {
+	buf := make([]byte, 256)
+	off, err := dns.PackDomainName(dns.Fqdn(name), buf, 0, nil, false)
+	if err != nil {
+		return strings.ToLower(name)
+	}
+	labels := [][]byte{}
+	for i := 0; i < off && buf[i] != 0; i += int(buf[i]) + 1 {
+		labels = append(labels, bytes.ToLower(buf[i+1:i+1+int(buf[i])]))
+	}
+	key := make([]byte, 0, off)
+	for i := len(labels) - 1; i >= 0; i-- {
+		key = append(key, labels[i]...)
+		key = append(key, 0)
+	}
+	return string(key)
+}
+
+func commonAncestor(a, b string) string {
+	n := dns.CompareDomainName(a, b)
+	labels := dns.SplitDomainName(a)
+	return dns.Fqdn(strings.Join(labels[len(labels)-n:], "."))
+}
+
+func parent(name string) string {
+	off, end := dns.NextLabel(name, 0)
+	if end {
+		return "."
+	}
+	return name[off:]
+}
+
+const (
+	maxNSECZones   = 1000
+	maxNSECRecords = 1000
+)
+
+// setWildcard stores the answer in m when it is expanded from a wildcard, under the wildcard name, so that it can
+// be used for other names the wildcard matches. The NSEC records proving qname doesn't exist are stored as well.
+func (w *ResponseWriter) setWildcard(m *dns.Msg, duration time.Duration) {
+	wildcard := wildcardName(m)
+	if wildcard == "" || responseSubnet(m) != nil {
+		return
+	}
+	m1 := m.Copy()
+	m1.Question[0].Name = wildcard
+	for _, r := range m1.Answer {
+		r.Header().Name = wildcard
+	}
+	i := newItem(m1, w.now(), duration)
+	countEvictions(w.server, Success, w.name, w.pcache.AddSize(hash(wildcard, m.Question[0].Qtype, false), i, i.size))
+
+	w.nsec.add(m, w.now(), duration)
+}
+
+// wildcardName returns the wildcard the answer in m is expanded from, or the empty string if it isn't. The
+// signatures of an expanded answer have fewer labels than the owner name (RFC 4035, Section 5.3.4).
+func wildcardName(m *dns.Msg) string {
+	qname := m.Question[0].Name
+	wildcard := ""
+	for _, r := range m.Answer {
+		if !strings.EqualFold(r.Header().Name, qname) {
+			return ""
+		}
+		sig, ok := r.(*dns.RRSIG)
+		if !ok {
+			continue
+		}
+		labels := dns.SplitDomainName(qname)
+		if int(sig.Labels) >= len(labels) {
+			return ""
+		}
+		wildcard = strings.ToLower(dns.Fqdn("*." + strings.Join(labels[len(labels)-int(sig.Labels):], ".")))
+	}
+	return wildcard
+}
+
+// synthesize returns an answer for state synthesized from the cached NSEC(3) records, or nil if there is none.
+func (c *Cache) synthesize(state request.Request, now time.Time, do bool, server string) *dns.Msg {
+	qtype := state.QType()
+	if qtype == dns.TypeDS {
+		// DS records are in the parent zone, which we may not have the records of.
+		return nil
+	}
+	syn := c.nsec.synthesize(state.Name(), qtype, now)
+	if syn == nil {
+		return nil
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(state.Req)
+	m.Authoritative = true
+	m.RecursionAvailable = true
+	m.AuthenticatedData = do || state.Req.AuthenticatedData
+	m.Rcode = syn.rcode
+	ttl := syn.ttl
+
+	t := "nodata"
+	if syn.rcode == dns.RcodeNameError {
+		t = "nxdomain"
+	}
+	if syn.wildcard != "" {
+		el, ok := c.pcache.Get(hash(syn.wildcard, qtype, false))
+		if !ok || el.(*item).ttl(now) <= 0 {
+			return nil
+		}
+		i := el.(*item)
+		if d := time.Duration(i.ttl(now)) * time.Second; d < ttl {
+			ttl = d
+		}
+		for _, r := range i.Answer {
+			r1 := dns.Copy(r)
+			r1.Header().Name = state.QName()
+			m.Answer = append(m.Answer, r1)
+		}
+		t = "wildcard"
+	}
+	for _, r := range syn.ns {
+		m.Ns = append(m.Ns, dns.Copy(r))
+	}
+
+	sec := uint32(ttl.Seconds())
+	m.Answer = filterRRSlice(m.Answer, sec, do, false)
+	m.Ns = filterRRSlice(m.Ns, sec, do, false)
+
+	synthesized.WithLabelValues(server, t, c.name).Inc()
+	return m
+}
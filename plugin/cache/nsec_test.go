@@ -0,0 +1,357 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// nsecZones holds the answers of two signed zones: example.org, and example.net which has a wildcard.
+var nsecZones = map[string]*dns.Msg{
+	"b.example.org.": {
+		MsgHdr: dns.MsgHdr{Rcode: dns.RcodeNameError},
+		Ns: []dns.RR{
+			test.SOA("example.org. 3600 IN SOA ns.example.org. hostmaster.example.org. 1 7200 3600 1209600 3600"),
+			test.RRSIG("example.org. 3600 IN RRSIG SOA 8 2 3600 20300101000000 20200101000000 12345 example.org. c2ln"),
+			test.NSEC("example.org. 3600 IN NSEC a.example.org. NS SOA RRSIG NSEC DNSKEY"),
+			test.RRSIG("example.org. 3600 IN RRSIG NSEC 8 2 3600 20300101000000 20200101000000 12345 example.org. c2ln"),
+			test.NSEC("a.example.org. 3600 IN NSEC d.example.org. A RRSIG NSEC"),
+			test.RRSIG("a.example.org. 3600 IN RRSIG NSEC 8 3 3600 20300101000000 20200101000000 12345 example.org. c2ln"),
+		},
+	},
+	"d.example.org.": {
+		Ns: []dns.RR{
+			test.SOA("example.org. 3600 IN SOA ns.example.org. hostmaster.example.org. 1 7200 3600 1209600 3600"),
+			test.RRSIG("example.org. 3600 IN RRSIG SOA 8 2 3600 20300101000000 20200101000000 12345 example.org. c2ln"),
+			test.NSEC("d.example.org. 3600 IN NSEC f.example.org. A TXT RRSIG NSEC"),
+			test.RRSIG("d.example.org. 3600 IN RRSIG NSEC 8 3 3600 20300101000000 20200101000000 12345 example.org. c2ln"),
+		},
+	},
+	"x.example.net.": {
+		Answer: []dns.RR{
+			test.A("x.example.net. 300 IN A 192.0.2.1"),
+			test.RRSIG("x.example.net. 300 IN RRSIG A 8 2 300 20300101000000 20200101000000 12345 example.net. c2ln"),
+		},
+		Ns: []dns.RR{
+			test.NSEC("*.example.net. 3600 IN NSEC example.net. A RRSIG NSEC"),
+			test.RRSIG("*.example.net. 3600 IN RRSIG NSEC 8 2 3600 20300101000000 20200101000000 12345 example.net. c2ln"),
+		},
+	},
+}
+
+// nsecBackend answers from nsecZones, with the AD bit set to ad.
+func nsecBackend(ad bool, calls *int) plugin.Handler {
+	return plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		*calls++
+		m := new(dns.Msg)
+		if z, ok := nsecZones[r.Question[0].Name]; ok {
+			m = z.Copy()
+		}
+		m.SetRcode(r, m.Rcode)
+		m.Authoritative, m.RecursionAvailable, m.AuthenticatedData = true, true, ad
+		m.Extra = []dns.RR{test.OPT(4096, true)}
+		w.WriteMsg(m)
+		return m.Rcode, nil
+	})
+}
+
+func nsecQuery(c *Cache, qname string, qtype uint16) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion(qname, qtype)
+	req.SetEdns0(4096, true)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, req)
+	return rec.Msg
+}
+
+func TestCacheAggressiveNSEC(t *testing.T) {
+	c := New()
+	c.nsec = newNSECStore()
+	calls := 0
+	c.Next = nsecBackend(true, &calls)
+
+	nsecQuery(c, "b.example.org.", dns.TypeA)
+	nsecQuery(c, "d.example.org.", dns.TypeAAAA)
+	nsecQuery(c, "x.example.net.", dns.TypeA)
+
+	tests := []struct {
+		qname       string
+		qtype       uint16
+		synthesized bool
+		rcode       int
+		answer      string
+	}{
+		{"c.example.org.", dns.TypeA, true, dns.RcodeNameError, ""},
+		{"c.sub.b.example.org.", dns.TypeTXT, true, dns.RcodeNameError, ""},
+		{"d.example.org.", dns.TypeMX, true, dns.RcodeSuccess, ""},
+		{"y.example.net.", dns.TypeA, true, dns.RcodeSuccess, "y.example.net."},
+		{"d.example.org.", dns.TypeTXT, false, 0, ""}, // type exists
+		{"a.example.org.", dns.TypeMX, true, dns.RcodeSuccess, ""},
+		{"e.example.org.", dns.TypeA, true, dns.RcodeNameError, ""},
+		{"g.example.org.", dns.TypeA, false, 0, ""},    // not covered
+		{"y.example.net.", dns.TypeAAAA, false, 0, ""}, // wildcard expansion not cached
+		{"b.example.org.", dns.TypeDS, false, 0, ""},
+	}
+
+	for i, tc := range tests {
+		before := calls
+		m := nsecQuery(c, tc.qname, tc.qtype)
+		if synthesized := calls == before; synthesized != tc.synthesized {
+			t.Errorf("Test %d: expected synthesized to be %t for %s", i, tc.synthesized, tc.qname)
+			continue
+		}
+		if !tc.synthesized {
+			continue
+		}
+		if m.Rcode != tc.rcode || !m.AuthenticatedData {
+			t.Errorf("Test %d: expected rcode %d with AD bit, got %d", i, tc.rcode, m.Rcode)
+		}
+		if tc.answer == "" {
+			if len(m.Answer) != 0 || len(m.Ns) == 0 || m.Ns[0].Header().Rrtype != dns.TypeSOA {
+				t.Errorf("Test %d: expected negative answer with SOA, got %v", i, m)
+			}
+			continue
+		}
+		if len(m.Answer) != 2 || m.Answer[0].Header().Name != tc.answer || len(m.Ns) != 2 {
+			t.Errorf("Test %d: expected wildcard answer for %s with proof, got %v", i, tc.answer, m)
+		}
+	}
+
+	// The expansion is stored under the wildcard, and the stored records are not modified.
+	el, ok := c.pcache.Get(hash("*.example.net.", dns.TypeA, false))
+	if !ok || el.(*item).Answer[0].Header().Name != "*.example.net." {
+		t.Errorf("Expected wildcard expansion to be cached under *.example.net.")
+	}
+	el, ok = c.pcache.Get(hash("x.example.net.", dns.TypeA, false))
+	if !ok || el.(*item).Answer[0].Header().Name != "x.example.net." {
+		t.Errorf("Expected answer for x.example.net. to be left alone")
+	}
+}
+
+func TestCacheAggressiveNSECNoDo(t *testing.T) {
+	c := New()
+	c.nsec = newNSECStore()
+	calls := 0
+	c.Next = nsecBackend(true, &calls)
+	nsecQuery(c, "b.example.org.", dns.TypeA)
+
+	req := new(dns.Msg)
+	req.SetQuestion("c.example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, req)
+
+	if calls != 1 || rec.Msg.Rcode != dns.RcodeNameError {
+		t.Fatalf("Expected synthesized NXDOMAIN, got %d calls and %v", calls, rec.Msg)
+	}
+	if rec.Msg.AuthenticatedData || len(rec.Msg.Ns) != 1 {
+		t.Errorf("Expected only the SOA record and no AD bit, got %v", rec.Msg)
+	}
+}
+
+func TestCacheAggressiveNSECNotAuthenticated(t *testing.T) {
+	c := New()
+	c.nsec = newNSECStore()
+	calls := 0
+	c.Next = nsecBackend(false, &calls)
+
+	nsecQuery(c, "b.example.org.", dns.TypeA)
+	nsecQuery(c, "c.example.org.", dns.TypeA)
+	if calls != 2 {
+		t.Errorf("Expected no synthesis without the AD bit, got %d calls", calls)
+	}
+}
+
+// nsec3Chain returns the NSEC3 records of the names in example.org., with the given flags.
+func nsec3Chain(flags uint8, names ...string) []dns.RR {
+	hashes := []string{}
+	types := map[string][]uint16{}
+	for _, n := range names {
+		h := dns.HashName(n, dns.SHA1, 0, "")
+		hashes = append(hashes, h)
+		types[h] = []uint16{dns.TypeA, dns.TypeRRSIG}
+		if n == "example.org." {
+			types[h] = []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeDNSKEY, dns.TypeNSEC3PARAM}
+		}
+	}
+	sort.Strings(hashes)
+
+	rrs := []dns.RR{test.SOA("example.org. 3600 IN SOA ns.example.org. hostmaster.example.org. 1 7200 3600 1209600 3600")}
+	for i, h := range hashes {
+		rrs = append(rrs, &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: h + ".example.org.", Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 3600},
+			Hash:       dns.SHA1,
+			Flags:      flags,
+			HashLength: 20,
+			NextDomain: hashes[(i+1)%len(hashes)],
+			TypeBitMap: types[h],
+		})
+	}
+	return rrs
+}
+
+func TestNSEC3Synthesis(t *testing.T) {
+	tests := []struct {
+		flags    uint8
+		qname    string
+		qtype    uint16
+		expected int // -1 for no synthesis
+	}{
+		{0, "b.example.org.", dns.TypeA, dns.RcodeNameError},
+		{0, "x.b.example.org.", dns.TypeA, dns.RcodeNameError},
+		{0, "a.example.org.", dns.TypeMX, dns.RcodeSuccess},
+		{0, "a.example.org.", dns.TypeA, -1},
+		{0, "example.com.", dns.TypeA, -1},
+		{1, "b.example.org.", dns.TypeA, -1}, // opt-out
+	}
+
+	now := time.Now()
+	for i, tc := range tests {
+		s := newNSECStore()
+		s.add(&dns.Msg{Ns: nsec3Chain(tc.flags, "example.org.", "a.example.org.", "d.example.org.")}, now, time.Hour)
+
+		syn := s.synthesize(tc.qname, tc.qtype, now)
+		if tc.expected < 0 {
+			if syn != nil {
+				t.Errorf("Test %d: expected no synthesis for %s, got %v", i, tc.qname, syn)
+			}
+			continue
+		}
+		if syn == nil || syn.rcode != tc.expected {
+			t.Errorf("Test %d: expected rcode %d for %s, got %v", i, tc.expected, tc.qname, syn)
+		}
+	}
+
+	// Expired records aren't used.
+	s := newNSECStore()
+	s.add(&dns.Msg{Ns: nsec3Chain(0, "example.org.", "a.example.org.")}, now, time.Hour)
+	if syn := s.synthesize("b.example.org.", dns.TypeA, now.Add(2*time.Hour)); syn != nil {
+		t.Errorf("Expected no synthesis from expired records, got %v", syn)
+	}
+}
+
+func TestNSECEmptyNonTerminal(t *testing.T) {
+	now := time.Now()
+	s := newNSECStore()
+	s.add(&dns.Msg{Ns: []dns.RR{
+		test.SOA("example.com. 3600 IN SOA ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 3600"),
+		test.NSEC("a.example.com. 3600 IN NSEC x.b.example.com. A RRSIG NSEC"),
+	}}, now, time.Hour)
+
+	// b.example.com exists, as x.b.example.com does.
+	syn := s.synthesize("b.example.com.", dns.TypeA, now)
+	if syn == nil || syn.rcode != dns.RcodeSuccess || syn.wildcard != "" {
+		t.Errorf("Expected NODATA for the empty non-terminal, got %v", syn)
+	}
+	syn = s.synthesize("B.Example.COM.", dns.TypeA, now)
+	if syn == nil || syn.rcode != dns.RcodeSuccess || syn.wildcard != "" {
+		t.Errorf("Expected NODATA for the empty non-terminal in another case, got %v", syn)
+	}
+
+	// With NSEC3 the empty non-terminal has a record of its own.
+	s = newNSECStore()
+	s.add(&dns.Msg{Ns: nsec3Chain(0, "example.org.", "a.example.org.", "b.example.org.", "x.b.example.org.")}, now, time.Hour)
+	syn = s.synthesize("b.example.org.", dns.TypeTXT, now)
+	if syn == nil || syn.rcode != dns.RcodeSuccess {
+		t.Errorf("Expected NODATA for the NSEC3 empty non-terminal, got %v", syn)
+	}
+}
+
+func TestNSECStoreZone(t *testing.T) {
+	now := time.Now()
+	s := newNSECStore()
+	s.add(&dns.Msg{Ns: nsec3Chain(0, "example.org.", "a.example.org.")}, now, time.Hour)
+	s.add(&dns.Msg{Ns: []dns.RR{test.SOA("sub.example.org. 3600 IN SOA ns.example.org. hostmaster.example.org. 1 7200 3600 1209600 3600")}}, now, time.Hour)
+
+	tests := []struct {
+		qname    string
+		expected string
+	}{
+		{"b.example.org.", "example.org."},
+		{"x.b.Example.ORG.", "example.org."},
+		{"b.sub.example.org.", "sub.example.org."},
+		{"sub.example.org.", "sub.example.org."},
+		{"example.com.", ""},
+		{".", ""},
+	}
+	for i, tc := range tests {
+		s.RLock()
+		zone, z := s.zone(tc.qname)
+		s.RUnlock()
+		if zone != tc.expected || (z == nil) != (tc.expected == "") {
+			t.Errorf("Test %d: expected zone %q for %s, got %q", i, tc.expected, tc.qname, zone)
+		}
+	}
+}
+
+func TestNSECZoneInsertFull(t *testing.T) {
+	now := time.Now()
+	z := &nsecZone{}
+	for i := 0; i < maxNSECRecords; i++ {
+		rr := &dns.NSEC{Hdr: dns.RR_Header{Name: fmt.Sprintf("n%d.example.org.", i), Rrtype: dns.TypeNSEC}}
+		// n0 expires first.
+		z.insert(&denial{rr: rr, key: canonicalKey(rr.Hdr.Name), expire: now.Add(time.Hour + time.Duration(i)*time.Second)}, now)
+	}
+
+	rr := &dns.NSEC{Hdr: dns.RR_Header{Name: "new.example.org.", Rrtype: dns.TypeNSEC}}
+	z.insert(&denial{rr: rr, key: canonicalKey(rr.Hdr.Name), expire: now.Add(2 * time.Hour)}, now)
+
+	if len(z.denials) != maxNSECRecords {
+		t.Fatalf("Expected %d records, got %d", maxNSECRecords, len(z.denials))
+	}
+	if match, _ := z.find(canonicalKey("new.example.org."), now); match == nil {
+		t.Errorf("Expected the new record to be inserted in a full zone")
+	}
+	if match, _ := z.find(canonicalKey("n0.example.org."), now); match != nil {
+		t.Errorf("Expected the record that expires first to be evicted")
+	}
+}
+
+func TestCanonicalKey(t *testing.T) {
+	// RFC 4034, Section 6.1.
+	names := []string{"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.", "zABC.a.EXAMPLE.", "z.example.", "\\001.z.example.", "*.z.example.", "\\200.z.example."}
+	for i := 1; i < len(names); i++ {
+		if canonicalKey(names[i-1]) >= canonicalKey(names[i]) {
+			t.Errorf("Expected %s to sort before %s", names[i-1], names[i])
+		}
+	}
+	if canonicalKey("A.Example.") != canonicalKey(strings.ToLower("A.Example.")) {
+		t.Error("Expected keys to be case insensitive")
+	}
+}
+
+func TestSetupAggressiveNSEC(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+	}{
+		{"aggressive_nsec", false},
+		{"aggressive_nsec yes", true},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", "cache {\n"+test.input+"\n}")
+		ca, err := cacheParse(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %v: Expected error but found nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if ca.nsec == nil {
+			t.Errorf("Test %v: Expected aggressive NSEC to be enabled", i)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/cache"
+
+	"github.com/miekg/dns"
+)
+
+// A snapshot starts with snapshotMagic and the format version, followed by one record per cached item:
+//
+//	type (1 byte): 0 for success, 1 for denial
+//	stored (8 bytes): time the item was stored, Unix nanoseconds
+//	ttl (4 bytes): the original TTL of the item
+//	length (2 bytes) and the packed message, including the question
+//
+// All integers are big endian. A snapshot with a different version is ignored, this makes it safe to
+// change the format (or the cache key) in a new release.
+const (
+	snapshotMagic   = "CDNSCACH"
+	snapshotVersion = 3
+)
+
+var errSnapshotVersion = errors.New("unsupported snapshot version")
+
+// persist holds the settings for saving the cache to disk.
+type persist struct {
+	file     string
+	interval time.Duration
+	stop     chan bool
+}
+
+// save writes a snapshot of the cache to c.persist.file. The snapshot is written to a temporary file
+// first, and then renamed, so a crash never leaves a truncated snapshot behind.
+func (c *Cache) save() error {
+	tmp, err := os.CreateTemp(filepath.Dir(c.persist.file), filepath.Base(c.persist.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+
+	n, err := c.writeSnapshot(tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.persist.file); err != nil {
+		return err
+	}
+	log.Debugf("Saved %d items to %s", n, c.persist.file)
+	return nil
+}
+
+// load reads the snapshot in c.persist.file into the cache. A missing snapshot is not an error.
+func (c *Cache) load() error {
+	f, err := os.Open(c.persist.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	n, err := c.readSnapshot(f)
+	if err != nil {
+		return err
+	}
+	log.Infof("Loaded %d items from %s", n, c.persist.file)
+	return nil
+}
+
+// writeSnapshot writes all items of the cache to w and returns the number of items written.
+func (c *Cache) writeSnapshot(w io.Writer) (int, error) {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(snapshotMagic)
+	binary.Write(bw, binary.BigEndian, uint16(snapshotVersion))
+
+	n := 0
+	var err error
+	for t, ca := range []*cache.Cache{c.pcache, c.ncache} {
+		ca.Walk(func(items map[uint64]interface{}, key uint64) bool {
+			// Walk only stops the current shard, so keep returning false.
+			if err != nil {
+				return false
+			}
+			el, ok := items[key]
+			if !ok {
+				return true
+			}
+			if err = writeItem(bw, byte(t), el.(*item)); err != nil {
+				return false
+			}
+			n++
+			return true
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return n, bw.Flush()
+}
+
+func writeItem(w *bufio.Writer, t byte, i *item) error {
+	m := new(dns.Msg)
+	m.SetQuestion(i.Name, i.QType)
+	m.Rcode = i.Rcode
+	m.AuthenticatedData = i.AuthenticatedData
+	m.RecursionAvailable = i.RecursionAvailable
+	m.CheckingDisabled = i.CheckingDisabled
+	m.Answer = i.Answer
+	m.Ns = i.Ns
+	m.Extra = i.Extra
+	if i.subnet != nil {
+		// Store the subnet as an ECS option, the slice is capped so we never write into i.Extra.
+		o := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}, Option: []dns.EDNS0{i.subnet.option()}}
+		m.Extra = append(i.Extra[:len(i.Extra):len(i.Extra)], o)
+	}
+	m.Compress = true
+	buf, err := m.Pack()
+	if err != nil {
+		return err
+	}
+
+	var hdr [15]byte
+	hdr[0] = t
+	binary.BigEndian.PutUint64(hdr[1:], uint64(i.stored.UnixNano()))
+	binary.BigEndian.PutUint32(hdr[9:], i.origTTL)
+	binary.BigEndian.PutUint16(hdr[13:], uint16(len(buf)))
+	w.Write(hdr[:])
+	_, err = w.Write(buf)
+	return err
+}
+
+// readSnapshot reads a snapshot from r and adds the items that can still be served to the cache. It
+// returns the number of items added.
+func (c *Cache) readSnapshot(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(snapshotMagic)+2)
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(magic[:len(snapshotMagic)], []byte(snapshotMagic)) {
+		return 0, errors.New("not a cache snapshot")
+	}
+	if v := binary.BigEndian.Uint16(magic[len(snapshotMagic):]); v != snapshotVersion {
+		return 0, fmt.Errorf("%w: %d", errSnapshotVersion, v)
+	}
+
+	now := c.now()
+	n := 0
+	var hdr [15]byte
+	for {
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			if err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		buf := make([]byte, binary.BigEndian.Uint16(hdr[13:]))
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return n, err
+		}
+
+		stored := time.Unix(0, int64(binary.BigEndian.Uint64(hdr[1:])))
+		ttl := time.Duration(binary.BigEndian.Uint32(hdr[9:])) * time.Second
+
+		m := new(dns.Msg)
+		if err := m.Unpack(buf); err != nil || len(m.Question) == 0 {
+			return n, fmt.Errorf("corrupt snapshot item: %v", err)
+		}
+		i := newItem(m, stored, ttl)
+		if !c.servable(i, now) {
+			continue
+		}
+
+		k := i.key()
+		if i.subnet != nil {
+			c.addVariant(i.Name, i.QType, i.CheckingDisabled, i.subnet, k)
+		}
+		switch hdr[0] {
+		case 0:
+			c.pcache.AddSize(k, i, i.size)
+		case 1:
+			c.ncache.AddSize(k, i, i.size)
+		default:
+			return n, fmt.Errorf("corrupt snapshot item: unknown type %d", hdr[0])
+		}
+		n++
+	}
+}
+
+// servable returns true if i can still be served, possibly as a stale item.
+func (c *Cache) servable(i *item, now time.Time) bool {
+	ttl := i.ttl(now)
+	return ttl > 0 || (c.staleUpTo > 0 && -ttl < int(c.staleUpTo.Seconds()))
+}
+
+// periodicSave saves the cache every c.persist.interval until stopped.
+func (c *Cache) periodicSave() {
+	tick := time.NewTicker(c.persist.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-c.persist.stop:
+			return
+		case <-tick.C:
+			if err := c.save(); err != nil {
+				log.Warningf("Failed to save cache to %s: %s", c.persist.file, err)
+			}
+		}
+	}
+}
+
+// OnStartup loads the snapshot and starts saving the cache periodically.
+func (c *Cache) OnStartup() error {
+	if c.persist == nil {
+		return nil
+	}
+	if err := c.load(); err != nil {
+		// A snapshot we can't read is not fatal, we just start with an empty cache.
+		log.Warningf("Ignoring cache snapshot %s: %s", c.persist.file, err)
+	}
+	if c.persist.interval > 0 {
+		c.persist.stop = make(chan bool)
+		go c.periodicSave()
+	}
+	return nil
+}
+
+// OnShutdown stops the periodic saves and saves the cache a final time.
+func (c *Cache) OnShutdown() error {
+	if c.persist == nil {
+		return nil
+	}
+	if c.persist.stop != nil {
+		close(c.persist.stop)
+		c.persist.stop = nil
+	}
+	if err := c.save(); err != nil {
+		log.Warningf("Failed to save cache to %s: %s", c.persist.file, err)
+	}
+	return nil
+}
+
+const defaultPersistInterval = 5 * time.Minute
@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestSnapshot(t *testing.T) {
+	c := New()
+	c.Next = BackendHandler()
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for _, name := range []string{"a.example.org.", "b.example.org."} {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		c.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
+	}
+	c.Next = nxDomainBackend(60)
+	req := new(dns.Msg)
+	req.SetQuestion("nx.example.org.", dns.TypeA)
+	c.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
+
+	buf := &bytes.Buffer{}
+	if n, err := c.writeSnapshot(buf); err != nil || n != 3 {
+		t.Fatalf("Expected 3 items written, got %d: %v", n, err)
+	}
+	snapshot := buf.Bytes()
+
+	// A minute later the negative item (TTL 60) has expired, the positive ones (TTL 303) are still good.
+	c1 := New()
+	c1.Next = nxDomainBackend(60) // must not be called
+	c1.now = func() time.Time { return now.Add(time.Minute) }
+	if n, err := c1.readSnapshot(bytes.NewReader(snapshot)); err != nil || n != 2 {
+		t.Fatalf("Expected 2 items read, got %d: %v", n, err)
+	}
+
+	req = new(dns.Msg)
+	req.SetQuestion("a.example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c1.ServeDNS(context.TODO(), rec, req)
+	if rec.Msg.Rcode != dns.RcodeSuccess || len(rec.Msg.Answer) != 1 {
+		t.Fatalf("Expected cached answer, got %v", rec.Msg)
+	}
+	if ttl := rec.Msg.Answer[0].Header().Ttl; ttl != 303-60 {
+		t.Errorf("Expected TTL %d, got %d", 303-60, ttl)
+	}
+
+	// With serve_stale the expired negative item is loaded as well.
+	c2 := New()
+	c2.staleUpTo = time.Hour
+	c2.now = func() time.Time { return now.Add(time.Minute) }
+	if n, err := c2.readSnapshot(bytes.NewReader(snapshot)); err != nil || n != 3 {
+		t.Fatalf("Expected 3 items read, got %d: %v", n, err)
+	}
+
+	// A snapshot with another version is ignored.
+	old := append([]byte{}, snapshot...)
+	old[len(snapshotMagic)+1]++
+	if _, err := New().readSnapshot(bytes.NewReader(old)); !errors.Is(err, errSnapshotVersion) {
+		t.Errorf("Expected %s, got %v", errSnapshotVersion, err)
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	c := New()
+	c.persist = &persist{file: file}
+	if err := c.OnStartup(); err != nil {
+		t.Fatalf("Expected no error for a missing snapshot, got %s", err)
+	}
+	c.Next = BackendHandler()
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	c.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
+	c.OnShutdown()
+
+	c1 := New()
+	c1.persist = &persist{file: file}
+	c1.OnStartup()
+	if c1.pcache.Len() != 1 {
+		t.Errorf("Expected 1 item loaded from %s, got %d", file, c1.pcache.Len())
+	}
+
+	// A corrupt snapshot is ignored.
+	os.WriteFile(file, []byte("garbage"), 0644)
+	c2 := New()
+	c2.persist = &persist{file: file}
+	if err := c2.OnStartup(); err != nil {
+		t.Errorf("Expected no error for a corrupt snapshot, got %s", err)
+	}
+}
+
+func TestSetupPersist(t *testing.T) {
+	tests := []struct {
+		input            string
+		shouldErr        bool
+		expectedFile     string
+		expectedInterval time.Duration
+	}{
+		{"persist /var/lib/coredns/cache", false, "/var/lib/coredns/cache", defaultPersistInterval},
+		{"persist /var/lib/coredns/cache 1m", false, "/var/lib/coredns/cache", time.Minute},
+		{"persist /var/lib/coredns/cache 0", false, "/var/lib/coredns/cache", 0},
+		// fails
+		{"persist", true, "", 0},
+		{"persist /var/lib/coredns/cache -1m", true, "", 0},
+		{"persist /var/lib/coredns/cache 1m 2m", true, "", 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %v: Expected error but found nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if ca.persist.file != test.expectedFile || ca.persist.interval != test.expectedInterval {
+			t.Errorf("Test %v: Expected %s every %s, got %s every %s", i, test.expectedFile, test.expectedInterval, ca.persist.file, ca.persist.interval)
+		}
+	}
+}
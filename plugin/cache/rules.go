@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+	"github.com/coredns/coredns/plugin/pkg/response"
+
+	"github.com/miekg/dns"
+)
+
+// rule overrides the TTL bounds and prefetch settings of the cache for the names and types it matches.
+type rule struct {
+	minTTL   time.Duration // -1 when not set
+	maxTTL   time.Duration // -1 when not set
+	prefetch int           // -1 when not set
+	nocache  bool
+}
+
+func newRule() *rule { return &rule{minTTL: -1, maxTTL: -1, prefetch: -1} }
+
+// minimalTTL returns the minimal TTL of m, allowing for a maximum TTL of r beyond the default maximum.
+func (r *rule) minimalTTL(m *dns.Msg, mt response.Type) time.Duration {
+	if r != nil && r.maxTTL > dnsutil.MaximumDefaulTTL {
+		return dnsutil.MinimalTTLUpTo(m, mt, r.maxTTL)
+	}
+	return dnsutil.MinimalTTL(m, mt)
+}
+
+// computeTTL is computeTTL with the TTL bounds of r, when set, instead of minTTL and maxTTL.
+func (r *rule) computeTTL(msgTTL, minTTL, maxTTL time.Duration) time.Duration {
+	if r != nil && r.minTTL >= 0 {
+		minTTL = r.minTTL
+	}
+	if r != nil && r.maxTTL >= 0 {
+		maxTTL = r.maxTTL
+	}
+	return computeTTL(msgTTL, minTTL, maxTTL)
+}
+
+// rules holds the rules in a tree of labels, starting at the root, so finding the rule for a name only walks
+// the labels of that name.
+type rules struct {
+	root *ruleNode
+}
+
+type ruleNode struct {
+	children map[string]*ruleNode
+	zone     ruleSet // rules for the name and all names below it
+	sub      ruleSet // rules for the names below it only, i.e. *.name
+}
+
+// ruleSet holds the rules for specific types, and the rule for all other types.
+type ruleSet struct {
+	types map[uint16]*rule
+	any   *rule
+}
+
+func (s *ruleSet) get(qtype uint16) *rule {
+	if r, ok := s.types[qtype]; ok {
+		return r
+	}
+	return s.any
+}
+
+func (s *ruleSet) add(types []uint16, r *rule) error {
+	if len(types) == 0 {
+		if s.any != nil {
+			return errDuplicateRule
+		}
+		s.any = r
+		return nil
+	}
+	if s.types == nil {
+		s.types = make(map[uint16]*rule)
+	}
+	for _, t := range types {
+		if _, ok := s.types[t]; ok {
+			return errDuplicateRule
+		}
+		s.types[t] = r
+	}
+	return nil
+}
+
+// add adds the rule r for name, which must be normalized, and the names below it, for types, or for all types
+// when types is empty. A name starting with "*." only matches the names below it.
+func (rs *rules) add(name string, types []uint16, r *rule) error {
+	if rs.root == nil {
+		rs.root = &ruleNode{}
+	}
+	sub := strings.HasPrefix(name, "*.")
+	if sub {
+		name = name[2:]
+	}
+	n := rs.root
+	for end := len(name) - 1; end > 0; {
+		start := strings.LastIndexByte(name[:end], '.') + 1
+		label := name[start:end]
+		child, ok := n.children[label]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[string]*ruleNode)
+			}
+			child = &ruleNode{}
+			n.children[label] = child
+		}
+		n = child
+		end = start - 1
+	}
+	set := &n.zone
+	if sub {
+		set = &n.sub
+	}
+	if err := set.add(types, r); err != nil {
+		return fmt.Errorf("%s for %s", err, name)
+	}
+	return nil
+}
+
+// match returns the rule for qname, which must be lowercased and fully qualified, and qtype, or nil if there is
+// none. The rule for the longest matching name wins, and for the same name the rule for qtype.
+func (rs *rules) match(qname string, qtype uint16) *rule {
+	if rs.root == nil {
+		return nil
+	}
+	n := rs.root
+	best := n.zone.get(qtype)
+	for end := len(qname) - 1; end > 0; {
+		// There's a label below n, so its rules for the names below it apply.
+		if r := n.sub.get(qtype); r != nil {
+			best = r
+		}
+		start := strings.LastIndexByte(qname[:end], '.') + 1
+		n = n.children[qname[start:end]]
+		if n == nil {
+			break
+		}
+		if r := n.zone.get(qtype); r != nil {
+			best = r
+		}
+		end = start - 1
+	}
+	return best
+}
+
+var errDuplicateRule = errors.New("duplicate rule")
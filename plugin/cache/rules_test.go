@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestRulesMatch(t *testing.T) {
+	rs := rules{}
+	add := func(name string, types []uint16, max int) {
+		r := newRule()
+		r.maxTTL = time.Duration(max) * time.Second
+		if err := rs.add(name, types, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("example.", nil, 1)
+	add("example.", []uint16{dns.TypeMX}, 2)
+	add("cdn.example.", []uint16{dns.TypeA, dns.TypeAAAA}, 3)
+	add("*.cdn.example.", []uint16{dns.TypeA}, 4)
+	add("a.b.c.example.", nil, 5)
+
+	tests := []struct {
+		qname    string
+		qtype    uint16
+		expected int // max TTL of the rule, -1 for no rule
+	}{
+		{"example.", dns.TypeA, 1},
+		{"example.", dns.TypeMX, 2},
+		{"www.example.", dns.TypeMX, 2},
+		{"cdn.example.", dns.TypeA, 3},
+		{"cdn.example.", dns.TypeAAAA, 3},
+		{"cdn.example.", dns.TypeMX, 2},
+		{"cdn.example.", dns.TypeTXT, 1},
+		{"x.cdn.example.", dns.TypeA, 4},
+		{"x.y.cdn.example.", dns.TypeA, 4},
+		{"x.cdn.example.", dns.TypeAAAA, 3},
+		{"b.c.example.", dns.TypeA, 1},
+		{"a.b.c.example.", dns.TypeA, 5},
+		{"z.a.b.c.example.", dns.TypeMX, 5},
+		{"example.org.", dns.TypeA, -1},
+		{"org.", dns.TypeA, -1},
+		{".", dns.TypeNS, -1},
+	}
+	for i, tc := range tests {
+		r := rs.match(tc.qname, tc.qtype)
+		got := -1
+		if r != nil {
+			got = int(r.maxTTL.Seconds())
+		}
+		if got != tc.expected {
+			t.Errorf("Test %d: expected rule %d for %s %s, got %d", i, tc.expected, tc.qname, dns.TypeToString[tc.qtype], got)
+		}
+	}
+
+	if r := (&rules{}).match("example.", dns.TypeA); r != nil {
+		t.Errorf("Expected no rule without rules, got %v", r)
+	}
+	root := rules{}
+	root.add(".", nil, newRule())
+	if r := root.match("example.org.", dns.TypeA); r == nil {
+		t.Errorf("Expected root rule to match everything")
+	}
+}
+
+func TestSetupRules(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+	}{
+		{"rule cdn.example A AAAA max 30", false},
+		{"rule *.cdn.example. a max 30\nrule example. MX max 86400", false},
+		{"rule example. min 60 max 600 prefetch 5", false},
+		{"rule tracking.example. nocache", false},
+		{"rule example. prefetch 0", false},
+		{"rule example. A max 30\nrule example. max 30", false},
+		// fails
+		{"rule example.", true},
+		{"rule example. A", true},
+		{"rule example. max", true},
+		{"rule example. max -1", true},
+		{"rule example. max 30s", true},
+		{"rule example. min 60 max 30", true},
+		{"rule example. max 30 A", true},
+		{"rule example. A max 30\nrule example. A AAAA max 60", true},
+		{"rule example. nocache\nrule example. max 60", true},
+	}
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", tc.input))
+		_, err := cacheParse(c)
+		if tc.shouldErr && err == nil {
+			t.Errorf("Test %d: expected error but found nil", i)
+		}
+		if !tc.shouldErr && err != nil {
+			t.Errorf("Test %d: expected no error but found: %s", i, err)
+		}
+	}
+}
+
+func TestCacheRules(t *testing.T) {
+	c, err := cacheParse(caddy.NewTestController("dns", `cache . {
+		rule *.cdn.example. A max 30
+		rule example. MX max 86400
+		rule nocache.example. nocache
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	c.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		calls++
+		m := new(dns.Msg)
+		m.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 86400 IN " + dns.TypeToString[r.Question[0].Qtype] + " 10 mx.example.")
+		if r.Question[0].Qtype == dns.TypeA {
+			rr = test.A(r.Question[0].Name + " 86400 IN A 127.0.0.1")
+		}
+		m.Answer = []dns.RR{rr}
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+
+	tests := []struct {
+		qname string
+		qtype uint16
+		ttl   uint32
+		calls int // backend calls after the second query
+	}{
+		{"www.cdn.example.", dns.TypeA, 30, 1},
+		{"example.", dns.TypeMX, 86400, 1},
+		{"www.example.", dns.TypeA, uint32(maxTTL.Seconds()), 1},
+		{"www.nocache.example.", dns.TypeA, 86400, 2},
+	}
+	for i, tc := range tests {
+		calls = 0
+		for j := 0; j < 2; j++ {
+			req := new(dns.Msg)
+			req.SetQuestion(tc.qname, tc.qtype)
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			c.ServeDNS(context.TODO(), rec, req)
+			if ttl := rec.Msg.Answer[0].Header().Ttl; ttl != tc.ttl {
+				t.Errorf("Test %d, query %d: expected TTL %d, got %d", i, j, tc.ttl, ttl)
+			}
+		}
+		if calls != tc.calls {
+			t.Errorf("Test %d: expected %d calls to the backend, got %d", i, tc.calls, calls)
+		}
+	}
+}
@@ -3,7 +3,10 @@ package cache
 import (
 	"errors"
 	"fmt"
+	"net"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coredns/caddy"
@@ -11,6 +14,8 @@ import (
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/pkg/cache"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
+
+	"github.com/miekg/dns"
 )
 
 var log = clog.NewWithPlugin("cache")
@@ -22,11 +27,35 @@ func setup(c *caddy.Controller) error {
 	if err != nil {
 		return plugin.Error("cache", err)
 	}
+	if ca.name != "" {
+		if err := share(c, ca); err != nil {
+			return plugin.Error("cache", err)
+		}
+	}
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		ca.Next = next
 		return ca
 	})
 
+	c.OnStartup(ca.OnStartup)
+	c.OnShutdown(ca.OnShutdown)
+
+	server := strings.Join(c.ServerBlockKeys, " ")
+	c.OnStartup(func() error { instances.add(server, ca); return nil })
+	c.OnShutdown(func() error { instances.remove(server, ca); return nil })
+
+	if a := ca.admin; a != nil {
+		uniqAddr.Set(a.Addr, a.onStartup)
+		c.OnStartup(func() error { uniqAddr.Set(a.Addr, a.onStartup); return nil })
+		c.OnRestartFailed(func() error { uniqAddr.Set(a.Addr, a.onStartup); return nil })
+
+		c.OnStartup(func() error { return uniqAddr.ForEach() })
+		c.OnRestartFailed(func() error { return uniqAddr.ForEach() })
+
+		c.OnRestart(a.onFinalShutdown)
+		c.OnFinalShutdown(a.onFinalShutdown)
+	}
+
 	return nil
 }
 
@@ -165,11 +194,11 @@ func cacheParse(c *caddy.Controller) (*Cache, error) {
 
 			case "serve_stale":
 				args := c.RemainingArgs()
-				if len(args) > 1 {
+				if len(args) > 2 {
 					return nil, c.ArgErr()
 				}
 				ca.staleUpTo = 1 * time.Hour
-				if len(args) == 1 {
+				if len(args) > 0 {
 					d, err := time.ParseDuration(args[0])
 					if err != nil {
 						return nil, err
@@ -179,15 +208,243 @@ func cacheParse(c *caddy.Controller) (*Cache, error) {
 					}
 					ca.staleUpTo = d
 				}
+				if len(args) > 1 {
+					switch args[1] {
+					case "immediate":
+						ca.staleVerify = false
+					case "verify":
+						ca.staleVerify = true
+					default:
+						return nil, fmt.Errorf("invalid value for serve_stale refresh mode: %s", args[1])
+					}
+				}
+			case "stale_timeout", "stale_recheck", "stale_ttl":
+				option := c.Val()
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if d < 0 {
+					return nil, fmt.Errorf("invalid negative duration for %s", option)
+				}
+				switch option {
+				case "stale_timeout":
+					ca.staleTimeout = d
+				case "stale_recheck":
+					ca.staleRecheck = d
+				case "stale_ttl":
+					ca.staleTTL = d
+				}
+			case "stale_on_error":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				ca.staleOnError = true
+				ca.staleVerify = true
+			case "ecs_variants":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				n, err := strconv.Atoi(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if n < 1 {
+					return nil, fmt.Errorf("ecs_variants should be positive: %d", n)
+				}
+				ca.maxVariants = n
+			case "eviction":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				switch args[0] {
+				case "random":
+					ca.policy = cache.Random
+				case "lru":
+					ca.policy = cache.LRU
+				case "tinylfu":
+					ca.policy = cache.TinyLFU
+				default:
+					return nil, fmt.Errorf("unknown eviction policy: %s", args[0])
+				}
+			case "max_bytes":
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 {
+					return nil, c.ArgErr()
+				}
+				pbytes, err := parseBytes(args[0])
+				if err != nil {
+					return nil, err
+				}
+				ca.pbytes, ca.nbytes = pbytes, pbytes
+				if len(args) > 1 {
+					nbytes, err := parseBytes(args[1])
+					if err != nil {
+						return nil, err
+					}
+					ca.nbytes = nbytes
+				}
+			case "admin":
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 {
+					return nil, c.ArgErr()
+				}
+				if _, _, err := net.SplitHostPort(args[0]); err != nil {
+					return nil, err
+				}
+				ca.admin = &admin{Addr: args[0]}
+				if len(args) > 1 {
+					ca.admin.token = args[1]
+				}
+			case "persist":
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 {
+					return nil, c.ArgErr()
+				}
+				p := &persist{file: args[0], interval: defaultPersistInterval}
+				if root := dnsserver.GetConfig(c).Root; !filepath.IsAbs(p.file) && root != "" {
+					p.file = filepath.Join(root, p.file)
+				}
+				if len(args) > 1 {
+					d, err := time.ParseDuration(args[1])
+					if err != nil {
+						return nil, err
+					}
+					if d < 0 {
+						return nil, errors.New("invalid negative duration for persist")
+					}
+					p.interval = d
+				}
+				ca.persist = p
+			case "rule":
+				args := c.RemainingArgs()
+				if len(args) < 2 {
+					return nil, c.ArgErr()
+				}
+				name, types, r, err := parseRule(args)
+				if err != nil {
+					return nil, err
+				}
+				if err := ca.rules.add(name, types, r); err != nil {
+					return nil, err
+				}
+			case "name":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				ca.name = args[0]
+			case "aggressive_nsec":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				ca.nsec = newNSECStore()
 			default:
 				return nil, c.ArgErr()
 			}
 		}
 
 		ca.Zones = origins
-		ca.pcache = cache.New(ca.pcap)
-		ca.ncache = cache.New(ca.ncap)
+		ca.pcache = cache.NewWithPolicy(ca.pcap, ca.pbytes, ca.policy)
+		ca.ncache = cache.NewWithPolicy(ca.ncap, ca.nbytes, ca.policy)
+		ca.variants = cache.New(ca.pcap)
+		ca.failures = cache.New(ca.pcap)
 	}
 
 	return ca, nil
 }
+
+// parseRule parses the arguments of a rule: NAME [TYPE...] [min TTL] [max TTL] [prefetch AMOUNT] [nocache].
+func parseRule(args []string) (string, []uint16, *rule, error) {
+	name := args[0]
+	sub := strings.HasPrefix(name, "*.")
+	if sub {
+		name = name[2:]
+	}
+	name = plugin.Name(name).Normalize()
+	if sub {
+		name = "*." + name
+	}
+
+	var types []uint16
+	args = args[1:]
+	for len(args) > 0 {
+		t, ok := dns.StringToType[strings.ToUpper(args[0])]
+		if !ok {
+			break
+		}
+		types = append(types, t)
+		args = args[1:]
+	}
+
+	r := newRule()
+	for len(args) > 0 {
+		switch args[0] {
+		case "min", "max", "prefetch":
+			if len(args) < 2 {
+				return "", nil, nil, fmt.Errorf("missing value for %s in rule for %s", args[0], name)
+			}
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return "", nil, nil, err
+			}
+			if n < 0 {
+				return "", nil, nil, fmt.Errorf("%s can not be negative: %d", args[0], n)
+			}
+			switch args[0] {
+			case "min":
+				r.minTTL = time.Duration(n) * time.Second
+			case "max":
+				r.maxTTL = time.Duration(n) * time.Second
+			case "prefetch":
+				r.prefetch = n
+			}
+			args = args[2:]
+		case "nocache":
+			r.nocache = true
+			args = args[1:]
+		default:
+			return "", nil, nil, fmt.Errorf("unknown type or property in rule for %s: %s", name, args[0])
+		}
+	}
+	if r.minTTL >= 0 && r.maxTTL >= 0 && r.minTTL > r.maxTTL {
+		return "", nil, nil, fmt.Errorf("min TTL %s is larger than max TTL %s in rule for %s", r.minTTL, r.maxTTL, name)
+	}
+	if *r == *newRule() {
+		return "", nil, nil, fmt.Errorf("rule for %s doesn't change anything", name)
+	}
+	return name, types, r, nil
+}
+
+// parseBytes parses a size in bytes, with an optional K, M or G suffix (powers of 1024).
+func parseBytes(s string) (int, error) {
+	if s == "" {
+		return 0, errors.New("empty size")
+	}
+	mult := 1
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("size should be positive: %d", n)
+	}
+	return n * mult, nil
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/cache"
 )
 
 func TestSetup(t *testing.T) {
@@ -150,3 +151,76 @@ func TestServeStale(t *testing.T) {
 		}
 	}
 }
+
+func TestSetupECSVariants(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		expected  int
+	}{
+		{"", false, defaultMaxVariants},
+		{"ecs_variants 4", false, 4},
+		// fails
+		{"ecs_variants", true, 0},
+		{"ecs_variants 0", true, 0},
+		{"ecs_variants many", true, 0},
+		{"ecs_variants 1 2", true, 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %v: Expected error but found nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if ca.maxVariants != test.expected {
+			t.Errorf("Test %v: Expected %d variants but found: %d", i, test.expected, ca.maxVariants)
+		}
+	}
+}
+
+func TestSetupEviction(t *testing.T) {
+	tests := []struct {
+		input          string
+		shouldErr      bool
+		expectedPolicy cache.Policy
+		expectedPbytes int
+		expectedNbytes int
+	}{
+		{"", false, cache.Random, 0, 0},
+		{"eviction lru", false, cache.LRU, 0, 0},
+		{"eviction tinylfu\nmax_bytes 64M", false, cache.TinyLFU, 64 << 20, 64 << 20},
+		{"max_bytes 1G 512k", false, cache.Random, 1 << 30, 512 << 10},
+		{"max_bytes 4096", false, cache.Random, 4096, 4096},
+		// fails
+		{"eviction", true, cache.Random, 0, 0},
+		{"eviction lfu", true, cache.Random, 0, 0},
+		{"max_bytes", true, cache.Random, 0, 0},
+		{"max_bytes 0", true, cache.Random, 0, 0},
+		{"max_bytes 10X", true, cache.Random, 0, 0},
+		{"max_bytes 1M 1M 1M", true, cache.Random, 0, 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %v: Expected error but found nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if ca.policy != test.expectedPolicy || ca.pbytes != test.expectedPbytes || ca.nbytes != test.expectedNbytes {
+			t.Errorf("Test %v: Expected %d %d %d, got %d %d %d", i, test.expectedPolicy, test.expectedPbytes, test.expectedNbytes, ca.policy, ca.pbytes, ca.nbytes)
+		}
+	}
+}
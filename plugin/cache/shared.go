@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"fmt"
+
+	"github.com/coredns/caddy"
+)
+
+// sharedKey is the key under which a named cache is stored in the caddy instance.
+type sharedKey string
+
+// shared holds the caches that are shared between server blocks under one name.
+type shared struct {
+	first *Cache     // the first cache with this name, which defines the capacity
+	nsec  *nsecStore // shared by the caches that have aggressive_nsec enabled
+
+	persisted bool // one of the caches saves the items to disk
+}
+
+// share makes ca use the caches of the first cache with the same name in this caddy instance, so a reload
+// starts with new caches, like it does for unnamed caches. Only the caches are shared: zones, TTLs and all
+// other settings stay per server block.
+func share(c *caddy.Controller, ca *Cache) error {
+	key := sharedKey(ca.name)
+	s, ok := c.Get(key).(*shared)
+	if !ok {
+		c.Set(key, &shared{first: ca, nsec: ca.nsec, persisted: ca.persist != nil})
+		return nil
+	}
+	return s.add(ca)
+}
+
+func (s *shared) add(ca *Cache) error {
+	f := s.first
+	if ca.pcap != f.pcap || ca.ncap != f.ncap || ca.pbytes != f.pbytes || ca.nbytes != f.nbytes || ca.policy != f.policy {
+		return fmt.Errorf("cache %q is already defined with a different capacity or eviction policy", ca.name)
+	}
+	if ca.persist != nil {
+		if s.persisted {
+			return fmt.Errorf("cache %q is already persisted by another server block", ca.name)
+		}
+		s.persisted = true
+	}
+
+	ca.pcache, ca.ncache = f.pcache, f.ncache
+	ca.variants, ca.failures = f.variants, f.failures
+	if ca.nsec != nil {
+		if s.nsec == nil {
+			s.nsec = ca.nsec
+		}
+		ca.nsec = s.nsec
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestShare(t *testing.T) {
+	tests := []struct {
+		first     string
+		second    string
+		shouldErr bool
+	}{
+		{"name shared", "name shared", false},
+		{"name shared\nsuccess 100", "name shared\nsuccess 100\nprefetch 10", false},
+		{"name shared\naggressive_nsec", "name shared", false},
+		{"name shared\npersist cache.snap", "name shared", false},
+		// fails
+		{"name shared\nsuccess 100", "name shared", true},
+		{"name shared", "name shared\neviction lru", true},
+		{"name shared", "name shared\nmax_bytes 1M", true},
+		{"name shared\npersist a.snap", "name shared\npersist b.snap", true},
+	}
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", "cache example.org {\n"+tc.first+"\n}")
+		first, err := cacheParse(c)
+		if err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		if err := share(c, first); err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+
+		second, err := cacheParse(caddy.NewTestController("dns", "cache example.net {\n"+tc.second+"\n}"))
+		if err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		// share the second cache in the same caddy instance as the first.
+		err = c.Get(sharedKey("shared")).(*shared).add(second)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error, got nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error, got %s", i, err)
+			continue
+		}
+		if first.pcache != second.pcache || first.ncache != second.ncache || first.failures != second.failures {
+			t.Errorf("Test %d: expected caches to be shared", i)
+		}
+		if second.Zones[0] != "example.net." {
+			t.Errorf("Test %d: expected zones to stay per server block, got %v", i, second.Zones)
+		}
+		if first.nsec == nil && second.nsec != nil {
+			t.Errorf("Test %d: expected aggressive NSEC to stay per server block", i)
+		}
+	}
+}
+
+func TestShareServe(t *testing.T) {
+	c := caddy.NewTestController("dns", "cache . {\nname shared\n}")
+	first, _ := cacheParse(c)
+	share(c, first)
+	second, _ := cacheParse(caddy.NewTestController("dns", "cache . {\nname shared\n}"))
+	c.Get(sharedKey("shared")).(*shared).add(second)
+
+	calls := 0
+	first.Next = test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		calls++
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{test.A("example.org. 300 IN A 127.0.0.1")}
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+	second.Next = first.Next
+
+	for _, ca := range []*Cache{first, second} {
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		ca.ServeDNS(context.TODO(), rec, req)
+		if len(rec.Msg.Answer) != 1 {
+			t.Fatalf("Expected an answer, got %s", rec.Msg)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected the second server block to be answered from the shared cache, got %d calls", calls)
+	}
+}
@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// serveStale answers with the expired item i, as described in RFC 8767. With staleVerify the next plugin is
+// asked first, and i is only used when it fails or doesn't answer within staleTimeout. Otherwise i is used right
+// away and refreshed in the background. When refreshing failed less than staleRecheck ago, i is used without
+// asking the next plugin again.
+func (c *Cache) serveStale(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, state request.Request, server string, i *item, now time.Time, do bool) (int, error) {
+	key := i.key()
+	recheck := c.failedRecently(key, now)
+
+	if c.staleVerify && !recheck {
+		if res := c.verifyStale(ctx, state, server, key, now, do); res != nil {
+			w.WriteMsg(res)
+			return dns.RcodeSuccess, nil
+		}
+	}
+
+	servedStale.WithLabelValues(server, c.name).Inc()
+	// Adjust the time to get a 0 TTL in the reply built from a stale item.
+	expired := now.Add(time.Duration(i.ttl(now)) * time.Second)
+
+	if !c.staleVerify && !recheck {
+		cw, rec := c.newStaleResponseWriter(server, state, do)
+		go func() {
+			c.doPrefetch(ctx, state, cw, i, expired)
+			c.refreshed(server, key, rec.msg, now)
+		}()
+	}
+
+	w.WriteMsg(c.staleMsg(i, r, expired, do))
+	return dns.RcodeSuccess, nil
+}
+
+// verifyStale asks the next plugin for a fresh answer. It returns the answer, or nil if the next plugin failed
+// or didn't answer within staleTimeout. A late answer is still cached.
+func (c *Cache) verifyStale(ctx context.Context, state request.Request, server string, key uint64, now time.Time, do bool) *dns.Msg {
+	cw, rec := c.newStaleResponseWriter(server, state, do)
+	done := make(chan *dns.Msg, 1)
+	go func() {
+		c.doRefresh(ctx, state, cw)
+		if c.refreshed(server, key, rec.msg, now) {
+			done <- rec.msg
+			return
+		}
+		done <- nil
+	}()
+
+	timer := time.NewTimer(c.staleTimeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return res
+	case <-timer.C:
+		staleTimeouts.WithLabelValues(server, c.name).Inc()
+		return nil
+	}
+}
+
+// refreshed records the outcome of refreshing the stale item stored under key at now, res is the response of
+// the next plugin, if any. It returns true when refreshing was successful.
+func (c *Cache) refreshed(server string, key uint64, res *dns.Msg, now time.Time) bool {
+	if c.refreshFailed(res) {
+		staleRefreshFailures.WithLabelValues(server, c.name).Inc()
+		c.failures.Add(key, now)
+		return false
+	}
+	c.failures.Remove(key)
+	return true
+}
+
+// refreshFailed returns true if res means the next plugin failed, and a stale item should be used instead: when
+// there is no response, or a SERVFAIL. Unless staleOnError is set any rcode other than NOERROR and NXDOMAIN is
+// a failure as well.
+func (c *Cache) refreshFailed(res *dns.Msg) bool {
+	if res == nil || res.Rcode == dns.RcodeServerFailure {
+		return true
+	}
+	if c.staleOnError {
+		return false
+	}
+	return res.Rcode != dns.RcodeSuccess && res.Rcode != dns.RcodeNameError
+}
+
+// failedRecently returns true if refreshing the item stored under key failed less than staleRecheck ago.
+func (c *Cache) failedRecently(key uint64, now time.Time) bool {
+	t, ok := c.failures.Get(key)
+	return ok && now.Sub(t.(time.Time)) < c.staleRecheck
+}
+
+// newStaleResponseWriter returns a ResponseWriter to refresh a stale item. The response isn't written to the
+// client, but kept in the returned staleRecorder.
+func (c *Cache) newStaleResponseWriter(server string, state request.Request, do bool) (*ResponseWriter, *staleRecorder) {
+	rec := &staleRecorder{ResponseWriter: state.W}
+	cw := newPrefetchResponseWriter(server, state, c)
+	cw.ResponseWriter = rec
+	cw.prefetch = false
+	cw.stale = true
+	cw.do = do
+	return cw, rec
+}
+
+// staleMsg returns the reply built from the stale item i, with a TTL of staleTTL. When the client supports
+// EDNS0, an Extended DNS Error (RFC 8914) marks the reply as stale.
+func (c *Cache) staleMsg(i *item, r *dns.Msg, now time.Time, do bool) *dns.Msg {
+	m := i.toMsg(r, now, do)
+	ttl := uint32(c.staleTTL.Seconds())
+	for _, s := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range s {
+			rr.Header().Ttl = ttl
+		}
+	}
+
+	if r.IsEdns0() == nil {
+		return m
+	}
+	code := dns.ExtendedErrorCodeStaleAnswer
+	if m.Rcode == dns.RcodeNameError {
+		code = dns.ExtendedErrorCodeStaleNXDOMAINAnswer
+	}
+	o := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	o.Option = append(o.Option, &dns.EDNS0_EDE{InfoCode: code})
+	m.Extra = append(m.Extra, o)
+	return m
+}
+
+// staleRecorder is a dns.ResponseWriter that keeps the response instead of writing it.
+type staleRecorder struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+// WriteMsg implements the dns.ResponseWriter interface.
+func (r *staleRecorder) WriteMsg(m *dns.Msg) error {
+	r.msg = m
+	return nil
+}
+
+const (
+	defaultStaleTimeout = 1800 * time.Millisecond // RFC 8767, Section 5: the client response timer
+	defaultStaleRecheck = 30 * time.Second        // RFC 8767, Section 5: the failure recheck timer
+	defaultStaleTTL     = 30 * time.Second        // RFC 8767, Section 4
+)
@@ -0,0 +1,221 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// staleBackend answers with rcode, and an A record with ip for NOERROR, after delay.
+func staleBackend(rcode int, ip string, delay time.Duration, calls *int32) plugin.Handler {
+	return plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		atomic.AddInt32(calls, 1)
+		time.Sleep(delay)
+		m := new(dns.Msg)
+		m.SetRcode(r, rcode)
+		m.Response, m.RecursionAvailable = true, true
+		if rcode == dns.RcodeSuccess {
+			m.Answer = []dns.RR{test.A("example.org. 60 IN A " + ip)}
+		}
+		w.WriteMsg(m)
+		return rcode, nil
+	})
+}
+
+// newStaleCache returns a cache with a stale answer for example.org., 2 minutes after it was cached.
+func newStaleCache(t *testing.T) *Cache {
+	c := New()
+	c.staleUpTo = time.Hour
+	calls := int32(0)
+	c.Next = staleBackend(dns.RcodeSuccess, "127.0.0.1", 0, &calls)
+	staleQuery(c, true)
+
+	now := time.Now().Add(2 * time.Minute)
+	c.now = func() time.Time { return now }
+	return c
+}
+
+func staleQuery(c *Cache, edns bool) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	if edns {
+		req.SetEdns0(4096, false)
+	}
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	c.ServeDNS(context.TODO(), rec, req)
+	return rec.Msg
+}
+
+func staleAnswer(m *dns.Msg) (string, uint32, bool) {
+	ip, ttl := "", uint32(0)
+	if len(m.Answer) > 0 {
+		ip, ttl = m.Answer[0].(*dns.A).A.String(), m.Answer[0].Header().Ttl
+	}
+	stale := false
+	if o := m.IsEdns0(); o != nil {
+		for _, e := range o.Option {
+			if ede, ok := e.(*dns.EDNS0_EDE); ok && ede.InfoCode == dns.ExtendedErrorCodeStaleAnswer {
+				stale = true
+			}
+		}
+	}
+	return ip, ttl, stale
+}
+
+func TestServeStaleImmediate(t *testing.T) {
+	c := newStaleCache(t)
+	calls := int32(0)
+	c.Next = staleBackend(dns.RcodeServerFailure, "", 0, &calls)
+
+	ip, ttl, stale := staleAnswer(staleQuery(c, true))
+	if ip != "127.0.0.1" || ttl != 30 || !stale {
+		t.Errorf("Expected stale answer with TTL 30 and EDE, got %s %d %t", ip, ttl, stale)
+	}
+
+	// The background refresh fails, and the SERVFAIL doesn't replace the stale item.
+	for i := 0; i < 100 && c.failures.Len() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.failures.Len() != 1 || c.ncache.Len() != 0 {
+		t.Fatalf("Expected failed refresh to be recorded and not cached")
+	}
+
+	// Within the failure recheck time there is no refresh.
+	_, _, stale = staleAnswer(staleQuery(c, false))
+	if stale || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("Expected stale answer without EDE and no refresh, got %d calls", calls)
+	}
+}
+
+func TestServeStaleVerify(t *testing.T) {
+	tests := []struct {
+		rcode      int
+		delay      time.Duration
+		onError    bool
+		expectedIP string
+		stale      bool
+	}{
+		{dns.RcodeSuccess, 0, false, "127.0.0.2", false},
+		{dns.RcodeServerFailure, 0, false, "127.0.0.1", true},
+		{dns.RcodeRefused, 0, false, "127.0.0.1", true},
+		{dns.RcodeRefused, 0, true, "", false},
+		{dns.RcodeSuccess, 200 * time.Millisecond, false, "127.0.0.1", true},
+	}
+
+	for i, tc := range tests {
+		c := newStaleCache(t)
+		c.staleVerify, c.staleOnError = true, tc.onError
+		c.staleTimeout = 50 * time.Millisecond
+		calls := int32(0)
+		c.Next = staleBackend(tc.rcode, "127.0.0.2", tc.delay, &calls)
+
+		m := staleQuery(c, true)
+		ip, _, stale := staleAnswer(m)
+		if ip != tc.expectedIP || stale != tc.stale {
+			t.Errorf("Test %d: expected %q (stale %t), got %q (stale %t)", i, tc.expectedIP, tc.stale, ip, stale)
+		}
+		if tc.expectedIP == "" && m.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %d, got %d", i, tc.rcode, m.Rcode)
+		}
+	}
+}
+
+func TestServeStaleVerifyLateAnswer(t *testing.T) {
+	c := newStaleCache(t)
+	c.staleVerify = true
+	c.staleTimeout = 10 * time.Millisecond
+	calls := int32(0)
+	next := staleBackend(dns.RcodeSuccess, "127.0.0.2", 100*time.Millisecond, &calls)
+	done := make(chan struct{})
+	c.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		defer close(done)
+		return next.ServeDNS(ctx, w, r)
+	})
+
+	if ip, _, _ := staleAnswer(staleQuery(c, false)); ip != "127.0.0.1" {
+		t.Fatalf("Expected stale answer, got %s", ip)
+	}
+	<-done
+
+	// The late answer was cached.
+	if ip, _, stale := staleAnswer(staleQuery(c, false)); ip != "127.0.0.2" || stale {
+		t.Errorf("Expected fresh answer, got %s", ip)
+	}
+	if x := atomic.LoadInt32(&calls); x != 1 {
+		t.Errorf("Expected 1 call to the backend, got %d", x)
+	}
+}
+
+func TestServeStaleRecheck(t *testing.T) {
+	c := newStaleCache(t)
+	c.staleVerify = true
+	calls := int32(0)
+	c.Next = staleBackend(dns.RcodeServerFailure, "", 0, &calls)
+
+	staleQuery(c, false)
+	staleQuery(c, false)
+	if x := atomic.LoadInt32(&calls); x != 1 {
+		t.Errorf("Expected 1 call within the recheck time, got %d", x)
+	}
+
+	now := c.now().Add(defaultStaleRecheck)
+	c.now = func() time.Time { return now }
+	staleQuery(c, false)
+	if x := atomic.LoadInt32(&calls); x != 2 {
+		t.Errorf("Expected 2 calls after the recheck time, got %d", x)
+	}
+}
+
+func TestSetupServeStaleOptions(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		verify    bool
+		onError   bool
+		timeout   time.Duration
+		recheck   time.Duration
+		ttl       time.Duration
+	}{
+		{"serve_stale", false, false, false, defaultStaleTimeout, defaultStaleRecheck, defaultStaleTTL},
+		{"serve_stale 1h immediate", false, false, false, defaultStaleTimeout, defaultStaleRecheck, defaultStaleTTL},
+		{"serve_stale 1h verify", false, true, false, defaultStaleTimeout, defaultStaleRecheck, defaultStaleTTL},
+		{"serve_stale\nstale_on_error", false, true, true, defaultStaleTimeout, defaultStaleRecheck, defaultStaleTTL},
+		{"serve_stale 1h verify\nstale_timeout 500ms\nstale_recheck 1m\nstale_ttl 0s", false, true, false, 500 * time.Millisecond, time.Minute, 0},
+		// fails
+		{"serve_stale 1h later", true, false, false, 0, 0, 0},
+		{"serve_stale 1h verify more", true, false, false, 0, 0, 0},
+		{"stale_timeout", true, false, false, 0, 0, 0},
+		{"stale_ttl -1s", true, false, false, 0, 0, 0},
+		{"stale_recheck 30", true, false, false, 0, 0, 0},
+		{"stale_on_error yes", true, false, false, 0, 0, 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %v: Expected error but found nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if ca.staleVerify != test.verify || ca.staleOnError != test.onError {
+			t.Errorf("Test %v: Expected verify %t and on error %t, got %t and %t", i, test.verify, test.onError, ca.staleVerify, ca.staleOnError)
+		}
+		if ca.staleTimeout != test.timeout || ca.staleRecheck != test.recheck || ca.staleTTL != test.ttl {
+			t.Errorf("Test %v: Expected %v %v %v, got %v %v %v", i, test.timeout, test.recheck, test.ttl, ca.staleTimeout, ca.staleRecheck, ca.staleTTL)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// wire is the packed reply built from an item, with the offsets of the fields that differ per reply: the ID and
+// flags in the header, the question name, and the TTLs.
+type wire struct {
+	buf  []byte
+	qend int   // end of the question name
+	ttls []int // offsets of the TTL fields
+}
+
+// wire returns the packed reply for i, for a client that did, or did not, set the DO bit. It is built on first
+// use, and nil if i can't be packed.
+func (i *item) wire(do bool) *wire {
+	j := 0
+	if do {
+		j = 1
+	}
+	if w, ok := i.packed[j].Load().(*wire); ok {
+		return w
+	}
+	w := newWire(i, do)
+	i.packed[j].Store(w)
+	return w
+}
+
+func newWire(i *item, do bool) *wire {
+	req := new(dns.Msg)
+	req.SetQuestion(i.Name, i.QType)
+	req.Id = 0
+	m := i.toMsg(req, i.stored, do)
+	m.Compress = true
+	buf, err := m.Pack()
+	if err != nil {
+		return nil
+	}
+	w := &wire{buf: buf}
+	if w.qend, w.ttls, err = ttlOffsets(buf); err != nil {
+		return nil
+	}
+	return w
+}
+
+// writeWire writes the reply for r built from i as packed bytes, when w can write those. It returns false when
+// the reply needs to be written as a dns.Msg instead.
+func (c *Cache) writeWire(w dns.ResponseWriter, r *dns.Msg, i *item, now time.Time, do bool) bool {
+	ww, ok := w.(request.WireWriter)
+	if !ok {
+		return false
+	}
+	if r.Opcode != dns.OpcodeQuery || len(r.Question) != 1 || r.Question[0].Qclass != dns.ClassINET || i.Rcode > 0xF {
+		return false
+	}
+	// Only an OPT record without options is handled here, like request.SizeAndDo would.
+	opt := r.IsEdns0()
+	if opt != nil && (len(opt.Option) > 0 || opt.Version() != 0) {
+		return false
+	}
+	ttl := i.ttl(now)
+	if ttl < 0 {
+		return false
+	}
+	wr := i.wire(do)
+	if wr == nil {
+		return false
+	}
+
+	// Names in the reply may be compressed to point into the question, so the question can't be patched when
+	// it differs from the cached one in case.
+	var qname [256]byte
+	n, err := dns.PackDomainName(r.Question[0].Name, qname[:], 0, nil, false)
+	if err != nil || !bytes.Equal(qname[:n], wr.buf[headerLen:wr.qend]) {
+		return false
+	}
+
+	buf := make([]byte, len(wr.buf), len(wr.buf)+optLen)
+	copy(buf, wr.buf)
+	binary.BigEndian.PutUint16(buf, r.Id)
+	buf[2] &^= flagRD
+	if r.RecursionDesired {
+		buf[2] |= flagRD
+	}
+	buf[3] &^= flagCD | flagAD
+	if r.CheckingDisabled {
+		buf[3] |= flagCD
+	}
+	if i.AuthenticatedData && (do || r.AuthenticatedData) {
+		buf[3] |= flagAD
+	}
+	for _, off := range wr.ttls {
+		binary.BigEndian.PutUint32(buf[off:], uint32(ttl))
+	}
+
+	if opt != nil {
+		// The request's OPT record, as request.SizeAndDo adds it.
+		flags := byte(0)
+		if do {
+			flags = 0x80
+		}
+		size := opt.UDPSize()
+		buf = append(buf, 0, byte(dns.TypeOPT>>8), byte(dns.TypeOPT), byte(size>>8), byte(size), 0, 0, flags, 0, 0, 0)
+		binary.BigEndian.PutUint16(buf[10:], binary.BigEndian.Uint16(buf[10:])+1)
+	}
+
+	written, _ := ww.WriteWire(buf, i.Rcode)
+	return written
+}
+
+// ttlOffsets walks the packed message buf, and returns the end of the question name and the offsets of the TTL
+// fields of all records, except OPT records.
+func ttlOffsets(buf []byte) (int, []int, error) {
+	if len(buf) < headerLen {
+		return 0, nil, errTruncated
+	}
+	qd := int(binary.BigEndian.Uint16(buf[4:]))
+	rrs := int(binary.BigEndian.Uint16(buf[6:])) + int(binary.BigEndian.Uint16(buf[8:])) + int(binary.BigEndian.Uint16(buf[10:]))
+	if qd != 1 {
+		return 0, nil, errors.New("not a single question")
+	}
+
+	qend, err := skipName(buf, headerLen)
+	if err != nil {
+		return 0, nil, err
+	}
+	off := qend + 4
+	ttls := make([]int, 0, rrs)
+	for k := 0; k < rrs; k++ {
+		if off, err = skipName(buf, off); err != nil {
+			return 0, nil, err
+		}
+		if off+10 > len(buf) {
+			return 0, nil, errTruncated
+		}
+		if binary.BigEndian.Uint16(buf[off:]) != dns.TypeOPT {
+			ttls = append(ttls, off+4)
+		}
+		off += 10 + int(binary.BigEndian.Uint16(buf[off+8:]))
+	}
+	if off != len(buf) {
+		return 0, nil, errTruncated
+	}
+	return qend, ttls, nil
+}
+
+// skipName returns the offset after the domain name at off in buf.
+func skipName(buf []byte, off int) (int, error) {
+	for off < len(buf) {
+		c := int(buf[off])
+		switch c & 0xC0 {
+		case 0x00:
+			if c == 0 {
+				return off + 1, nil
+			}
+			off += c + 1
+		case 0xC0:
+			return off + 2, nil
+		default:
+			return 0, errors.New("bad label")
+		}
+	}
+	return 0, errTruncated
+}
+
+var errTruncated = errors.New("truncated message")
+
+const (
+	headerLen = 12
+	optLen    = 11 // an OPT record without options
+
+	flagRD = 0x01 // in the third byte of the header
+	flagAD = 0x20 // in the fourth byte of the header
+	flagCD = 0x10 // in the fourth byte of the header
+)
@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// wireRecorder records the message or the packed bytes written to it.
+type wireRecorder struct {
+	test.ResponseWriter
+	msg *dns.Msg
+	buf []byte
+}
+
+func (w *wireRecorder) WriteMsg(m *dns.Msg) error   { w.msg = m; return nil }
+func (w *wireRecorder) Write(b []byte) (int, error) { w.buf = b; return len(b), nil }
+
+// reply returns the message written to w, unpacking it when written as bytes.
+func (w *wireRecorder) reply(t *testing.T) *dns.Msg {
+	if w.buf == nil {
+		return w.msg
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(w.buf); err != nil {
+		t.Fatalf("Failed to unpack reply: %s", err)
+	}
+	return m
+}
+
+func wireBackend() plugin.Handler {
+	return plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Response, m.RecursionAvailable, m.AuthenticatedData = true, true, true
+		switch r.Question[0].Name {
+		case "nx.example.org.":
+			m.Rcode = dns.RcodeNameError
+			m.Ns = []dns.RR{
+				test.SOA("example.org. 300 IN SOA ns.example.org. hostmaster.example.org. 1 7200 3600 1209600 300"),
+				test.RRSIG("example.org. 300 IN RRSIG SOA 8 2 300 20300101000000 20200101000000 12345 example.org. c2ln"),
+			}
+		case "big.example.org.":
+			for i := 0; i < 30; i++ {
+				m.Answer = append(m.Answer, test.TXT(`big.example.org. 300 IN TXT "this is a rather long text record to make the reply big"`))
+			}
+		default:
+			m.Answer = []dns.RR{
+				test.A(r.Question[0].Name + " 300 IN A 127.0.0.53"),
+				test.RRSIG(r.Question[0].Name + " 300 IN RRSIG A 8 3 300 20300101000000 20200101000000 12345 example.org. c2ln"),
+			}
+			m.Extra = []dns.RR{test.A("ns.example.org. 300 IN A 127.0.0.1")}
+		}
+		w.WriteMsg(m)
+		return m.Rcode, nil
+	})
+}
+
+func TestCacheWire(t *testing.T) {
+	c := New()
+	c.Next = wireBackend()
+
+	tests := []struct {
+		qname string
+		edns  bool
+		do    bool
+		cd    bool
+		wire  bool
+	}{
+		{"a.example.org.", false, false, false, true},
+		{"A.Example.ORG.", false, false, true, false}, // differs in case from the cached question
+		{"a.example.org.", true, false, false, true},
+		{"a.example.org.", true, true, false, true},
+		{"nx.example.org.", true, true, false, true},
+		{"nx.example.org.", false, false, false, true},
+		{"big.example.org.", false, false, false, false}, // doesn't fit in 512 bytes, must be truncated
+		{"big.example.org.", true, false, false, true},
+	}
+
+	for i, tc := range tests {
+		req := new(dns.Msg)
+		req.SetQuestion(tc.qname, dns.TypeA)
+		req.Id = uint16(1000 + i)
+		req.CheckingDisabled = tc.cd
+		if tc.edns {
+			req.SetEdns0(4096, tc.do)
+		}
+
+		// Fill the cache, and then get the same reply as a dns.Msg and as bytes.
+		c.ServeDNS(context.TODO(), request.NewScrubWriter(req, &wireRecorder{}), req.Copy())
+		c.now = func() time.Time { return time.Now().Add(10 * time.Second) }
+
+		msgw := &wireRecorder{}
+		c.ServeDNS(context.TODO(), &struct{ dns.ResponseWriter }{request.NewScrubWriter(req.Copy(), msgw)}, req.Copy())
+		wirew := &wireRecorder{}
+		c.ServeDNS(context.TODO(), request.NewScrubWriter(req.Copy(), wirew), req.Copy())
+		c.now = time.Now
+
+		if (wirew.buf != nil) != tc.wire {
+			t.Errorf("Test %d: expected written as bytes to be %t", i, tc.wire)
+			continue
+		}
+		if !tc.wire {
+			continue
+		}
+		expected, got := msgw.reply(t), wirew.reply(t)
+		if expected.String() != got.String() {
+			t.Errorf("Test %d: expected\n%s\ngot\n%s", i, expected, got)
+		}
+		if got.Question[0].Name != tc.qname || got.Id != req.Id || got.Answer != nil && got.Answer[0].Header().Ttl != 290 {
+			t.Errorf("Test %d: expected ID and TTL to be patched, got %s", i, got)
+		}
+	}
+}
+
+func TestCacheWireFallback(t *testing.T) {
+	c := New()
+	c.Next = wireBackend()
+
+	req := new(dns.Msg)
+	req.SetQuestion("a.example.org.", dns.TypeA)
+	c.ServeDNS(context.TODO(), &wireRecorder{}, req)
+
+	// A wrapping ResponseWriter that needs a dns.Msg.
+	w := &wireRecorder{}
+	c.ServeDNS(context.TODO(), &struct{ dns.ResponseWriter }{request.NewScrubWriter(req, w)}, req)
+	if w.buf != nil || w.msg == nil {
+		t.Error("Expected reply to be written as a dns.Msg")
+	}
+
+	// A request with EDNS0 options.
+	req.SetEdns0(4096, false)
+	req.IsEdns0().Option = []dns.EDNS0{&dns.EDNS0_NSID{Code: dns.EDNS0NSID}}
+	w = &wireRecorder{}
+	c.ServeDNS(context.TODO(), request.NewScrubWriter(req, w), req)
+	if w.buf != nil || w.msg == nil {
+		t.Error("Expected reply to be written as a dns.Msg")
+	}
+}
+
+func TestTTLOffsets(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.Answer = []dns.RR{test.A("example.org. 300 IN A 127.0.0.1"), test.A("example.org. 300 IN A 127.0.0.2")}
+	m.SetEdns0(4096, true)
+	m.Compress = true
+	buf, _ := m.Pack()
+
+	qend, ttls, err := ttlOffsets(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qend != 12+13 || len(ttls) != 2 {
+		t.Fatalf("Expected question end 25 and 2 TTLs, got %d and %v", qend, ttls)
+	}
+	for _, off := range ttls {
+		if buf[off+3] != 44 { // 300 = 0x012C
+			t.Errorf("Expected TTL at offset %d", off)
+		}
+	}
+
+	if _, _, err := ttlOffsets(buf[:len(buf)-1]); err == nil {
+		t.Error("Expected error for truncated message")
+	}
+}
+
+func benchmarkCacheHit(b *testing.B, wrap func(*dns.Msg, dns.ResponseWriter) dns.ResponseWriter) {
+	c := New()
+	c.Next = wireBackend()
+
+	req := new(dns.Msg)
+	req.SetQuestion("a.example.org.", dns.TypeA)
+	req.SetEdns0(4096, true)
+	c.ServeDNS(context.TODO(), &wireRecorder{}, req)
+
+	ctx := context.TODO()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.ServeDNS(ctx, wrap(req, &test.ResponseWriter{}), req)
+	}
+}
+
+func BenchmarkCacheHitMsg(b *testing.B) {
+	benchmarkCacheHit(b, func(req *dns.Msg, w dns.ResponseWriter) dns.ResponseWriter {
+		// Hide the WireWriter implementation of the ScrubWriter.
+		return &struct{ dns.ResponseWriter }{request.NewScrubWriter(req, &packWriter{w})}
+	})
+}
+
+func BenchmarkCacheHitWire(b *testing.B) {
+	benchmarkCacheHit(b, func(req *dns.Msg, w dns.ResponseWriter) dns.ResponseWriter {
+		return request.NewScrubWriter(req, &packWriter{w})
+	})
+}
+
+// packWriter packs the message like the server does.
+type packWriter struct {
+	dns.ResponseWriter
+}
+
+func (w *packWriter) WriteMsg(m *dns.Msg) error {
+	buf, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = w.ResponseWriter.Write(buf)
+	return err
+}
@@ -50,7 +50,12 @@ func (t *Transport) Dial(proto string) (*persistConn, bool, error) {
 		proto = "tcp-tls"
 	}
 
-	t.dial <- proto
+	select {
+	case t.dial <- proto:
+	case <-t.stop:
+		// The connection manager is gone, a query that still holds a proxy that was replaced must not block.
+		return nil, false, errTransportStopped
+	}
 	pc := <-t.ret
 
 	if pc != nil {
@@ -59,15 +64,7 @@ func (t *Transport) Dial(proto string) (*persistConn, bool, error) {
 	}
 	ConnCacheMissesCount.WithLabelValues(t.addr, proto).Add(1)
 
-	reqTime := time.Now()
-	timeout := t.dialTimeout()
-	if proto == "tcp-tls" {
-		conn, err := dns.DialTimeoutWithTLS("tcp", t.addr, t.tlsConfig, timeout)
-		t.updateDialTimeout(time.Since(reqTime))
-		return &persistConn{c: conn}, false, err
-	}
-	conn, err := dns.DialTimeout(proto, t.addr, timeout)
-	t.updateDialTimeout(time.Since(reqTime))
+	conn, err := t.dialConn(proto)
 	return &persistConn{c: conn}, false, err
 }
 
@@ -85,6 +82,21 @@ func (p *Proxy) Connect(ctx context.Context, state request.Request, opts options
 		proto = state.Proto()
 	}
 
+	if t := p.transport; t.pipeline != nil && (proto == "tcp" || t.tlsConfig != nil) {
+		if t.tlsConfig != nil {
+			proto = "tcp-tls"
+		}
+		ret, err := t.pipeline.Exchange(ctx, t, proto, state.Req)
+		if err != errPipelineFull {
+			if err != nil {
+				return ret, err
+			}
+			p.observe(ret, start)
+			return ret, nil
+		}
+		// All pipelined connections are full, fall back to a connection of our own.
+	}
+
 	pc, cached, err := p.transport.Dial(proto)
 	if err != nil {
 		return nil, err
@@ -124,6 +136,12 @@ func (p *Proxy) Connect(ctx context.Context, state request.Request, opts options
 
 	p.transport.Yield(pc)
 
+	p.observe(ret, start)
+	return ret, nil
+}
+
+// observe updates the metrics for a response from this proxy.
+func (p *Proxy) observe(ret *dns.Msg, start time.Time) {
 	rc, ok := dns.RcodeToString[ret.Rcode]
 	if !ok {
 		rc = strconv.Itoa(ret.Rcode)
@@ -132,8 +150,6 @@ func (p *Proxy) Connect(ctx context.Context, state request.Request, opts options
 	RequestCount.WithLabelValues(p.addr).Add(1)
 	RcodeCount.WithLabelValues(rc, p.addr).Add(1)
 	RequestDuration.WithLabelValues(p.addr, rc).Observe(time.Since(start).Seconds())
-
-	return ret, nil
 }
 
 const cumulativeAvgWeight = 4
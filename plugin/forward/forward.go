@@ -8,6 +8,7 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -30,19 +31,30 @@ var log = clog.NewWithPlugin("forward")
 type Forward struct {
 	concurrent int64 // atomic counters need to be first in struct for proper alignment
 
+	mu         sync.RWMutex // protects proxies, which are swapped when the upstreams change
 	proxies    []*Proxy
 	p          Policy
 	hcInterval time.Duration
 
+	upstreams *upstreams // sources of the upstream addresses
+
 	from    string
 	ignored []string
 
 	tlsConfig     *tls.Config
 	tlsServerName string
+	tlsPins       map[string][]string // SPKI pins per upstream address, "" holds the pins for all upstreams
+	tlsSAN        string              // SAN the upstream's certificate must carry
 	maxfails      uint32
 	expire        time.Duration
 	maxConcurrent int64
 
+	pipeConns int // number of pipelined connections per upstream, 0 disables pipelining
+	pipeMax   int // maximum number of in-flight queries per pipelined connection
+
+	nextRcodes  []int // rcodes that make us try the next upstream
+	maxAttempts int   // maximum number of upstreams tried for a query, 0 is unlimited
+
 	opts options // also here for testing
 
 	// ErrLimitExceeded indicates that a query was rejected because the number of concurrent queries has exceeded
@@ -62,12 +74,21 @@ func New() *Forward {
 
 // SetProxy appends p to the proxy list and starts healthchecking.
 func (f *Forward) SetProxy(p *Proxy) {
+	f.mu.Lock()
 	f.proxies = append(f.proxies, p)
+	f.mu.Unlock()
 	p.start(f.hcInterval)
 }
 
 // Len returns the number of configured proxies.
-func (f *Forward) Len() int { return len(f.proxies) }
+func (f *Forward) Len() int { return len(f.current()) }
+
+// current returns the current set of proxies.
+func (f *Forward) current() []*Proxy {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.proxies
+}
 
 // Name implements plugin.Handler.
 func (f *Forward) Name() string { return "forward" }
@@ -90,31 +111,41 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 	}
 
 	fails := 0
+	attempts := 0
 	var span, child ot.Span
 	var upstreamErr error
+	var failover *dns.Msg // last reply that matched one of f.nextRcodes
 	span = ot.SpanFromContext(ctx)
 	i := 0
-	list := f.List()
+	proxies := f.current()
+	list := f.p.List(proxies)
 	deadline := time.Now().Add(defaultTimeout)
 	start := time.Now()
 	for time.Now().Before(deadline) {
 		if i >= len(list) {
+			// Never wrap around when failing over, the upstreams have all been tried.
+			if failover != nil {
+				break
+			}
 			// reached the end of list, reset to begin
 			i = 0
 			fails = 0
 		}
+		if f.maxAttempts > 0 && attempts >= f.maxAttempts {
+			break
+		}
 
 		proxy := list[i]
 		i++
 		if proxy.Down(f.maxfails) {
 			fails++
-			if fails < len(f.proxies) {
+			if fails < len(proxies) {
 				continue
 			}
 			// All upstream proxies are dead, assume healthcheck is completely broken and randomly
 			// select an upstream to connect to.
 			r := new(random)
-			proxy = r.List(f.proxies)[0]
+			proxy = r.List(proxies)[0]
 
 			HealthcheckBrokenCount.Add(1)
 		}
@@ -134,6 +165,7 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 			err error
 		)
 		opts := f.opts
+		attempts++
 		for {
 			ret, err = proxy.Connect(ctx, state, opts)
 			if err == ErrCachedClosed { // Remote side closed conn, can only happen with TCP.
@@ -163,7 +195,7 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 				proxy.Healthcheck()
 			}
 
-			if fails < len(f.proxies) {
+			if fails < len(proxies) {
 				continue
 			}
 			break
@@ -179,10 +211,24 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 			return 0, nil
 		}
 
+		// Try the next upstream in the list if the rcode tells us to, but never wrap around.
+		if f.isNextRcode(ret.Rcode) && i < len(list) {
+			log.Infof("Failing over from %s for %q: got %s", proxy.addr, state.Name(), dns.RcodeToString[ret.Rcode])
+			FailoverCount.WithLabelValues(proxy.addr, dns.RcodeToString[ret.Rcode]).Inc()
+			failover = ret
+			continue
+		}
+
 		w.WriteMsg(ret)
 		return 0, nil
 	}
 
+	// Return the last reply we failed over from, this is better than a SERVFAIL of our own.
+	if failover != nil {
+		w.WriteMsg(failover)
+		return 0, nil
+	}
+
 	if upstreamErr != nil {
 		return dns.RcodeServerFailure, upstreamErr
 	}
@@ -211,6 +257,16 @@ func (f *Forward) isAllowedDomain(name string) bool {
 	return true
 }
 
+// isNextRcode returns true if rcode is one of the rcodes configured with next.
+func (f *Forward) isNextRcode(rcode int) bool {
+	for _, r := range f.nextRcodes {
+		if r == rcode {
+			return true
+		}
+	}
+	return false
+}
+
 // ForceTCP returns if TCP is forced to be used even when the request comes in over UDP.
 func (f *Forward) ForceTCP() bool { return f.opts.forceTCP }
 
@@ -218,7 +274,7 @@ func (f *Forward) ForceTCP() bool { return f.opts.forceTCP }
 func (f *Forward) PreferUDP() bool { return f.opts.preferUDP }
 
 // List returns a set of proxies to be used for this client depending on the policy in f.
-func (f *Forward) List() []*Proxy { return f.p.List(f.proxies) }
+func (f *Forward) List() []*Proxy { return f.p.List(f.current()) }
 
 var (
 	// ErrNoHealthy means no healthy proxies left.
@@ -227,6 +283,9 @@ var (
 	ErrNoForward = errors.New("no forwarder defined")
 	// ErrCachedClosed means cached connection was closed by peer.
 	ErrCachedClosed = errors.New("cached connection was closed by peer")
+
+	// errTransportStopped means the transport of a proxy was stopped while it was still used.
+	errTransportStopped = errors.New("transport was stopped")
 )
 
 // options holds various options that can be set.
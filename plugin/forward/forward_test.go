@@ -1,7 +1,18 @@
 package forward
 
 import (
+	"context"
+	"net"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestList(t *testing.T) {
@@ -22,3 +33,107 @@ func TestList(t *testing.T) {
 		}
 	}
 }
+
+func TestNextRcode(t *testing.T) {
+	// dnstest.NewServer registers a global handler, so one handler serves both upstreams and tells them
+	// apart by the port the query came in on.
+	var servfailPort string
+	handler := func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		_, port, _ := net.SplitHostPort(w.LocalAddr().String())
+		if port == servfailPort {
+			ret.SetRcode(r, dns.RcodeServerFailure)
+			w.WriteMsg(ret)
+			return
+		}
+		ret.SetReply(r)
+		ret.Answer = append(ret.Answer, test.A("example.org. IN A 127.0.0.1"))
+		w.WriteMsg(ret)
+	}
+	servfail := dnstest.NewServer(handler)
+	defer servfail.Close()
+	_, servfailPort, _ = net.SplitHostPort(servfail.Addr)
+
+	good := dnstest.NewServer(handler)
+	defer good.Close()
+
+	tests := []struct {
+		input         string
+		expectedRcode int
+	}{
+		{"forward . " + servfail.Addr + " " + good.Addr + " {\npolicy sequential\n}\n", dns.RcodeServerFailure},
+		{"forward . " + servfail.Addr + " " + good.Addr + " {\npolicy sequential\nnext SERVFAIL\n}\n", dns.RcodeSuccess},
+		{"forward . " + servfail.Addr + " " + good.Addr + " {\npolicy sequential\nnext SERVFAIL\nmax_attempts 1\n}\n", dns.RcodeServerFailure},
+		{"forward . " + servfail.Addr + " " + servfail.Addr + " {\npolicy sequential\nnext SERVFAIL\n}\n", dns.RcodeServerFailure},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		f, err := parseForward(c)
+		if err != nil {
+			t.Fatalf("Test %d: failed to create forwarder: %s", i, err)
+		}
+		f.OnStartup()
+
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+
+		if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+			t.Errorf("Test %d: expected to receive reply, but got: %s", i, err)
+		}
+		if rec.Msg == nil || rec.Msg.Rcode != tc.expectedRcode {
+			t.Errorf("Test %d: expected rcode %d, got %v", i, tc.expectedRcode, rec.Msg)
+		}
+		f.OnShutdown()
+	}
+
+	// Tests 1 and 2 fail over once, test 3 doesn't wrap around to the first upstream.
+	if n := testutil.ToFloat64(FailoverCount.WithLabelValues(servfail.Addr, "SERVFAIL")); n != 3 {
+		t.Errorf("Expected 3 failovers from %s, got %f", servfail.Addr, n)
+	}
+}
+
+func TestNextRcodeThenError(t *testing.T) {
+	var queries int32
+	servfail := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		atomic.AddInt32(&queries, 1)
+		ret := new(dns.Msg)
+		ret.SetRcode(r, dns.RcodeServerFailure)
+		w.WriteMsg(ret)
+	})
+	defer servfail.Close()
+
+	// An upstream that is gone makes every query to it fail.
+	gone, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone.Close()
+
+	c := caddy.NewTestController("dns", "forward . "+servfail.Addr+" "+gone.LocalAddr().String()+" {\npolicy sequential\nnext SERVFAIL\n}\n")
+	f, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Failed to create forwarder: %s", err)
+	}
+	f.OnStartup()
+	defer f.OnShutdown()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+
+	start := time.Now()
+	if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Errorf("Expected to receive reply, but got: %s", err)
+	}
+	if rec.Msg == nil || rec.Msg.Rcode != dns.RcodeServerFailure {
+		t.Errorf("Expected the SERVFAIL failed over from, got %v", rec.Msg)
+	}
+	if n := atomic.LoadInt32(&queries); n != 1 {
+		t.Errorf("Expected the failed over upstream to be queried once, got %d queries", n)
+	}
+	if time.Since(start) >= defaultTimeout {
+		t.Errorf("Expected a reply before the deadline, took %s", time.Since(start))
+	}
+}
@@ -64,4 +64,16 @@ var (
 		Name:      "conn_cache_misses_total",
 		Help:      "Counter of connection cache misses per upstream and protocol.",
 	}, []string{"to", "proto"})
+	TLSVerifyFailureCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "tls_verify_failures_total",
+		Help:      "Counter of failed certificate verifications (SPKI pin or SAN) per upstream.",
+	}, []string{"to", "reason"})
+	FailoverCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "failovers_total",
+		Help:      "Counter of failovers to the next upstream per upstream and RCODE that caused it.",
+	}, []string{"to", "rcode"})
 )
@@ -21,6 +21,7 @@ type Transport struct {
 	expire      time.Duration                  // After this duration a connection is expired.
 	addr        string
 	tlsConfig   *tls.Config
+	pipeline    *pipeline // when set, TCP and DoT queries are pipelined
 
 	dial  chan string
 	yield chan *persistConn
@@ -139,8 +140,13 @@ func (t *Transport) Yield(pc *persistConn) {
 // Start starts the transport's connection manager.
 func (t *Transport) Start() { go t.connManager() }
 
-// Stop stops the transport's connection manager.
-func (t *Transport) Stop() { close(t.stop) }
+// Stop stops the transport's connection manager and closes the pipelined connections.
+func (t *Transport) Stop() {
+	close(t.stop)
+	if t.pipeline != nil {
+		t.pipeline.close()
+	}
+}
 
 // SetExpire sets the connection expire time in transport.
 func (t *Transport) SetExpire(expire time.Duration) { t.expire = expire }
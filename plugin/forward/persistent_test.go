@@ -107,3 +107,23 @@ func TestCleanupAll(t *testing.T) {
 		t.Error("Expected no cached connections")
 	}
 }
+
+func TestDialStopped(t *testing.T) {
+	tr := newTransport("127.0.0.1:53")
+	tr.Start()
+	tr.Stop()
+
+	done := make(chan error)
+	go func() {
+		_, _, err := tr.Dial("udp")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != errTransportStopped {
+			t.Errorf("Expected %q, got %v", errTransportStopped, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Dial blocked on a stopped transport")
+	}
+}
@@ -0,0 +1,118 @@
+package forward
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	pkgtls "github.com/coredns/coredns/plugin/pkg/tls"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newCert(t *testing.T) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dns.example.org"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"dns.example.org"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestSetupTLSPin(t *testing.T) {
+	cert := newCert(t)
+	pin := pkgtls.SPKIPin(cert)
+	other := pkgtls.SPKIPin(newCert(t))
+
+	tests := []struct {
+		input     string
+		shouldErr bool
+		addr      string // upstream to check
+		pinned    bool   // expect a verifier on addr
+		verifyErr bool   // expect verification of cert to fail
+	}{
+		{"forward . tls://127.0.0.1", false, "127.0.0.1:853", false, false},
+		{"forward . tls://127.0.0.1 {\ntls_spki_pin " + pin + "\n}\n", false, "127.0.0.1:853", true, false},
+		{"forward . tls://127.0.0.1 {\ntls_spki_pin " + other + "\n}\n", false, "127.0.0.1:853", true, true},
+		{"forward . tls://127.0.0.1 tls://127.0.0.2 {\ntls_spki_pin tls://127.0.0.2 " + other + " " + pin + "\n}\n", false, "127.0.0.2:853", true, false},
+		{"forward . tls://127.0.0.1 tls://127.0.0.2 {\ntls_spki_pin 127.0.0.2 " + other + "\n}\n", false, "127.0.0.1:853", false, false},
+		{"forward . tls://127.0.0.1 {\ntls_san dns.example.org\n}\n", false, "127.0.0.1:853", true, false},
+		{"forward . tls://127.0.0.1 {\ntls_san dns.example.net\n}\n", false, "127.0.0.1:853", true, true},
+		// negative
+		{"forward . tls://127.0.0.1 {\ntls_spki_pin\n}\n", true, "", false, false},
+		{"forward . tls://127.0.0.1 {\ntls_spki_pin 127.0.0.1\n}\n", true, "", false, false},
+		{"forward . tls://127.0.0.1 {\ntls_spki_pin 127.0.0.1 notapin\n}\n", true, "", false, false},
+		{"forward . tls://127.0.0.1 {\ntls_san\n}\n", true, "", false, false},
+		{"forward . 127.0.0.1 {\ntls_spki_pin " + pin + "\n}\n", true, "", false, false},
+		{"forward . 127.0.0.1 {\ntls\ntls_san dns.example.org\n}\n", true, "", false, false},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		f, err := parseForward(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+
+		var p *Proxy
+		for _, x := range f.current() {
+			if x.addr == test.addr {
+				p = x
+			}
+		}
+		if p == nil {
+			t.Fatalf("Test %d: no proxy for %s", i, test.addr)
+		}
+		cfg := p.transport.tlsConfig
+		if (cfg.VerifyConnection != nil) != test.pinned {
+			t.Errorf("Test %d: expected verifier on %s: %t", i, test.addr, test.pinned)
+			continue
+		}
+		if !test.pinned {
+			continue
+		}
+
+		before := testutil.ToFloat64(TLSVerifyFailureCount.WithLabelValues(p.addr, "pin")) + testutil.ToFloat64(TLSVerifyFailureCount.WithLabelValues(p.addr, "san"))
+		err = cfg.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+		if (err != nil) != test.verifyErr {
+			t.Errorf("Test %d: expected verification error: %t, got: %v", i, test.verifyErr, err)
+		}
+		after := testutil.ToFloat64(TLSVerifyFailureCount.WithLabelValues(p.addr, "pin")) + testutil.ToFloat64(TLSVerifyFailureCount.WithLabelValues(p.addr, "san"))
+		if test.verifyErr {
+			if after != before+1 {
+				t.Errorf("Test %d: expected failure to be counted", i)
+			}
+			if !p.Down(f.maxfails) {
+				t.Errorf("Test %d: expected %s to be down", i, p.addr)
+			}
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// pipeline multiplexes queries over a few long-lived TCP or DNS-over-TLS connections. Responses are matched
+// to queries by message ID, so they may arrive out-of-order (RFC 7766, Section 6.2.1.1).
+type pipeline struct {
+	size int // maximum number of connections
+	max  int // maximum number of in-flight queries per connection
+
+	mu      sync.Mutex
+	conns   []*pipeConn
+	dialing int           // connections being dialed
+	dialed  chan struct{} // closed when a dial finishes
+}
+
+// pipeConn is a single pipelined connection. Queries get a new ID that is unique on this connection, the
+// original ID is restored on the response.
+type pipeConn struct {
+	c      *dns.Conn
+	expire time.Duration
+
+	wmu sync.Mutex // serializes writes
+
+	mu       sync.Mutex
+	inflight map[uint16]chan *dns.Msg
+	closed   bool
+}
+
+var (
+	// errPipelineFull means all pipelined connections have the maximum number of in-flight queries.
+	errPipelineFull = errors.New("all pipelined connections are full")
+	// errPipelineClosed means the pipelined connection was closed while the query was in-flight.
+	errPipelineClosed = errors.New("pipelined connection was closed")
+)
+
+func newPipeline(size, max int) *pipeline { return &pipeline{size: size, max: max} }
+
+// Exchange sends m over one of the pipelined connections to t's address and waits for the response. It returns
+// errPipelineFull when no connection has room for another query; the caller should then use a connection of
+// its own.
+func (pl *pipeline) Exchange(ctx context.Context, t *Transport, proto string, m *dns.Msg) (*dns.Msg, error) {
+	pc, cached, err := pl.conn(t, proto)
+	if err != nil {
+		return nil, err
+	}
+
+	id, ch, err := pc.register(pl.max)
+	if err != nil {
+		return nil, err
+	}
+
+	q := *m // shallow copy is enough, we only change the ID
+	q.Id = id
+	pc.wmu.Lock()
+	pc.c.SetWriteDeadline(time.Now().Add(maxTimeout))
+	err = pc.c.WriteMsg(&q)
+	pc.wmu.Unlock()
+	pc.c.SetReadDeadline(time.Now().Add(pc.expire))
+	if err != nil {
+		pc.unregister(id)
+		pc.close()
+		if cached {
+			return nil, ErrCachedClosed
+		}
+		return nil, err
+	}
+
+	timer := time.NewTimer(readTimeout)
+	defer timer.Stop()
+
+	select {
+	case ret, ok := <-ch:
+		if !ok {
+			if cached {
+				return nil, ErrCachedClosed
+			}
+			return nil, errPipelineClosed
+		}
+		ret.Id = m.Id
+		return ret, nil
+	case <-timer.C:
+		pc.unregister(id)
+		return nil, &net.OpError{Op: "read", Net: proto, Err: errTimeout}
+	case <-ctx.Done():
+		pc.unregister(id)
+		return nil, ctx.Err()
+	}
+}
+
+// conn returns the open connection with the fewest in-flight queries, or dials a new one when all of them are
+// busy and we may open more. The returned bool is true when an existing connection is returned.
+func (pl *pipeline) conn(t *Transport, proto string) (*pipeConn, bool, error) {
+	pl.mu.Lock()
+	for {
+		var (
+			best  *pipeConn
+			least = pl.max
+		)
+		live := pl.conns[:0]
+		for _, pc := range pl.conns {
+			n, closed := pc.load()
+			if closed {
+				continue
+			}
+			live = append(live, pc)
+			if n < least {
+				best, least = pc, n
+			}
+		}
+		pl.conns = live
+
+		// Use an idle connection, or a busy one when we can't open more.
+		full := len(pl.conns)+pl.dialing >= pl.size
+		if best != nil && (least == 0 || full) {
+			pl.mu.Unlock()
+			ConnCacheHitsCount.WithLabelValues(t.addr, proto).Add(1)
+			return best, true, nil
+		}
+		if !full {
+			break
+		}
+		if pl.dialing == 0 {
+			pl.mu.Unlock()
+			return nil, false, errPipelineFull
+		}
+		// Wait for the connections being dialed, instead of opening one of our own.
+		dialed := pl.dialed
+		pl.mu.Unlock()
+		<-dialed
+		pl.mu.Lock()
+	}
+	if pl.dialed == nil {
+		pl.dialed = make(chan struct{})
+	}
+	pl.dialing++
+	pl.mu.Unlock()
+
+	ConnCacheMissesCount.WithLabelValues(t.addr, proto).Add(1)
+	c, err := t.dialConn(proto)
+
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.dialing--
+	close(pl.dialed)
+	pl.dialed = nil
+	if pl.dialing > 0 {
+		pl.dialed = make(chan struct{})
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	pc := &pipeConn{c: c, expire: t.expire, inflight: make(map[uint16]chan *dns.Msg)}
+	go pc.read()
+	pl.conns = append(pl.conns, pc)
+	return pc, false, nil
+}
+
+// close closes all pipelined connections.
+func (pl *pipeline) close() {
+	pl.mu.Lock()
+	conns := pl.conns
+	pl.conns = nil
+	pl.mu.Unlock()
+
+	for _, pc := range conns {
+		pc.close()
+	}
+}
+
+// load returns the number of in-flight queries and if the connection is closed.
+func (pc *pipeConn) load() (int, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return len(pc.inflight), pc.closed
+}
+
+// register allocates an unused ID on this connection and returns it with the channel the response is sent on.
+// It returns errPipelineFull when max queries are already in-flight.
+func (pc *pipeConn) register(max int) (uint16, chan *dns.Msg, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.closed {
+		return 0, nil, ErrCachedClosed
+	}
+	if len(pc.inflight) >= max {
+		return 0, nil, errPipelineFull
+	}
+	id := uint16(rand.Uint32())
+	for {
+		if _, ok := pc.inflight[id]; !ok {
+			break
+		}
+		id++
+	}
+	ch := make(chan *dns.Msg, 1)
+	pc.inflight[id] = ch
+	return id, ch, nil
+}
+
+func (pc *pipeConn) unregister(id uint16) {
+	pc.mu.Lock()
+	delete(pc.inflight, id)
+	pc.mu.Unlock()
+}
+
+// close closes the connection and fails all in-flight queries.
+func (pc *pipeConn) close() {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.closed {
+		return
+	}
+	pc.closed = true
+	pc.c.Close()
+	for id, ch := range pc.inflight {
+		close(ch)
+		delete(pc.inflight, id)
+	}
+}
+
+// read reads responses and hands them to the waiting queries. Responses we don't (or no longer) wait for are
+// dropped. The connection is closed when it was idle for pc.expire or on any error.
+func (pc *pipeConn) read() {
+	defer pc.close()
+	for {
+		pc.c.SetReadDeadline(time.Now().Add(pc.expire))
+		ret, err := pc.c.ReadMsg()
+		if err != nil {
+			if err != io.EOF {
+				if n, closed := pc.load(); n > 0 && !closed {
+					log.Debugf("Closing pipelined connection to %s with %d queries in-flight: %s", pc.c.RemoteAddr(), n, err)
+				}
+			}
+			return
+		}
+
+		pc.mu.Lock()
+		ch, ok := pc.inflight[ret.Id]
+		delete(pc.inflight, ret.Id)
+		pc.mu.Unlock()
+		if ok {
+			ch <- ret
+		}
+	}
+}
+
+// errTimeout is returned (wrapped in a net.OpError) when a pipelined query didn't get a response in time.
+var errTimeout = timeoutError{}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// dialConn dials a new connection to t's address.
+func (t *Transport) dialConn(proto string) (*dns.Conn, error) {
+	reqTime := time.Now()
+	timeout := t.dialTimeout()
+	var (
+		conn *dns.Conn
+		err  error
+	)
+	if proto == "tcp-tls" {
+		conn, err = dns.DialTimeoutWithTLS("tcp", t.addr, t.tlsConfig, timeout)
+	} else {
+		conn, err = dns.DialTimeout(proto, t.addr, timeout)
+	}
+	t.updateDialTimeout(time.Since(reqTime))
+	return conn, err
+}
+
+// SetPipeline enables pipelining of TCP and DNS-over-TLS queries over at most size connections, each having at most
+// max queries in-flight.
+func (t *Transport) SetPipeline(size, max int) { t.pipeline = newPipeline(size, max) }
+
+const (
+	defaultPipeConns = 2   // default number of pipelined connections per upstream
+	defaultPipeMax   = 100 // default maximum number of in-flight queries per pipelined connection
+)
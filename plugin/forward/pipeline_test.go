@@ -0,0 +1,222 @@
+package forward
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// reverseServer is a TCP server that reads n queries from a connection and answers them in reverse order.
+func reverseServer(t *testing.T, n int) (string, *int32, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := new(int32)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(accepted, 1)
+			go func(conn *dns.Conn) {
+				defer conn.Close()
+				qs := []*dns.Msg{}
+				for len(qs) < n {
+					m, err := conn.ReadMsg()
+					if err != nil {
+						return
+					}
+					qs = append(qs, m)
+				}
+				for i := len(qs) - 1; i >= 0; i-- {
+					ret := new(dns.Msg)
+					ret.SetReply(qs[i])
+					ret.Answer = append(ret.Answer, test.A(qs[i].Question[0].Name+" IN A 127.0.0.1"))
+					conn.WriteMsg(ret)
+				}
+			}(&dns.Conn{Conn: c})
+		}
+	}()
+	return l.Addr().String(), accepted, func() { l.Close() }
+}
+
+func TestPipelineOutOfOrder(t *testing.T) {
+	const n = 5
+	addr, accepted, stop := reverseServer(t, n)
+	defer stop()
+
+	c := caddy.NewTestController("dns", "forward . "+addr+" {\nforce_tcp\npipeline 1 10\n}\n")
+	f, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Failed to create forwarder: %s", err)
+	}
+	f.OnStartup()
+	defer f.OnShutdown()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := "example" + strconv.Itoa(i) + ".org."
+			m := new(dns.Msg)
+			m.SetQuestion(name, dns.TypeA)
+			m.Id = 42 // all queries use the same ID, the pipeline must tell them apart
+			state := request.Request{W: &test.ResponseWriter{}, Req: m}
+
+			ret, err := f.current()[0].Connect(context.TODO(), state, f.opts)
+			if err != nil {
+				t.Errorf("Query %d: expected no error, got: %s", i, err)
+				return
+			}
+			if ret.Id != 42 {
+				t.Errorf("Query %d: expected ID 42, got %d", i, ret.Id)
+			}
+			if x := ret.Answer[0].Header().Name; x != name {
+				t.Errorf("Query %d: expected answer for %s, got %s", i, name, x)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if x := atomic.LoadInt32(accepted); x != 1 {
+		t.Errorf("Expected 1 connection, got %d", x)
+	}
+}
+
+func TestPipelineFull(t *testing.T) {
+	addr, _, stop := reverseServer(t, 2)
+	defer stop()
+
+	tr := newTransport(addr)
+	tr.Start()
+	defer tr.Stop()
+	tr.SetPipeline(1, 1)
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+
+	errc := make(chan error)
+	go func() {
+		_, err := tr.pipeline.Exchange(context.TODO(), tr, "tcp", m)
+		errc <- err
+	}()
+
+	// Wait until the first query is in-flight.
+	for {
+		tr.pipeline.mu.Lock()
+		n := 0
+		if len(tr.pipeline.conns) > 0 {
+			n, _ = tr.pipeline.conns[0].load()
+		}
+		tr.pipeline.mu.Unlock()
+		if n > 0 {
+			break
+		}
+	}
+
+	if _, err := tr.pipeline.Exchange(context.TODO(), tr, "tcp", m); err != errPipelineFull {
+		t.Errorf("Expected %s, got: %v", errPipelineFull, err)
+	}
+
+	tr.pipeline.close()
+	if err := <-errc; err != errPipelineClosed {
+		t.Errorf("Expected %s, got: %v", errPipelineClosed, err)
+	}
+}
+
+func TestSetupPipeline(t *testing.T) {
+	tests := []struct {
+		input         string
+		shouldErr     bool
+		expectedConns int
+		expectedMax   int
+	}{
+		{"forward . 127.0.0.1\n", false, 0, 0},
+		{"forward . 127.0.0.1 {\npipeline\n}\n", false, defaultPipeConns, defaultPipeMax},
+		{"forward . 127.0.0.1 {\npipeline 4\n}\n", false, 4, defaultPipeMax},
+		{"forward . 127.0.0.1 {\npipeline 4 20\n}\n", false, 4, 20},
+		{"forward . 127.0.0.1 {\npipeline 0\n}\n", true, 0, 0},
+		{"forward . 127.0.0.1 {\npipeline 1 2 3\n}\n", true, 0, 0},
+		{"forward . 127.0.0.1 {\npipeline many\n}\n", true, 0, 0},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		f, err := parseForward(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+		if f.pipeConns != test.expectedConns || f.pipeMax != test.expectedMax {
+			t.Errorf("Test %d: expected %d/%d, got %d/%d", i, test.expectedConns, test.expectedMax, f.pipeConns, f.pipeMax)
+		}
+		if (f.current()[0].transport.pipeline != nil) != (test.expectedConns > 0) {
+			t.Errorf("Test %d: expected pipeline to be set: %t", i, test.expectedConns > 0)
+		}
+	}
+}
+
+// BenchmarkProxyTCP compares the per-query connection cache with pipelining for concurrent TCP queries.
+func BenchmarkProxyTCP(b *testing.B) {
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		ret.Answer = append(ret.Answer, test.A("example.org. IN A 127.0.0.1"))
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	for _, bm := range []struct {
+		name   string
+		config string
+	}{
+		{"cached", "force_tcp"},
+		{"pipeline", "force_tcp\npipeline 4 100"},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			c := caddy.NewTestController("dns", "forward . "+s.Addr+" {\n"+bm.config+"\n}\n")
+			f, err := parseForward(c)
+			if err != nil {
+				b.Fatalf("Failed to create forwarder: %s", err)
+			}
+			f.OnStartup()
+			defer f.OnShutdown()
+
+			dials := testutil.ToFloat64(ConnCacheMissesCount.WithLabelValues(s.Addr, "tcp"))
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				m := new(dns.Msg)
+				m.SetQuestion("example.org.", dns.TypeA)
+				for pb.Next() {
+					rec := dnstest.NewRecorder(&test.ResponseWriter{})
+					if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+			b.StopTimer()
+			b.ReportMetric(testutil.ToFloat64(ConnCacheMissesCount.WithLabelValues(s.Addr, "tcp"))-dials, "dials")
+		})
+	}
+}
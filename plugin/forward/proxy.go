@@ -6,6 +6,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	pkgtls "github.com/coredns/coredns/plugin/pkg/tls"
 	"github.com/coredns/coredns/plugin/pkg/up"
 )
 
@@ -40,6 +41,22 @@ func (p *Proxy) SetTLSConfig(cfg *tls.Config) {
 	p.health.SetTLSConfig(cfg)
 }
 
+// proxyTLSConfig returns the TLS config for p. When SPKI pins or a SAN are configured this is a copy of f.tlsConfig
+// that verifies them; a failure is logged, counted and marks p as down.
+func (f *Forward) proxyTLSConfig(p *Proxy) *tls.Config {
+	pins := append(append([]string{}, f.tlsPins[""]...), f.tlsPins[p.addr]...)
+	if len(pins) == 0 && f.tlsSAN == "" {
+		return f.tlsConfig
+	}
+
+	v := pkgtls.Verifier{Pins: pins, SAN: f.tlsSAN}
+	return v.Config(f.tlsConfig, func(err error) {
+		TLSVerifyFailureCount.WithLabelValues(p.addr, pkgtls.VerifyErrorReason(err)).Add(1)
+		log.Warningf("Certificate verification failed for %s: %s", p.addr, err)
+		atomic.StoreUint32(&p.fails, f.maxfails+1)
+	})
+}
+
 // SetExpire sets the expire duration in the lower p.transport.
 func (p *Proxy) SetExpire(expire time.Duration) { p.transport.SetExpire(expire) }
 
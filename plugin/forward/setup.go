@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coredns/caddy"
@@ -14,6 +15,8 @@ import (
 	"github.com/coredns/coredns/plugin/pkg/parse"
 	pkgtls "github.com/coredns/coredns/plugin/pkg/tls"
 	"github.com/coredns/coredns/plugin/pkg/transport"
+
+	"github.com/miekg/dns"
 )
 
 func init() { plugin.Register("forward", setup) }
@@ -23,9 +26,6 @@ func setup(c *caddy.Controller) error {
 	if err != nil {
 		return plugin.Error("forward", err)
 	}
-	if f.Len() > max {
-		return plugin.Error("forward", fmt.Errorf("more than %d TOs configured: %d", max, f.Len()))
-	}
 
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		f.Next = next
@@ -51,17 +51,25 @@ func setup(c *caddy.Controller) error {
 	return nil
 }
 
-// OnStartup starts a goroutines for all proxies.
+// OnStartup starts a goroutines for all proxies and, if needed, one to refresh the upstreams.
 func (f *Forward) OnStartup() (err error) {
-	for _, p := range f.proxies {
+	for _, p := range f.current() {
 		p.start(f.hcInterval)
 	}
+	if u := f.upstreams; u != nil && u.interval > 0 && u.dynamic() {
+		u.stop = make(chan bool)
+		go f.periodicRefresh()
+	}
 	return nil
 }
 
 // OnShutdown stops all configured proxies.
 func (f *Forward) OnShutdown() error {
-	for _, p := range f.proxies {
+	if u := f.upstreams; u != nil && u.stop != nil {
+		close(u.stop)
+		u.stop = nil
+	}
+	for _, p := range f.current() {
 		p.stop()
 	}
 	return nil
@@ -105,23 +113,7 @@ func parseStanza(c *caddy.Controller) (*Forward, error) {
 		return f, c.ArgErr()
 	}
 
-	toHosts, err := parse.HostPortOrFile(to...)
-	if err != nil {
-		return f, err
-	}
-
-	transports := make([]string, len(toHosts))
-	allowedTrans := map[string]bool{"dns": true, "tls": true}
-	for i, host := range toHosts {
-		trans, h := parse.Transport(host)
-
-		if !allowedTrans[trans] {
-			return f, fmt.Errorf("'%s' is not supported as a destination protocol in forward: %s", trans, host)
-		}
-		p := NewProxy(h, trans)
-		f.proxies = append(f.proxies, p)
-		transports[i] = trans
-	}
+	f.upstreams = &upstreams{to: to, interval: defaultRefresh}
 
 	for c.NextBlock() {
 		if err := parseBlock(c, f); err != nil {
@@ -129,22 +121,30 @@ func parseStanza(c *caddy.Controller) (*Forward, error) {
 		}
 	}
 
+	toHosts, err := f.upstreams.resolve()
+	if err != nil {
+		return f, err
+	}
+	if (len(f.tlsPins) > 0 || f.tlsSAN != "") && !hasTLS(append([]string{f.upstreams.srv}, toHosts...)) {
+		return f, fmt.Errorf("tls_spki_pin and tls_san require tls:// upstreams")
+	}
+
 	if f.tlsServerName != "" {
 		f.tlsConfig.ServerName = f.tlsServerName
 	}
 
 	// Initialize ClientSessionCache in tls.Config. This may speed up a TLS handshake
 	// in upcoming connections to the same TLS server.
-	f.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(len(f.proxies))
+	f.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(len(toHosts))
 
-	for i := range f.proxies {
-		// Only set this for proxies that need it.
-		if transports[i] == transport.TLS {
-			f.proxies[i].SetTLSConfig(f.tlsConfig)
+	for _, host := range toHosts {
+		p, err := f.newProxy(host)
+		if err != nil {
+			return f, err
 		}
-		f.proxies[i].SetExpire(f.expire)
-		f.proxies[i].health.SetRecursionDesired(f.opts.hcRecursionDesired)
+		f.proxies = append(f.proxies, p)
 	}
+	f.upstreams.hosts = toHosts
 
 	return f, nil
 }
@@ -219,6 +219,38 @@ func parseBlock(c *caddy.Controller, f *Forward) error {
 			return c.ArgErr()
 		}
 		f.tlsServerName = c.Val()
+	case "tls_spki_pin":
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return c.ArgErr()
+		}
+		addr := ""
+		if !pkgtls.IsSPKIPin(args[0]) {
+			_, h := parse.Transport(args[0])
+			a, err := parse.HostPort(h, transport.TLSPort)
+			if err != nil {
+				return err
+			}
+			addr = a
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			return c.ArgErr()
+		}
+		for _, pin := range args {
+			if !pkgtls.IsSPKIPin(pin) {
+				return fmt.Errorf("tls_spki_pin: not a base64 encoded SHA-256 hash: '%s'", pin)
+			}
+		}
+		if f.tlsPins == nil {
+			f.tlsPins = make(map[string][]string)
+		}
+		f.tlsPins[addr] = append(f.tlsPins[addr], args...)
+	case "tls_san":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		f.tlsSAN = c.Val()
 	case "expire":
 		if !c.NextArg() {
 			return c.ArgErr()
@@ -258,6 +290,78 @@ func parseBlock(c *caddy.Controller, f *Forward) error {
 		}
 		f.ErrLimitExceeded = errors.New("concurrent queries exceeded maximum " + c.Val())
 		f.maxConcurrent = int64(n)
+	case "next":
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return c.ArgErr()
+		}
+		for _, a := range args {
+			rc, ok := dns.StringToRcode[strings.ToUpper(a)]
+			if !ok {
+				return fmt.Errorf("next: unknown rcode '%s'", a)
+			}
+			f.nextRcodes = append(f.nextRcodes, rc)
+		}
+	case "max_attempts":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		n, err := strconv.Atoi(c.Val())
+		if err != nil {
+			return err
+		}
+		if n < 1 {
+			return fmt.Errorf("max_attempts must be positive: %d", n)
+		}
+		f.maxAttempts = n
+	case "pipeline":
+		f.pipeConns, f.pipeMax = defaultPipeConns, defaultPipeMax
+		args := c.RemainingArgs()
+		if len(args) > 2 {
+			return c.ArgErr()
+		}
+		for i, a := range args {
+			n, err := strconv.Atoi(a)
+			if err != nil {
+				return err
+			}
+			if n < 1 {
+				return fmt.Errorf("pipeline: values must be positive: %d", n)
+			}
+			if i == 0 {
+				f.pipeConns = n
+				continue
+			}
+			f.pipeMax = n
+		}
+	case "upstream_file":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		f.upstreams.file = c.Val()
+		if c.NextArg() {
+			return c.ArgErr()
+		}
+	case "upstream_srv":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		f.upstreams.srv = c.Val()
+		if c.NextArg() {
+			return c.ArgErr()
+		}
+	case "refresh":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		dur, err := time.ParseDuration(c.Val())
+		if err != nil {
+			return err
+		}
+		if dur < 0 {
+			return fmt.Errorf("refresh can't be negative: %s", dur)
+		}
+		f.upstreams.interval = dur
 
 	default:
 		return c.Errf("unknown property '%s'", c.Val())
@@ -267,3 +371,13 @@ func parseBlock(c *caddy.Controller, f *Forward) error {
 }
 
 const max = 15 // Maximum number of upstreams.
+
+// hasTLS returns true if any of hosts uses DNS-over-TLS.
+func hasTLS(hosts []string) bool {
+	for _, h := range hosts {
+		if trans, _ := parse.Transport(h); trans == transport.TLS {
+			return true
+		}
+	}
+	return false
+}
@@ -8,6 +8,8 @@ import (
 	"testing"
 
 	"github.com/coredns/caddy"
+
+	"github.com/miekg/dns"
 )
 
 func TestSetup(t *testing.T) {
@@ -257,3 +259,49 @@ func TestSetupHealthCheck(t *testing.T) {
 		}
 	}
 }
+
+func TestSetupNext(t *testing.T) {
+	tests := []struct {
+		input            string
+		shouldErr        bool
+		expectedRcodes   []int
+		expectedAttempts int
+		expectedErr      string
+	}{
+		// positive
+		{"forward . 127.0.0.1\n", false, nil, 0, ""},
+		{"forward . 127.0.0.1 {\nnext SERVFAIL\n}\n", false, []int{dns.RcodeServerFailure}, 0, ""},
+		{"forward . 127.0.0.1 {\nnext servfail REFUSED NOTIMP\nmax_attempts 2\n}\n", false, []int{dns.RcodeServerFailure, dns.RcodeRefused, dns.RcodeNotImplemented}, 2, ""},
+		// negative
+		{"forward . 127.0.0.1 {\nnext\n}\n", true, nil, 0, "Wrong argument count"},
+		{"forward . 127.0.0.1 {\nnext BLAH\n}\n", true, nil, 0, "unknown rcode"},
+		{"forward . 127.0.0.1 {\nmax_attempts 0\n}\n", true, nil, 0, "must be positive"},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		f, err := parseForward(c)
+
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %d: expected error but found %s for input %s", i, err, test.input)
+		}
+
+		if err != nil {
+			if !test.shouldErr {
+				t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			}
+
+			if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(f.nextRcodes, test.expectedRcodes) {
+			t.Errorf("Test %d: expected: %v, got: %v", i, test.expectedRcodes, f.nextRcodes)
+		}
+		if f.maxAttempts != test.expectedAttempts {
+			t.Errorf("Test %d: expected: %d, got: %d", i, test.expectedAttempts, f.maxAttempts)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package forward
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/parse"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+)
+
+// upstreams holds the sources of upstream addresses. Files (like /etc/resolv.conf) given in TO, the upstream_file
+// and the upstream_srv name are re-read every interval while running.
+type upstreams struct {
+	to       []string      // TO as given in the Corefile, hosts and files in order
+	file     string        // file with one upstream per line
+	srv      string        // SRV name to resolve, with an optional transport prefix
+	interval time.Duration // how often to re-read the sources, 0 disables it
+
+	hosts    []string // hosts of the currently used proxies, index aligned with Forward.proxies
+	srvHosts []string // the targets found the last time srv resolved
+	stop     chan bool
+}
+
+// dynamic returns true if any of the sources can change while we're running.
+func (u *upstreams) dynamic() bool {
+	if u.file != "" || u.srv != "" {
+		return true
+	}
+	for _, t := range u.to {
+		if isFile(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve reads all sources and returns the upstream hosts in order: TO, upstream_file and upstream_srv. More than
+// max hosts, or none at all, is an error, both at startup and when refreshing. An SRV name that doesn't resolve is
+// not: the targets found the last time are used, none at startup, and it's looked up again on the next refresh.
+func (u *upstreams) resolve() ([]string, error) {
+	hosts, err := parse.HostPortOrFile(u.to...)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.file != "" {
+		hs, err := readUpstreamFile(u.file)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, hs...)
+	}
+
+	if u.srv != "" {
+		hs, err := lookupSRV(u.srv)
+		if err != nil {
+			log.Warningf("Failed to look up upstream SRV %q, using the %d targets found before: %s", u.srv, len(u.srvHosts), err)
+			hs = u.srvHosts
+		}
+		u.srvHosts = hs
+		hosts = append(hosts, hs...)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no upstreams found")
+	}
+	if len(hosts) > max {
+		return nil, fmt.Errorf("more than %d TOs configured: %d", max, len(hosts))
+	}
+	return hosts, nil
+}
+
+// readUpstreamFile reads a file with upstreams, one or more per line in the same syntax as TO. Comments start with '#'.
+func readUpstreamFile(name string) ([]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var to []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		to = append(to, strings.Fields(line)...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(to) == 0 {
+		return nil, fmt.Errorf("no upstreams found in %q", name)
+	}
+	return parse.HostPortOrFile(to...)
+}
+
+// lookupSRV is lookupUpstreamSRV, or a fake in the tests.
+var lookupSRV = lookupUpstreamSRV
+
+// lookupUpstreamSRV resolves the SRV records for name and returns the addresses of the targets.
+func lookupUpstreamSRV(name string) ([]string, error) {
+	trans, name := parse.Transport(name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), srvTimeout)
+	defer cancel()
+
+	_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	for _, srv := range srvs {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, srv.Target)
+		if err != nil {
+			log.Warningf("Failed to resolve SRV target %q: %s", srv.Target, err)
+			continue
+		}
+		for _, a := range addrs {
+			h := net.JoinHostPort(a, strconv.Itoa(int(srv.Port)))
+			if trans != transport.DNS {
+				h = trans + "://" + h
+			}
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no upstreams found for SRV %q", name)
+	}
+	return hosts, nil
+}
+
+// isFile returns true if s is not an address but an existing file.
+func isFile(s string) bool {
+	if trans, _ := parse.Transport(s); trans != transport.DNS {
+		return false
+	}
+	fi, err := os.Stat(s)
+	return err == nil && !fi.IsDir()
+}
+
+// newProxy returns a new proxy for host, which may carry a transport prefix.
+func (f *Forward) newProxy(host string) (*Proxy, error) {
+	trans, h := parse.Transport(host)
+	if trans != transport.DNS && trans != transport.TLS {
+		return nil, fmt.Errorf("'%s' is not supported as a destination protocol in forward: %s", trans, host)
+	}
+
+	p := NewProxy(h, trans)
+	// Only set this for proxies that need it.
+	if trans == transport.TLS {
+		p.SetTLSConfig(f.proxyTLSConfig(p))
+	}
+	p.SetExpire(f.expire)
+	if f.pipeConns > 0 {
+		p.transport.SetPipeline(f.pipeConns, f.pipeMax)
+	}
+	p.health.SetRecursionDesired(f.opts.hcRecursionDesired)
+	return p, nil
+}
+
+// refresh re-reads the upstream sources and swaps in a new proxy set when they changed. Proxies for hosts
+// that are still present are carried over, so they keep their connections and health check state.
+func (f *Forward) refresh() {
+	u := f.upstreams
+	hosts, err := u.resolve()
+	if err != nil {
+		log.Warningf("Failed to refresh upstreams, keeping the current set: %s", err)
+		return
+	}
+	if equalHosts(hosts, u.hosts) {
+		return
+	}
+
+	f.mu.RLock()
+	old := make(map[string][]*Proxy, len(u.hosts))
+	for i, h := range u.hosts {
+		old[h] = append(old[h], f.proxies[i])
+	}
+	f.mu.RUnlock()
+
+	proxies := make([]*Proxy, 0, len(hosts))
+	fresh := []*Proxy{}
+	for _, h := range hosts {
+		if ps := old[h]; len(ps) > 0 {
+			proxies = append(proxies, ps[0])
+			old[h] = ps[1:]
+			continue
+		}
+		p, err := f.newProxy(h)
+		if err != nil {
+			log.Warningf("Failed to refresh upstreams, keeping the current set: %s", err)
+			return
+		}
+		proxies = append(proxies, p)
+		fresh = append(fresh, p)
+	}
+	for _, p := range fresh {
+		p.start(f.hcInterval)
+	}
+
+	f.mu.Lock()
+	f.proxies = proxies
+	f.mu.Unlock()
+
+	log.Infof("Upstreams changed from %v to %v", u.hosts, hosts)
+	u.hosts = hosts
+
+	// Queries in flight may still use the old proxies. Only their health checks are stopped here, the transport is
+	// stopped by the finalizer once they are unused, and Dial doesn't block on a stopped transport.
+	for _, ps := range old {
+		for _, p := range ps {
+			p.stop()
+		}
+	}
+}
+
+// periodicRefresh calls refresh every u.interval until u.stop is closed.
+func (f *Forward) periodicRefresh() {
+	u := f.upstreams
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-u.stop:
+			return
+		case <-ticker.C:
+			f.refresh()
+		}
+	}
+}
+
+func equalHosts(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	defaultRefresh = 30 * time.Second
+	srvTimeout     = 5 * time.Second
+)
@@ -0,0 +1,177 @@
+package forward
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+)
+
+func TestRefreshResolvconf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forward")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	resolv := filepath.Join(dir, "resolv.conf")
+	if err := ioutil.WriteFile(resolv, []byte("nameserver 10.10.255.252\nnameserver 10.10.255.253\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	list := filepath.Join(dir, "upstreams")
+	if err := ioutil.WriteFile(list, []byte("# extra upstreams\n10.10.255.1 10.10.255.2:1053\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := caddy.NewTestController("dns", "forward . "+resolv+" {\nupstream_file "+list+"\n}\n")
+	f, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Failed to create forwarder: %s", err)
+	}
+	if !f.upstreams.dynamic() {
+		t.Errorf("Expected upstreams to be dynamic")
+	}
+	expect := []string{"10.10.255.252:53", "10.10.255.253:53", "10.10.255.1:53", "10.10.255.2:1053"}
+	checkProxies(t, f, expect)
+
+	kept := f.current()[1]
+	kept.fails = 3 // health check state that should survive the refresh
+
+	if err := ioutil.WriteFile(resolv, []byte("nameserver 10.10.255.254\nnameserver 10.10.255.253\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(list, []byte("tls://10.10.255.3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f.refresh()
+	defer f.OnShutdown()
+
+	expect = []string{"10.10.255.254:53", "10.10.255.253:53", "10.10.255.3:853"}
+	checkProxies(t, f, expect)
+	if f.current()[1] != kept || kept.fails != 3 {
+		t.Errorf("Expected proxy for %s to be carried over", kept.addr)
+	}
+	if f.current()[2].transport.tlsConfig == nil {
+		t.Errorf("Expected TLS config to be set for %s", f.current()[2].addr)
+	}
+
+	// A broken source keeps the current set.
+	if err := ioutil.WriteFile(list, []byte("\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f.refresh()
+	checkProxies(t, f, expect)
+
+	// Too many upstreams is an error, like it is at startup, and keeps the current set.
+	many := ""
+	for i := 1; i <= max; i++ {
+		many += fmt.Sprintf("10.10.254.%d\n", i)
+	}
+	if err := ioutil.WriteFile(list, []byte(many), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f.refresh()
+	checkProxies(t, f, expect)
+
+	c = caddy.NewTestController("dns", "forward . "+resolv+" {\nupstream_file "+list+"\n}\n")
+	if _, err := parseForward(c); err == nil {
+		t.Errorf("Expected error for more than %d upstreams, got none", max)
+	}
+}
+
+func checkProxies(t *testing.T, f *Forward, expect []string) {
+	t.Helper()
+	proxies := f.current()
+	if len(proxies) != len(expect) {
+		t.Fatalf("Expected %d proxies, got %d", len(expect), len(proxies))
+	}
+	for i, p := range proxies {
+		if p.addr != expect[i] {
+			t.Errorf("Expected proxy %d to be %q, got %q", i, expect[i], p.addr)
+		}
+	}
+}
+
+func TestSetupRefresh(t *testing.T) {
+	tests := []struct {
+		input       string
+		shouldErr   bool
+		expectedVal time.Duration
+		dynamic     bool
+	}{
+		{"forward . 127.0.0.1\n", false, defaultRefresh, false},
+		{"forward . 127.0.0.1 {\nrefresh 10s\n}\n", false, 10 * time.Second, false},
+		{"forward . 127.0.0.1 {\nrefresh 0s\n}\n", false, 0, false},
+		{"forward . 127.0.0.1 {\nrefresh -1s\n}\n", true, 0, false},
+		{"forward . 127.0.0.1 {\nupstream_file\n}\n", true, 0, false},
+		{"forward . 127.0.0.1 {\nupstream_file /does/not/exist\n}\n", true, 0, false},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		f, err := parseForward(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+		if f.upstreams.interval != test.expectedVal {
+			t.Errorf("Test %d: expected: %s, got: %s", i, test.expectedVal, f.upstreams.interval)
+		}
+		if f.upstreams.dynamic() != test.dynamic {
+			t.Errorf("Test %d: expected dynamic to be %t", i, test.dynamic)
+		}
+	}
+}
+
+func TestResolveSRVFailure(t *testing.T) {
+	defer func(l func(string) ([]string, error)) { lookupSRV = l }(lookupSRV)
+	var srvErr error
+	lookupSRV = func(string) ([]string, error) {
+		if srvErr != nil {
+			return nil, srvErr
+		}
+		return []string{"10.0.0.2:53"}, nil
+	}
+
+	// The SRV name doesn't resolve at startup, the other upstreams are used.
+	srvErr = errors.New("no such host")
+	f, err := parseForward(caddy.NewTestController("dns", "forward . 127.0.0.1 {\nupstream_srv _dns._udp.example.org\n}\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if !equalHosts(f.upstreams.hosts, []string{"127.0.0.1:53"}) {
+		t.Errorf("Expected only the TO upstream, got %v", f.upstreams.hosts)
+	}
+
+	// It resolves on the next refresh.
+	srvErr = nil
+	f.refresh()
+	if !equalHosts(f.upstreams.hosts, []string{"127.0.0.1:53", "10.0.0.2:53"}) {
+		t.Errorf("Expected the SRV target to be added, got %v", f.upstreams.hosts)
+	}
+
+	// A failure on a later refresh keeps the targets found before.
+	srvErr = errors.New("no such host")
+	f.refresh()
+	if !equalHosts(f.upstreams.hosts, []string{"127.0.0.1:53", "10.0.0.2:53"}) {
+		t.Errorf("Expected the SRV target to be kept, got %v", f.upstreams.hosts)
+	}
+	f.OnShutdown()
+
+	// Without any other upstream it's an error.
+	u := &upstreams{srv: "_dns._udp.example.org"}
+	if _, err := u.resolve(); err == nil {
+		t.Errorf("Expected an error without any upstream")
+	}
+}
@@ -8,12 +8,15 @@ import (
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/debug"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
 	"github.com/coredns/coredns/request"
 
 	"github.com/miekg/dns"
 	ot "github.com/opentracing/opentracing-go"
 )
 
+var log = clog.NewWithPlugin("grpc")
+
 // GRPC represents a plugin instance that can proxy requests to another (DNS) server via gRPC protocol.
 // It has a list of proxies each representing one upstream proxy.
 type GRPC struct {
@@ -25,6 +28,10 @@ type GRPC struct {
 
 	tlsConfig     *tls.Config
 	tlsServerName string
+	tlsPins       map[string][]string // SPKI pins per upstream, "" holds the pins for all upstreams
+	tlsSAN        string              // SAN the upstream's certificate must carry
+
+	streams int // number of streams per upstream, 0 disables streaming
 
 	Next plugin.Handler
 }
@@ -58,6 +65,10 @@ func (g *GRPC) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (
 
 		proxy := list[i]
 		i++
+		// Skip upstreams that failed certificate verification, unless that is all we have left.
+		if proxy.Down() && !allDown(list[i:]) {
+			continue
+		}
 
 		if span != nil {
 			child = span.Tracer().StartSpan("query", ot.ChildOf(span.Context()))
@@ -132,6 +143,16 @@ func (g *GRPC) isAllowedDomain(name string) bool {
 	return true
 }
 
+// allDown returns true if all proxies in list are down.
+func allDown(list []*Proxy) bool {
+	for _, p := range list {
+		if !p.Down() {
+			return false
+		}
+	}
+	return true
+}
+
 // List returns a set of proxies to be used for this client depending on the policy in p.
 func (g *GRPC) list() []*Proxy { return g.p.List(g.proxies) }
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/coredns/coredns/pb"
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
@@ -19,6 +20,7 @@ func TestGRPC(t *testing.T) {
 		t.Fatalf("Error packing response: %s", err.Error())
 	}
 	dnsPacket := &pb.DnsPacket{Msg: msg}
+	down := time.Now().Add(time.Minute).UnixNano()
 	tests := map[string]struct {
 		proxies []*Proxy
 		wantErr bool
@@ -51,6 +53,20 @@ func TestGRPC(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		"multiple_proxies_one_down": {
+			proxies: []*Proxy{
+				{down: down, client: &testServiceClient{dnsPacket: nil, err: errors.New("")}},
+				{client: &testServiceClient{dnsPacket: dnsPacket, err: nil}},
+			},
+			wantErr: false,
+		},
+		"multiple_proxies_all_down": {
+			proxies: []*Proxy{
+				{down: down, client: &testServiceClient{dnsPacket: dnsPacket, err: nil}},
+				{down: down, client: &testServiceClient{dnsPacket: dnsPacket, err: nil}},
+			},
+			wantErr: false,
+		},
 		"multiple_proxies_ko": {
 			proxies: []*Proxy{
 				{client: &testServiceClient{dnsPacket: nil, err: errors.New("")}},
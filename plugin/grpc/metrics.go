@@ -28,4 +28,10 @@ var (
 		Buckets:   plugin.TimeBuckets,
 		Help:      "Histogram of the time each request took.",
 	}, []string{"to"})
+	TLSVerifyFailureCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "grpc",
+		Name:      "tls_verify_failures_total",
+		Help:      "Counter of failed certificate verifications (SPKI pin or SAN) per upstream.",
+	}, []string{"to", "reason"})
 )
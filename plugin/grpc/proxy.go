@@ -4,9 +4,11 @@ import (
 	"context"
 	"crypto/tls"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/coredns/coredns/pb"
+	pkgtls "github.com/coredns/coredns/plugin/pkg/tls"
 
 	"github.com/miekg/dns"
 	"google.golang.org/grpc"
@@ -17,19 +19,29 @@ import (
 
 // Proxy defines an upstream host.
 type Proxy struct {
+	down int64 // unix time in nanoseconds until which the proxy is down after a failed certificate verification, atomic
 	addr string
 
 	// connection
 	client   pb.DnsServiceClient
 	dialOpts []grpc.DialOption
+	streams  *streamPool // when set, queries are sent over streams
 }
 
-// newProxy returns a new proxy.
-func newProxy(addr string, tlsConfig *tls.Config) (*Proxy, error) {
+// newProxy returns a new proxy. If v has pins or a SAN they are verified on the TLS connection.
+func newProxy(addr string, tlsConfig *tls.Config, v pkgtls.Verifier) (*Proxy, error) {
 	p := &Proxy{
 		addr: addr,
 	}
 
+	if tlsConfig != nil && (len(v.Pins) > 0 || v.SAN != "") {
+		tlsConfig = v.Config(tlsConfig, func(err error) {
+			TLSVerifyFailureCount.WithLabelValues(p.addr, pkgtls.VerifyErrorReason(err)).Add(1)
+			log.Warningf("Certificate verification failed for %s: %s", p.addr, err)
+			atomic.StoreInt64(&p.down, time.Now().Add(downDuration).UnixNano())
+		})
+	}
+
 	if tlsConfig != nil {
 		p.dialOpts = append(p.dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
 	} else {
@@ -49,6 +61,37 @@ func newProxy(addr string, tlsConfig *tls.Config) (*Proxy, error) {
 func (p *Proxy) query(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
 	start := time.Now()
 
+	var (
+		ret *dns.Msg
+		err error
+	)
+	if p.streams != nil {
+		ret, err = p.streams.query(ctx, req)
+	}
+	// Without streams, or when they are not available, use a single RPC.
+	if p.streams == nil || err == errStreamUnsupported || err == errStreamClosed {
+		ret, err = p.unary(ctx, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.StoreInt64(&p.down, 0)
+
+	rc, ok := dns.RcodeToString[ret.Rcode]
+	if !ok {
+		rc = strconv.Itoa(ret.Rcode)
+	}
+
+	RequestCount.WithLabelValues(p.addr).Add(1)
+	RcodeCount.WithLabelValues(rc, p.addr).Add(1)
+	RequestDuration.WithLabelValues(p.addr).Observe(time.Since(start).Seconds())
+
+	return ret, nil
+}
+
+// unary sends the request in a single Query RPC.
+func (p *Proxy) unary(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
 	msg, err := req.Pack()
 	if err != nil {
 		return nil, err
@@ -67,15 +110,11 @@ func (p *Proxy) query(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
 	if err := ret.Unpack(reply.Msg); err != nil {
 		return nil, err
 	}
-
-	rc, ok := dns.RcodeToString[ret.Rcode]
-	if !ok {
-		rc = strconv.Itoa(ret.Rcode)
-	}
-
-	RequestCount.WithLabelValues(p.addr).Add(1)
-	RcodeCount.WithLabelValues(rc, p.addr).Add(1)
-	RequestDuration.WithLabelValues(p.addr).Observe(time.Since(start).Seconds())
-
 	return ret, nil
 }
+
+// Down returns true if this proxy failed the certificate verification in the last downDuration.
+func (p *Proxy) Down() bool { return time.Now().UnixNano() < atomic.LoadInt64(&p.down) }
+
+// downDuration is how long a proxy is skipped after a failed certificate verification, after that it is tried again.
+const downDuration = 30 * time.Second
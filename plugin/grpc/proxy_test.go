@@ -4,12 +4,15 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/coredns/coredns/pb"
 
 	"github.com/miekg/dns"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
 func TestProxy(t *testing.T) {
@@ -64,3 +67,18 @@ type testServiceClient struct {
 func (m testServiceClient) Query(ctx context.Context, in *pb.DnsPacket, opts ...grpc.CallOption) (*pb.DnsPacket, error) {
 	return m.dnsPacket, m.err
 }
+
+func (m testServiceClient) Stream(ctx context.Context, opts ...grpc.CallOption) (pb.DnsService_StreamClient, error) {
+	return nil, status.Error(codes.Unimplemented, "no streams")
+}
+
+func TestProxyDownExpires(t *testing.T) {
+	p := &Proxy{down: time.Now().Add(time.Minute).UnixNano()}
+	if !p.Down() {
+		t.Errorf("Expected proxy to be down")
+	}
+	p.down = time.Now().Add(-time.Second).UnixNano()
+	if p.Down() {
+		t.Errorf("Expected proxy to be up again after the down duration")
+	}
+}
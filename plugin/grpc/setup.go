@@ -3,6 +3,7 @@ package grpc
 import (
 	"crypto/tls"
 	"fmt"
+	"strconv"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
@@ -28,6 +29,15 @@ func setup(c *caddy.Controller) error {
 		return g
 	})
 
+	c.OnShutdown(func() error {
+		for _, p := range g.proxies {
+			if p.streams != nil {
+				p.streams.close()
+			}
+		}
+		return nil
+	})
+
 	return nil
 }
 
@@ -74,6 +84,10 @@ func parseStanza(c *caddy.Controller) (*GRPC, error) {
 		}
 	}
 
+	if g.tlsConfig == nil && g.tlsServerName == "" && (len(g.tlsPins) > 0 || g.tlsSAN != "") {
+		return g, fmt.Errorf("tls_spki_pin and tls_san require tls")
+	}
+
 	if g.tlsServerName != "" {
 		if g.tlsConfig == nil {
 			g.tlsConfig = new(tls.Config)
@@ -81,10 +95,14 @@ func parseStanza(c *caddy.Controller) (*GRPC, error) {
 		g.tlsConfig.ServerName = g.tlsServerName
 	}
 	for _, host := range toHosts {
-		pr, err := newProxy(host, g.tlsConfig)
+		v := pkgtls.Verifier{Pins: append(append([]string{}, g.tlsPins[""]...), g.tlsPins[host]...), SAN: g.tlsSAN}
+		pr, err := newProxy(host, g.tlsConfig, v)
 		if err != nil {
 			return nil, err
 		}
+		if g.streams > 0 {
+			pr.streams = newStreamPool(pr.client, g.streams)
+		}
 		g.proxies = append(g.proxies, pr)
 	}
 
@@ -118,6 +136,52 @@ func parseBlock(c *caddy.Controller, g *GRPC) error {
 			return c.ArgErr()
 		}
 		g.tlsServerName = c.Val()
+	case "tls_spki_pin":
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return c.ArgErr()
+		}
+		host := ""
+		if !pkgtls.IsSPKIPin(args[0]) {
+			hosts, err := parse.HostPortOrFile(args[0])
+			if err != nil {
+				return err
+			}
+			host = hosts[0]
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			return c.ArgErr()
+		}
+		for _, pin := range args {
+			if !pkgtls.IsSPKIPin(pin) {
+				return fmt.Errorf("tls_spki_pin: not a base64 encoded SHA-256 hash: '%s'", pin)
+			}
+		}
+		if g.tlsPins == nil {
+			g.tlsPins = make(map[string][]string)
+		}
+		g.tlsPins[host] = append(g.tlsPins[host], args...)
+	case "tls_san":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		g.tlsSAN = c.Val()
+	case "stream":
+		g.streams = defaultStreams
+		if c.NextArg() {
+			n, err := strconv.Atoi(c.Val())
+			if err != nil {
+				return err
+			}
+			if n < 1 {
+				return fmt.Errorf("stream: number of streams must be positive: %d", n)
+			}
+			g.streams = n
+		}
+		if c.NextArg() {
+			return c.ArgErr()
+		}
 	case "policy":
 		if !c.NextArg() {
 			return c.ArgErr()
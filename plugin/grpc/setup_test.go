@@ -151,3 +151,48 @@ nameserver 10.10.255.253`), 0666); err != nil {
 		}
 	}
 }
+
+func TestSetupTLSPin(t *testing.T) {
+	const (
+		pin1 = "YLh1dUR9y6Kja30RrAn7JKnbQG/uEtLMkBgFF2Fuihg="
+		pin2 = "sRHdihwgkaib1P1gxX8HFszlD+7/gTfNvuAybgLPNis="
+	)
+	tests := []struct {
+		input        string
+		shouldErr    bool
+		expectedPins map[string][]string
+		expectedSAN  string
+	}{
+		// positive
+		{"grpc . 127.0.0.1 {\ntls\ntls_spki_pin " + pin1 + " " + pin2 + "\n}\n", false, map[string][]string{"": {pin1, pin2}}, ""},
+		{"grpc . 127.0.0.1:8053 {\ntls\ntls_spki_pin 127.0.0.1:8053 " + pin1 + "\ntls_san dns.example.org\n}\n", false, map[string][]string{"127.0.0.1:8053": {pin1}}, "dns.example.org"},
+		// negative
+		{"grpc . 127.0.0.1 {\ntls_spki_pin\n}\n", true, nil, ""},
+		{"grpc . 127.0.0.1 {\ntls_spki_pin 127.0.0.1\n}\n", true, nil, ""},
+		{"grpc . 127.0.0.1 {\ntls_spki_pin 127.0.0.1 nopin\n}\n", true, nil, ""},
+		{"grpc . 127.0.0.1 {\ntls_san\n}\n", true, nil, ""},
+		{"grpc . 127.0.0.1 {\ntls_spki_pin " + pin1 + "\n}\n", true, nil, ""},
+		{"grpc . 127.0.0.1 {\ntls_san dns.example.org\n}\n", true, nil, ""},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("grpc", test.input)
+		g, err := parseGRPC(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(g.tlsPins, test.expectedPins) {
+			t.Errorf("Test %d: expected pins %v, got %v", i, test.expectedPins, g.tlsPins)
+		}
+		if g.tlsSAN != test.expectedSAN {
+			t.Errorf("Test %d: expected SAN %q, got %q", i, test.expectedSAN, g.tlsSAN)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/pb"
+
+	"github.com/miekg/dns"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// streamPool sends queries over a few long-lived bidirectional gRPC streams, instead of doing one RPC per query.
+// The server may reply out-of-order, replies are matched to the queries by message ID.
+type streamPool struct {
+	client pb.DnsServiceClient
+	size   int
+
+	mu          sync.Mutex
+	streams     []*dnsStream
+	next        int           // next stream to use, round robin
+	unsupported bool          // the server doesn't implement the Stream RPC
+	opening     chan struct{} // closed when the stream being opened is added, nil if none is
+}
+
+// dnsStream is a single stream. Queries get a new ID that is unique on this stream, the original ID is restored
+// on the reply.
+type dnsStream struct {
+	s      pb.DnsService_StreamClient
+	cancel context.CancelFunc
+
+	smu sync.Mutex // serializes Send
+
+	mu       sync.Mutex
+	inflight map[uint16]chan *dns.Msg
+	closed   bool
+}
+
+var (
+	// errStreamUnsupported means the server doesn't implement the Stream RPC, we should fall back to Query.
+	errStreamUnsupported = errors.New("gRPC stream not supported by upstream")
+	// errStreamClosed means the stream was closed while the query was in-flight.
+	errStreamClosed = errors.New("gRPC stream was closed")
+)
+
+func newStreamPool(client pb.DnsServiceClient, size int) *streamPool {
+	return &streamPool{client: client, size: size}
+}
+
+// query sends req on one of the streams and waits for the reply.
+func (sp *streamPool) query(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	ds, err := sp.stream(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	id, ch, err := ds.register()
+	if err != nil {
+		return nil, err
+	}
+
+	q := *req // shallow copy is enough, we only change the ID
+	q.Id = id
+	msg, err := q.Pack()
+	if err != nil {
+		ds.unregister(id)
+		return nil, err
+	}
+
+	ds.smu.Lock()
+	err = ds.s.Send(&pb.DnsPacket{Msg: msg})
+	ds.smu.Unlock()
+	if err != nil {
+		ds.unregister(id)
+		ds.close()
+		return nil, errStreamClosed
+	}
+
+	timer := time.NewTimer(streamTimeout)
+	defer timer.Stop()
+
+	select {
+	case ret, ok := <-ch:
+		if !ok {
+			return nil, errStreamClosed
+		}
+		ret.Id = req.Id
+		return ret, nil
+	case <-timer.C:
+		ds.unregister(id)
+		return nil, status.Error(codes.DeadlineExceeded, "no reply on gRPC stream")
+	case <-ctx.Done():
+		ds.unregister(id)
+		return nil, ctx.Err()
+	}
+}
+
+// stream returns the next open stream, opening a new one when we have less than sp.size. One stream is opened at
+// a time; without any open stream we wait for it, until ctx is done.
+func (sp *streamPool) stream(ctx context.Context) (*dnsStream, error) {
+	for {
+		sp.mu.Lock()
+		if sp.unsupported {
+			sp.mu.Unlock()
+			return nil, errStreamUnsupported
+		}
+
+		live := sp.streams[:0]
+		for _, ds := range sp.streams {
+			if !ds.isClosed() {
+				live = append(live, ds)
+			}
+		}
+		sp.streams = live
+
+		if len(sp.streams) < sp.size && sp.opening == nil {
+			sp.opening = make(chan struct{})
+			sp.mu.Unlock()
+			return sp.open()
+		}
+		if len(sp.streams) > 0 {
+			sp.next = (sp.next + 1) % len(sp.streams)
+			ds := sp.streams[sp.next]
+			sp.mu.Unlock()
+			return ds, nil
+		}
+		opening := sp.opening
+		sp.mu.Unlock()
+
+		select {
+		case <-opening:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// open opens a new stream and adds it to sp. Setting up the stream may take a while, so it's done without holding
+// the lock.
+func (sp *streamPool) open() (*dnsStream, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := sp.client.Stream(ctx)
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	close(sp.opening)
+	sp.opening = nil
+
+	if err != nil {
+		cancel()
+		if status.Code(err) == codes.Unimplemented {
+			sp.unsupported = true
+			return nil, errStreamUnsupported
+		}
+		return nil, err
+	}
+	ds := &dnsStream{s: s, cancel: cancel, inflight: make(map[uint16]chan *dns.Msg)}
+	go ds.recv(sp)
+	sp.streams = append(sp.streams, ds)
+	return ds, nil
+}
+
+// close closes all streams.
+func (sp *streamPool) close() {
+	sp.mu.Lock()
+	streams := sp.streams
+	sp.streams = nil
+	sp.mu.Unlock()
+
+	for _, ds := range streams {
+		ds.close()
+	}
+}
+
+// register allocates an unused ID on this stream and returns it with the channel the reply is sent on.
+func (ds *dnsStream) register() (uint16, chan *dns.Msg, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.closed {
+		return 0, nil, errStreamClosed
+	}
+	id := uint16(rand.Uint32())
+	for {
+		if _, ok := ds.inflight[id]; !ok {
+			break
+		}
+		id++
+	}
+	ch := make(chan *dns.Msg, 1)
+	ds.inflight[id] = ch
+	return id, ch, nil
+}
+
+func (ds *dnsStream) unregister(id uint16) {
+	ds.mu.Lock()
+	delete(ds.inflight, id)
+	ds.mu.Unlock()
+}
+
+func (ds *dnsStream) isClosed() bool {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.closed
+}
+
+// close cancels the stream and fails all in-flight queries.
+func (ds *dnsStream) close() {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.closed {
+		return
+	}
+	ds.closed = true
+	ds.cancel()
+	for id, ch := range ds.inflight {
+		close(ch)
+		delete(ds.inflight, id)
+	}
+}
+
+// recv receives replies and hands them to the waiting queries, until the stream fails.
+func (ds *dnsStream) recv(sp *streamPool) {
+	defer ds.close()
+	for {
+		reply, err := ds.s.Recv()
+		if err != nil {
+			if status.Code(err) == codes.Unimplemented {
+				sp.mu.Lock()
+				sp.unsupported = true
+				sp.mu.Unlock()
+			}
+			return
+		}
+
+		ret := new(dns.Msg)
+		if err := ret.Unpack(reply.Msg); err != nil {
+			continue
+		}
+
+		ds.mu.Lock()
+		ch, ok := ds.inflight[ret.Id]
+		delete(ds.inflight, ret.Id)
+		ds.mu.Unlock()
+		if ok {
+			ch <- ret
+		}
+	}
+}
+
+const (
+	defaultStreams = 2
+	streamTimeout  = defaultTimeout
+)
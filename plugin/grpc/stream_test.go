@@ -0,0 +1,222 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/pb"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testServer answers A queries for any name. Stream replies in reverse order, once n queries have been received.
+type testServer struct {
+	n         int
+	streaming bool
+
+	mu      sync.Mutex
+	unary   int
+	streams int
+}
+
+func (s *testServer) reply(in *pb.DnsPacket) *pb.DnsPacket {
+	m := new(dns.Msg)
+	m.Unpack(in.Msg)
+	ret := new(dns.Msg)
+	ret.SetReply(m)
+	ret.Answer = append(ret.Answer, test.A(m.Question[0].Name+" IN A 127.0.0.1"))
+	msg, _ := ret.Pack()
+	return &pb.DnsPacket{Msg: msg}
+}
+
+func (s *testServer) Query(ctx context.Context, in *pb.DnsPacket) (*pb.DnsPacket, error) {
+	s.mu.Lock()
+	s.unary++
+	s.mu.Unlock()
+	return s.reply(in), nil
+}
+
+func (s *testServer) Stream(stream pb.DnsService_StreamServer) error {
+	if !s.streaming {
+		return status.Error(codes.Unimplemented, "no streams")
+	}
+	s.mu.Lock()
+	s.streams++
+	s.mu.Unlock()
+
+	qs := []*pb.DnsPacket{}
+	for len(qs) < s.n {
+		in, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		qs = append(qs, in)
+	}
+	for i := len(qs) - 1; i >= 0; i-- {
+		if err := stream.Send(s.reply(qs[i])); err != nil {
+			return err
+		}
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+func TestProxyStream(t *testing.T) {
+	const n = 5
+	for _, streaming := range []bool{true, false} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := &testServer{n: n, streaming: streaming}
+		gs := grpc.NewServer()
+		pb.RegisterDnsServiceServer(gs, srv)
+		go gs.Serve(l)
+
+		c := caddy.NewTestController("dns", "grpc . "+l.Addr().String()+" {\nstream 1\n}\n")
+		g, err := parseGRPC(c)
+		if err != nil {
+			t.Fatalf("Failed to create grpc: %s", err)
+		}
+		p := g.proxies[0]
+
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				name := "example" + strconv.Itoa(i) + ".org."
+				m := new(dns.Msg)
+				m.SetQuestion(name, dns.TypeA)
+				m.Id = 42 // all queries use the same ID, the stream must tell them apart
+
+				ret, err := p.query(context.TODO(), m)
+				if err != nil {
+					t.Errorf("Query %d: expected no error, got: %s", i, err)
+					return
+				}
+				if ret.Id != 42 {
+					t.Errorf("Query %d: expected ID 42, got %d", i, ret.Id)
+				}
+				if x := ret.Answer[0].Header().Name; x != name {
+					t.Errorf("Query %d: expected answer for %s, got %s", i, name, x)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		srv.mu.Lock()
+		if streaming && (srv.streams != 1 || srv.unary != 0) {
+			t.Errorf("Expected all queries on 1 stream, got %d streams and %d unary queries", srv.streams, srv.unary)
+		}
+		if !streaming && srv.unary != n {
+			t.Errorf("Expected %d unary queries when streams aren't supported, got %d", n, srv.unary)
+		}
+		srv.mu.Unlock()
+
+		p.streams.close()
+		gs.Stop()
+	}
+}
+
+// slowClient is a client that opens a stream only once release is closed.
+type slowClient struct {
+	pb.DnsServiceClient
+	release chan struct{}
+}
+
+func (c *slowClient) Stream(ctx context.Context, opts ...grpc.CallOption) (pb.DnsService_StreamClient, error) {
+	<-c.release
+	return &idleStream{ctx: ctx}, nil
+}
+
+// idleStream is a stream that never receives anything.
+type idleStream struct {
+	pb.DnsService_StreamClient
+	ctx context.Context
+}
+
+func (s *idleStream) Recv() (*pb.DnsPacket, error) {
+	<-s.ctx.Done()
+	return nil, s.ctx.Err()
+}
+
+func TestStreamPoolSlowOpen(t *testing.T) {
+	client := &slowClient{release: make(chan struct{})}
+	sp := newStreamPool(client, 2)
+	defer sp.close()
+
+	opened := make(chan *dnsStream)
+	go func() {
+		ds, _ := sp.stream(context.TODO())
+		opened <- ds
+	}()
+
+	for opening := false; !opening; time.Sleep(time.Millisecond) {
+		sp.mu.Lock()
+		opening = sp.opening != nil
+		sp.mu.Unlock()
+	}
+
+	// While the first stream is being opened, the pool isn't locked and other queries give up in time.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := sp.stream(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected %s while the stream is being opened, got %v", context.DeadlineExceeded, err)
+	}
+	close(client.release)
+	ds := <-opened
+	if ds == nil {
+		t.Fatalf("Expected a stream")
+	}
+	if x, err := sp.stream(context.TODO()); err != nil || x == nil {
+		t.Errorf("Expected a second stream, got %v", err)
+	}
+	if n := len(sp.streams); n != 2 {
+		t.Errorf("Expected 2 streams, got %d", n)
+	}
+}
+
+func TestSetupStream(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		expected  int
+	}{
+		{"grpc . 127.0.0.1", false, 0},
+		{"grpc . 127.0.0.1 {\nstream\n}\n", false, defaultStreams},
+		{"grpc . 127.0.0.1 {\nstream 8\n}\n", false, 8},
+		{"grpc . 127.0.0.1 {\nstream 0\n}\n", true, 0},
+		{"grpc . 127.0.0.1 {\nstream 1 2\n}\n", true, 0},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("grpc", test.input)
+		g, err := parseGRPC(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+		if g.streams != test.expected {
+			t.Errorf("Test %d: expected %d streams, got %d", i, test.expected, g.streams)
+		}
+		if (g.proxies[0].streams != nil) != (test.expected > 0) {
+			t.Errorf("Test %d: expected stream pool to be set: %t", i, test.expected > 0)
+		}
+	}
+}
@@ -23,7 +23,7 @@ func (m *Metrics) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 	}
 
 	// Record response to get status code and size of the reply.
-	rw := dnstest.NewRecorder(w)
+	rw := &recorder{dnstest.NewRecorder(w)}
 	status, err := plugin.NextOrFailure(m.Name(), m.Next, ctx, rw, r)
 
 	rc := rw.Rcode
@@ -40,3 +40,22 @@ func (m *Metrics) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 
 // Name implements the Handler interface.
 func (m *Metrics) Name() string { return "prometheus" }
+
+// recorder is a dnstest.Recorder that passes on packed replies as well, as only their rcode and size are needed.
+type recorder struct {
+	*dnstest.Recorder
+}
+
+// WriteWire implements the request.WireWriter interface.
+func (r *recorder) WriteWire(buf []byte, rcode int) (bool, error) {
+	ww, ok := r.ResponseWriter.(request.WireWriter)
+	if !ok {
+		return false, nil
+	}
+	written, err := ww.WriteWire(buf, rcode)
+	if written && err == nil {
+		r.Rcode = rcode
+		r.Len += len(buf)
+	}
+	return written, err
+}
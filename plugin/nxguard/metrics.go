@@ -0,0 +1,32 @@
+package nxguard
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// mitigationCount is the number of times a zone crossed the thresholds.
+	mitigationCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "mitigations_total",
+		Help:      "Counter of the times a zone crossed the thresholds and got mitigated.",
+	}, []string{"server"})
+	// mitigatedZones is 1 for the zones that are mitigated.
+	mitigatedZones = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "mitigated_zones",
+		Help:      "The zones that are mitigated, with value 1.",
+	}, []string{"server", "zone"})
+	// synthesizedCount is the number of responses synthesized for the mitigated zones.
+	synthesizedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "synthesized_responses_total",
+		Help:      "Counter of responses synthesized for the mitigated zones, per rcode.",
+	}, []string{"server", "rcode"})
+)
@@ -0,0 +1,122 @@
+// Package nxguard implements a plugin that mitigates pseudo-random subdomain attacks.
+package nxguard
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+// NXGuard tracks the responses of the next plugins per registered domain, and answers the queries for the
+// registered domains that look under a pseudo-random subdomain attack itself, for a while.
+type NXGuard struct {
+	Next plugin.Handler
+
+	Zones  []string
+	except []string // the zones that are never mitigated
+	rcode  int      // the rcode of the synthesized responses
+	thresholds
+
+	zones *cache.Cache // the zone state per registered domain
+	mu    sync.Mutex   // makes looking up and adding to zones atomic
+	now   func() time.Time
+}
+
+// ServeDNS implements the plugin.Handler interface.
+func (g *NXGuard) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	qname := state.Name()
+	if plugin.Zones(g.Zones).Matches(qname) == "" || plugin.Zones(g.except).Matches(qname) != "" {
+		return plugin.NextOrFailure(g.Name(), g.Next, ctx, w, r)
+	}
+
+	name := strings.TrimSuffix(qname, ".")
+	domain, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil || domain == name {
+		// Not below a registered domain, the apex of the zone is always answered.
+		return plugin.NextOrFailure(g.Name(), g.Next, ctx, w, r)
+	}
+
+	z := g.zone(domain)
+	now := g.now()
+	server := metrics.WithServer(ctx)
+	if z.mitigated(now) {
+		synthesizedCount.WithLabelValues(server, dns.RcodeToString[g.rcode]).Inc()
+		m := new(dns.Msg)
+		m.SetRcode(r, g.rcode)
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	}
+	g.end(z, now)
+
+	rw := dnstest.NewRecorder(w)
+	rcode, err := plugin.NextOrFailure(g.Name(), g.Next, ctx, rw, r)
+	if rw.Msg != nil {
+		rcode = rw.Rcode
+	}
+
+	label := name[:strings.IndexByte(name, '.')]
+	if z.observe(cache.Hash([]byte(label)), rcode == dns.RcodeNameError, now, server, g.thresholds) {
+		log.Infof("Mitigating %s for %s: NXDOMAIN ratio and unique labels above the thresholds", domain, g.cooldown)
+		mitigationCount.WithLabelValues(server).Inc()
+	}
+	return rcode, err
+}
+
+// zone returns the zone state of domain, creating it if it doesn't exist.
+func (g *NXGuard) zone(domain string) *zone {
+	key := cache.Hash([]byte(domain))
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if z, ok := g.zones.Get(key); ok {
+		return z.(*zone)
+	}
+	z := &zone{name: domain}
+	g.zones.Add(key, z)
+	return z
+}
+
+// end ends the mitigation of z if it is over at now.
+func (g *NXGuard) end(z *zone, now time.Time) {
+	if ended, _ := z.expire(now); ended {
+		log.Infof("Mitigation of %s ended", z.name)
+	}
+}
+
+// expire ends the mitigations that are over at now.
+func (g *NXGuard) expire(now time.Time) {
+	g.zones.Walk(func(items map[uint64]interface{}, key uint64) bool {
+		if z, ok := items[key].(*zone); ok {
+			g.end(z, now)
+		}
+		return true
+	})
+}
+
+// OnShutdown removes the mitigated zones of g from the metrics.
+func (g *NXGuard) OnShutdown() error {
+	g.zones.Walk(func(items map[uint64]interface{}, key uint64) bool {
+		if z, ok := items[key].(*zone); ok {
+			z.Lock()
+			if !z.until.IsZero() {
+				mitigatedZones.DeleteLabelValues(z.server, z.name)
+			}
+			z.Unlock()
+		}
+		return true
+	})
+	return nil
+}
+
+// Name implements the Handler interface.
+func (g *NXGuard) Name() string { return "nxguard" }
@@ -0,0 +1,127 @@
+package nxguard
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNXGuard(t *testing.T) {
+	g, err := parse(caddy.NewTestController("dns", `nxguard {
+		minimum 10
+		unique 5
+		cooldown 1m
+		except safe.example.net
+	}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	g.Zones = []string{"."}
+	now := time.Now()
+	g.now = func() time.Time { return now }
+
+	forwarded := 0
+	g.Next = test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		forwarded++
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeNameError)
+		if r.Question[0].Name == "www.example.org." {
+			m.SetReply(r)
+		}
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+
+	query := func(qname string) int {
+		m := new(dns.Msg)
+		m.SetQuestion(qname, dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := g.ServeDNS(context.Background(), rec, m); err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+		return rec.Rcode
+	}
+
+	// An attack on example.net, along with normal traffic for example.org.
+	for i := 0; i < 10; i++ {
+		query("www.example.org.")
+		query("x" + strconv.Itoa(i) + ".example.net.")
+	}
+	if forwarded != 20 {
+		t.Fatalf("Expected 20 forwarded queries, got %d", forwarded)
+	}
+	if v := testutil.ToFloat64(mitigatedZones.WithLabelValues("", "example.net")); v != 1 {
+		t.Errorf("Expected example.net to be mitigated")
+	}
+
+	if rcode := query("random.example.net."); rcode != dns.RcodeNameError {
+		t.Errorf("Expected a synthesized NXDOMAIN, got rcode %d", rcode)
+	}
+	query("www.example.org.")
+	query("example.net.")                 // the apex of the zone
+	query("www.safe.example.net.")        // an exception
+	query("a.b.c.d.e.f.g.h.example.org.") // another zone
+	if forwarded != 24 {
+		t.Errorf("Expected 24 forwarded queries, got %d", forwarded)
+	}
+	if n := testutil.ToFloat64(synthesizedCount.WithLabelValues("", "NXDOMAIN")); n != 1 {
+		t.Errorf("Expected 1 synthesized response, got %f", n)
+	}
+
+	now = now.Add(time.Minute)
+	g.expire(now)
+	if n := testutil.CollectAndCount(mitigatedZones); n != 0 {
+		t.Errorf("Expected no mitigated zones, got %d", n)
+	}
+	query("random.example.net.")
+	if forwarded != 25 {
+		t.Errorf("Expected 25 forwarded queries after the cooldown, got %d", forwarded)
+	}
+}
+
+func TestNXGuardEvict(t *testing.T) {
+	g, err := parse(caddy.NewTestController("dns", `nxguard {
+		minimum 1
+		unique 1
+		zones 1
+	}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	g.Zones = []string{"."}
+	g.Next = test.NextHandler(dns.RcodeNameError, nil)
+
+	m := new(dns.Msg)
+	m.SetQuestion("x.example.net.", dns.TypeA)
+	if _, err := g.ServeDNS(context.Background(), dnstest.NewRecorder(&test.ResponseWriter{}), m); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if v := testutil.ToFloat64(mitigatedZones.WithLabelValues("", "example.net")); v != 1 {
+		t.Fatalf("Expected example.net to be mitigated")
+	}
+
+	// Fill the shard of example.net, of the 256 shards holding at least 4 zones, so it gets evicted.
+	key := cache.Hash([]byte("example.net"))
+	for i, n := 0, 0; n < 4; i++ {
+		domain := "example" + strconv.Itoa(i) + ".org"
+		if cache.Hash([]byte(domain))&255 == key&255 {
+			g.zone(domain)
+			n++
+		}
+	}
+	if _, ok := g.zones.Get(key); ok {
+		t.Fatalf("Expected example.net to be evicted")
+	}
+	if mitigatedZones.DeleteLabelValues("", "example.net") {
+		t.Errorf("Expected the evicted example.net to be removed from the metrics")
+	}
+}
@@ -0,0 +1,169 @@
+package nxguard
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+
+	"github.com/miekg/dns"
+)
+
+const pluginName = "nxguard"
+
+var log = clog.NewWithPlugin(pluginName)
+
+func init() { plugin.Register(pluginName, setup) }
+
+func setup(c *caddy.Controller) error {
+	g, err := parse(c)
+	if err != nil {
+		return plugin.Error(pluginName, err)
+	}
+
+	stop := make(chan struct{})
+	c.OnStartup(func() error {
+		go g.periodicExpire(stop)
+		return nil
+	})
+	c.OnShutdown(func() error {
+		close(stop)
+		return g.OnShutdown()
+	})
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		g.Next = next
+		return g
+	})
+
+	return nil
+}
+
+// periodicExpire ends the mitigations that are over every window, until stop is closed.
+func (g *NXGuard) periodicExpire(stop chan struct{}) {
+	ticker := time.NewTicker(g.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			g.expire(g.now())
+		}
+	}
+}
+
+// The defaults of the settings.
+const (
+	defaultWindow   = 10 * time.Second
+	defaultMinimum  = 100
+	defaultRatio    = 0.8
+	defaultUnique   = 50
+	defaultCooldown = time.Minute
+	defaultZones    = 10000
+)
+
+func parse(c *caddy.Controller) (*NXGuard, error) {
+	g := &NXGuard{
+		rcode: dns.RcodeNameError,
+		thresholds: thresholds{
+			window:   defaultWindow,
+			minimum:  defaultMinimum,
+			ratio:    defaultRatio,
+			unique:   defaultUnique,
+			cooldown: defaultCooldown,
+		},
+		now: time.Now,
+	}
+	zones := defaultZones
+
+	i := 0
+	for c.Next() {
+		if i > 0 {
+			return nil, plugin.ErrOnce
+		}
+		i++
+
+		g.Zones = plugin.OriginsFromArgsOrServerBlock(c.RemainingArgs(), c.ServerBlockKeys)
+
+		for c.NextBlock() {
+			switch c.Val() {
+			case "window", "cooldown":
+				property := c.Val()
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil || d <= 0 {
+					return nil, c.Errf("invalid duration '%s' for %s", args[0], property)
+				}
+				if property == "window" {
+					g.window = d
+				} else {
+					g.cooldown = d
+				}
+			case "minimum", "unique", "zones":
+				property := c.Val()
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				n, err := strconv.Atoi(args[0])
+				if err != nil || n <= 0 {
+					return nil, c.Errf("invalid number '%s' for %s", args[0], property)
+				}
+				switch property {
+				case "minimum":
+					g.minimum = n
+				case "unique":
+					g.unique = n
+				default:
+					zones = n
+				}
+			case "ratio":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				f, err := strconv.ParseFloat(args[0], 64)
+				if err != nil || !(f >= 0 && f <= 1) {
+					return nil, c.Errf("invalid ratio '%s'; expect a number between 0 and 1", args[0])
+				}
+				g.ratio = f
+			case "rcode":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				switch strings.ToUpper(args[0]) {
+				case "NXDOMAIN":
+					g.rcode = dns.RcodeNameError
+				case "SERVFAIL":
+					g.rcode = dns.RcodeServerFailure
+				default:
+					return nil, c.Errf("unknown rcode '%s'; expect 'NXDOMAIN' or 'SERVFAIL'", args[0])
+				}
+			case "except":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				for _, a := range args {
+					g.except = append(g.except, plugin.Host(a).NormalizeExact()...)
+				}
+			default:
+				return nil, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+	}
+
+	g.zones = cache.NewWithPolicy(zones, 0, cache.LRU)
+	g.zones.OnEvict(func(_ uint64, el interface{}) { el.(*zone).evict() })
+	return g, nil
+}
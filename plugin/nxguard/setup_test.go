@@ -0,0 +1,88 @@
+package nxguard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+	}{
+		{`nxguard`, false},
+		{`nxguard example.org {
+			window 5s
+			minimum 20
+			ratio 0.9
+			unique 10
+			cooldown 5m
+			rcode servfail
+			zones 1000
+			except example.com
+		}`, false},
+		// fails
+		{`nxguard {
+			window
+		}`, true},
+		{`nxguard {
+			cooldown -1s
+		}`, true},
+		{`nxguard {
+			minimum 0
+		}`, true},
+		{`nxguard {
+			ratio 1.5
+		}`, true},
+		{`nxguard {
+			rcode REFUSED
+		}`, true},
+		{`nxguard {
+			except
+		}`, true},
+		{`nxguard {
+			blah
+		}`, true},
+		{`nxguard
+		nxguard`, true},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		_, err := parse(c)
+		if tc.shouldErr && err == nil {
+			t.Errorf("Test %d: expected error but got none for input %s", i, tc.input)
+		}
+		if !tc.shouldErr && err != nil {
+			t.Errorf("Test %d: expected no error but got %s for input %s", i, err, tc.input)
+		}
+	}
+}
+
+func TestSetupSettings(t *testing.T) {
+	g, err := parse(caddy.NewTestController("dns", `nxguard example.org {
+		window 5s
+		ratio 0.5
+		rcode SERVFAIL
+		except www.example.org
+	}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if len(g.Zones) != 1 || g.Zones[0] != "example.org." {
+		t.Errorf("Expected zones [example.org.], got %v", g.Zones)
+	}
+	if len(g.except) != 1 || g.except[0] != "www.example.org." {
+		t.Errorf("Expected except [www.example.org.], got %v", g.except)
+	}
+	if g.window != 5*time.Second || g.ratio != 0.5 || g.minimum != defaultMinimum || g.cooldown != defaultCooldown {
+		t.Errorf("Unexpected thresholds %+v", g.thresholds)
+	}
+	if g.rcode != dns.RcodeServerFailure {
+		t.Errorf("Expected rcode SERVFAIL, got %d", g.rcode)
+	}
+}
@@ -0,0 +1,100 @@
+package nxguard
+
+import (
+	"sync"
+	"time"
+)
+
+// zone holds the responses for the names under a registered domain within the current window, and when the
+// zone is mitigated, until when.
+type zone struct {
+	sync.Mutex
+	name string
+
+	start     time.Time
+	responses int
+	nxdomains int
+	labels    map[uint64]struct{} // the hashes of the unique labels queried
+
+	until   time.Time // the end of the mitigation, zero when not mitigated
+	server  string    // the server the mitigation started on
+	evicted bool      // whether z is evicted from the zones, and is no longer in the metrics
+}
+
+// thresholds are the thresholds that start the mitigation of a zone.
+type thresholds struct {
+	window   time.Duration
+	minimum  int     // the minimum number of responses in the window
+	ratio    float64 // the minimum ratio of NXDOMAIN responses
+	unique   int     // the minimum number of unique labels
+	cooldown time.Duration
+}
+
+// mitigated returns true if z is mitigated at now.
+func (z *zone) mitigated(now time.Time) bool {
+	z.Lock()
+	defer z.Unlock()
+	return now.Before(z.until)
+}
+
+// observe adds a response, for a name with a leftmost label with hash label, to z. It returns true if z crosses
+// the thresholds, and is mitigated from then on. The mitigated zones metric is updated under the lock of z.
+func (z *zone) observe(label uint64, nxdomain bool, now time.Time, server string, t thresholds) bool {
+	z.Lock()
+	defer z.Unlock()
+
+	if now.Before(z.until) {
+		return false
+	}
+	if now.Sub(z.start) > t.window {
+		z.reset(now)
+	}
+	z.responses++
+	if nxdomain {
+		z.nxdomains++
+	}
+	if len(z.labels) < t.unique {
+		z.labels[label] = struct{}{}
+	}
+
+	if z.responses < t.minimum || len(z.labels) < t.unique || float64(z.nxdomains) < t.ratio*float64(z.responses) {
+		return false
+	}
+	z.until = now.Add(t.cooldown)
+	z.server = server
+	z.reset(z.until)
+	if !z.evicted {
+		mitigatedZones.WithLabelValues(server, z.name).Set(1)
+	}
+	return true
+}
+
+// expire ends the mitigation of z if it is over at now. It returns true and the server the mitigation started on
+// if it ended.
+func (z *zone) expire(now time.Time) (bool, string) {
+	z.Lock()
+	defer z.Unlock()
+	if z.until.IsZero() || now.Before(z.until) {
+		return false, ""
+	}
+	z.until = time.Time{}
+	mitigatedZones.DeleteLabelValues(z.server, z.name)
+	return true, z.server
+}
+
+// evict removes z from the metrics, for good, when it's evicted from the zones.
+func (z *zone) evict() {
+	z.Lock()
+	defer z.Unlock()
+	if !z.until.IsZero() {
+		mitigatedZones.DeleteLabelValues(z.server, z.name)
+	}
+	z.evicted = true
+}
+
+// reset starts a new window at start.
+func (z *zone) reset(start time.Time) {
+	z.start = start
+	z.responses, z.nxdomains = 0, 0
+	z.labels = make(map[uint64]struct{})
+}
@@ -0,0 +1,60 @@
+package nxguard
+
+import (
+	"testing"
+	"time"
+)
+
+func TestZoneObserve(t *testing.T) {
+	th := thresholds{window: 10 * time.Second, minimum: 4, ratio: 0.75, unique: 3, cooldown: time.Minute}
+	z := &zone{name: "example.org"}
+	now := time.Now()
+
+	// Not enough unique labels.
+	for i := 0; i < 5; i++ {
+		if z.observe(1, true, now, "", th) {
+			t.Fatalf("Expected no mitigation with a single label")
+		}
+	}
+
+	// A new window, with too few NXDOMAIN responses.
+	now = now.Add(11 * time.Second)
+	for i := uint64(0); i < 4; i++ {
+		if z.observe(i, i%2 == 0, now, "", th) {
+			t.Fatalf("Expected no mitigation at a ratio of 0.5")
+		}
+	}
+
+	now = now.Add(11 * time.Second)
+	z.observe(1, true, now, "", th)
+	z.observe(2, true, now, "", th)
+	z.observe(3, false, now, "", th)
+	if !z.observe(4, true, now, "dns://:53", th) {
+		t.Fatalf("Expected a mitigation")
+	}
+	if !z.mitigated(now.Add(time.Second)) {
+		t.Errorf("Expected the zone to be mitigated")
+	}
+	if z.observe(5, true, now.Add(time.Second), "", th) {
+		t.Errorf("Expected no new mitigation while mitigated")
+	}
+	if ended, _ := z.expire(now.Add(time.Second)); ended {
+		t.Errorf("Expected the mitigation not to end before the cooldown")
+	}
+
+	later := now.Add(time.Minute)
+	if z.mitigated(later) {
+		t.Errorf("Expected the mitigation to be over")
+	}
+	if ended, server := z.expire(later); !ended || server != "dns://:53" {
+		t.Errorf("Expected the mitigation on dns://:53 to end, got %t and %q", ended, server)
+	}
+	if ended, _ := z.expire(later); ended {
+		t.Errorf("Expected the mitigation to end once")
+	}
+
+	// The counts of the window before the mitigation are gone.
+	if z.observe(6, true, later, "", th) {
+		t.Errorf("Expected no mitigation in a new window")
+	}
+}
@@ -1,9 +1,12 @@
 // Package cache implements a cache. The cache hold 256 shards, each shard
-// holds a cache: a map with a mutex. There is no fancy expunge algorithm, it
-// just randomly evicts elements when it gets full.
+// holds a cache: a map with a mutex. By default there is no fancy expunge
+// algorithm, it just randomly evicts elements when it gets full. With NewWithPolicy
+// a cache evicts the least recently used element instead, optionally with TinyLFU
+// admission, and can be limited in bytes as well as in elements.
 package cache
 
 import (
+	"container/list"
 	"hash/fnv"
 	"sync"
 )
@@ -15,31 +18,79 @@ func Hash(what []byte) uint64 {
 	return h.Sum64()
 }
 
+// Policy is the eviction policy of a cache.
+type Policy int
+
+const (
+	// Random evicts a random element.
+	Random Policy = iota
+	// LRU evicts the least recently used element.
+	LRU
+	// TinyLFU evicts the least recently used element, but only admits a new element when it is used
+	// more often than the element it would replace.
+	TinyLFU
+)
+
+// Evicted holds the number of elements evicted by an Add, by reason.
+type Evicted struct {
+	Capacity  int // evicted because the shard holds its maximum number of elements
+	Bytes     int // evicted because the shard holds its maximum number of bytes
+	Admission int // the new element was not admitted, as it is used less often than the one it would replace
+}
+
+// Total returns the total number of evicted elements.
+func (e Evicted) Total() int { return e.Capacity + e.Bytes + e.Admission }
+
 // Cache is cache.
 type Cache struct {
 	shards [shardSize]*shard
 }
 
-// shard is a cache with random eviction.
+// shard is a cache with random, LRU or TinyLFU eviction.
 type shard struct {
 	items map[uint64]interface{}
 	size  int
 
+	policy   Policy
+	maxBytes int
+	bytes    int
+	entries  map[uint64]*list.Element // entry of each item, for the size and the LRU order, nil if untracked
+	lru      *list.List               // front is the most recently used, nil if untracked
+	sketch   *sketch                  // only with TinyLFU
+	onEvict  func(uint64, interface{})
+
 	sync.RWMutex
 }
 
+// entry is the element stored in a shard's LRU list.
+type entry struct {
+	key  uint64
+	size int
+}
+
 // New returns a new cache.
-func New(size int) *Cache {
+func New(size int) *Cache { return NewWithPolicy(size, 0, Random) }
+
+// NewWithPolicy returns a new cache that holds at most size elements, and, when maxBytes is larger than 0, at
+// most maxBytes bytes, as given to AddSize. Elements are evicted according to policy.
+func NewWithPolicy(size, maxBytes int, policy Policy) *Cache {
 	ssize := size / shardSize
 	if ssize < 4 {
 		ssize = 4
 	}
+	sbytes := 0
+	if maxBytes > 0 {
+		sbytes = maxBytes / shardSize
+		if sbytes < 1 {
+			sbytes = 1
+		}
+	}
 
 	c := &Cache{}
 
 	// Initialize all the shards
 	for i := 0; i < shardSize; i++ {
-		c.shards[i] = newShard(ssize)
+		c.shards[i] = newShardWithPolicy(ssize, sbytes, policy)
 	}
 	return c
 }
@@ -47,8 +98,14 @@ func New(size int) *Cache {
 // Add adds a new element to the cache. If the element already exists it is overwritten.
 // Returns true if an existing element was evicted to make room for this element.
 func (c *Cache) Add(key uint64, el interface{}) bool {
+	return c.AddSize(key, el, 0).Total() > 0
+}
+
+// AddSize adds a new element of size bytes to the cache. If the element already exists it is overwritten.
+// It returns the elements evicted to make room for this element.
+func (c *Cache) AddSize(key uint64, el interface{}, size int) Evicted {
 	shard := key & (shardSize - 1)
-	return c.shards[shard].Add(key, el)
+	return c.shards[shard].AddSize(key, el, size)
 }
 
 // Get looks up element index under key.
@@ -72,6 +129,25 @@ func (c *Cache) Len() int {
 	return l
 }
 
+// Bytes returns the number of bytes in the cache, as given to AddSize. The bytes are not counted by a cache with
+// random eviction and no limit in bytes.
+func (c *Cache) Bytes() int {
+	b := 0
+	for _, s := range c.shards {
+		b += s.Bytes()
+	}
+	return b
+}
+
+// OnEvict sets f to be called with the key and the element of every element that is evicted to make room for
+// another one. It must be set before the cache is used. f is called while holding the lock of a shard, so it may
+// not use the cache.
+func (c *Cache) OnEvict(f func(key uint64, el interface{})) {
+	for _, s := range c.shards {
+		s.onEvict = f
+	}
+}
+
 // Walk walks each shard in the cache.
 func (c *Cache) Walk(f func(map[uint64]interface{}, uint64) bool) {
 	for _, s := range c.shards {
@@ -80,31 +156,143 @@ func (c *Cache) Walk(f func(map[uint64]interface{}, uint64) bool) {
 }
 
 // newShard returns a new shard with size.
-func newShard(size int) *shard { return &shard{items: make(map[uint64]interface{}), size: size} }
+func newShard(size int) *shard { return newShardWithPolicy(size, 0, Random) }
+
+func newShardWithPolicy(size, maxBytes int, policy Policy) *shard {
+	s := &shard{
+		items:    make(map[uint64]interface{}),
+		size:     size,
+		policy:   policy,
+		maxBytes: maxBytes,
+	}
+	if s.tracked() {
+		s.entries = make(map[uint64]*list.Element)
+		s.lru = list.New()
+	}
+	if policy == TinyLFU {
+		s.sketch = newSketch(size)
+	}
+	return s
+}
 
 // Add adds element indexed by key into the cache. Any existing element is overwritten
 // Returns true if an existing element was evicted to make room for this element.
 func (s *shard) Add(key uint64, el interface{}) bool {
-	eviction := false
+	return s.AddSize(key, el, 0).Total() > 0
+}
+
+// AddSize adds element indexed by key of size bytes into the cache. Any existing element is overwritten.
+// It returns the elements evicted to make room for this element.
+func (s *shard) AddSize(key uint64, el interface{}, size int) Evicted {
+	ev := Evicted{}
 	s.Lock()
-	if len(s.items) >= s.size {
-		if _, ok := s.items[key]; !ok {
-			for k := range s.items {
-				delete(s.items, k)
-				eviction = true
+	defer s.Unlock()
+
+	if !s.tracked() {
+		if _, ok := s.items[key]; !ok && len(s.items) >= s.size {
+			s.evict(key)
+			ev.Capacity++
+		}
+		s.items[key] = el
+		return ev
+	}
+
+	if s.sketch != nil {
+		s.sketch.increment(key)
+	}
+
+	if e, ok := s.entries[key]; ok {
+		s.bytes += size - e.Value.(*entry).size
+		e.Value.(*entry).size = size
+		s.items[key] = el
+		s.lru.MoveToFront(e)
+		for s.maxBytes > 0 && s.bytes > s.maxBytes && s.lru.Len() > 1 {
+			s.evict(key)
+			ev.Bytes++
+		}
+		return ev
+	}
+
+	if s.maxBytes > 0 && size > s.maxBytes {
+		// This will never fit.
+		ev.Admission++
+		return ev
+	}
+
+	full := len(s.items) >= s.size || (s.maxBytes > 0 && s.bytes+size > s.maxBytes)
+	if full && s.sketch != nil {
+		if victim := s.victim(); s.sketch.estimate(key) <= s.sketch.estimate(victim) {
+			ev.Admission++
+			return ev
+		}
+	}
+
+	for len(s.items) >= s.size {
+		s.evict(key)
+		ev.Capacity++
+	}
+	for s.maxBytes > 0 && s.bytes+size > s.maxBytes && len(s.items) > 0 {
+		s.evict(key)
+		ev.Bytes++
+	}
+
+	s.items[key] = el
+	s.entries[key] = s.lru.PushFront(&entry{key: key, size: size})
+	s.bytes += size
+	return ev
+}
+
+// tracked returns true if s keeps an entry per element, for the LRU order or the size. A shard with random
+// eviction and no limit in bytes doesn't, so it costs no more than a map.
+func (s *shard) tracked() bool { return s.policy != Random || s.maxBytes > 0 }
+
+// victim returns the key of the element to evict next. The caller must hold the lock.
+func (s *shard) victim() uint64 {
+	if s.policy == Random {
+		for k := range s.items {
+			return k
+		}
+	}
+	return s.lru.Back().Value.(*entry).key
+}
+
+// evict removes the next victim, which may not be keep. The caller must hold the lock.
+func (s *shard) evict(keep uint64) {
+	k := s.victim()
+	if k == keep {
+		// Only possible with Random, pick another element.
+		for k = range s.items {
+			if k != keep {
 				break
 			}
 		}
 	}
-	s.items[key] = el
-	s.Unlock()
-	return eviction
+	el := s.items[k]
+	s.remove(k)
+	if s.onEvict != nil {
+		s.onEvict(k, el)
+	}
+}
+
+// remove removes the element indexed by key. The caller must hold the lock.
+func (s *shard) remove(key uint64) {
+	delete(s.items, key)
+	s.forget(key)
+}
+
+// forget removes the entry for key. The caller must hold the lock.
+func (s *shard) forget(key uint64) {
+	if e, ok := s.entries[key]; ok {
+		s.bytes -= e.Value.(*entry).size
+		s.lru.Remove(e)
+		delete(s.entries, key)
+	}
 }
 
 // Remove removes the element indexed by key from the cache.
 func (s *shard) Remove(key uint64) {
 	s.Lock()
-	delete(s.items, key)
+	s.remove(key)
 	s.Unlock()
 }
 
@@ -112,7 +300,7 @@ func (s *shard) Remove(key uint64) {
 func (s *shard) Evict() {
 	s.Lock()
 	for k := range s.items {
-		delete(s.items, k)
+		s.remove(k)
 		break
 	}
 	s.Unlock()
@@ -120,9 +308,22 @@ func (s *shard) Evict() {
 
 // Get looks up the element indexed under key.
 func (s *shard) Get(key uint64) (interface{}, bool) {
-	s.RLock()
+	if s.policy == Random {
+		s.RLock()
+		el, found := s.items[key]
+		s.RUnlock()
+		return el, found
+	}
+
+	s.Lock()
 	el, found := s.items[key]
-	s.RUnlock()
+	if found {
+		s.lru.MoveToFront(s.entries[key])
+	}
+	if s.sketch != nil {
+		s.sketch.increment(key)
+	}
+	s.Unlock()
 	return el, found
 }
 
@@ -134,10 +335,19 @@ func (s *shard) Len() int {
 	return l
 }
 
+// Bytes returns the current number of bytes in the cache.
+func (s *shard) Bytes() int {
+	s.RLock()
+	b := s.bytes
+	s.RUnlock()
+	return b
+}
+
 // Walk walks the shard for each element the function f is executed while holding a write lock.
+// Elements that f deletes from the map are removed from the shard.
 func (s *shard) Walk(f func(map[uint64]interface{}, uint64) bool) {
-	items := make([]uint64, len(s.items))
 	s.RLock()
+	items := make([]uint64, len(s.items))
 	i := 0
 	for k := range s.items {
 		items[i] = k
@@ -147,6 +357,9 @@ func (s *shard) Walk(f func(map[uint64]interface{}, uint64) bool) {
 	for _, k := range items {
 		s.Lock()
 		ok := f(s.items, k)
+		if _, found := s.items[k]; !found {
+			s.forget(k)
+		}
 		s.Unlock()
 		if !ok {
 			return
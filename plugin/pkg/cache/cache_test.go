@@ -83,3 +83,22 @@ func BenchmarkCache(b *testing.B) {
 		c.Get(1)
 	}
 }
+
+func TestCacheOnEvict(t *testing.T) {
+	c := NewWithPolicy(4, 0, LRU)
+	evicted := map[uint64]interface{}{}
+	c.OnEvict(func(key uint64, el interface{}) { evicted[key] = el })
+
+	// The shard of key 0 holds 4 elements.
+	for i := uint64(0); i < 5; i++ {
+		c.Add(i*shardSize, int(i))
+	}
+	if len(evicted) != 1 || evicted[0] != 0 {
+		t.Errorf("Expected the least recently used element to be evicted, got %v", evicted)
+	}
+
+	c.Remove(shardSize)
+	if len(evicted) != 1 {
+		t.Errorf("Expected a removed element not to be evicted, got %v", evicted)
+	}
+}
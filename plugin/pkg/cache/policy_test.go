@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"testing"
+)
+
+func TestShardLRU(t *testing.T) {
+	s := newShardWithPolicy(3, 0, LRU)
+	s.Add(1, 1)
+	s.Add(2, 2)
+	s.Add(3, 3)
+	s.Get(1) // 2 is now the least recently used
+
+	if ev := s.AddSize(4, 4, 0); ev.Capacity != 1 {
+		t.Errorf("Expected 1 capacity eviction, got %+v", ev)
+	}
+	if _, found := s.Get(2); found {
+		t.Error("Expected least recently used item to be evicted")
+	}
+	for _, k := range []uint64{1, 3, 4} {
+		if _, found := s.Get(k); !found {
+			t.Errorf("Expected item %d to be in the cache", k)
+		}
+	}
+}
+
+func TestShardBytes(t *testing.T) {
+	s := newShardWithPolicy(10, 100, LRU)
+	s.AddSize(1, 1, 40)
+	s.AddSize(2, 2, 40)
+	if b := s.Bytes(); b != 80 {
+		t.Errorf("Expected 80 bytes, got %d", b)
+	}
+
+	// Needs room, evicts 1.
+	if ev := s.AddSize(3, 3, 40); ev.Bytes != 1 {
+		t.Errorf("Expected 1 bytes eviction, got %+v", ev)
+	}
+	if _, found := s.Get(1); found {
+		t.Error("Expected item 1 to be evicted")
+	}
+
+	// Growing an existing item evicts the others.
+	if ev := s.AddSize(3, 3, 90); ev.Bytes != 1 || s.Len() != 1 || s.Bytes() != 90 {
+		t.Errorf("Expected 1 bytes eviction and 90 bytes, got %+v and %d", ev, s.Bytes())
+	}
+
+	// Never fits.
+	if ev := s.AddSize(4, 4, 101); ev.Admission != 1 {
+		t.Errorf("Expected item larger than the shard not to be admitted, got %+v", ev)
+	}
+	if _, found := s.Get(4); found {
+		t.Error("Expected too large item not to be cached")
+	}
+
+	s.Remove(3)
+	if b := s.Bytes(); b != 0 {
+		t.Errorf("Expected 0 bytes after remove, got %d", b)
+	}
+}
+
+func TestShardTinyLFU(t *testing.T) {
+	s := newShardWithPolicy(2, 0, TinyLFU)
+	for i := 0; i < 5; i++ {
+		s.Add(1, 1)
+		s.Get(1)
+		s.Add(2, 2)
+		s.Get(2)
+	}
+
+	// 3 is new, and used less than the victim.
+	if ev := s.AddSize(3, 3, 0); ev.Admission != 1 {
+		t.Errorf("Expected item not to be admitted, got %+v", ev)
+	}
+	if _, found := s.Get(3); found {
+		t.Error("Expected item 3 not to be cached")
+	}
+
+	// After a lot of lookups it is admitted.
+	for i := 0; i < 10; i++ {
+		s.Get(3)
+	}
+	if ev := s.AddSize(3, 3, 0); ev.Capacity != 1 {
+		t.Errorf("Expected item to be admitted with 1 eviction, got %+v", ev)
+	}
+	if _, found := s.Get(3); !found {
+		t.Error("Expected item 3 to be cached")
+	}
+}
+
+func TestShardWalkDelete(t *testing.T) {
+	s := newShardWithPolicy(10, 100, LRU)
+	s.AddSize(1, 1, 10)
+	s.AddSize(2, 2, 20)
+
+	s.Walk(func(items map[uint64]interface{}, key uint64) bool {
+		if key == 1 {
+			delete(items, key)
+		}
+		return true
+	})
+	if s.Len() != 1 || s.Bytes() != 20 || s.lru.Len() != 1 {
+		t.Errorf("Expected 1 item of 20 bytes after walk, got %d items, %d bytes", s.Len(), s.Bytes())
+	}
+}
+
+func TestSketch(t *testing.T) {
+	s := newSketch(16)
+	for i := 0; i < 10; i++ {
+		s.increment(1)
+	}
+	s.increment(2)
+	if e1, e2 := s.estimate(1), s.estimate(2); e1 < 10 || e2 < 1 || e2 >= e1 {
+		t.Errorf("Unexpected estimates: %d and %d", e1, e2)
+	}
+
+	// After a reset counters are halved.
+	for i := 0; i < s.reset; i++ {
+		s.increment(3)
+	}
+	if e1 := s.estimate(1); e1 > 5 {
+		t.Errorf("Expected estimate to be halved, got %d", e1)
+	}
+}
+
+func BenchmarkShardLRU(b *testing.B) {
+	s := newShardWithPolicy(shardSize, 0, LRU)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := uint64(i) % shardSize * 2
+		s.AddSize(k, 1, 100)
+		s.Get(k)
+	}
+}
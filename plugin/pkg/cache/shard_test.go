@@ -137,3 +137,25 @@ func BenchmarkShardParallel(b *testing.B) {
 		}
 	})
 }
+
+func TestShardRandomUntracked(t *testing.T) {
+	s := newShard(2)
+	if s.entries != nil || s.lru != nil {
+		t.Fatalf("Expected no LRU entries with random eviction")
+	}
+	s.Add(1, 1)
+	s.Add(2, 2)
+	if s.Add(2, 3) {
+		t.Errorf("Expected no eviction when overwriting an element")
+	}
+	if !s.Add(3, 3) {
+		t.Errorf("Expected an eviction when full")
+	}
+	if l := s.Len(); l != 2 {
+		t.Errorf("Expected 2 elements, got %d", l)
+	}
+
+	if s := newShardWithPolicy(2, 10, Random); s.entries == nil {
+		t.Errorf("Expected LRU entries with a limit in bytes")
+	}
+}
@@ -0,0 +1,61 @@
+package cache
+
+// sketch is a count-min sketch that estimates how often a key was used, for TinyLFU admission. It has
+// depth rows of 8 bit counters. To favor recent use, all counters are halved after every 10 * size
+// increments.
+type sketch struct {
+	rows      [depth][]uint8
+	mask      uint64
+	additions int
+	reset     int
+}
+
+func newSketch(size int) *sketch {
+	w := 16
+	for w < size*2 {
+		w <<= 1
+	}
+	s := &sketch{mask: uint64(w - 1), reset: 10 * size}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+// index returns the counter of key in row i. The keys are hashes already, so we just mix in a per-row seed.
+func (s *sketch) index(key uint64, i int) uint64 {
+	h := (key ^ seeds[i]) * 0x9E3779B97F4A7C15
+	return (h >> 32) & s.mask
+}
+
+func (s *sketch) increment(key uint64) {
+	for i := range s.rows {
+		j := s.index(key, i)
+		if s.rows[i][j] < 255 {
+			s.rows[i][j]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.reset {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] >>= 1
+			}
+		}
+		s.additions /= 2
+	}
+}
+
+func (s *sketch) estimate(key uint64) uint8 {
+	min := uint8(255)
+	for i := range s.rows {
+		if c := s.rows[i][s.index(key, i)]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+const depth = 4
+
+var seeds = [depth]uint64{0xc3a5c85c97cb3127, 0xb492b66fbe98f273, 0x9ae16a3b2f90404f, 0xcbf29ce484222325}
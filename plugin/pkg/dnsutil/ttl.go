@@ -10,6 +10,11 @@ import (
 
 // MinimalTTL scans the message returns the lowest TTL found taking into the response.Type of the message.
 func MinimalTTL(m *dns.Msg, mt response.Type) time.Duration {
+	return MinimalTTLUpTo(m, mt, MaximumDefaulTTL)
+}
+
+// MinimalTTLUpTo is like MinimalTTL, but returns at most max instead of MaximumDefaulTTL.
+func MinimalTTLUpTo(m *dns.Msg, mt response.Type, max time.Duration) time.Duration {
 	if mt != response.NoError && mt != response.NameError && mt != response.NoData {
 		return MinimalDefaultTTL
 	}
@@ -20,7 +25,7 @@ func MinimalTTL(m *dns.Msg, mt response.Type) time.Duration {
 		return MinimalDefaultTTL
 	}
 
-	minTTL := MaximumDefaulTTL
+	minTTL := max
 	for _, r := range m.Answer {
 		if r.Header().Ttl < uint32(minTTL.Seconds()) {
 			minTTL = time.Duration(r.Header().Ttl) * time.Second
@@ -41,6 +41,23 @@ func TestMinimalTTL(t *testing.T) {
 	}
 }
 
+func TestMinimalTTLUpTo(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeMX)
+	m.Answer = []dns.RR{test.MX("example.org.	86400	IN	MX	10 mx.example.org.")}
+	mt, _ := response.Typify(m, time.Now().UTC())
+
+	if dur := MinimalTTL(m, mt); dur != MaximumDefaulTTL {
+		t.Errorf("Expected minttl duration to be %s, got %s", MaximumDefaulTTL, dur)
+	}
+	if dur := MinimalTTLUpTo(m, mt, 48*time.Hour); dur != 24*time.Hour {
+		t.Errorf("Expected minttl duration to be %s, got %s", 24*time.Hour, dur)
+	}
+	if dur := MinimalTTLUpTo(m, mt, 30*time.Second); dur != 30*time.Second {
+		t.Errorf("Expected minttl duration to be %s, got %s", 30*time.Second, dur)
+	}
+}
+
 func BenchmarkMinimalTTL(b *testing.B) {
 	m := new(dns.Msg)
 	m.SetQuestion("example.org.", dns.TypeA)
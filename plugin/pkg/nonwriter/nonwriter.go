@@ -19,3 +19,13 @@ func (w *Writer) WriteMsg(res *dns.Msg) error {
 	w.Msg = res
 	return nil
 }
+
+// Write records the packed message buf, but doesn't write it itself.
+func (w *Writer) Write(buf []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(buf); err != nil {
+		return 0, err
+	}
+	w.Msg = m
+	return len(buf), nil
+}
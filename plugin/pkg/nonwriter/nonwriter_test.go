@@ -17,3 +17,19 @@ func TestNonWriter(t *testing.T) {
 		t.Errorf("Expacted 'example.org.' got %q:", x)
 	}
 }
+
+func TestNonWriterWrite(t *testing.T) {
+	nw := New(nil)
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	buf, _ := m.Pack()
+	if _, err := nw.Write(buf); err != nil {
+		t.Errorf("Got error when writing to nonwriter: %s", err)
+	}
+	if x := nw.Msg.Question[0].Name; x != "example.org." {
+		t.Errorf("Expected 'example.org.' got %q:", x)
+	}
+	if _, err := nw.Write(buf[:5]); err == nil {
+		t.Error("Expected error when writing a malformed message")
+	}
+}
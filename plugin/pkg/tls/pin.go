@@ -0,0 +1,96 @@
+package tls
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrPinMismatch is returned when the server's public key doesn't match any of the pins.
+	ErrPinMismatch = errors.New("certificate public key does not match any pin")
+	// ErrSANMismatch is returned when the server's certificate doesn't carry the required SAN.
+	ErrSANMismatch = errors.New("certificate does not contain the required SAN")
+)
+
+// SPKIPin returns the base64 encoded SHA-256 hash of the certificate's SubjectPublicKeyInfo. This is the pin
+// format from RFC 7469, as used in the out-of-band key-pinned privacy profile of RFC 7858.
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// IsSPKIPin returns true if s looks like a pin: a base64 encoded SHA-256 hash.
+func IsSPKIPin(s string) bool {
+	b, err := base64.StdEncoding.DecodeString(s)
+	return err == nil && len(b) == sha256.Size
+}
+
+// Verifier verifies the certificate of a TLS server against a set of SPKI pins and a required subject
+// alternative name (SAN). An empty pin set or SAN is not checked.
+type Verifier struct {
+	Pins []string
+	SAN  string
+}
+
+// Verify checks the leaf certificate in cs. It returns ErrPinMismatch or ErrSANMismatch (wrapped) on failure.
+// Only the leaf is checked against the pins, as that is the key the server proved to own in the handshake.
+func (v Verifier) Verify(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no peer certificate")
+	}
+	leaf := cs.PeerCertificates[0]
+
+	if len(v.Pins) > 0 {
+		pin := SPKIPin(leaf)
+		found := false
+		for _, p := range v.Pins {
+			if p == pin {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: got %s", ErrPinMismatch, pin)
+		}
+	}
+
+	if v.SAN != "" {
+		if err := leaf.VerifyHostname(v.SAN); err != nil {
+			return fmt.Errorf("%w: %s", ErrSANMismatch, err)
+		}
+	}
+	return nil
+}
+
+// Config returns a copy of cfg that verifies the server with v. If v has pins the certificate chain is not
+// validated against the CAs, the pins are authoritative. The function failed, if not nil, is called with every
+// verification error.
+func (v Verifier) Config(cfg *tls.Config, failed func(error)) *tls.Config {
+	c := cfg.Clone()
+	if len(v.Pins) > 0 {
+		c.InsecureSkipVerify = true
+	}
+	c.VerifyConnection = func(cs tls.ConnectionState) error {
+		err := v.Verify(cs)
+		if err != nil && failed != nil {
+			failed(err)
+		}
+		return err
+	}
+	return c
+}
+
+// VerifyErrorReason returns "pin", "san" or "other" for an error returned by Verify, for use as a metric label.
+func VerifyErrorReason(err error) string {
+	switch {
+	case errors.Is(err, ErrPinMismatch):
+		return "pin"
+	case errors.Is(err, ErrSANMismatch):
+		return "san"
+	}
+	return "other"
+}
@@ -0,0 +1,99 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func newCert(t *testing.T) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dns.example.org"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"dns.example.org"},
+		IPAddresses:  []net.IP{net.ParseIP("192.0.2.53")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestVerifier(t *testing.T) {
+	cert := newCert(t)
+	other := newCert(t)
+	pin := SPKIPin(cert)
+
+	if !IsSPKIPin(pin) {
+		t.Errorf("Expected %q to be a pin", pin)
+	}
+	if IsSPKIPin("1.1.1.1") {
+		t.Errorf("Expected %q not to be a pin", "1.1.1.1")
+	}
+
+	cs := tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	tests := []struct {
+		v   Verifier
+		err error
+	}{
+		{Verifier{}, nil},
+		{Verifier{Pins: []string{pin}}, nil},
+		{Verifier{Pins: []string{SPKIPin(other), pin}}, nil},
+		{Verifier{Pins: []string{SPKIPin(other)}}, ErrPinMismatch},
+		{Verifier{SAN: "dns.example.org"}, nil},
+		{Verifier{SAN: "192.0.2.53"}, nil},
+		{Verifier{SAN: "dns.example.net"}, ErrSANMismatch},
+		{Verifier{SAN: "192.0.2.1"}, ErrSANMismatch},
+		{Verifier{Pins: []string{pin}, SAN: "dns.example.net"}, ErrSANMismatch},
+	}
+	for i, tc := range tests {
+		err := tc.v.Verify(cs)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("Test %d: expected %v, got %v", i, tc.err, err)
+		}
+	}
+}
+
+func TestVerifierConfig(t *testing.T) {
+	cert := newCert(t)
+	cfg := &tls.Config{ServerName: "dns.example.org"}
+
+	var failed error
+	c := Verifier{Pins: []string{SPKIPin(newCert(t))}}.Config(cfg, func(err error) { failed = err })
+	if !c.InsecureSkipVerify {
+		t.Error("Expected InsecureSkipVerify to be set with pins")
+	}
+	if cfg.InsecureSkipVerify || cfg.VerifyConnection != nil {
+		t.Error("Expected original config to be unchanged")
+	}
+	if err := c.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}); !errors.Is(err, ErrPinMismatch) {
+		t.Errorf("Expected %s, got %v", ErrPinMismatch, err)
+	}
+	if !errors.Is(failed, ErrPinMismatch) {
+		t.Errorf("Expected failed to be called with %s, got %v", ErrPinMismatch, failed)
+	}
+
+	c = Verifier{SAN: "dns.example.org"}.Config(cfg, nil)
+	if c.InsecureSkipVerify {
+		t.Error("Expected InsecureSkipVerify not to be set without pins")
+	}
+}
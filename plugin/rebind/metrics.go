@@ -0,0 +1,16 @@
+package rebind
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// responseCount is the number of responses inspected, by the decision made.
+var responseCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: pluginName,
+	Name:      "responses_total",
+	Help:      "Counter of responses inspected per decision.",
+}, []string{"server", "decision"})
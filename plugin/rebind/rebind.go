@@ -0,0 +1,203 @@
+// Package rebind implements a plugin that protects against DNS rebinding attacks.
+package rebind
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/nonwriter"
+	"github.com/coredns/coredns/request"
+
+	"github.com/infobloxopen/go-trees/iptree"
+	"github.com/miekg/dns"
+)
+
+// Rebind filters the addresses in the responses of the next plugins that are in the protected networks.
+type Rebind struct {
+	Next plugin.Handler
+
+	Zones  []string
+	nets   *iptree.Tree // the protected networks
+	allow  []string     // the zones that may resolve to the protected networks
+	action action
+	cname  bool // whether to remove the CNAMEs that lead to removed addresses
+
+	cnameAllowed bool // whether to treat CNAMEs to the allowed zones as protected
+}
+
+// action defines what is done with a response that has an address in a protected network.
+type action int
+
+const (
+	// actionRemove removes the records with addresses in the protected networks from the response.
+	actionRemove action = iota
+	// actionRefuse answers with REFUSED.
+	actionRefuse
+)
+
+// The decisions that are made for a response.
+const (
+	decisionPass    = "pass"    // no address in the protected networks
+	decisionAllowed = "allowed" // addresses in the protected networks, for a name in an allowed zone
+	decisionRemoved = "removed"
+	decisionRefused = "refused"
+)
+
+// ServeDNS implements the plugin.Handler interface.
+func (rb *Rebind) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	if plugin.Zones(rb.Zones).Matches(state.Name()) == "" {
+		return plugin.NextOrFailure(rb.Name(), rb.Next, ctx, w, r)
+	}
+
+	nw := nonwriter.New(w)
+	rcode, err := plugin.NextOrFailure(rb.Name(), rb.Next, ctx, nw, r)
+	if nw.Msg == nil {
+		return rcode, err
+	}
+
+	res, d, addr := rb.filter(state, nw.Msg)
+	server := metrics.WithServer(ctx)
+	responseCount.WithLabelValues(server, d).Inc()
+	if h, ok := ctx.Value(decisionKey{}).(*decision); ok {
+		h.decision, h.address = d, addr
+	}
+	if d == decisionRemoved || d == decisionRefused {
+		log.Debugf("%s %s: %s response with %s", state.Name(), state.Type(), d, addr)
+	}
+
+	w.WriteMsg(res)
+	if d == decisionRefused {
+		return dns.RcodeSuccess, nil
+	}
+	return rcode, err
+}
+
+// filter returns the response to write for res, the decision made, and the first address in the protected
+// networks, or the first CNAME target in an allowed zone, that was found. Both the answer and the additional
+// section, e.g. the addresses of the targets of MX and SRV records, are filtered.
+func (rb *Rebind) filter(state request.Request, res *dns.Msg) (*dns.Msg, string, string) {
+	if res.Rcode != dns.RcodeSuccess {
+		return res, decisionPass, ""
+	}
+
+	allowed := plugin.Zones(rb.allow).Matches(state.Name()) != ""
+	found := ""
+	answer, removed := rb.strip(res.Answer, &found)
+	extra, _ := rb.strip(res.Extra, &found)
+
+	if found == "" {
+		return res, decisionPass, ""
+	}
+	if allowed {
+		return res, decisionAllowed, found
+	}
+
+	if rb.action == actionRefuse {
+		m := new(dns.Msg)
+		m.SetRcode(state.Req, dns.RcodeRefused)
+		return m, decisionRefused, found
+	}
+	if rb.cname {
+		answer = removeChains(answer, removed)
+	}
+	m := res.Copy()
+	m.Answer = answer
+	m.Extra = extra
+	return m, decisionRemoved, found
+}
+
+// strip returns rrs without the protected records, and the lower cased owners of the records that were removed.
+// The first protected address or CNAME target is stored in found, if that is still empty.
+func (rb *Rebind) strip(rrs []dns.RR, found *string) ([]dns.RR, map[string]struct{}) {
+	kept := make([]dns.RR, 0, len(rrs))
+	removed := map[string]struct{}{}
+	for _, rr := range rrs {
+		if s := rb.protected(rr); s != "" {
+			if *found == "" {
+				*found = s
+			}
+			removed[strings.ToLower(rr.Header().Name)] = struct{}{}
+			continue
+		}
+		kept = append(kept, rr)
+	}
+	return kept, removed
+}
+
+// removeChains removes the CNAMEs from the answer that lead to a dead owner: an owner in removed that has no
+// records left. The owners of the CNAMEs removed are dead too, so whole chains are removed.
+func removeChains(answer []dns.RR, removed map[string]struct{}) []dns.RR {
+	dead := make(map[string]struct{}, len(removed))
+	for o := range removed {
+		dead[o] = struct{}{}
+	}
+	for _, rr := range answer {
+		delete(dead, strings.ToLower(rr.Header().Name))
+	}
+
+	for {
+		n := len(answer)
+		kept := answer[:0]
+		for _, rr := range answer {
+			if c, ok := rr.(*dns.CNAME); ok {
+				if _, ok := dead[strings.ToLower(c.Target)]; ok {
+					dead[strings.ToLower(c.Hdr.Name)] = struct{}{}
+					continue
+				}
+			}
+			kept = append(kept, rr)
+		}
+		answer = kept
+		if len(answer) == n {
+			return answer
+		}
+	}
+}
+
+// protected returns the address of rr if it is in a protected network, or the target of a CNAME in an allowed zone
+// when those are protected. It returns the empty string otherwise.
+func (rb *Rebind) protected(rr dns.RR) string {
+	var ip net.IP
+	switch x := rr.(type) {
+	case *dns.A:
+		ip = x.A
+	case *dns.AAAA:
+		ip = x.AAAA
+	case *dns.CNAME:
+		if rb.cnameAllowed && plugin.Zones(rb.allow).Matches(x.Target) != "" {
+			return x.Target
+		}
+		return ""
+	default:
+		return ""
+	}
+	if _, ok := rb.nets.GetByIP(ip); ok {
+		return ip.String()
+	}
+	return ""
+}
+
+// decision holds the decision for a query, for the metadata.
+type decision struct {
+	decision string
+	address  string
+}
+
+type decisionKey struct{}
+
+// Metadata implements the metadata.Provider interface.
+func (rb *Rebind) Metadata(ctx context.Context, state request.Request) context.Context {
+	d := &decision{}
+	ctx = context.WithValue(ctx, decisionKey{}, d)
+	metadata.SetValueFunc(ctx, "rebind/decision", func() string { return d.decision })
+	metadata.SetValueFunc(ctx, "rebind/address", func() string { return d.address })
+	return ctx
+}
+
+// Name implements the Handler interface.
+func (rb *Rebind) Name() string { return "rebind" }
@@ -0,0 +1,143 @@
+package rebind
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// answers are the answers of the next plugin.
+var answers = map[string][]dns.RR{
+	"public.example.org.": {test.A("public.example.org. 300 IN A 192.0.2.1")},
+	"evil.example.org.": {
+		test.A("evil.example.org. 300 IN A 192.0.2.1"),
+		test.A("evil.example.org. 300 IN A 10.0.0.1"),
+	},
+	"evil6.example.org.": {test.AAAA("evil6.example.org. 300 IN AAAA ::ffff:127.0.0.1")},
+	"db.corp.internal.":  {test.A("db.corp.internal. 300 IN A 10.0.0.2")},
+	"alias.example.org.": {
+		test.CNAME("alias.example.org. 300 IN CNAME db.corp.internal."),
+		test.A("db.corp.internal. 300 IN A 192.0.2.2"),
+	},
+	"chain.example.org.": {
+		test.CNAME("chain.example.org. 300 IN CNAME mid.example.org."),
+		test.CNAME("mid.example.org. 300 IN CNAME internal.example.org."),
+		test.A("internal.example.org. 300 IN A 10.0.0.3"),
+	},
+	"mx.example.org.": {test.MX("mx.example.org. 300 IN MX 10 mail.example.org.")},
+}
+
+// extras are the additional records of the next plugin.
+var extras = map[string][]dns.RR{
+	"mx.example.org.": {
+		test.A("mail.example.org. 300 IN A 192.0.2.3"),
+		test.A("mail.example.org. 300 IN A 10.0.0.4"),
+	},
+}
+
+func next() test.Handler {
+	return test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = answers[r.Question[0].Name]
+		m.Extra = extras[r.Question[0].Name]
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		config   string
+		qname    string
+		qtype    uint16
+		rcode    int
+		answer   int
+		extra    int
+		decision string
+		address  string
+	}{
+		{`rebind . {
+			allow corp.internal
+		}`, "public.example.org.", dns.TypeA, dns.RcodeSuccess, 1, 0, "pass", ""},
+		{`rebind . {
+			allow corp.internal
+		}`, "evil.example.org.", dns.TypeA, dns.RcodeSuccess, 1, 0, "removed", "10.0.0.1"},
+		{`rebind . {
+			allow corp.internal
+		}`, "evil6.example.org.", dns.TypeAAAA, dns.RcodeSuccess, 0, 0, "removed", "127.0.0.1"},
+		{`rebind . {
+			allow corp.internal
+		}`, "db.corp.internal.", dns.TypeA, dns.RcodeSuccess, 1, 0, "allowed", "10.0.0.2"},
+		{`rebind . {
+			allow corp.internal
+		}`, "alias.example.org.", dns.TypeA, dns.RcodeSuccess, 2, 0, "pass", ""},
+		{`rebind . {
+			allow corp.internal
+			cname_allowed
+		}`, "alias.example.org.", dns.TypeA, dns.RcodeSuccess, 1, 0, "removed", "db.corp.internal."},
+		{`rebind .`, "chain.example.org.", dns.TypeA, dns.RcodeSuccess, 2, 0, "removed", "10.0.0.3"},
+		{`rebind . {
+			cname
+		}`, "chain.example.org.", dns.TypeA, dns.RcodeSuccess, 0, 0, "removed", "10.0.0.3"},
+		{`rebind . {
+			cname
+		}`, "alias.example.org.", dns.TypeA, dns.RcodeSuccess, 2, 0, "pass", ""},
+		{`rebind .`, "mx.example.org.", dns.TypeMX, dns.RcodeSuccess, 1, 1, "removed", "10.0.0.4"},
+		{`rebind . {
+			action refuse
+		}`, "evil.example.org.", dns.TypeA, dns.RcodeRefused, 0, 0, "refused", "10.0.0.1"},
+		{`rebind . {
+			action refuse
+		}`, "db.corp.internal.", dns.TypeA, dns.RcodeRefused, 0, 0, "refused", "10.0.0.2"},
+		{`rebind . {
+			net 192.0.2.0/24
+		}`, "evil.example.org.", dns.TypeA, dns.RcodeSuccess, 1, 0, "removed", "192.0.2.1"},
+		{`rebind example.net`, "evil.example.org.", dns.TypeA, dns.RcodeSuccess, 2, 0, "", ""},
+	}
+
+	for i, tc := range tests {
+		rb, err := parse(caddy.NewTestController("dns", tc.config))
+		if err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		rb.Next = next()
+
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, tc.qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		ctx := metadata.ContextWithMetadata(context.Background())
+		ctx = rb.Metadata(ctx, request.Request{W: rec, Req: m})
+
+		if _, err := rb.ServeDNS(ctx, rec, m); err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		if rec.Msg.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %d, got %d", i, tc.rcode, rec.Msg.Rcode)
+		}
+		if len(rec.Msg.Answer) != tc.answer {
+			t.Errorf("Test %d: expected %d records, got %d", i, tc.answer, len(rec.Msg.Answer))
+		}
+		if len(rec.Msg.Extra) != tc.extra {
+			t.Errorf("Test %d: expected %d additional records, got %d", i, tc.extra, len(rec.Msg.Extra))
+		}
+		if d := metadata.ValueFunc(ctx, "rebind/decision")(); d != tc.decision {
+			t.Errorf("Test %d: expected decision %q, got %q", i, tc.decision, d)
+		}
+		if a := metadata.ValueFunc(ctx, "rebind/address")(); a != tc.address {
+			t.Errorf("Test %d: expected address %q, got %q", i, tc.address, a)
+		}
+	}
+
+	// The answer of the next plugin is left alone.
+	if len(answers["evil.example.org."]) != 2 {
+		t.Errorf("Expected the answer of the next plugin to be unchanged")
+	}
+}
@@ -0,0 +1,133 @@
+package rebind
+
+import (
+	"net"
+	"strings"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+
+	"github.com/infobloxopen/go-trees/iptree"
+)
+
+const pluginName = "rebind"
+
+var log = clog.NewWithPlugin(pluginName)
+
+func init() { plugin.Register(pluginName, setup) }
+
+func setup(c *caddy.Controller) error {
+	rb, err := parse(c)
+	if err != nil {
+		return plugin.Error(pluginName, err)
+	}
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		rb.Next = next
+		return rb
+	})
+
+	return nil
+}
+
+// defaultNets are the networks that are protected when none are configured: the private, loopback, link-local,
+// shared address space and unspecified addresses. IPv4-mapped IPv6 addresses are matched as IPv4 addresses.
+var defaultNets = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+func parse(c *caddy.Controller) (*Rebind, error) {
+	rb := &Rebind{nets: iptree.NewTree()}
+
+	i := 0
+	for c.Next() {
+		if i > 0 {
+			return nil, plugin.ErrOnce
+		}
+		i++
+
+		rb.Zones = plugin.OriginsFromArgsOrServerBlock(c.RemainingArgs(), c.ServerBlockKeys)
+
+		nets := []string{}
+		for c.NextBlock() {
+			switch c.Val() {
+			case "net":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				nets = append(nets, args...)
+			case "allow":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				for _, a := range args {
+					rb.allow = append(rb.allow, plugin.Host(a).NormalizeExact()...)
+				}
+			case "action":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				switch c.Val() {
+				case "remove":
+					rb.action = actionRemove
+				case "refuse":
+					rb.action = actionRefuse
+				default:
+					return nil, c.Errf("unknown action '%s'; expect 'remove' or 'refuse'", c.Val())
+				}
+				if c.NextArg() {
+					return nil, c.ArgErr()
+				}
+			case "cname":
+				if c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				rb.cname = true
+			case "cname_allowed":
+				if c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				rb.cnameAllowed = true
+			default:
+				return nil, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+
+		if len(nets) == 0 {
+			nets = defaultNets
+		}
+		for _, n := range nets {
+			_, ipnet, err := net.ParseCIDR(normalize(n))
+			if err != nil {
+				return nil, c.Errf("illegal CIDR notation %q", n)
+			}
+			rb.nets.InplaceInsertNet(ipnet, struct{}{})
+		}
+	}
+	return rb, nil
+}
+
+// normalize appends '/32' for any single IPv4 address and '/128' for IPv6.
+func normalize(n string) string {
+	if strings.Contains(n, "/") {
+		return n
+	}
+	if strings.Contains(n, ":") {
+		return n + "/128"
+	}
+	return n + "/32"
+}
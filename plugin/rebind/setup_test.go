@@ -0,0 +1,92 @@
+package rebind
+
+import (
+	"net"
+	"testing"
+
+	"github.com/coredns/caddy"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+	}{
+		{`rebind`, false},
+		{`rebind example.org {
+			net 10.0.0.0/8 192.168.1.1 fd00::/8
+			allow corp.internal home.arpa
+			action refuse
+			cname
+			cname_allowed
+		}`, false},
+		{`rebind {
+			action remove
+		}`, false},
+		// fails
+		{`rebind {
+			net
+		}`, true},
+		{`rebind {
+			net 10.0.0.0/33
+		}`, true},
+		{`rebind {
+			allow
+		}`, true},
+		{`rebind {
+			action drop
+		}`, true},
+		{`rebind {
+			action
+		}`, true},
+		{`rebind {
+			cname yes
+		}`, true},
+		{`rebind {
+			cname_allowed yes
+		}`, true},
+		{`rebind {
+			blah
+		}`, true},
+		{`rebind
+		rebind`, true},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		err := setup(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+		}
+		if !test.shouldErr && err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+		}
+	}
+}
+
+func TestSetupNets(t *testing.T) {
+	rb, err := parse(caddy.NewTestController("dns", `rebind`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	for _, ip := range []string{"10.1.2.3", "127.0.0.1", "192.168.1.1", "172.31.0.1", "::1", "fd12::1", "fe80::1", "::ffff:10.0.0.1"} {
+		if _, ok := rb.nets.GetByIP(net.ParseIP(ip)); !ok {
+			t.Errorf("Expected %s to be protected by default", ip)
+		}
+	}
+	for _, ip := range []string{"192.0.2.1", "8.8.8.8", "2001:db8::1", "172.32.0.1"} {
+		if _, ok := rb.nets.GetByIP(net.ParseIP(ip)); ok {
+			t.Errorf("Expected %s to not be protected by default", ip)
+		}
+	}
+
+	rb, err = parse(caddy.NewTestController("dns", `rebind {
+		net 192.0.2.0/24
+	}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if _, ok := rb.nets.GetByIP(net.ParseIP("10.0.0.1")); ok {
+		t.Errorf("Expected the configured networks to replace the default ones")
+	}
+}
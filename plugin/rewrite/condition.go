@@ -0,0 +1,207 @@
+package rewrite
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// condition is a condition a request must match for a conditional rule to be applied.
+type condition interface {
+	match(ctx context.Context, state request.Request) bool
+}
+
+// conditionalRule is a rule that is only applied to the requests that match all of its conditions.
+type conditionalRule struct {
+	Rule
+	conditions []condition
+}
+
+// Rewrite rewrites the current request when it matches all conditions of the rule.
+func (rule *conditionalRule) Rewrite(ctx context.Context, state request.Request) (ResponseRules, Result) {
+	for _, c := range rule.conditions {
+		if !c.match(ctx, state) {
+			return nil, RewriteIgnored
+		}
+	}
+	return rule.Rule.Rewrite(ctx, state)
+}
+
+// isConditionKeyword returns true if arg starts the conditions of a rule.
+func isConditionKeyword(arg string) bool {
+	arg = strings.ToLower(arg)
+	return arg == "if" || arg == "when"
+}
+
+// newConditions parses the conditions of a rule, e.g. 'client 10.0.0.0/8 type A AAAA not transport tcp'.
+// A condition matches when one of its values matches; all conditions must match.
+func newConditions(args ...string) ([]condition, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no conditions specified")
+	}
+
+	var conditions []condition
+	for len(args) > 0 {
+		not := strings.ToLower(args[0]) == "not"
+		if not {
+			args = args[1:]
+			if len(args) == 0 {
+				return nil, fmt.Errorf("no condition specified after 'not'")
+			}
+		}
+		kind := strings.ToLower(args[0])
+
+		i := 1
+		for ; i < len(args) && !isConditionType(args[i]); i++ {
+		}
+		values := args[1:i]
+		args = args[i:]
+		if len(values) == 0 {
+			return nil, fmt.Errorf("no values specified for condition %q", kind)
+		}
+
+		var c condition
+		switch kind {
+		case "client":
+			nets := clientCondition{}
+			for _, v := range values {
+				if !strings.Contains(v, "/") {
+					if strings.Contains(v, ":") {
+						v += "/128"
+					} else {
+						v += "/32"
+					}
+				}
+				_, n, err := net.ParseCIDR(v)
+				if err != nil {
+					return nil, fmt.Errorf("invalid client network %q", v)
+				}
+				nets = append(nets, n)
+			}
+			c = nets
+		case "transport":
+			transports := transportCondition{}
+			for _, v := range values {
+				v = strings.ToLower(v)
+				switch v {
+				case "udp", "tcp", transport.DNS, transport.TLS, transport.GRPC, transport.HTTPS:
+				default:
+					return nil, fmt.Errorf("invalid transport %q", v)
+				}
+				transports = append(transports, v)
+			}
+			c = transports
+		case "type":
+			types := typeCondition{}
+			for _, v := range values {
+				qtype, ok := dns.StringToType[strings.ToUpper(v)]
+				if !ok {
+					return nil, fmt.Errorf("invalid type %q", v)
+				}
+				types[qtype] = struct{}{}
+			}
+			c = types
+		case "meta":
+			mvs := metaCondition{}
+			for _, v := range values {
+				i := strings.Index(v, "=")
+				if i < 0 || !metadata.IsLabel(v[:i]) {
+					return nil, fmt.Errorf("invalid metadata condition %q, expect LABEL=VALUE", v)
+				}
+				mvs = append(mvs, metaValue{label: v[:i], value: v[i+1:]})
+			}
+			c = mvs
+		default:
+			return nil, fmt.Errorf("invalid condition %q, expect 'client', 'transport', 'type' or 'meta'", kind)
+		}
+		if not {
+			c = notCondition{c}
+		}
+		conditions = append(conditions, c)
+	}
+	return conditions, nil
+}
+
+// isConditionType returns true if arg is a type of condition or 'not'.
+func isConditionType(arg string) bool {
+	switch strings.ToLower(arg) {
+	case "client", "transport", "type", "meta", "not":
+		return true
+	}
+	return false
+}
+
+// clientCondition matches requests from a client in one of its networks.
+type clientCondition []*net.IPNet
+
+func (c clientCondition) match(_ context.Context, state request.Request) bool {
+	ip := net.ParseIP(state.IP())
+	for _, n := range c {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// transportCondition matches requests received over one of its transports. The transports 'udp' and 'tcp' are
+// plain DNS over UDP and TCP, 'dns' is both.
+type transportCondition []string
+
+func (c transportCondition) match(ctx context.Context, state request.Request) bool {
+	scheme := transport.DNS
+	if srv, ok := ctx.Value(dnsserver.Key{}).(*dnsserver.Server); ok {
+		if i := strings.Index(srv.Addr, "://"); i > 0 {
+			scheme = srv.Addr[:i]
+		}
+	}
+	for _, t := range c {
+		if t == scheme || (scheme == transport.DNS && t == state.Proto()) {
+			return true
+		}
+	}
+	return false
+}
+
+// typeCondition matches requests for one of its query types.
+type typeCondition map[uint16]struct{}
+
+func (c typeCondition) match(_ context.Context, state request.Request) bool {
+	_, ok := c[state.QType()]
+	return ok
+}
+
+// metaCondition matches requests that have one of its metadata values.
+type metaCondition []metaValue
+
+// metaValue is a value of a metadata label.
+type metaValue struct {
+	label string
+	value string
+}
+
+func (c metaCondition) match(ctx context.Context, _ request.Request) bool {
+	for _, mv := range c {
+		if f := metadata.ValueFunc(ctx, mv.label); f != nil && f() == mv.value {
+			return true
+		}
+	}
+	return false
+}
+
+// notCondition matches requests its condition doesn't match.
+type notCondition struct {
+	condition
+}
+
+func (c notCondition) match(ctx context.Context, state request.Request) bool {
+	return !c.condition.match(ctx, state)
+}
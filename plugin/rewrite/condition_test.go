@@ -0,0 +1,136 @@
+package rewrite
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestNewConditionalRule(t *testing.T) {
+	tests := []struct {
+		args        []string
+		shouldError bool
+	}{
+		{[]string{"name", "a.com", "b.com", "if", "client", "10.0.0.0/8", "192.168.0.1"}, false},
+		{[]string{"continue", "name", "a.com", "b.com", "when", "transport", "tcp", "tls", "type", "A", "AAAA"}, false},
+		{[]string{"name", "suffix", "a.com", "b.com", "answer", "auto", "if", "meta", "kubernetes/client-namespace=ns1"}, false},
+		{[]string{"ttl", "a.com", "15", "if", "not", "client", "::1"}, false},
+		{[]string{"name", "a.com", "b.com", "if"}, true},
+		{[]string{"if", "client", "10.0.0.0/8"}, true},
+		{[]string{"continue", "if", "client", "10.0.0.0/8"}, true},
+		{[]string{"name", "a.com", "if", "client", "10.0.0.0/8"}, true},
+		{[]string{"name", "a.com", "b.com", "if", "client"}, true},
+		{[]string{"name", "a.com", "b.com", "if", "client", "10.0.0.0/33"}, true},
+		{[]string{"name", "a.com", "b.com", "if", "transport", "quic"}, true},
+		{[]string{"name", "a.com", "b.com", "if", "type", "XY"}, true},
+		{[]string{"name", "a.com", "b.com", "if", "meta", "kubernetes/client-namespace"}, true},
+		{[]string{"name", "a.com", "b.com", "if", "not"}, true},
+		{[]string{"name", "a.com", "b.com", "if", "foo", "bar"}, true},
+	}
+
+	for i, tc := range tests {
+		r, err := newRule(tc.args...)
+		if err == nil && tc.shouldError {
+			t.Errorf("Test %d: expected error but got success", i)
+		} else if err != nil && !tc.shouldError {
+			t.Errorf("Test %d: expected success but got error: %s", i, err)
+		}
+		if err != nil {
+			continue
+		}
+		if reflect.TypeOf(r) != reflect.TypeOf(&conditionalRule{}) {
+			t.Errorf("Test %d: expected a conditional rule, got %T", i, r)
+		}
+	}
+}
+
+func TestNewRuleKeywordArgs(t *testing.T) {
+	tests := []struct {
+		args        []string
+		conditional bool
+	}{
+		{[]string{"name", "if", "when"}, false},
+		{[]string{"stop", "name", "exact", "if", "when"}, false},
+		{[]string{"name", "regex", "if", "when", "if", "client", "10.0.0.0/8"}, true},
+		{[]string{"ttl", "when", "15"}, false},
+		{[]string{"rcode", "if", "NXDOMAIN", "SERVFAIL"}, false},
+		{[]string{"edns0", "local", "set", "0xffee", "when"}, false},
+		{[]string{"edns0", "response", "local", "set", "0xffee", "if", "when", "type", "A"}, true},
+	}
+
+	for i, tc := range tests {
+		r, err := newRule(tc.args...)
+		if err != nil {
+			t.Errorf("Test %d: expected success but got error: %s", i, err)
+			continue
+		}
+		if _, ok := r.(*conditionalRule); ok != tc.conditional {
+			t.Errorf("Test %d: expected a conditional rule to be %t, got %T", i, tc.conditional, r)
+		}
+	}
+}
+
+func TestRewriteConditions(t *testing.T) {
+	rules, err := rewriteParse(caddy.NewTestController("dns", `rewrite stop {
+		name suffix .svc.cluster.local. .svc.other.local. answer auto
+		if meta kubernetes/client-namespace=ns1
+	}
+	rewrite stop name exact tcp.example.org. tls.example.org. when transport tls
+	rewrite continue name exact tcp.example.org. udp.example.org. if not transport tcp
+	rewrite stop name exact udp.example.org. local.example.org. if client 10.240.0.0/16 type A
+	rewrite stop type ANY HINFO if client 192.168.0.0/16`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	rw := Rewrite{Next: plugin.HandlerFunc(msgPrinter), Rules: rules, RevertPolicy: NoRestorePolicy()}
+
+	tests := []struct {
+		name      string
+		qtype     uint16
+		tcp       bool
+		server    string
+		namespace string
+		to        string
+		toType    uint16
+	}{
+		{"a.svc.cluster.local.", dns.TypeA, false, "", "ns1", "a.svc.other.local.", dns.TypeA},
+		{"a.svc.cluster.local.", dns.TypeA, false, "", "ns2", "a.svc.cluster.local.", dns.TypeA},
+		{"tcp.example.org.", dns.TypeA, true, "tls://.:853", "", "tls.example.org.", dns.TypeA},
+		{"tcp.example.org.", dns.TypeA, true, "dns://.:53", "", "tcp.example.org.", dns.TypeA},
+		// the first rule continues, the second one stops
+		{"tcp.example.org.", dns.TypeA, false, "dns://.:53", "", "local.example.org.", dns.TypeA},
+		{"tcp.example.org.", dns.TypeAAAA, false, "dns://.:53", "", "udp.example.org.", dns.TypeAAAA},
+		{"tcp.example.org.", dns.TypeANY, false, "dns://.:53", "", "udp.example.org.", dns.TypeANY},
+	}
+
+	for i, tc := range tests {
+		ctx := context.Background()
+		if tc.server != "" {
+			ctx = context.WithValue(ctx, dnsserver.Key{}, &dnsserver.Server{Addr: tc.server})
+		}
+		ctx = metadata.ContextWithMetadata(ctx)
+		namespace := tc.namespace
+		metadata.SetValueFunc(ctx, "kubernetes/client-namespace", func() string { return namespace })
+
+		m := new(dns.Msg)
+		m.SetQuestion(tc.name, tc.qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{TCP: tc.tcp})
+		rw.ServeDNS(ctx, rec, m)
+
+		if rec.Msg.Question[0].Name != tc.to {
+			t.Errorf("Test %d: expected name %q, got %q", i, tc.to, rec.Msg.Question[0].Name)
+		}
+		if rec.Msg.Question[0].Qtype != tc.toType {
+			t.Errorf("Test %d: expected type %d, got %d", i, tc.toType, rec.Msg.Question[0].Qtype)
+		}
+	}
+}
@@ -100,8 +100,53 @@ func (rule *edns0LocalRule) Rewrite(ctx context.Context, state request.Request)
 // Mode returns the processing mode.
 func (rule *edns0LocalRule) Mode() string { return rule.mode }
 
+// edns0RemoveRule is a rewrite rule that removes EDNS0 options from the request.
+type edns0RemoveRule struct {
+	mode string
+	code uint16
+}
+
+// Rewrite will remove the request EDNS0 options with the rule's code.
+func (rule *edns0RemoveRule) Rewrite(ctx context.Context, state request.Request) (ResponseRules, Result) {
+	o := state.Req.IsEdns0()
+	if o == nil || !removeEdns0Option(o, rule.code) {
+		return nil, RewriteIgnored
+	}
+	return nil, RewriteDone
+}
+
+// Mode returns the processing mode.
+func (rule *edns0RemoveRule) Mode() string { return rule.mode }
+
+// removeEdns0Option removes the options with code from o. It returns true if an option was removed.
+func removeEdns0Option(o *dns.OPT, code uint16) bool {
+	options := o.Option[:0]
+	for _, s := range o.Option {
+		if s.Option() != code {
+			options = append(options, s)
+		}
+	}
+	removed := len(options) != len(o.Option)
+	o.Option = options
+	return removed
+}
+
+// edns0Codes are the names of the EDNS0 options that can be removed or copied by name.
+var edns0Codes = map[string]uint16{
+	"nsid":      dns.EDNS0NSID,
+	"subnet":    dns.EDNS0SUBNET,
+	"expire":    dns.EDNS0EXPIRE,
+	"cookie":    dns.EDNS0COOKIE,
+	"keepalive": dns.EDNS0TCPKEEPALIVE,
+	"padding":   dns.EDNS0PADDING,
+	"ede":       dns.EDNS0EDE,
+}
+
 // newEdns0Rule creates an EDNS0 rule of the appropriate type based on the args
 func newEdns0Rule(mode string, args ...string) (Rule, error) {
+	if len(args) > 0 && strings.ToLower(args[0]) == "response" {
+		return newEdns0ResponseRule(mode, args[1:]...)
+	}
 	if len(args) < 2 {
 		return nil, fmt.Errorf("too few arguments for an EDNS0 rule")
 	}
@@ -112,6 +157,8 @@ func newEdns0Rule(mode string, args ...string) (Rule, error) {
 	case Append:
 	case Replace:
 	case Set:
+	case Remove:
+		return newEdns0RemoveRule(mode, ruleType, args[2:]...)
 	default:
 		return nil, fmt.Errorf("invalid action: %q", action)
 	}
@@ -141,6 +188,38 @@ func newEdns0Rule(mode string, args ...string) (Rule, error) {
 	}
 }
 
+// newEdns0RemoveRule creates an EDNS0 rule that removes the options of ruleType, or, for local options, the
+// options with the code in args.
+func newEdns0RemoveRule(mode, ruleType string, args ...string) (*edns0RemoveRule, error) {
+	code, err := edns0OptionCode(ruleType, Remove, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &edns0RemoveRule{mode: mode, code: code}, nil
+}
+
+// edns0OptionCode returns the code of the options of ruleType, or, for local options, the code in args.
+func edns0OptionCode(ruleType, action string, args ...string) (uint16, error) {
+	if ruleType == "local" {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("EDNS0 local %s rules require exactly one arg", action)
+		}
+		c, err := strconv.ParseUint(args[0], 0, 16)
+		if err != nil {
+			return 0, err
+		}
+		return uint16(c), nil
+	}
+	code, ok := edns0Codes[ruleType]
+	if !ok {
+		return 0, fmt.Errorf("invalid rule type %q", ruleType)
+	}
+	if len(args) != 0 {
+		return 0, fmt.Errorf("EDNS0 %s %s rules do not accept args", ruleType, action)
+	}
+	return code, nil
+}
+
 func newEdns0LocalRule(mode, action, code, data string) (*edns0LocalRule, error) {
 	c, err := strconv.ParseUint(code, 0, 16)
 	if err != nil {
@@ -180,8 +259,12 @@ func newEdns0VariableRule(mode, action, code, variable string) (*edns0VariableRu
 
 // ruleData returns the data specified by the variable.
 func (rule *edns0VariableRule) ruleData(ctx context.Context, state request.Request) ([]byte, error) {
+	return variableData(ctx, state, rule.variable)
+}
 
-	switch rule.variable {
+// variableData returns the data specified by variable.
+func variableData(ctx context.Context, state request.Request, variable string) ([]byte, error) {
+	switch variable {
 	case queryName:
 		return []byte(state.QName()), nil
 
@@ -204,7 +287,7 @@ func (rule *edns0VariableRule) ruleData(ctx context.Context, state request.Reque
 		return []byte(state.Proto()), nil
 	}
 
-	fetcher := metadata.ValueFunc(ctx, rule.variable[1:len(rule.variable)-1])
+	fetcher := metadata.ValueFunc(ctx, variable[1:len(variable)-1])
 	if fetcher != nil {
 		value := fetcher()
 		if len(value) > 0 {
@@ -212,7 +295,7 @@ func (rule *edns0VariableRule) ruleData(ctx context.Context, state request.Reque
 		}
 	}
 
-	return nil, fmt.Errorf("unable to extract data for variable %s", rule.variable)
+	return nil, fmt.Errorf("unable to extract data for variable %s", variable)
 }
 
 // Rewrite will alter the request EDNS0 local options with specified variables.
@@ -358,6 +441,8 @@ const (
 	Replace = "replace"
 	Set     = "set"
 	Append  = "append"
+	Remove  = "remove"
+	Copy    = "copy"
 )
 
 // Supported local EDNS0 variables
@@ -0,0 +1,145 @@
+package rewrite
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// edns0ResponseRule is a response rule that sets or removes an EDNS0 option in the OPT record of the response.
+// A response without an OPT record is left alone.
+type edns0ResponseRule struct {
+	action string
+	code   uint16
+	option dns.EDNS0 // the option to add, nil for the remove action
+}
+
+// RewriteResponse leaves the records alone, the OPT record is rewritten by RewriteMsg.
+func (r *edns0ResponseRule) RewriteResponse(rr dns.RR) {}
+
+func (r *edns0ResponseRule) RewriteMsg(res *dns.Msg) {
+	o := res.IsEdns0()
+	if o == nil {
+		return
+	}
+
+	switch r.action {
+	case Remove:
+		removeEdns0Option(o, r.code)
+	case Set:
+		removeEdns0Option(o, r.code)
+		o.Option = append(o.Option, r.option)
+	case Replace:
+		if removeEdns0Option(o, r.code) {
+			o.Option = append(o.Option, r.option)
+		}
+	case Append:
+		for _, s := range o.Option {
+			if s.Option() == r.code {
+				return
+			}
+		}
+		o.Option = append(o.Option, r.option)
+	}
+}
+
+// edns0ResponseRewriteRule is a rewrite rule that alters the EDNS0 options of the response.
+type edns0ResponseRewriteRule struct {
+	mode     string
+	action   string
+	code     uint16
+	data     []byte
+	variable string // the variable the data is taken from, if any
+}
+
+// Rewrite adds the response rule for the EDNS0 option. The data of a variable is that of the request, and an
+// option is copied from the request as it is before the next plugins handle it.
+func (rule *edns0ResponseRewriteRule) Rewrite(ctx context.Context, state request.Request) (ResponseRules, Result) {
+	switch rule.action {
+	case Remove:
+		return ResponseRules{&edns0ResponseRule{action: Remove, code: rule.code}}, RewriteDone
+	case Copy:
+		o := state.Req.IsEdns0()
+		if o == nil {
+			return nil, RewriteIgnored
+		}
+		for _, s := range o.Option {
+			if s.Option() == rule.code {
+				// The later rules may alter the option of the request in place, so a copy is kept.
+				c := dns.Copy(&dns.OPT{Option: []dns.EDNS0{s}}).(*dns.OPT).Option[0]
+				return ResponseRules{&edns0ResponseRule{action: Set, code: rule.code, option: c}}, RewriteDone
+			}
+		}
+		return nil, RewriteIgnored
+	}
+
+	data := rule.data
+	if rule.variable != "" {
+		var err error
+		data, err = variableData(ctx, state, rule.variable)
+		if err != nil || data == nil {
+			return nil, RewriteIgnored
+		}
+	}
+	option := &dns.EDNS0_LOCAL{Code: rule.code, Data: data}
+	return ResponseRules{&edns0ResponseRule{action: rule.action, code: rule.code, option: option}}, RewriteDone
+}
+
+// Mode returns the processing mode.
+func (rule *edns0ResponseRewriteRule) Mode() string { return rule.mode }
+
+// newEdns0ResponseRule creates an EDNS0 rule for the response based on the args.
+func newEdns0ResponseRule(mode string, args ...string) (Rule, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("too few arguments for an EDNS0 response rule")
+	}
+
+	ruleType := strings.ToLower(args[0])
+	action := strings.ToLower(args[1])
+	switch action {
+	case Remove, Copy:
+		code, err := edns0OptionCode(ruleType, action, args[2:]...)
+		if err != nil {
+			return nil, err
+		}
+		return &edns0ResponseRewriteRule{mode: mode, action: action, code: code}, nil
+	case Append, Replace, Set:
+	default:
+		return nil, fmt.Errorf("invalid action: %q", action)
+	}
+
+	if ruleType != "local" {
+		return nil, fmt.Errorf("EDNS0 response rules can only %s local options", action)
+	}
+	if len(args) != 4 {
+		return nil, fmt.Errorf("EDNS0 local rules require exactly three args")
+	}
+	c, err := strconv.ParseUint(args[2], 0, 16)
+	if err != nil {
+		return nil, err
+	}
+	rule := &edns0ResponseRewriteRule{mode: mode, action: action, code: uint16(c)}
+
+	data := args[3]
+	switch {
+	case strings.HasPrefix(data, "{") && strings.HasSuffix(data, "}"):
+		if !isValidVariable(data) {
+			return nil, fmt.Errorf("unsupported variable name %q", data)
+		}
+		rule.variable = data
+	case strings.HasPrefix(data, "0x"):
+		rule.data, err = hex.DecodeString(data[2:])
+		if err != nil {
+			return nil, err
+		}
+	default:
+		rule.data = []byte(data)
+	}
+	return rule, nil
+}
@@ -0,0 +1,177 @@
+package rewrite
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestRewriteEDNS0Remove(t *testing.T) {
+	var rules []Rule
+	for _, args := range [][]string{
+		{"continue", "edns0", "subnet", "remove"},
+		{"continue", "edns0", "local", "remove", "12"},
+	} {
+		r, err := newRule(args...)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+		rules = append(rules, r)
+	}
+
+	var forwarded *dns.Msg
+	rw := Rewrite{
+		Next: plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+			forwarded = r.Copy()
+			return msgPrinter(ctx, w, r)
+		}),
+		Rules:        rules,
+		RevertPolicy: NoRevertPolicy(),
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.SetEdns0(4096, false)
+	o := m.IsEdns0()
+	o.Option = append(o.Option,
+		&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: []byte{192, 0, 2, 0}},
+		&dns.EDNS0_PADDING{Padding: make([]byte, 16)},
+		&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708"},
+	)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	rw.ServeDNS(context.TODO(), rec, m)
+
+	options := forwarded.IsEdns0().Option
+	if len(options) != 1 || options[0].Option() != dns.EDNS0COOKIE {
+		t.Errorf("Expected only the cookie option to be forwarded, got %v", options)
+	}
+}
+
+func TestRewriteEDNS0Response(t *testing.T) {
+	var rules []Rule
+	for _, args := range [][]string{
+		{"continue", "edns0", "response", "padding", "remove"},
+		{"continue", "edns0", "response", "local", "set", "0xffee", "{qname}"},
+		{"continue", "edns0", "response", "local", "append", "0xffed", "abcd"},
+		{"continue", "edns0", "response", "local", "replace", "0xffec", "abcd"},
+		{"continue", "edns0", "response", "local", "copy", "0xffeb"},
+		{"continue", "edns0", "response", "ede", "copy"},
+		// the request option is removed before the next plugin, but it is still copied to the response
+		{"continue", "edns0", "local", "remove", "0xffeb"},
+	} {
+		r, err := newRule(args...)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+		rules = append(rules, r)
+	}
+
+	rw := Rewrite{
+		Next: plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+			for _, s := range r.IsEdns0().Option {
+				if s.Option() == 0xffeb {
+					t.Errorf("Expected option 0xffeb to be removed from the request")
+				}
+			}
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.SetEdns0(4096, false)
+			o := m.IsEdns0()
+			o.Option = append(o.Option,
+				&dns.EDNS0_PADDING{Padding: make([]byte, 16)},
+				&dns.EDNS0_LOCAL{Code: 0xffee, Data: []byte("old")},
+				&dns.EDNS0_LOCAL{Code: 0xffed, Data: []byte("old")},
+			)
+			w.WriteMsg(m)
+			return dns.RcodeSuccess, nil
+		}),
+		Rules: rules,
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.SetEdns0(4096, false)
+	o := m.IsEdns0()
+	o.Option = append(o.Option, &dns.EDNS0_LOCAL{Code: 0xffeb, Data: []byte("copied")})
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	rw.ServeDNS(context.TODO(), rec, m)
+
+	expected := map[uint16][]byte{
+		0xffee: []byte("example.org."),
+		0xffed: []byte("old"),
+		0xffeb: []byte("copied"),
+	}
+	options := rec.Msg.IsEdns0().Option
+	if len(options) != len(expected) {
+		t.Fatalf("Expected %d options, got %v", len(expected), options)
+	}
+	for _, s := range options {
+		e, ok := s.(*dns.EDNS0_LOCAL)
+		if !ok {
+			t.Errorf("Expected only local options, got %v", s)
+			continue
+		}
+		if !bytes.Equal(e.Data, expected[e.Code]) {
+			t.Errorf("Expected data %q for option 0x%x, got %q", expected[e.Code], e.Code, e.Data)
+		}
+	}
+
+	// A response without an OPT record is left alone.
+	r := &edns0ResponseRule{action: Set, code: 0xffee, option: &dns.EDNS0_LOCAL{Code: 0xffee}}
+	res := new(dns.Msg)
+	r.RewriteMsg(res)
+	if res.IsEdns0() != nil {
+		t.Errorf("Expected no OPT record to be added")
+	}
+}
+
+func TestRewriteEDNS0ResponseCopy(t *testing.T) {
+	var rules []Rule
+	for _, args := range [][]string{
+		{"continue", "edns0", "response", "local", "copy", "0xffeb"},
+		// the request option is changed in place after it's copied
+		{"continue", "edns0", "local", "set", "0xffeb", "changed"},
+	} {
+		r, err := newRule(args...)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+		rules = append(rules, r)
+	}
+
+	rw := Rewrite{
+		Next: plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.SetEdns0(4096, false)
+			w.WriteMsg(m)
+			return dns.RcodeSuccess, nil
+		}),
+		Rules: rules,
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.SetEdns0(4096, false)
+	o := m.IsEdns0()
+	o.Option = append(o.Option, &dns.EDNS0_LOCAL{Code: 0xffeb, Data: []byte("copied")})
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	rw.ServeDNS(context.TODO(), rec, m)
+
+	options := rec.Msg.IsEdns0().Option
+	if len(options) != 1 {
+		t.Fatalf("Expected 1 option, got %v", options)
+	}
+	if e, ok := options[0].(*dns.EDNS0_LOCAL); !ok || !bytes.Equal(e.Data, []byte("copied")) {
+		t.Errorf("Expected the option as it was in the request, got %v", options[0])
+	}
+}
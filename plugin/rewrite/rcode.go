@@ -0,0 +1,177 @@
+package rewrite
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+type rcodeResponseRule struct {
+	From int
+	To   int
+}
+
+// RewriteResponse leaves the records alone, the rcode is rewritten by RewriteMsg.
+func (r *rcodeResponseRule) RewriteResponse(rr dns.RR) {}
+
+func (r *rcodeResponseRule) RewriteMsg(res *dns.Msg) {
+	if res.Rcode == r.From {
+		res.Rcode = r.To
+	}
+}
+
+type rcodeRuleBase struct {
+	nextAction string
+	response   rcodeResponseRule
+}
+
+func newRcodeRuleBase(nextAction string, from, to int) rcodeRuleBase {
+	return rcodeRuleBase{
+		nextAction: nextAction,
+		response:   rcodeResponseRule{From: from, To: to},
+	}
+}
+
+func (rule *rcodeRuleBase) responseRule(match bool) (ResponseRules, Result) {
+	if match {
+		return ResponseRules{&rule.response}, RewriteDone
+	}
+	return nil, RewriteIgnored
+}
+
+// Mode returns the processing nextAction
+func (rule *rcodeRuleBase) Mode() string { return rule.nextAction }
+
+type exactRcodeRule struct {
+	rcodeRuleBase
+	From string
+}
+
+type prefixRcodeRule struct {
+	rcodeRuleBase
+	Prefix string
+}
+
+type suffixRcodeRule struct {
+	rcodeRuleBase
+	Suffix string
+}
+
+type substringRcodeRule struct {
+	rcodeRuleBase
+	Substring string
+}
+
+type regexRcodeRule struct {
+	rcodeRuleBase
+	Pattern *regexp.Regexp
+}
+
+// Rewrite rewrites the current request based upon exact match of the name
+// in the question section of the request.
+func (rule *exactRcodeRule) Rewrite(ctx context.Context, state request.Request) (ResponseRules, Result) {
+	return rule.responseRule(rule.From == state.Name())
+}
+
+// Rewrite rewrites the current request when the name begins with the matching string.
+func (rule *prefixRcodeRule) Rewrite(ctx context.Context, state request.Request) (ResponseRules, Result) {
+	return rule.responseRule(strings.HasPrefix(state.Name(), rule.Prefix))
+}
+
+// Rewrite rewrites the current request when the name ends with the matching string.
+func (rule *suffixRcodeRule) Rewrite(ctx context.Context, state request.Request) (ResponseRules, Result) {
+	return rule.responseRule(strings.HasSuffix(state.Name(), rule.Suffix))
+}
+
+// Rewrite rewrites the current request based upon partial match of the
+// name in the question section of the request.
+func (rule *substringRcodeRule) Rewrite(ctx context.Context, state request.Request) (ResponseRules, Result) {
+	return rule.responseRule(strings.Contains(state.Name(), rule.Substring))
+}
+
+// Rewrite rewrites the current request when the name in the question
+// section of the request matches a regular expression.
+func (rule *regexRcodeRule) Rewrite(ctx context.Context, state request.Request) (ResponseRules, Result) {
+	return rule.responseRule(rule.Pattern.MatchString(state.Name()))
+}
+
+// newRcodeRule creates a name matching rule based on exact, partial, or regex match,
+// that rewrites the rcode of the response.
+func newRcodeRule(nextAction string, args ...string) (Rule, error) {
+	if len(args) < 3 {
+		return nil, fmt.Errorf("too few (%d) arguments for a rcode rule", len(args))
+	}
+	if len(args) > 4 {
+		return nil, fmt.Errorf("too many (%d) arguments for a rcode rule", len(args))
+	}
+	from, valid := isValidRcode(args[len(args)-2])
+	if !valid {
+		return nil, fmt.Errorf("invalid rcode '%s' for a rcode rule", args[len(args)-2])
+	}
+	to, valid := isValidRcode(args[len(args)-1])
+	if !valid {
+		return nil, fmt.Errorf("invalid rcode '%s' for a rcode rule", args[len(args)-1])
+	}
+	if to > 0xF {
+		// An extended rcode needs an OPT record, which the response may not have.
+		return nil, fmt.Errorf("rcode '%s' is an extended rcode, a rcode rule can only rewrite to 0-15", args[len(args)-1])
+	}
+	if len(args) == 3 {
+		return &exactRcodeRule{
+			newRcodeRuleBase(nextAction, from, to),
+			plugin.Name(args[0]).Normalize(),
+		}, nil
+	}
+	switch strings.ToLower(args[0]) {
+	case ExactMatch:
+		return &exactRcodeRule{
+			newRcodeRuleBase(nextAction, from, to),
+			plugin.Name(args[1]).Normalize(),
+		}, nil
+	case PrefixMatch:
+		return &prefixRcodeRule{
+			newRcodeRuleBase(nextAction, from, to),
+			plugin.Name(args[1]).Normalize(),
+		}, nil
+	case SuffixMatch:
+		return &suffixRcodeRule{
+			newRcodeRuleBase(nextAction, from, to),
+			plugin.Name(args[1]).Normalize(),
+		}, nil
+	case SubstringMatch:
+		return &substringRcodeRule{
+			newRcodeRuleBase(nextAction, from, to),
+			plugin.Name(args[1]).Normalize(),
+		}, nil
+	case RegexMatch:
+		regexPattern, err := regexp.Compile(args[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern in a rcode rule: %s", args[1])
+		}
+		return &regexRcodeRule{
+			newRcodeRuleBase(nextAction, from, to),
+			regexPattern,
+		}, nil
+	default:
+		return nil, fmt.Errorf("rcode rule supports only exact, prefix, suffix, substring, and regex name matching")
+	}
+}
+
+// isValidRcode returns the rcode for v, which is either the name of an rcode, e.g. NXDOMAIN, or its number.
+func isValidRcode(v string) (int, bool) {
+	if rcode, ok := dns.StringToRcode[strings.ToUpper(v)]; ok {
+		return rcode, true
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 || i > 0xFFF {
+		return 0, false
+	}
+	return i, true
+}
@@ -0,0 +1,93 @@
+package rewrite
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestNewRcodeRule(t *testing.T) {
+	tests := []struct {
+		next         string
+		args         []string
+		expectedType reflect.Type
+		expectedErr  bool
+	}{
+		{"stop", []string{"srv1.coredns.rocks", "NXDOMAIN", "NOERROR"}, reflect.TypeOf(&exactRcodeRule{}), false},
+		{"stop", []string{"exact", "srv1.coredns.rocks", "nxdomain", "0"}, reflect.TypeOf(&exactRcodeRule{}), false},
+		{"stop", []string{"prefix", "srv1", "NXDOMAIN", "NOERROR"}, reflect.TypeOf(&prefixRcodeRule{}), false},
+		{"stop", []string{"suffix", "coredns.rocks", "SERVFAIL", "REFUSED"}, reflect.TypeOf(&suffixRcodeRule{}), false},
+		{"stop", []string{"substring", "coredns", "NXDOMAIN", "NOERROR"}, reflect.TypeOf(&substringRcodeRule{}), false},
+		{"stop", []string{"regex", `(srv1)\.(coredns)\.(rocks)`, "NXDOMAIN", "NOERROR"}, reflect.TypeOf(&regexRcodeRule{}), false},
+		{"stop", []string{"srv1.coredns.rocks", "NXDOMAIN"}, nil, true},
+		{"stop", []string{"srv1.coredns.rocks", "NXDOMAIN", "NOPE"}, nil, true},
+		{"stop", []string{"srv1.coredns.rocks", "-1", "NOERROR"}, nil, true},
+		{"stop", []string{"srv1.coredns.rocks", "NXDOMAIN", "BADVERS"}, nil, true},
+		{"stop", []string{"srv1.coredns.rocks", "NXDOMAIN", "16"}, nil, true},
+		{"stop", []string{"srv1.coredns.rocks", "BADCOOKIE", "15"}, reflect.TypeOf(&exactRcodeRule{}), false},
+		{"stop", []string{"foo", "srv1.coredns.rocks", "NXDOMAIN", "NOERROR"}, nil, true},
+		{"stop", []string{"regex", `(srv1\.coredns`, "NXDOMAIN", "NOERROR"}, nil, true},
+		{"stop", []string{"exact", "srv1.coredns.rocks", "NXDOMAIN", "NOERROR", "extra"}, nil, true},
+	}
+	for i, tc := range tests {
+		r, err := newRcodeRule(tc.next, tc.args...)
+		if err == nil && tc.expectedErr {
+			t.Errorf("Test %d: expected error but got success", i)
+		} else if err != nil && !tc.expectedErr {
+			t.Errorf("Test %d: expected success but got error: %s", i, err)
+		}
+		if !tc.expectedErr && reflect.TypeOf(r) != tc.expectedType {
+			t.Errorf("Test %d: expected %q but got %q", i, tc.expectedType, r)
+		}
+	}
+}
+
+func TestRcodeRewrite(t *testing.T) {
+	rules := []Rule{}
+	r, _ := newRcodeRule("continue", "suffix", ".example.org.", "NXDOMAIN", "NOERROR")
+	rules = append(rules, r)
+	r, _ = newRcodeRule("stop", "regex", `^servfail\.`, "SERVFAIL", "REFUSED")
+	rules = append(rules, r)
+
+	rw := Rewrite{
+		Next: plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+			m := new(dns.Msg)
+			m.SetRcode(r, dns.RcodeNameError)
+			if r.Question[0].Name == "servfail.example.net." {
+				m.Rcode = dns.RcodeServerFailure
+			}
+			m.Ns = []dns.RR{test.SOA("example.org. 300 IN SOA ns.example.org. admin.example.org. 1 3600 600 86400 300")}
+			w.WriteMsg(m)
+			return m.Rcode, nil
+		}),
+		Rules: rules,
+	}
+
+	tests := []struct {
+		name  string
+		rcode int
+	}{
+		{"a.example.org.", dns.RcodeSuccess},
+		{"a.example.net.", dns.RcodeNameError},
+		{"servfail.example.net.", dns.RcodeRefused},
+	}
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.name, dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		rw.ServeDNS(context.TODO(), rec, m)
+
+		if rec.Msg.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %d, got %d", i, tc.rcode, rec.Msg.Rcode)
+		}
+		if len(rec.Msg.Ns) != 1 {
+			t.Errorf("Test %d: expected the SOA record to be kept", i)
+		}
+	}
+}
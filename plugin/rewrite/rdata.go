@@ -0,0 +1,107 @@
+package rewrite
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// addressResponseRule maps the addresses of A and AAAA records in one network to the same addresses in another
+// network of the same size.
+type addressResponseRule struct {
+	from *net.IPNet
+	to   *net.IPNet
+}
+
+func (r *addressResponseRule) RewriteResponse(rr dns.RR) {
+	switch x := rr.(type) {
+	case *dns.A:
+		if ip := x.A.To4(); ip != nil {
+			x.A = r.translate(ip)
+		}
+	case *dns.AAAA:
+		x.AAAA = r.translate(x.AAAA)
+	}
+}
+
+// translate returns ip mapped to the to network, if it is in the from network, and ip otherwise.
+func (r *addressResponseRule) translate(ip net.IP) net.IP {
+	if len(ip) != len(r.from.IP) || !r.from.Contains(ip) {
+		return ip
+	}
+	mapped := make(net.IP, len(ip))
+	for i := range ip {
+		mapped[i] = r.to.IP[i] | ip[i]&^r.from.Mask[i]
+	}
+	return mapped
+}
+
+// addressRule rewrites the addresses in the responses to all requests.
+type addressRule struct {
+	nextAction string
+	response   addressResponseRule
+}
+
+// newAddressRule creates a rule that maps the addresses in one network to another network.
+func newAddressRule(nextAction string, args ...string) (Rule, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("address rules must have exactly two arguments")
+	}
+	_, from, err := net.ParseCIDR(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid network '%s' for an address rule", args[0])
+	}
+	_, to, err := net.ParseCIDR(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid network '%s' for an address rule", args[1])
+	}
+	fromOnes, fromBits := from.Mask.Size()
+	toOnes, toBits := to.Mask.Size()
+	if fromOnes != toOnes || fromBits != toBits {
+		return nil, fmt.Errorf("networks '%s' and '%s' of an address rule must be of the same family and size", args[0], args[1])
+	}
+	return &addressRule{nextAction: nextAction, response: addressResponseRule{from: from, to: to}}, nil
+}
+
+// Rewrite adds the address response rule for every request.
+func (rule *addressRule) Rewrite(ctx context.Context, state request.Request) (ResponseRules, Result) {
+	return ResponseRules{&rule.response}, RewriteDone
+}
+
+// Mode returns the processing nextAction
+func (rule *addressRule) Mode() string { return rule.nextAction }
+
+// targetRule rewrites the targets of the records in the responses to all requests, such as those of CNAME, SRV and
+// MX records.
+type targetRule struct {
+	nextAction string
+	response   valueRewriterResponseRule
+}
+
+// newTargetRule creates a rule that rewrites the targets matching a regular expression.
+func newTargetRule(nextAction string, args ...string) (Rule, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("target rules must have exactly two arguments")
+	}
+	pattern, err := isValidRegexPattern(args[0], args[1])
+	if err != nil {
+		return nil, fmt.Errorf("target rule: %s", err)
+	}
+	return &targetRule{
+		nextAction: nextAction,
+		response:   valueRewriterResponseRule{newStringRewriter(pattern, plugin.Name(args[1]).Normalize())},
+	}, nil
+}
+
+// Rewrite adds the target response rule for every request.
+func (rule *targetRule) Rewrite(ctx context.Context, state request.Request) (ResponseRules, Result) {
+	return ResponseRules{&rule.response}, RewriteDone
+}
+
+// Mode returns the processing nextAction
+func (rule *targetRule) Mode() string { return rule.nextAction }
@@ -0,0 +1,102 @@
+package rewrite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestNewAddressAndTargetRule(t *testing.T) {
+	tests := []struct {
+		args        []string
+		shouldError bool
+	}{
+		{[]string{"address", "10.1.0.0/16", "192.168.0.0/16"}, false},
+		{[]string{"continue", "address", "fd00::/64", "2001:db8::/64"}, false},
+		{[]string{"address", "10.1.0.0/16"}, true},
+		{[]string{"address", "10.1.0.0/16", "192.168.0.0/24"}, true},
+		{[]string{"address", "10.1.0.0/16", "2001:db8::/16"}, true},
+		{[]string{"address", "10.1.0.1", "192.168.0.1"}, true},
+		{[]string{"address", "10.1.0.0/16", "192.168.0.0/16", "10.2.0.0/16"}, true},
+		{[]string{"target", "(.*)\\.internal\\.", "{1}.example.org."}, false},
+		{[]string{"target", "(.*)\\.internal\\."}, true},
+		{[]string{"target", "(.*\\.internal\\.", "{1}.example.org."}, true},
+	}
+	for i, tc := range tests {
+		_, err := newRule(tc.args...)
+		if err == nil && tc.shouldError {
+			t.Errorf("Test %d: expected error but got success", i)
+		} else if err != nil && !tc.shouldError {
+			t.Errorf("Test %d: expected success but got error: %s", i, err)
+		}
+	}
+}
+
+func TestRewriteAddressAndTarget(t *testing.T) {
+	var rules []Rule
+	for _, args := range [][]string{
+		{"continue", "address", "10.1.0.0/16", "192.168.0.0/16"},
+		{"continue", "address", "fd00:1::/64", "2001:db8:1::/64"},
+		{"continue", "target", "(.*)\\.internal\\.", "{1}.example.org."},
+	} {
+		r, err := newRule(args...)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+		rules = append(rules, r)
+	}
+
+	answer := []dns.RR{
+		test.A("a.example.org. 300 IN A 10.1.2.3"),
+		test.A("a.example.org. 300 IN A 10.2.2.3"),
+		test.AAAA("a.example.org. 300 IN AAAA fd00:1::2:3"),
+		test.AAAA("a.example.org. 300 IN AAAA ::ffff:10.1.2.3"),
+		test.CNAME("b.example.org. 300 IN CNAME db.internal."),
+		test.SRV("_dns._udp.example.org. 300 IN SRV 0 0 53 ns.internal."),
+		test.MX("example.org. 300 IN MX 10 mail.internal."),
+		test.MX("example.org. 300 IN MX 20 mail.example.net."),
+	}
+	rw := Rewrite{
+		Next: plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Answer = answer
+			w.WriteMsg(m)
+			return dns.RcodeSuccess, nil
+		}),
+		Rules: rules,
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("a.example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	rw.ServeDNS(context.TODO(), rec, m)
+
+	expected := []dns.RR{
+		test.A("a.example.org. 300 IN A 192.168.2.3"),
+		test.A("a.example.org. 300 IN A 10.2.2.3"),
+		test.AAAA("a.example.org. 300 IN AAAA 2001:db8:1::2:3"),
+		test.AAAA("a.example.org. 300 IN AAAA ::ffff:10.1.2.3"),
+		test.CNAME("b.example.org. 300 IN CNAME db.example.org."),
+		test.SRV("_dns._udp.example.org. 300 IN SRV 0 0 53 ns.example.org."),
+		test.MX("example.org. 300 IN MX 10 mail.example.org."),
+		test.MX("example.org. 300 IN MX 20 mail.example.net."),
+	}
+	if len(rec.Msg.Answer) != len(expected) {
+		t.Fatalf("Expected %d records, got %d", len(expected), len(rec.Msg.Answer))
+	}
+	for i := range expected {
+		if rec.Msg.Answer[i].String() != expected[i].String() {
+			t.Errorf("Expected %s, got %s", expected[i], rec.Msg.Answer[i])
+		}
+	}
+	// The answer of the next plugin is left alone.
+	if answer[0].(*dns.A).A.String() != "10.1.2.3" {
+		t.Errorf("Expected the answer of the next plugin to be unchanged, got %s", answer[0])
+	}
+}
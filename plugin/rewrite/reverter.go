@@ -44,6 +44,12 @@ type ResponseRule interface {
 	RewriteResponse(rr dns.RR)
 }
 
+// MsgResponseRule is a ResponseRule that also rewrites the response message itself, e.g. its rcode.
+type MsgResponseRule interface {
+	ResponseRule
+	RewriteMsg(res *dns.Msg)
+}
+
 // ResponseRules describes an ordered list of response rules to apply
 // after a name rewrite
 type ResponseRules = []ResponseRule
@@ -76,6 +82,11 @@ func (r *ResponseReverter) WriteMsg(res1 *dns.Msg) error {
 		res.Question[0] = r.originalQuestion
 	}
 	if len(r.ResponseRules) > 0 {
+		for _, rule := range r.ResponseRules {
+			if mr, ok := rule.(MsgResponseRule); ok {
+				mr.RewriteMsg(res)
+			}
+		}
 		for _, rr := range res.Ns {
 			r.rewriteResourceRecord(res, rr)
 		}
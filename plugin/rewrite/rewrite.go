@@ -83,7 +83,24 @@ func newRule(args ...string) (Rule, error) {
 		return nil, fmt.Errorf("no rule type specified for rewrite")
 	}
 
+	for i := fixedArgs(args); i < len(args); i++ {
+		if isConditionKeyword(args[i]) {
+			rule, err := newRule(args[:i]...)
+			if err != nil {
+				return nil, err
+			}
+			conditions, err := newConditions(args[i+1:]...)
+			if err != nil {
+				return nil, err
+			}
+			return &conditionalRule{Rule: rule, conditions: conditions}, nil
+		}
+	}
+
 	arg0 := strings.ToLower(args[0])
+	if (arg0 == Continue || arg0 == Stop) && len(args) < 2 {
+		return nil, fmt.Errorf("no rule type specified for rewrite")
+	}
 	var ruleType string
 	var expectNumArgs, startArg int
 	mode := Stop
@@ -123,7 +140,70 @@ func newRule(args ...string) (Rule, error) {
 		return newEdns0Rule(mode, args[startArg:]...)
 	case "ttl":
 		return newTTLRule(mode, args[startArg:]...)
+	case "rcode":
+		return newRcodeRule(mode, args[startArg:]...)
+	case "address":
+		return newAddressRule(mode, args[startArg:]...)
+	case "target":
+		return newTargetRule(mode, args[startArg:]...)
 	default:
 		return nil, fmt.Errorf("invalid rule type %q", args[0])
 	}
 }
+
+// fixedArgs returns the number of arguments in args, including the mode and the rule type, that always belong to
+// the rule itself. An if or when among those is an argument of the rule, e.g. a name, and doesn't start the
+// conditions.
+func fixedArgs(args []string) int {
+	start := 0
+	if arg0 := strings.ToLower(args[0]); arg0 == Continue || arg0 == Stop {
+		start = 1
+	}
+	if start >= len(args) {
+		return len(args)
+	}
+	ruleType, args := strings.ToLower(args[start]), args[start+1:]
+	n := start + 1
+
+	switch ruleType {
+	case "name", "ttl":
+		n += 2
+		if len(args) > 0 && isMatchType(args[0]) {
+			n++
+		}
+	case "rcode":
+		n += 3
+		if len(args) > 0 && isMatchType(args[0]) {
+			n++
+		}
+	case "class", "type", "address", "target":
+		n += 2
+	case "edns0":
+		if len(args) > 0 && strings.ToLower(args[0]) == "response" {
+			n++
+			args = args[1:]
+		}
+		n += 2
+		if len(args) >= 2 {
+			option, action := strings.ToLower(args[0]), strings.ToLower(args[1])
+			switch {
+			case action == Remove || action == Copy:
+				if option == "local" {
+					n++
+				}
+			case option == "local" || option == "subnet":
+				n += 2
+			}
+		}
+	}
+	return n
+}
+
+// isMatchType returns true if arg is one of the match types of the name, ttl and rcode rules.
+func isMatchType(arg string) bool {
+	switch strings.ToLower(arg) {
+	case ExactMatch, PrefixMatch, SuffixMatch, SubstringMatch, RegexMatch:
+		return true
+	}
+	return false
+}
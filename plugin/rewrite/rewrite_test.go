@@ -110,6 +110,27 @@ func TestNewRule(t *testing.T) {
 		{[]string{"edns0", "subnet", "set", "24", "56"}, false, reflect.TypeOf(&edns0SubnetRule{})},
 		{[]string{"edns0", "subnet", "append", "24", "56"}, false, reflect.TypeOf(&edns0SubnetRule{})},
 		{[]string{"edns0", "subnet", "replace", "24", "56"}, false, reflect.TypeOf(&edns0SubnetRule{})},
+		{[]string{"edns0", "subnet", "remove"}, false, reflect.TypeOf(&edns0RemoveRule{})},
+		{[]string{"edns0", "padding", "remove"}, false, reflect.TypeOf(&edns0RemoveRule{})},
+		{[]string{"edns0", "cookie", "remove"}, false, reflect.TypeOf(&edns0RemoveRule{})},
+		{[]string{"edns0", "ede", "remove"}, false, reflect.TypeOf(&edns0RemoveRule{})},
+		{[]string{"edns0", "local", "remove", "0xffee"}, false, reflect.TypeOf(&edns0RemoveRule{})},
+		{[]string{"edns0", "local", "remove"}, true, nil},
+		{[]string{"edns0", "subnet", "remove", "24"}, true, nil},
+		{[]string{"edns0", "foo", "remove"}, true, nil},
+		{[]string{"edns0", "subnet", "copy"}, true, nil},
+		{[]string{"edns0", "response", "local", "set", "0xffee", "abcd"}, false, reflect.TypeOf(&edns0ResponseRewriteRule{})},
+		{[]string{"edns0", "response", "local", "append", "0xffee", "0x61626364"}, false, reflect.TypeOf(&edns0ResponseRewriteRule{})},
+		{[]string{"edns0", "response", "local", "replace", "0xffee", "{client_ip}"}, false, reflect.TypeOf(&edns0ResponseRewriteRule{})},
+		{[]string{"edns0", "response", "local", "set", "0xffee", "{dummy}"}, true, nil},
+		{[]string{"edns0", "response", "local", "set", "0xffee", "0xabcdefg"}, true, nil},
+		{[]string{"edns0", "response", "local", "set", "0xffee"}, true, nil},
+		{[]string{"edns0", "response", "nsid", "set"}, true, nil},
+		{[]string{"edns0", "response", "padding", "remove"}, false, reflect.TypeOf(&edns0ResponseRewriteRule{})},
+		{[]string{"edns0", "response", "ede", "copy"}, false, reflect.TypeOf(&edns0ResponseRewriteRule{})},
+		{[]string{"edns0", "response", "local", "copy", "65518"}, false, reflect.TypeOf(&edns0ResponseRewriteRule{})},
+		{[]string{"edns0", "response", "local", "foo", "65518"}, true, nil},
+		{[]string{"edns0", "response", "local"}, true, nil},
 		{[]string{"unknown-action", "name", "a.com", "b.com"}, true, nil},
 		{[]string{"stop", "name", "a.com", "b.com"}, false, reflect.TypeOf(&exactNameRule{})},
 		{[]string{"continue", "name", "a.com", "b.com"}, false, reflect.TypeOf(&exactNameRule{})},
@@ -0,0 +1,25 @@
+package rpz
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// hitCount is the number of queries that triggered a rule.
+	hitCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "rpz",
+		Name:      "hits_total",
+		Help:      "Counter of queries that triggered a policy rule.",
+	}, []string{"server", "zone", "trigger", "action"})
+	// ruleCount is the number of rules in each policy zone.
+	ruleCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "rpz",
+		Name:      "rules",
+		Help:      "The number of rules in a policy zone.",
+	}, []string{"zone"})
+)
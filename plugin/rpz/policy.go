@@ -0,0 +1,284 @@
+package rpz
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/infobloxopen/go-trees/iptree"
+	"github.com/miekg/dns"
+)
+
+// action is what is done with a query that triggers a rule.
+type action int
+
+const (
+	actionNXDOMAIN action = iota
+	actionNODATA
+	actionPassthru
+	actionDrop
+	actionTCPOnly
+	actionLocalData
+)
+
+var actionNames = [...]string{"nxdomain", "nodata", "passthru", "drop", "tcp-only", "local-data"}
+
+func (a action) String() string { return actionNames[a] }
+
+// trigger is what a rule matches on. The triggers are in order of precedence within a policy zone.
+type trigger int
+
+const (
+	triggerClientIP trigger = iota
+	triggerQNAME
+	triggerResponseIP
+	triggerNSDNAME
+	triggerNSIP
+)
+
+var triggerNames = [...]string{"client-ip", "qname", "response-ip", "nsdname", "nsip"}
+
+func (t trigger) String() string { return triggerNames[t] }
+
+// The labels that end the owner names of the triggers other than QNAME.
+const (
+	labelClientIP = "rpz-client-ip"
+	labelIP       = "rpz-ip"
+	labelNSDNAME  = "rpz-nsdname"
+	labelNSIP     = "rpz-nsip"
+)
+
+// rule is a trigger with its action, made from the records of one owner name in a policy zone.
+type rule struct {
+	owner   string // owner name in the policy zone
+	trigger trigger
+	action  action
+	data    []dns.RR   // the records for actionLocalData
+	net     *net.IPNet // the network of IP triggers
+}
+
+// policy is a compiled policy zone.
+type policy struct {
+	origin   string
+	soa      *dns.SOA
+	rules    int
+	response bool // whether there are triggers that match on the response
+
+	qname      names
+	nsdname    names
+	clientIP   *iptree.Tree
+	responseIP *iptree.Tree
+	nsIP       *iptree.Tree
+}
+
+// names holds the rules for names, and for the names below them (wildcards).
+type names struct {
+	exact    map[string]*rule
+	wildcard map[string]*rule // keyed by the name the wildcard is below
+}
+
+// match returns the rule for name: the rule for the name itself, or else the rule of the closest wildcard.
+func (n *names) match(name string) *rule {
+	if r, ok := n.exact[name]; ok {
+		return r
+	}
+	if len(n.wildcard) == 0 || name == "." {
+		return nil
+	}
+	for off := 0; ; {
+		next, end := dns.NextLabel(name, off)
+		parent := "."
+		if !end {
+			parent = name[next:]
+		}
+		if r, ok := n.wildcard[parent]; ok {
+			return r
+		}
+		if end {
+			return nil
+		}
+		off = next
+	}
+}
+
+func (n *names) add(name string, r *rule) {
+	switch {
+	case name == "*.":
+		n.wildcard["."] = r
+	case strings.HasPrefix(name, "*."):
+		n.wildcard[name[2:]] = r
+	default:
+		n.exact[name] = r
+	}
+}
+
+// matchIP returns the rule for the longest network in t that contains one of the ips.
+func matchIP(t *iptree.Tree, ips []net.IP) *rule {
+	var best *rule
+	bestLen := -1
+	for _, ip := range ips {
+		v, ok := t.GetByIP(ip)
+		if !ok {
+			continue
+		}
+		r := v.(*rule)
+		if l, _ := r.net.Mask.Size(); l > bestLen {
+			best, bestLen = r, l
+		}
+	}
+	return best
+}
+
+// newPolicy compiles the records of the policy zone origin into a policy. Records that are not valid
+// triggers are logged and skipped, as are the apex records and DNSSEC records.
+func newPolicy(origin string, soa *dns.SOA, rrs []dns.RR) *policy {
+	p := &policy{
+		origin:     origin,
+		soa:        soa,
+		qname:      names{exact: map[string]*rule{}, wildcard: map[string]*rule{}},
+		nsdname:    names{exact: map[string]*rule{}, wildcard: map[string]*rule{}},
+		clientIP:   iptree.NewTree(),
+		responseIP: iptree.NewTree(),
+		nsIP:       iptree.NewTree(),
+	}
+
+	owners := []string{}
+	byOwner := map[string][]dns.RR{}
+	for _, rr := range rrs {
+		owner := strings.ToLower(rr.Header().Name)
+		if owner == origin || !dns.IsSubDomain(origin, owner) {
+			continue
+		}
+		switch rr.Header().Rrtype {
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3, dns.TypeSOA:
+			continue
+		}
+		if _, ok := byOwner[owner]; !ok {
+			owners = append(owners, owner)
+		}
+		byOwner[owner] = append(byOwner[owner], rr)
+	}
+
+	for _, owner := range owners {
+		if err := p.add(owner, byOwner[owner]); err != nil {
+			log.Warningf("Ignoring rule %s in policy zone %s: %s", owner, origin, err)
+			continue
+		}
+		p.rules++
+	}
+	return p
+}
+
+// add adds the rule for owner, with the records rrs.
+func (p *policy) add(owner string, rrs []dns.RR) error {
+	labels := dns.SplitDomainName(owner[:len(owner)-len(p.origin)])
+	last := labels[len(labels)-1]
+
+	r := &rule{owner: owner}
+	switch last {
+	case labelClientIP, labelIP, labelNSIP:
+		n, err := parseIPTrigger(labels[:len(labels)-1])
+		if err != nil {
+			return err
+		}
+		r.net = n
+		t := p.clientIP
+		r.trigger = triggerClientIP
+		if last == labelIP {
+			t, r.trigger = p.responseIP, triggerResponseIP
+		} else if last == labelNSIP {
+			t, r.trigger = p.nsIP, triggerNSIP
+		}
+		r.action, r.data = ruleAction("", rrs)
+		t.InplaceInsertNet(n, r)
+		p.response = p.response || r.trigger != triggerClientIP
+
+	case labelNSDNAME:
+		if len(labels) == 1 {
+			return fmt.Errorf("no name in NSDNAME trigger")
+		}
+		name := strings.Join(labels[:len(labels)-1], ".") + "."
+		r.trigger = triggerNSDNAME
+		r.action, r.data = ruleAction(name, rrs)
+		p.nsdname.add(name, r)
+		p.response = true
+
+	default:
+		if strings.HasPrefix(last, "rpz-") {
+			return fmt.Errorf("unsupported trigger %s", last)
+		}
+		name := strings.Join(labels, ".") + "."
+		r.trigger = triggerQNAME
+		r.action, r.data = ruleAction(name, rrs)
+		p.qname.add(name, r)
+	}
+	return nil
+}
+
+// ruleAction returns the action encoded in the records rrs of the trigger for name, and the records for
+// actionLocalData.
+func ruleAction(name string, rrs []dns.RR) (action, []dns.RR) {
+	if len(rrs) == 1 {
+		if c, ok := rrs[0].(*dns.CNAME); ok {
+			switch t := strings.ToLower(c.Target); t {
+			case ".":
+				return actionNXDOMAIN, nil
+			case "*.":
+				return actionNODATA, nil
+			case "rpz-passthru.":
+				return actionPassthru, nil
+			case "rpz-drop.":
+				return actionDrop, nil
+			case "rpz-tcp-only.":
+				return actionTCPOnly, nil
+			default:
+				// The obsolete way to encode PASSTHRU: a CNAME to the name itself.
+				if t == name {
+					return actionPassthru, nil
+				}
+			}
+		}
+	}
+	return actionLocalData, rrs
+}
+
+// parseIPTrigger parses the labels of an IP trigger, the prefix length followed by the address in reverse, e.g.
+// 24.0.2.0.192 for 192.0.2.0/24, or 48.zz.db8.2001 for 2001:db8::/48, where zz stands for "::".
+func parseIPTrigger(labels []string) (*net.IPNet, error) {
+	if len(labels) < 2 {
+		return nil, fmt.Errorf("no address in IP trigger")
+	}
+	prefix, err := strconv.Atoi(labels[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid prefix length in IP trigger: %s", labels[0])
+	}
+
+	addr := make([]string, len(labels)-1)
+	for i, l := range labels[1:] {
+		addr[len(addr)-1-i] = l
+	}
+	s := ""
+	if len(addr) == 4 && prefix <= 32 && !strings.Contains(strings.Join(addr, ""), "zz") {
+		s = strings.Join(addr, ".")
+	} else {
+		for i := range addr {
+			if addr[i] == "zz" {
+				addr[i] = ""
+			}
+		}
+		s = strings.Join(addr, ":")
+		if addr[0] == "" {
+			s = ":" + s
+		}
+		if addr[len(addr)-1] == "" {
+			s += ":"
+		}
+	}
+
+	_, n, err := net.ParseCIDR(s + "/" + strconv.Itoa(prefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid address in IP trigger: %s", err)
+	}
+	return n, nil
+}
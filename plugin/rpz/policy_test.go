@@ -0,0 +1,116 @@
+package rpz
+
+import (
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseIPTrigger(t *testing.T) {
+	tests := []struct {
+		owner    string
+		expected string
+		err      bool
+	}{
+		{"32.1.2.0.192", "192.0.2.1/32", false},
+		{"24.0.2.0.192", "192.0.2.0/24", false},
+		{"8.0.0.0.10", "10.0.0.0/8", false},
+		{"128.1.zz.db8.2001", "2001:db8::1/128", false},
+		{"48.zz.db8.2001", "2001:db8::/48", false},
+		{"64.zz.1.0.db8.2001", "2001:db8:0:1::/64", false},
+		{"128.1.zz", "::1/128", false},
+		{"32.1.2.0", "", true},
+		{"x.1.2.0.192", "", true},
+		{"33.1.2.0.192", "", true},
+		{"32", "", true},
+	}
+
+	for i, tc := range tests {
+		n, err := parseIPTrigger(dns.SplitDomainName(tc.owner))
+		if tc.err {
+			if err == nil {
+				t.Errorf("Test %d: expected error for %s, got %s", i, tc.owner, n)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error for %s, got %s", i, tc.owner, err)
+			continue
+		}
+		if n.String() != tc.expected {
+			t.Errorf("Test %d: expected %s, got %s", i, tc.expected, n)
+		}
+	}
+}
+
+func TestPolicyMatch(t *testing.T) {
+	rrs := []dns.RR{
+		test.CNAME("example.org.rpz. IN CNAME ."),
+		test.CNAME("*.example.org.rpz. IN CNAME *."),
+		test.CNAME("*.a.example.org.rpz. IN CNAME rpz-passthru."),
+		test.CNAME("self.example.org.rpz. IN CNAME self.example.org."),
+		test.A("local.example.org.rpz. IN A 192.0.2.1"),
+		test.A("local.example.org.rpz. IN A 192.0.2.2"),
+		test.CNAME("24.0.2.0.192.rpz-ip.rpz. IN CNAME ."),
+		test.CNAME("32.1.2.0.192.rpz-ip.rpz. IN CNAME rpz-drop."),
+		test.CNAME("x.rpz-bogus.rpz. IN CNAME ."),
+		test.CNAME("rpz-ip.rpz. IN CNAME ."),
+		test.NS("rpz. IN NS ns.rpz."),
+	}
+	p := newPolicy("rpz.", test.SOA("rpz. IN SOA ns.rpz. admin.rpz. 1 3600 600 86400 60"), rrs)
+
+	if p.rules != 7 {
+		t.Errorf("Expected 7 rules, got %d", p.rules)
+	}
+	if !p.response {
+		t.Errorf("Expected the policy to have response triggers")
+	}
+
+	names := []struct {
+		qname  string
+		action action
+		owner  string
+	}{
+		{"example.org.", actionNXDOMAIN, "example.org.rpz."},
+		{"b.example.org.", actionNODATA, "*.example.org.rpz."},
+		{"a.example.org.", actionNODATA, "*.example.org.rpz."},
+		{"b.a.example.org.", actionPassthru, "*.a.example.org.rpz."},
+		{"c.b.a.example.org.", actionPassthru, "*.a.example.org.rpz."},
+		{"self.example.org.", actionPassthru, "self.example.org.rpz."},
+		{"local.example.org.", actionLocalData, "local.example.org.rpz."},
+		{"example.net.", 0, ""},
+		{"org.", 0, ""},
+	}
+	for i, tc := range names {
+		r := p.qname.match(tc.qname)
+		if tc.owner == "" {
+			if r != nil {
+				t.Errorf("Test %d: expected no match for %s, got %s", i, tc.qname, r.owner)
+			}
+			continue
+		}
+		if r == nil {
+			t.Errorf("Test %d: expected a match for %s", i, tc.qname)
+			continue
+		}
+		if r.owner != tc.owner || r.action != tc.action {
+			t.Errorf("Test %d: expected %s by %s, got %s by %s", i, tc.action, tc.owner, r.action, r.owner)
+		}
+	}
+
+	if r := p.qname.match("local.example.org."); len(r.data) != 2 {
+		t.Errorf("Expected 2 records of local data, got %d", len(r.data))
+	}
+
+	// The longest prefix wins, whatever the order of the addresses.
+	r := matchIP(p.responseIP, []net.IP{net.ParseIP("192.0.2.3"), net.ParseIP("192.0.2.1")})
+	if r == nil || r.action != actionDrop {
+		t.Errorf("Expected the /32 rule to match")
+	}
+	if r := matchIP(p.responseIP, []net.IP{net.ParseIP("192.0.3.1")}); r != nil {
+		t.Errorf("Expected no match, got %s", r.owner)
+	}
+}
@@ -0,0 +1,267 @@
+// Package rpz implements DNS response policy zones.
+package rpz
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/nonwriter"
+	"github.com/coredns/coredns/plugin/pkg/upstream"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// RPZ applies the policies of response policy zones to the queries for its zones.
+type RPZ struct {
+	Next plugin.Handler
+
+	Zones    []string
+	policies []*zone // in order of precedence
+
+	upstream *upstream.Upstream
+}
+
+// match is a rule that is triggered by a query.
+type match struct {
+	policy *policy
+	rule   *rule
+}
+
+// ServeDNS implements the plugin.Handler interface.
+func (rp *RPZ) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	if plugin.Zones(rp.Zones).Matches(state.Name()) == "" {
+		return plugin.NextOrFailure(rp.Name(), rp.Next, ctx, w, r)
+	}
+
+	policies := make([]*policy, 0, len(rp.policies))
+	for _, z := range rp.policies {
+		if p := z.get(); p != nil {
+			policies = append(policies, p)
+		}
+	}
+
+	// The client IP and QNAME triggers are checked before the query is resolved. Only the policies that
+	// take precedence over the first of these matches need to see the response.
+	var m *match
+	client := []net.IP{net.ParseIP(state.IP())}
+	for i, p := range policies {
+		if rl := matchIP(p.clientIP, client); rl != nil {
+			m = &match{p, rl}
+		} else if rl := p.qname.match(state.Name()); rl != nil {
+			m = &match{p, rl}
+		}
+		if m != nil {
+			policies = policies[:i]
+			break
+		}
+	}
+
+	response := false
+	for _, p := range policies {
+		response = response || p.response
+	}
+	if !response {
+		if m == nil {
+			return plugin.NextOrFailure(rp.Name(), rp.Next, ctx, w, r)
+		}
+		return rp.apply(ctx, w, state, m, nil)
+	}
+
+	nw := nonwriter.New(w)
+	rcode, err := plugin.NextOrFailure(rp.Name(), rp.Next, ctx, nw, r)
+	if nw.Msg == nil {
+		if m == nil {
+			return rcode, err
+		}
+		return rp.apply(ctx, w, state, m, nil)
+	}
+	for _, p := range policies {
+		if rl := p.matchResponse(nw.Msg); rl != nil {
+			m = &match{p, rl}
+			break
+		}
+	}
+	if m == nil {
+		w.WriteMsg(nw.Msg)
+		return rcode, err
+	}
+	return rp.apply(ctx, w, state, m, nw.Msg)
+}
+
+// matchResponse returns the rule triggered by the response resp, if any.
+func (p *policy) matchResponse(resp *dns.Msg) *rule {
+	if rl := matchIP(p.responseIP, addresses(resp.Answer, nil)); rl != nil {
+		return rl
+	}
+
+	servers := map[string]struct{}{}
+	for _, rr := range resp.Ns {
+		if ns, ok := rr.(*dns.NS); ok {
+			servers[strings.ToLower(ns.Ns)] = struct{}{}
+		}
+	}
+	if len(servers) == 0 {
+		return nil
+	}
+	for s := range servers {
+		if rl := p.nsdname.match(s); rl != nil {
+			return rl
+		}
+	}
+	return matchIP(p.nsIP, addresses(resp.Extra, servers))
+}
+
+// addresses returns the addresses in the A and AAAA records of rrs, only of the owners in names when that
+// isn't nil.
+func addresses(rrs []dns.RR, names map[string]struct{}) []net.IP {
+	ips := []net.IP{}
+	for _, rr := range rrs {
+		if names != nil {
+			if _, ok := names[strings.ToLower(rr.Header().Name)]; !ok {
+				continue
+			}
+		}
+		switch x := rr.(type) {
+		case *dns.A:
+			ips = append(ips, x.A)
+		case *dns.AAAA:
+			ips = append(ips, x.AAAA)
+		}
+	}
+	return ips
+}
+
+// apply carries out the action of the matched rule. The response resp is the resolved answer to the
+// query, if the query has been resolved.
+func (rp *RPZ) apply(ctx context.Context, w dns.ResponseWriter, state request.Request, m *match, resp *dns.Msg) (int, error) {
+	act := m.rule.action
+	if act == actionTCPOnly && state.Proto() == "tcp" {
+		act = actionPassthru
+	}
+	rp.report(ctx, state, m, act)
+
+	switch act {
+	case actionPassthru:
+		if resp != nil {
+			w.WriteMsg(resp)
+			return dns.RcodeSuccess, nil
+		}
+		return plugin.NextOrFailure(rp.Name(), rp.Next, ctx, w, state.Req)
+
+	case actionDrop:
+		return dns.RcodeSuccess, nil
+
+	case actionTCPOnly:
+		a := new(dns.Msg)
+		a.SetReply(state.Req)
+		a.Truncated = true
+		a.RecursionAvailable = true
+		w.WriteMsg(a)
+		return dns.RcodeSuccess, nil
+	}
+
+	a := new(dns.Msg)
+	a.SetReply(state.Req)
+	a.RecursionAvailable = true
+
+	switch act {
+	case actionNXDOMAIN:
+		a.Rcode = dns.RcodeNameError
+		a.Ns = []dns.RR{m.policy.soa}
+	case actionNODATA:
+		a.Ns = []dns.RR{m.policy.soa}
+	case actionLocalData:
+		a.Answer = rp.localData(ctx, state, m.rule)
+		if len(a.Answer) == 0 {
+			a.Ns = []dns.RR{m.policy.soa}
+		}
+	}
+	w.WriteMsg(a)
+	return dns.RcodeSuccess, nil
+}
+
+// localData returns the answer made from the records of rl. A CNAME is followed to the records it points to.
+func (rp *RPZ) localData(ctx context.Context, state request.Request, rl *rule) []dns.RR {
+	qname, qtype := state.QName(), state.QType()
+	answer := []dns.RR{}
+	for _, rr := range rl.data {
+		if rr.Header().Rrtype != qtype && rr.Header().Rrtype != dns.TypeCNAME {
+			continue
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Name = qname
+		if c, ok := rr.(*dns.CNAME); ok {
+			if qtype != dns.TypeCNAME {
+				answer = []dns.RR{c}
+				// A wildcard target prepends the query name, e.g. *.garden. becomes <qname>.garden.
+				if strings.HasPrefix(c.Target, "*.") {
+					c.Target = qname + c.Target[2:]
+				}
+				if resp, err := rp.upstream.Lookup(ctx, state, c.Target, qtype); err == nil {
+					answer = append(answer, resp.Answer...)
+				}
+				return answer
+			}
+		}
+		answer = append(answer, rr)
+	}
+	return answer
+}
+
+// report reports the match m through metadata, the log and metrics.
+func (rp *RPZ) report(ctx context.Context, state request.Request, m *match, act action) {
+	if h, ok := ctx.Value(hitKey{}).(*hit); ok {
+		h.match, h.action = m, act
+	}
+	hitCount.WithLabelValues(metrics.WithServer(ctx), m.policy.origin, m.rule.trigger.String(), act.String()).Inc()
+	log.Infof("%s %s from %s: %s by %s rule %s", state.Name(), state.Type(), state.IP(), act, m.rule.trigger, m.rule.owner)
+}
+
+// hit holds the match of a query, for the metadata.
+type hit struct {
+	match  *match
+	action action
+}
+
+type hitKey struct{}
+
+// Metadata implements the metadata.Provider interface.
+func (rp *RPZ) Metadata(ctx context.Context, state request.Request) context.Context {
+	h := &hit{}
+	ctx = context.WithValue(ctx, hitKey{}, h)
+
+	metadata.SetValueFunc(ctx, "rpz/zone", func() string {
+		if h.match == nil {
+			return ""
+		}
+		return h.match.policy.origin
+	})
+	metadata.SetValueFunc(ctx, "rpz/trigger", func() string {
+		if h.match == nil {
+			return ""
+		}
+		return h.match.rule.trigger.String()
+	})
+	metadata.SetValueFunc(ctx, "rpz/action", func() string {
+		if h.match == nil {
+			return ""
+		}
+		return h.action.String()
+	})
+	metadata.SetValueFunc(ctx, "rpz/rule", func() string {
+		if h.match == nil {
+			return ""
+		}
+		return h.match.rule.owner
+	})
+	return ctx
+}
+
+// Name implements the Handler interface.
+func (rp *RPZ) Name() string { return "rpz" }
@@ -0,0 +1,271 @@
+package rpz
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/upstream"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+const first = `$ORIGIN first.rpz.
+$TTL 60
+@	3600	IN	SOA	ns.first.rpz. admin.first.rpz. 1 3600 600 86400 60
+@	3600	IN	NS	ns.first.rpz.
+
+nxdomain.example.org	CNAME	.
+*.wild.example.org	CNAME	*.
+drop.example.org	CNAME	rpz-drop.
+tcp.example.org		CNAME	rpz-tcp-only.
+pass.example.org	CNAME	rpz-passthru.
+local.example.org	A	192.0.2.1
+local.example.org	TXT	"blocked"
+garden.example.org	CNAME	*.walled.garden.
+
+32.66.2.0.192.rpz-ip	CNAME	.
+ns.evil.net.rpz-nsdname	CNAME	.
+24.0.113.51.198.rpz-nsip	CNAME	.
+`
+
+const second = `$ORIGIN second.rpz.
+$TTL 60
+@	3600	IN	SOA	ns.second.rpz. admin.second.rpz. 1 3600 600 86400 60
+
+pass.example.org	CNAME	.
+other.example.org	CNAME	.
+broken.example.org	CNAME	rpz-passthru.
+`
+
+// writeZone writes the zone text to a file in a temporary directory and returns the zone read from it.
+func writeZone(t *testing.T, origin, text string) *zone {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), origin)
+	if err := os.WriteFile(name, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	z := newZone(origin)
+	z.file = name
+	if err := z.readFile(); err != nil {
+		t.Fatal(err)
+	}
+	return z
+}
+
+// backend answers A queries with the address 192.0.2.66 for evil-ip.example.org. and 192.0.2.1 for
+// everything else. Names in nsevil.example.org. get a referral style answer with ns.evil.net., and names
+// in nsip.example.org. one with a name server in 198.51.113.0/24. broken.example.org. gets a SERVFAIL.
+func backend() test.Handler {
+	return test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		state := request.Request{W: w, Req: r}
+		m := new(dns.Msg)
+		m.SetReply(r)
+		switch {
+		case state.Name() == "broken.example.org.":
+			m.Rcode = dns.RcodeServerFailure
+		case state.Name() == "evil-ip.example.org.":
+			m.Answer = []dns.RR{test.A(state.Name() + " 300 IN A 192.0.2.66")}
+		case dns.IsSubDomain("nsevil.example.org.", state.Name()):
+			m.Answer = []dns.RR{test.A(state.Name() + " 300 IN A 192.0.2.1")}
+			m.Ns = []dns.RR{test.NS("nsevil.example.org. 300 IN NS ns.evil.net.")}
+		case dns.IsSubDomain("nsip.example.org.", state.Name()):
+			m.Answer = []dns.RR{test.A(state.Name() + " 300 IN A 192.0.2.1")}
+			m.Ns = []dns.RR{test.NS("nsip.example.org. 300 IN NS ns.nsip.example.org.")}
+			m.Extra = []dns.RR{test.A("ns.nsip.example.org. 300 IN A 198.51.113.1")}
+		default:
+			m.Answer = []dns.RR{test.A(state.Name() + " 300 IN A 192.0.2.1")}
+		}
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+}
+
+func newTestRPZ(t *testing.T) *RPZ {
+	return &RPZ{
+		Next:     backend(),
+		Zones:    []string{"."},
+		policies: []*zone{writeZone(t, "first.rpz.", first), writeZone(t, "second.rpz.", second)},
+		upstream: upstream.New(),
+	}
+}
+
+func TestRPZ(t *testing.T) {
+	rp := newTestRPZ(t)
+
+	tests := []struct {
+		qname  string
+		qtype  uint16
+		tcp    bool
+		rcode  int
+		answer []dns.RR
+		ns     []dns.RR
+		tc     bool
+	}{
+		{qname: "example.org.", qtype: dns.TypeA, rcode: dns.RcodeSuccess,
+			answer: []dns.RR{test.A("example.org. 300 IN A 192.0.2.1")}},
+		{qname: "nxdomain.example.org.", qtype: dns.TypeA, rcode: dns.RcodeNameError,
+			ns: []dns.RR{test.SOA("first.rpz. 3600 IN SOA ns.first.rpz. admin.first.rpz. 1 3600 600 86400 60")}},
+		{qname: "a.b.wild.example.org.", qtype: dns.TypeA, rcode: dns.RcodeSuccess,
+			ns: []dns.RR{test.SOA("first.rpz. 3600 IN SOA ns.first.rpz. admin.first.rpz. 1 3600 600 86400 60")}},
+		{qname: "wild.example.org.", qtype: dns.TypeA, rcode: dns.RcodeSuccess,
+			answer: []dns.RR{test.A("wild.example.org. 300 IN A 192.0.2.1")}},
+		{qname: "tcp.example.org.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, tc: true},
+		{qname: "tcp.example.org.", qtype: dns.TypeA, tcp: true, rcode: dns.RcodeSuccess,
+			answer: []dns.RR{test.A("tcp.example.org. 300 IN A 192.0.2.1")}},
+		// The first policy zone takes precedence.
+		{qname: "pass.example.org.", qtype: dns.TypeA, rcode: dns.RcodeSuccess,
+			answer: []dns.RR{test.A("pass.example.org. 300 IN A 192.0.2.1")}},
+		{qname: "other.example.org.", qtype: dns.TypeA, rcode: dns.RcodeNameError,
+			ns: []dns.RR{test.SOA("second.rpz. 3600 IN SOA ns.second.rpz. admin.second.rpz. 1 3600 600 86400 60")}},
+		{qname: "local.example.org.", qtype: dns.TypeA, rcode: dns.RcodeSuccess,
+			answer: []dns.RR{test.A("local.example.org. 60 IN A 192.0.2.1")}},
+		{qname: "local.example.org.", qtype: dns.TypeTXT, rcode: dns.RcodeSuccess,
+			answer: []dns.RR{test.TXT(`local.example.org. 60 IN TXT "blocked"`)}},
+		{qname: "local.example.org.", qtype: dns.TypeMX, rcode: dns.RcodeSuccess,
+			ns: []dns.RR{test.SOA("first.rpz. 3600 IN SOA ns.first.rpz. admin.first.rpz. 1 3600 600 86400 60")}},
+		{qname: "garden.example.org.", qtype: dns.TypeA, rcode: dns.RcodeSuccess,
+			answer: []dns.RR{test.CNAME("garden.example.org. 60 IN CNAME garden.example.org.walled.garden.")}},
+		{qname: "evil-ip.example.org.", qtype: dns.TypeA, rcode: dns.RcodeNameError,
+			ns: []dns.RR{test.SOA("first.rpz. 3600 IN SOA ns.first.rpz. admin.first.rpz. 1 3600 600 86400 60")}},
+		{qname: "www.nsevil.example.org.", qtype: dns.TypeA, rcode: dns.RcodeNameError,
+			ns: []dns.RR{test.SOA("first.rpz. 3600 IN SOA ns.first.rpz. admin.first.rpz. 1 3600 600 86400 60")}},
+		{qname: "www.nsip.example.org.", qtype: dns.TypeA, rcode: dns.RcodeNameError,
+			ns: []dns.RR{test.SOA("first.rpz. 3600 IN SOA ns.first.rpz. admin.first.rpz. 1 3600 600 86400 60")}},
+	}
+
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, tc.qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{TCP: tc.tcp})
+		if _, err := rp.ServeDNS(context.Background(), rec, m); err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		if rec.Msg == nil {
+			t.Fatalf("Test %d: expected a reply", i)
+		}
+		if rec.Msg.Truncated != tc.tc {
+			t.Errorf("Test %d: expected TC bit %t, got %t", i, tc.tc, rec.Msg.Truncated)
+		}
+		if err := test.SortAndCheck(rec.Msg, test.Case{Qname: tc.qname, Qtype: tc.qtype, Rcode: tc.rcode, Answer: tc.answer, Ns: tc.ns}); err != nil {
+			t.Errorf("Test %d: %s", i, err)
+		}
+	}
+}
+
+func TestRPZDrop(t *testing.T) {
+	rp := newTestRPZ(t)
+
+	m := new(dns.Msg)
+	m.SetQuestion("drop.example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	rcode, err := rp.ServeDNS(context.Background(), rec, m)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if rec.Msg != nil {
+		t.Errorf("Expected no reply, got %s", rec.Msg)
+	}
+	if !plugin.ClientWrite(rcode) {
+		t.Errorf("Expected the server to not reply, got rcode %d", rcode)
+	}
+}
+
+func TestRPZPassthruServfail(t *testing.T) {
+	rp := newTestRPZ(t)
+
+	// The response triggers of the first policy make the query resolve before the passthru rule of the second
+	// policy is applied.
+	m := new(dns.Msg)
+	m.SetQuestion("broken.example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	rcode, err := rp.ServeDNS(context.Background(), rec, m)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if rec.Msg == nil || rec.Msg.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("Expected the SERVFAIL of the upstream answer, got %v", rec.Msg)
+	}
+	if !plugin.ClientWrite(rcode) {
+		t.Errorf("Expected the server to not write a second reply, got rcode %d", rcode)
+	}
+}
+
+func TestRPZClientIP(t *testing.T) {
+	rp := &RPZ{
+		Next:  backend(),
+		Zones: []string{"example.org."},
+		policies: []*zone{writeZone(t, "client.rpz.", `$ORIGIN client.rpz.
+$TTL 60
+@	IN	SOA	ns.client.rpz. admin.client.rpz. 1 3600 600 86400 60
+16.0.0.240.10.rpz-client-ip	CNAME	.
+32.1.0.240.10.rpz-client-ip	CNAME	rpz-passthru.
+64.zz.1.0.db8.2001.rpz-client-ip	CNAME	.
+`)},
+		upstream: upstream.New(),
+	}
+
+	tests := []struct {
+		qname string
+		ip    string
+		rcode int
+	}{
+		{"example.org.", "10.240.0.1", dns.RcodeSuccess},
+		{"example.org.", "10.240.0.2", dns.RcodeNameError},
+		{"example.org.", "10.241.0.2", dns.RcodeSuccess},
+		{"example.org.", "2001:db8:0:1::53", dns.RcodeNameError},
+		{"example.org.", "2001:db8:0:2::53", dns.RcodeSuccess},
+		{"example.net.", "10.240.0.2", dns.RcodeSuccess}, // not in the zones of the plugin
+	}
+
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{RemoteIP: tc.ip})
+		if _, err := rp.ServeDNS(context.Background(), rec, m); err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		if rec.Msg.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %d, got %d", i, tc.rcode, rec.Msg.Rcode)
+		}
+	}
+}
+
+func TestRPZMetadata(t *testing.T) {
+	rp := newTestRPZ(t)
+
+	m := new(dns.Msg)
+	m.SetQuestion("other.example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	ctx := metadata.ContextWithMetadata(context.Background())
+	ctx = rp.Metadata(ctx, request.Request{W: rec, Req: m})
+
+	if f := metadata.ValueFunc(ctx, "rpz/action"); f() != "" {
+		t.Errorf("Expected no action before the query is handled, got %q", f())
+	}
+	if _, err := rp.ServeDNS(ctx, rec, m); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	expected := map[string]string{
+		"rpz/zone":    "second.rpz.",
+		"rpz/trigger": "qname",
+		"rpz/action":  "nxdomain",
+		"rpz/rule":    "other.example.org.second.rpz.",
+	}
+	for label, value := range expected {
+		f := metadata.ValueFunc(ctx, label)
+		if f == nil {
+			t.Fatalf("Expected metadata label %q", label)
+		}
+		if f() != value {
+			t.Errorf("Expected %q for %q, got %q", value, label, f())
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package rpz
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/plugin/pkg/parse"
+	"github.com/coredns/coredns/plugin/pkg/upstream"
+)
+
+var log = clog.NewWithPlugin("rpz")
+
+func init() { plugin.Register("rpz", setup) }
+
+func setup(c *caddy.Controller) error {
+	rp, err := parseRPZ(c)
+	if err != nil {
+		return plugin.Error("rpz", err)
+	}
+
+	for _, z := range rp.policies {
+		z := z
+		c.OnStartup(z.Load)
+		c.OnShutdown(z.OnShutdown)
+	}
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		rp.Next = next
+		return rp
+	})
+
+	return nil
+}
+
+func parseRPZ(c *caddy.Controller) (*RPZ, error) {
+	rp := &RPZ{upstream: upstream.New()}
+	config := dnsserver.GetConfig(c)
+
+	i := 0
+	for c.Next() {
+		if i > 0 {
+			return nil, plugin.ErrOnce
+		}
+		i++
+
+		rp.Zones = plugin.OriginsFromArgsOrServerBlock(c.RemainingArgs(), c.ServerBlockKeys)
+
+		origins := map[string]struct{}{}
+		for c.NextBlock() {
+			switch c.Val() {
+			case "file":
+				// file ORIGIN FILE [RELOAD]
+				args := c.RemainingArgs()
+				if len(args) < 2 || len(args) > 3 {
+					return nil, c.ArgErr()
+				}
+				z := newZone(plugin.Host(args[0]).NormalizeExact()[0])
+				z.file = args[1]
+				if !filepath.IsAbs(z.file) && config.Root != "" {
+					z.file = filepath.Join(config.Root, z.file)
+				}
+				if len(args) == 3 {
+					d, err := time.ParseDuration(args[2])
+					if err != nil {
+						return nil, c.Errf("invalid reload interval %q: %s", args[2], err)
+					}
+					if d < 0 {
+						return nil, c.Errf("invalid negative reload interval %q", args[2])
+					}
+					z.reload = d
+				}
+				rp.policies = append(rp.policies, z)
+
+			case "transfer":
+				// transfer ORIGIN from ADDRESS...
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				z := newZone(plugin.Host(c.Val()).NormalizeExact()[0])
+				from, err := parse.TransferIn(c)
+				if err != nil {
+					return nil, err
+				}
+				z.from = from
+				rp.policies = append(rp.policies, z)
+
+			default:
+				return nil, c.Errf("unknown property '%s'", c.Val())
+			}
+
+			origin := rp.policies[len(rp.policies)-1].origin
+			if _, ok := origins[origin]; ok {
+				return nil, c.Errf("policy zone %s defined more than once", origin)
+			}
+			origins[origin] = struct{}{}
+		}
+	}
+	if len(rp.policies) == 0 {
+		return nil, c.Err("no policy zones defined")
+	}
+	return rp, nil
+}
@@ -0,0 +1,106 @@
+package rpz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		zones     []string
+		origins   []string
+		reload    time.Duration
+		from      []string
+	}{
+		{`rpz {
+			file rpz.local db.rpz
+		}`, false, nil, []string{"rpz.local."}, 0, nil},
+		{`rpz example.org {
+			file rpz.local db.rpz 1m
+			transfer rpz.example.net from 10.0.0.1
+		}`, false, []string{"example.org."}, []string{"rpz.local.", "rpz.example.net."}, time.Minute, []string{"10.0.0.1:53"}},
+		{`rpz {
+			transfer rpz.example.net from 10.0.0.1 10.0.0.2:5353
+		}`, false, nil, []string{"rpz.example.net."}, 0, []string{"10.0.0.1:53", "10.0.0.2:5353"}},
+		{`rpz {
+			file RPZ.Local db.rpz
+			transfer Rpz.Example.Net from 10.0.0.1
+		}`, false, nil, []string{"rpz.local.", "rpz.example.net."}, 0, []string{"10.0.0.1:53"}},
+		// fails
+		{`rpz`, true, nil, nil, 0, nil},
+		{`rpz {
+			file rpz.local
+		}`, true, nil, nil, 0, nil},
+		{`rpz {
+			file rpz.local db.rpz 1x
+		}`, true, nil, nil, 0, nil},
+		{`rpz {
+			file rpz.local db.rpz -1m
+		}`, true, nil, nil, 0, nil},
+		{`rpz {
+			transfer rpz.local
+		}`, true, nil, nil, 0, nil},
+		{`rpz {
+			transfer rpz.local to 10.0.0.1
+		}`, true, nil, nil, 0, nil},
+		{`rpz {
+			file rpz.local db.rpz
+			transfer rpz.local from 10.0.0.1
+		}`, true, nil, nil, 0, nil},
+		{`rpz {
+			blah
+		}`, true, nil, nil, 0, nil},
+		{`rpz {
+			file rpz.local db.rpz
+		}
+		rpz {
+			file rpz.other db.other
+		}`, true, nil, nil, 0, nil},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		rp, err := parseRPZ(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+
+		if len(rp.Zones) != len(test.zones) {
+			t.Errorf("Test %d: expected zones %v, got %v", i, test.zones, rp.Zones)
+		}
+		for j, zone := range test.zones {
+			if rp.Zones[j] != zone {
+				t.Errorf("Test %d: expected zone %s, got %s", i, zone, rp.Zones[j])
+			}
+		}
+		if len(rp.policies) != len(test.origins) {
+			t.Fatalf("Test %d: expected %d policy zones, got %d", i, len(test.origins), len(rp.policies))
+		}
+		for j, z := range rp.policies {
+			if z.origin != test.origins[j] {
+				t.Errorf("Test %d: expected policy zone %s, got %s", i, test.origins[j], z.origin)
+			}
+			if z.file != "" && z.reload != test.reload {
+				t.Errorf("Test %d: expected reload %s, got %s", i, test.reload, z.reload)
+			}
+			if z.file == "" {
+				for k, f := range test.from {
+					if z.from[k] != f {
+						t.Errorf("Test %d: expected transfer from %s, got %s", i, f, z.from[k])
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package rpz
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin/file"
+
+	"github.com/miekg/dns"
+)
+
+// zone is a policy zone. It is read from a file, or transferred from primaries, and kept up to date.
+type zone struct {
+	origin string
+
+	file   string
+	reload time.Duration
+
+	from []string // primaries to transfer the zone from
+
+	sync.RWMutex
+	policy  *policy
+	records map[string]dns.RR // the records of a transferred zone, to apply IXFRs to
+	loaded  time.Time         // when the zone was last loaded or found to be up to date
+	expired bool
+
+	shutdown chan struct{}
+	stop     sync.Once
+}
+
+func newZone(origin string) *zone {
+	return &zone{origin: origin, shutdown: make(chan struct{})}
+}
+
+// get returns the policy of z, or nil if it has not been loaded yet or has expired.
+func (z *zone) get() *policy {
+	z.RLock()
+	defer z.RUnlock()
+	if z.expired {
+		return nil
+	}
+	return z.policy
+}
+
+// current returns the policy of z, also when it has expired.
+func (z *zone) current() (*policy, time.Time) {
+	z.RLock()
+	defer z.RUnlock()
+	return z.policy, z.loaded
+}
+
+func (z *zone) set(p *policy) {
+	z.Lock()
+	z.policy = p
+	z.loaded = time.Now()
+	z.expired = false
+	z.Unlock()
+	ruleCount.WithLabelValues(z.origin).Set(float64(p.rules))
+	log.Infof("Loaded policy zone %s with %d rules and %d SOA serial", z.origin, p.rules, p.soa.Serial)
+}
+
+// serial returns the SOA serial of the policy, or -1 if there is none.
+func (z *zone) serial() int64 {
+	p, _ := z.current()
+	if p == nil {
+		return -1
+	}
+	return int64(p.soa.Serial)
+}
+
+// readFile reads the zone from its file. It leaves the policy alone when the SOA serial is unchanged.
+func (z *zone) readFile() error {
+	reader, err := os.Open(z.file)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	fz, err := file.Parse(reader, z.origin, z.file, -1)
+	if err != nil {
+		return err
+	}
+	if serial := z.serial(); serial >= 0 && fz.Apex.SOA.Serial == uint32(serial) {
+		return nil
+	}
+
+	rrs := []dns.RR{}
+	for _, e := range fz.Tree.All() {
+		rrs = append(rrs, e.All()...)
+	}
+	z.set(newPolicy(z.origin, fz.Apex.SOA, rrs))
+	return nil
+}
+
+// transfer retrieves the zone from the primaries: incrementally when there is a policy to start from,
+// falling back to a full transfer.
+func (z *zone) transfer() error {
+	var err error
+	for _, tr := range z.from {
+		if z.serial() >= 0 {
+			if err = z.ixfr(tr); err == nil {
+				return nil
+			}
+			log.Warningf("Failed to incrementally transfer %s from %s, trying AXFR: %s", z.origin, tr, err)
+		}
+		if err = z.axfr(tr); err == nil {
+			return nil
+		}
+		log.Warningf("Failed to transfer %s from %s: %s", z.origin, tr, err)
+	}
+	return err
+}
+
+func (z *zone) axfr(tr string) error {
+	m := new(dns.Msg)
+	m.SetAxfr(z.origin)
+	rrs, err := transferIn(m, tr)
+	if err != nil {
+		return err
+	}
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok || len(rrs) < 2 {
+		return fmt.Errorf("no SOA at the start of the transfer")
+	}
+	records := map[string]dns.RR{}
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		records[key(rr)] = rr
+	}
+	z.apply(soa, records)
+	return nil
+}
+
+// ixfr applies an incremental zone transfer (RFC 1995). A server may reply with the full zone instead, or
+// with only the SOA when the zone is up to date.
+func (z *zone) ixfr(tr string) error {
+	p, _ := z.current()
+	m := new(dns.Msg)
+	m.SetIxfr(z.origin, p.soa.Serial, p.soa.Ns, p.soa.Mbox)
+	rrs, err := transferIn(m, tr)
+	if err != nil {
+		return err
+	}
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return fmt.Errorf("no SOA at the start of the transfer")
+	}
+	if len(rrs) == 1 {
+		if soa.Serial != p.soa.Serial {
+			return fmt.Errorf("SOA serial %d differs from %d without changes", soa.Serial, p.soa.Serial)
+		}
+		z.Lock()
+		z.loaded = time.Now()
+		z.expired = false
+		z.Unlock()
+		return nil
+	}
+
+	if _, ok := rrs[1].(*dns.SOA); !ok {
+		// A full transfer.
+		records := map[string]dns.RR{}
+		for _, rr := range rrs[1 : len(rrs)-1] {
+			records[key(rr)] = rr
+		}
+		z.apply(soa, records)
+		return nil
+	}
+
+	z.RLock()
+	records := make(map[string]dns.RR, len(z.records))
+	for k, rr := range z.records {
+		records[k] = rr
+	}
+	z.RUnlock()
+
+	// Each difference sequence is the old SOA followed by the deleted records, and the new SOA followed
+	// by the added ones.
+	add := true
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		if _, ok := rr.(*dns.SOA); ok {
+			add = !add
+			continue
+		}
+		if add {
+			records[key(rr)] = rr
+		} else {
+			delete(records, key(rr))
+		}
+	}
+	z.apply(soa, records)
+	return nil
+}
+
+// apply sets the records of a transfer as the policy of z.
+func (z *zone) apply(soa *dns.SOA, records map[string]dns.RR) {
+	rrs := make([]dns.RR, 0, len(records))
+	for _, rr := range records {
+		rrs = append(rrs, rr)
+	}
+	z.Lock()
+	z.records = records
+	z.Unlock()
+	z.set(newPolicy(z.origin, soa, rrs))
+}
+
+// key returns the key of rr in the records of a zone: the record without its TTL.
+func key(rr dns.RR) string {
+	rr = dns.Copy(rr)
+	rr.Header().Ttl = 0
+	return rr.String()
+}
+
+func transferIn(m *dns.Msg, tr string) ([]dns.RR, error) {
+	t := new(dns.Transfer)
+	c, err := t.In(m, tr)
+	if err != nil {
+		return nil, err
+	}
+	rrs := []dns.RR{}
+	for env := range c {
+		if env.Error != nil {
+			return nil, env.Error
+		}
+		rrs = append(rrs, env.RR...)
+	}
+	if len(rrs) == 0 {
+		return nil, fmt.Errorf("empty transfer")
+	}
+	return rrs, nil
+}
+
+// Load loads the zone for the first time and keeps it up to date until shutdown. A transferred zone is
+// retried until it is loaded, without blocking the start up of the server.
+func (z *zone) Load() error {
+	if z.file != "" {
+		if err := z.readFile(); err != nil {
+			return fmt.Errorf("failed to load policy zone %s: %s", z.origin, err)
+		}
+		go z.update()
+		return nil
+	}
+
+	go func() {
+		dur := 250 * time.Millisecond
+		for {
+			err := z.transfer()
+			if err == nil {
+				break
+			}
+			log.Warningf("All %s primaries failed to transfer, retrying in %s: %s", z.origin, dur, err)
+			select {
+			case <-time.After(dur):
+			case <-z.shutdown:
+				return
+			}
+			if dur *= 2; dur > 10*time.Second {
+				dur = 10 * time.Second
+			}
+		}
+		z.update()
+	}()
+	return nil
+}
+
+// update rereads a file every reload interval. A transferred zone is refreshed according to its SOA, and
+// is no longer used when it could not be refreshed before it expired.
+func (z *zone) update() {
+	if z.file != "" {
+		if z.reload == 0 {
+			return
+		}
+		tick := time.NewTicker(z.reload)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				if err := z.readFile(); err != nil {
+					log.Errorf("Failed to reload policy zone %s: %s", z.origin, err)
+				}
+			case <-z.shutdown:
+				return
+			}
+		}
+	}
+
+	for {
+		p, loaded := z.current()
+		wait := time.Duration(p.soa.Refresh)*time.Second - time.Since(loaded)
+		if wait <= 0 {
+			wait = time.Duration(p.soa.Retry) * time.Second
+		}
+		wait += time.Duration(rand.Intn(2000)) * time.Millisecond
+
+		select {
+		case <-time.After(wait):
+		case <-z.shutdown:
+			return
+		}
+
+		if err := z.transfer(); err != nil {
+			p, loaded := z.current()
+			if time.Since(loaded) > time.Duration(p.soa.Expire)*time.Second && z.get() != nil {
+				log.Errorf("Policy zone %s expired", z.origin)
+				z.Lock()
+				z.expired = true
+				z.Unlock()
+				ruleCount.WithLabelValues(z.origin).Set(0)
+			}
+		}
+	}
+}
+
+// OnShutdown stops updating the zone. It may be called more than once.
+func (z *zone) OnShutdown() error {
+	z.stop.Do(func() { close(z.shutdown) })
+	return nil
+}
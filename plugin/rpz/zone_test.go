@@ -0,0 +1,170 @@
+package rpz
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// primary serves the policy zone xfr.rpz. in two versions: serial 1 blocks a. and b., serial 2 blocks a. and c.
+type primary struct {
+	serial uint32
+	ixfr   int // number of IXFR requests seen
+}
+
+func (p *primary) soa(serial uint32) dns.RR {
+	return test.SOA(fmt.Sprintf("xfr.rpz. 60 IN SOA ns.xfr.rpz. admin.xfr.rpz. %d 3600 600 86400 60", serial))
+}
+
+func (p *primary) Handler(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	switch r.Question[0].Qtype {
+	case dns.TypeAXFR:
+		m.Answer = []dns.RR{p.soa(p.serial), test.CNAME("a.xfr.rpz. 60 IN CNAME .")}
+		if p.serial == 1 {
+			m.Answer = append(m.Answer, test.CNAME("b.xfr.rpz. 60 IN CNAME ."))
+		} else {
+			m.Answer = append(m.Answer, test.CNAME("c.xfr.rpz. 60 IN CNAME ."))
+		}
+		m.Answer = append(m.Answer, p.soa(p.serial))
+	case dns.TypeIXFR:
+		p.ixfr++
+		have := r.Ns[0].(*dns.SOA).Serial
+		if have == p.serial {
+			m.Answer = []dns.RR{p.soa(p.serial)}
+			break
+		}
+		m.Answer = []dns.RR{
+			p.soa(2),
+			p.soa(1), test.CNAME("b.xfr.rpz. 60 IN CNAME ."),
+			p.soa(2), test.CNAME("c.xfr.rpz. 60 IN CNAME ."),
+			p.soa(2),
+		}
+	}
+	w.WriteMsg(m)
+}
+
+func TestZoneTransfer(t *testing.T) {
+	p := &primary{serial: 1}
+	s := dnstest.NewServer(p.Handler)
+	defer s.Close()
+
+	z := newZone("xfr.rpz.")
+	z.from = []string{s.Addr}
+
+	if err := z.transfer(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if p.ixfr != 0 {
+		t.Errorf("Expected the first transfer to be an AXFR")
+	}
+	pol := z.get()
+	if pol.rules != 2 || pol.qname.match("b.") == nil || pol.qname.match("c.") != nil {
+		t.Fatalf("Expected the rules for a. and b. after the AXFR")
+	}
+
+	// Up to date.
+	if err := z.transfer(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if z.get() != pol {
+		t.Errorf("Expected the policy to stay the same")
+	}
+
+	p.serial = 2
+	if err := z.transfer(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if p.ixfr != 2 {
+		t.Errorf("Expected 2 IXFR requests, got %d", p.ixfr)
+	}
+	pol = z.get()
+	if pol.soa.Serial != 2 {
+		t.Errorf("Expected SOA serial 2, got %d", pol.soa.Serial)
+	}
+	if pol.rules != 2 || pol.qname.match("a.") == nil || pol.qname.match("b.") != nil || pol.qname.match("c.") == nil {
+		t.Errorf("Expected the rules for a. and c. after the IXFR")
+	}
+}
+
+func TestZoneReadFile(t *testing.T) {
+	z := writeZone(t, "file.rpz.", `$ORIGIN file.rpz.
+$TTL 60
+@	IN	SOA	ns.file.rpz. admin.file.rpz. 1 3600 600 86400 60
+a	CNAME	.
+`)
+	pol := z.get()
+	if pol.qname.match("a.") == nil {
+		t.Fatalf("Expected a rule for a.")
+	}
+
+	// The same serial leaves the policy alone.
+	if err := os.WriteFile(z.file, []byte(`$ORIGIN file.rpz.
+$TTL 60
+@	IN	SOA	ns.file.rpz. admin.file.rpz. 1 3600 600 86400 60
+b	CNAME	.
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.readFile(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if z.get() != pol {
+		t.Errorf("Expected the policy to stay the same")
+	}
+
+	if err := os.WriteFile(z.file, []byte(`$ORIGIN file.rpz.
+$TTL 60
+@	IN	SOA	ns.file.rpz. admin.file.rpz. 2 3600 600 86400 60
+b	CNAME	.
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.readFile(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if pol := z.get(); pol.qname.match("a.") != nil || pol.qname.match("b.") == nil {
+		t.Errorf("Expected the rule for b. only")
+	}
+}
+
+func TestZoneMixedCaseOrigin(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "db.rpz")
+	if err := os.WriteFile(name, []byte(`$ORIGIN rpz.example.
+$TTL 60
+@	IN	SOA	ns.rpz.example. admin.rpz.example. 1 3600 600 86400 60
+a	CNAME	.
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := caddy.NewTestController("dns", fmt.Sprintf("rpz {\n file RPZ.Example. %s\n}", name))
+	rp, err := parseRPZ(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	z := rp.policies[0]
+	if err := z.readFile(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if pol := z.get(); pol.rules != 1 || pol.qname.match("a.") == nil {
+		t.Errorf("Expected the rule for a.")
+	}
+}
+
+func TestZoneOnShutdownTwice(t *testing.T) {
+	z := newZone("rpz.example.")
+	if err := z.OnShutdown(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if err := z.OnShutdown(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+}
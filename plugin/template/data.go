@@ -0,0 +1,135 @@
+package template
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// dataFile holds the contents of a CSV, JSON or YAML file, that templates can index by key.
+type dataFile struct {
+	path string
+
+	sync.RWMutex
+	entries map[string]interface{}
+
+	// mtime and size are only read and modified by a single goroutine
+	mtime time.Time
+	size  int64
+}
+
+// get returns the entries of d. The returned map is replaced, never modified, when the file is reread.
+func (d *dataFile) get() map[string]interface{} {
+	d.RLock()
+	defer d.RUnlock()
+	return d.entries
+}
+
+// read reads the entries from the file, if the file has changed.
+func (d *dataFile) read() error {
+	file, err := os.Open(d.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if d.mtime.Equal(stat.ModTime()) && d.size == stat.Size() {
+		return nil
+	}
+
+	entries, err := parseData(d.path, file)
+	if err != nil {
+		return err
+	}
+
+	d.Lock()
+	d.entries = entries
+	d.Unlock()
+	d.mtime = stat.ModTime()
+	d.size = stat.Size()
+
+	log.Debugf("Parsed data %s into %d entries", d.path, len(entries))
+	return nil
+}
+
+// parseData parses the entries in r, in the format given by the extension of path.
+func parseData(path string, r io.Reader) (map[string]interface{}, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return parseCSV(r)
+	case ".json":
+		return parseJSON(r, false)
+	case ".yaml", ".yml":
+		return parseJSON(r, true)
+	}
+	return nil, fmt.Errorf("unknown data format of %s, expect .csv, .json, .yaml or .yml", path)
+}
+
+// parseCSV parses the CSV records in r. The first record holds the names of the columns, and the first column
+// holds the keys. Each entry maps the column names to the values of a record, e.g. the records
+//
+//	host,ip
+//	web,10.0.0.1
+//
+// give the entry "web" with the values {"host": "web", "ip": "10.0.0.1"}.
+func parseCSV(r io.Reader) (map[string]interface{}, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return map[string]interface{}{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]interface{}{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		values := make(map[string]interface{}, len(header))
+		for i, column := range header {
+			values[column] = record[i]
+		}
+		entries[record[0]] = values
+	}
+}
+
+// parseJSON parses the JSON, or YAML, object in r.
+func parseJSON(r io.Reader, isYAML bool) (map[string]interface{}, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if isYAML {
+		if buf, err = yaml.YAMLToJSON(buf); err != nil {
+			return nil, err
+		}
+	}
+
+	entries := map[string]interface{}{}
+	if err := json.Unmarshal(buf, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
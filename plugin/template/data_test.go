@@ -0,0 +1,171 @@
+package template
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseData(t *testing.T) {
+	tests := []struct {
+		path      string
+		input     string
+		expected  string // the ip of the entry web, if any
+		entries   int
+		shouldErr bool
+	}{
+		{"hosts.csv", "host,ip\n# comment\nweb, 10.0.0.1\ndb,10.0.0.2\n", "10.0.0.1", 2, false},
+		{"hosts.CSV", "host,ip\n", "", 0, false},
+		{"hosts.csv", "", "", 0, false},
+		{"hosts.csv", "host,ip\nweb\n", "", 0, true},
+		{"hosts.json", `{"web": {"ip": "10.0.0.1"}, "db": {"ip": "10.0.0.2"}}`, "10.0.0.1", 2, false},
+		{"hosts.json", `["web"]`, "", 0, true},
+		{"hosts.yaml", "web:\n  ip: 10.0.0.1\n", "10.0.0.1", 1, false},
+		{"hosts.yml", "web:\n  ip: 10.0.0.1\ndb:\n  ip: 10.0.0.2\n", "10.0.0.1", 2, false},
+		{"hosts.yaml", "- web\n", "", 0, true},
+		{"hosts.txt", "web 10.0.0.1\n", "", 0, true},
+	}
+
+	for i, tc := range tests {
+		entries, err := parseData(tc.path, strings.NewReader(tc.input))
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error for %s, got none", i, tc.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error for %s, got %s", i, tc.path, err)
+			continue
+		}
+		if len(entries) != tc.entries {
+			t.Errorf("Test %d: expected %d entries, got %d", i, tc.entries, len(entries))
+		}
+		if tc.expected == "" {
+			continue
+		}
+		web, ok := entries["web"].(map[string]interface{})
+		if !ok {
+			t.Errorf("Test %d: expected an entry for web, got %v", i, entries["web"])
+			continue
+		}
+		if web["ip"] != tc.expected {
+			t.Errorf("Test %d: expected ip %s for web, got %v", i, tc.expected, web["ip"])
+		}
+	}
+}
+
+func TestDataRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts.csv")
+	if err := os.WriteFile(path, []byte("host,ip\nweb,10.0.0.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := &dataFile{path: path}
+	if err := d.read(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	entries := d.get()
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+
+	if err := d.read(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if fmt.Sprintf("%p", d.get()) != fmt.Sprintf("%p", entries) {
+		t.Errorf("Expected the entries to be kept when the file did not change")
+	}
+
+	if err := os.WriteFile(path, []byte("host,ip\nweb,10.0.0.1\ndb,10.0.0.2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.read(); err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if len(d.get()) != 2 {
+		t.Errorf("Expected 2 entries after the file changed, got %d", len(d.get()))
+	}
+
+	// A file that can't be parsed keeps the previous entries.
+	if err := os.WriteFile(path, []byte("host,ip\nweb\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.read(); err == nil {
+		t.Errorf("Expected error for an invalid file, got none")
+	}
+	if len(d.get()) != 2 {
+		t.Errorf("Expected 2 entries after a failed read, got %d", len(d.get()))
+	}
+}
+
+func TestHandlerData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts.csv")
+	if err := os.WriteFile(path, []byte("host,ip,ip6,ttl\nweb,10.0.0.1,2001:db8::1,300\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := caddy.NewTestController("dns", fmt.Sprintf(`template IN A example. {
+		match ^(?P<host>[a-z]+)[.]example[.]$
+		answer "{{ .Name }} {{ index .Data .Group.host \"ttl\" }} IN A {{ index .Data .Group.host \"ip\" }}"
+		data %s
+		reload 0
+	}
+	template IN AAAA example. {
+		match ^(?P<host>[a-z]+)[.]example[.]$
+		answer "{{ with index .Data .Group.host }}{{ $.Name }} {{ .ttl }} IN AAAA {{ .ip6 }}{{ end }}"
+		data %s
+		reload 0
+	}`, path, path))
+	handler, err := templateParse(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	handler.Next = test.NextHandler(dns.RcodeNameError, nil)
+
+	tests := []struct {
+		qname        string
+		qtype        uint16
+		expectedCode int
+		expected     string
+	}{
+		{"web.example.", dns.TypeA, dns.RcodeSuccess, "web.example.\t300\tIN\tA\t10.0.0.1"},
+		{"db.example.", dns.TypeA, dns.RcodeServerFailure, ""}, // no entry for db
+		{"web.example.", dns.TypeAAAA, dns.RcodeSuccess, "web.example.\t300\tIN\tAAAA\t2001:db8::1"},
+		{"db.example.", dns.TypeAAAA, dns.RcodeSuccess, ""},
+	}
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, tc.qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		code, _ := handler.ServeDNS(context.Background(), rec, m)
+		if code != tc.expectedCode {
+			t.Errorf("Test %d: expected rcode %d, got %d", i, tc.expectedCode, code)
+			continue
+		}
+		if tc.expectedCode != dns.RcodeSuccess {
+			continue
+		}
+		if tc.expected == "" {
+			if len(rec.Msg.Answer) != 0 {
+				t.Errorf("Test %d: expected no answer, got %v", i, rec.Msg.Answer)
+			}
+			continue
+		}
+		if len(rec.Msg.Answer) != 1 || rec.Msg.Answer[0].String() != tc.expected {
+			t.Errorf("Test %d: expected answer %q, got %v", i, tc.expected, rec.Msg.Answer)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package template
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	gotmpl "text/template"
+
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+
+	"github.com/miekg/dns"
+)
+
+// funcMap holds the functions that can be used in the templates. The functions that take a string to work on take
+// it as the last argument, so they can be used in pipelines, e.g. {{ .Name | trimSuffix .Zone }}.
+var funcMap = gotmpl.FuncMap{
+	// IP addresses
+	"parseIP":     parseIP,
+	"dashedToIP":  dashedToIP,
+	"ipToDashed":  ipToDashed,
+	"reverseName": reverseName,
+	"reverseToIP": reverseToIP,
+	"inCIDR":      inCIDR,
+	"isIPv4":      func(s string) bool { ip := net.ParseIP(s); return ip != nil && ip.To4() != nil },
+	"isIPv6":      func(s string) bool { ip := net.ParseIP(s); return ip != nil && ip.To4() == nil },
+	"ipAdd":       ipAdd,
+
+	// arithmetic
+	"atoi": func(v interface{}) (int, error) { return toInt(v) },
+	"add":  func(a, b interface{}) (int, error) { return arith(a, b, '+') },
+	"sub":  func(a, b interface{}) (int, error) { return arith(a, b, '-') },
+	"mul":  func(a, b interface{}) (int, error) { return arith(a, b, '*') },
+	"div":  func(a, b interface{}) (int, error) { return arith(a, b, '/') },
+	"mod":  func(a, b interface{}) (int, error) { return arith(a, b, '%') },
+
+	// strings and names
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       func(sep string, elems []string) string { return strings.Join(elems, sep) },
+	"labels":     dns.SplitDomainName,
+	"fqdn":       dns.Fqdn,
+}
+
+// parseIP returns the canonical form of the IP address s.
+func parseIP(s string) (string, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return "", fmt.Errorf("invalid IP address %q", s)
+	}
+	return ip.String(), nil
+}
+
+// dashedToIP returns the IP address of s, an IP address with dashes instead of dots or colons, as used in names,
+// e.g. 10-1-2-3 or 2001-db8--1.
+func dashedToIP(s string) (string, error) {
+	if ip := net.ParseIP(strings.Replace(s, "-", ".", -1)); ip != nil && ip.To4() != nil {
+		return ip.String(), nil
+	}
+	if ip := net.ParseIP(strings.Replace(s, "-", ":", -1)); ip != nil {
+		return ip.String(), nil
+	}
+	return "", fmt.Errorf("invalid dashed IP address %q", s)
+}
+
+// ipToDashed returns the IP address s with dashes instead of dots or colons.
+func ipToDashed(s string) (string, error) {
+	ip, err := parseIP(s)
+	if err != nil {
+		return "", err
+	}
+	return strings.NewReplacer(".", "-", ":", "-").Replace(ip), nil
+}
+
+// reverseName returns the name in in-addr.arpa. or ip6.arpa. of the IP address s.
+func reverseName(s string) (string, error) {
+	return dns.ReverseAddr(s)
+}
+
+// reverseToIP returns the IP address of the name in in-addr.arpa. or ip6.arpa.
+func reverseToIP(name string) (string, error) {
+	ip := dnsutil.ExtractAddressFromReverse(name)
+	if ip == "" {
+		return "", fmt.Errorf("invalid reverse name %q", name)
+	}
+	return parseIP(ip)
+}
+
+// inCIDR returns true if the IP address s is in the network cidr.
+func inCIDR(cidr, s string) (bool, error) {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return false, fmt.Errorf("invalid IP address %q", s)
+	}
+	return n.Contains(ip), nil
+}
+
+// ipAdd returns the IP address s plus n, which may be negative.
+func ipAdd(n interface{}, s string) (string, error) {
+	i, err := toInt(n)
+	if err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return "", fmt.Errorf("invalid IP address %q", s)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+
+	sum := new(big.Int).Add(new(big.Int).SetBytes(ip), big.NewInt(int64(i)))
+	if sum.Sign() < 0 || sum.BitLen() > len(ip)*8 {
+		return "", fmt.Errorf("%s plus %d is out of range", s, i)
+	}
+	b := sum.Bytes()
+	res := make(net.IP, len(ip))
+	copy(res[len(res)-len(b):], b)
+	return res.String(), nil
+}
+
+// toInt converts v, a number or a string with a number, to an int.
+func toInt(v interface{}) (int, error) {
+	switch x := v.(type) {
+	case int:
+		return x, nil
+	case int64:
+		return int(x), nil
+	case uint16:
+		return int(x), nil
+	case uint32:
+		return int(x), nil
+	case float64: // numbers in JSON and YAML data
+		return int(x), nil
+	case string:
+		return strconv.Atoi(x)
+	}
+	return 0, fmt.Errorf("invalid number %v", v)
+}
+
+// arith returns a op b.
+func arith(a, b interface{}, op byte) (int, error) {
+	x, err := toInt(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toInt(b)
+	if err != nil {
+		return 0, err
+	}
+	switch op {
+	case '+':
+		return x + y, nil
+	case '-':
+		return x - y, nil
+	case '*':
+		return x * y, nil
+	}
+	if y == 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+	if op == '/' {
+		return x / y, nil
+	}
+	return x % y, nil
+}
@@ -0,0 +1,77 @@
+package template
+
+import (
+	"bytes"
+	"testing"
+	gotmpl "text/template"
+)
+
+func TestFuncs(t *testing.T) {
+	tests := []struct {
+		tmpl      string
+		expected  string
+		shouldErr bool
+	}{
+		// IP addresses
+		{`{{ parseIP "2001:DB8:0::1" }}`, "2001:db8::1", false},
+		{`{{ parseIP "10.1.2" }}`, "", true},
+		{`{{ dashedToIP "10-1-2-3" }}`, "10.1.2.3", false},
+		{`{{ dashedToIP "2001-db8--1" }}`, "2001:db8::1", false},
+		{`{{ dashedToIP "10-1-2" }}`, "", true},
+		{`{{ ipToDashed "10.1.2.3" }}`, "10-1-2-3", false},
+		{`{{ ipToDashed "2001:db8:0::1" }}`, "2001-db8--1", false},
+		{`{{ "10-1-2-3" | dashedToIP | reverseName }}`, "3.2.1.10.in-addr.arpa.", false},
+		{`{{ reverseName "2001:db8::1" }}`, "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", false},
+		{`{{ reverseToIP "3.2.1.10.in-addr.arpa." }}`, "10.1.2.3", false},
+		{`{{ reverseToIP "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa." }}`, "2001:db8::1", false},
+		{`{{ reverseToIP "example.org." }}`, "", true},
+		{`{{ inCIDR "10.0.0.0/8" "10.1.2.3" }}`, "true", false},
+		{`{{ "192.0.2.1" | inCIDR "10.0.0.0/8" }}`, "false", false},
+		{`{{ inCIDR "10.0.0.0" "10.1.2.3" }}`, "", true},
+		{`{{ isIPv4 "10.1.2.3" }} {{ isIPv6 "10.1.2.3" }}`, "true false", false},
+		{`{{ isIPv4 "2001:db8::1" }} {{ isIPv6 "2001:db8::1" }}`, "false true", false},
+		{`{{ ipAdd 2 "10.1.2.254" }}`, "10.1.3.0", false},
+		{`{{ ipAdd -1 "2001:db8::1" }}`, "2001:db8::", false},
+		{`{{ ipAdd "1" "255.255.255.255" }}`, "", true},
+		{`{{ ipAdd -1 "0.0.0.0" }}`, "", true},
+
+		// arithmetic
+		{`{{ add 1 "2" }} {{ sub 1 2 }} {{ mul "3" 4 }} {{ div 7 2 }} {{ mod 7 2 }}`, "3 -1 12 3 1", false},
+		{`{{ atoi "42" }}`, "42", false},
+		{`{{ atoi "x" }}`, "", true},
+		{`{{ div 1 0 }}`, "", true},
+		{`{{ mod 1 0 }}`, "", true},
+
+		// strings and names
+		{`{{ upper "ab" }} {{ lower "AB" }}`, "AB ab", false},
+		{`{{ "a.b.example." | replace "." "-" }}`, "a-b-example-", false},
+		{`{{ "www.example.org." | trimSuffix ".example.org." | trimPrefix "w" }}`, "ww", false},
+		{`{{ hasPrefix "www" "www.example." }} {{ hasSuffix "org." "www.example." }}`, "true false", false},
+		{`{{ contains "example" "www.example." }}`, "true", false},
+		{`{{ split "-" "10-1-2-3" | join "." }}`, "10.1.2.3", false},
+		{`{{ index (labels "www.example.org.") 1 }}`, "example", false},
+		{`{{ fqdn "example.org" }}`, "example.org.", false},
+	}
+
+	for i, tc := range tests {
+		tmpl, err := gotmpl.New("test").Funcs(funcMap).Parse(tc.tmpl)
+		if err != nil {
+			t.Fatalf("Test %d: failed to parse %q: %s", i, tc.tmpl, err)
+		}
+		buf := &bytes.Buffer{}
+		err = tmpl.Execute(buf, nil)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error for %q, got %q", i, tc.tmpl, buf.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error for %q, got %s", i, tc.tmpl, err)
+			continue
+		}
+		if buf.String() != tc.expected {
+			t.Errorf("Test %d: expected %q for %q, got %q", i, tc.expected, tc.tmpl, buf.String())
+		}
+	}
+}
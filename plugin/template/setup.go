@@ -3,15 +3,19 @@ package template
 import (
 	"regexp"
 	gotmpl "text/template"
+	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
 	"github.com/coredns/coredns/plugin/pkg/upstream"
 
 	"github.com/miekg/dns"
 )
 
+var log = clog.NewWithPlugin("template")
+
 func init() { plugin.Register("template", setupTemplate) }
 
 func setupTemplate(c *caddy.Controller) error {
@@ -20,6 +24,22 @@ func setupTemplate(c *caddy.Controller) error {
 		return plugin.Error("template", err)
 	}
 
+	for _, t := range handler.Templates {
+		if t.data == nil || t.reload == 0 {
+			continue
+		}
+		d, reload := t.data, t.reload
+		stop := make(chan struct{})
+		c.OnStartup(func() error {
+			go periodicDataUpdate(d, reload, stop)
+			return nil
+		})
+		c.OnShutdown(func() error {
+			close(stop)
+			return nil
+		})
+	}
+
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		handler.Next = next
 		return handler
@@ -28,6 +48,22 @@ func setupTemplate(c *caddy.Controller) error {
 	return nil
 }
 
+// periodicDataUpdate rereads the data file, if it changed, every reload interval, until stop is closed.
+func periodicDataUpdate(d *dataFile, reload time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(reload)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := d.read(); err != nil {
+				log.Warningf("Failed to reload data %s: %s", d.path, err)
+			}
+		}
+	}
+}
+
 func templateParse(c *caddy.Controller) (handler Handler, err error) {
 	handler.Templates = make([]template, 0)
 
@@ -58,6 +94,7 @@ func templateParse(c *caddy.Controller) (handler Handler, err error) {
 
 		t.answer = make([]*gotmpl.Template, 0)
 		t.upstream = upstream.New()
+		t.reload = defaultReload
 
 		for c.NextBlock() {
 			switch c.Val() {
@@ -81,7 +118,7 @@ func templateParse(c *caddy.Controller) (handler Handler, err error) {
 					return handler, c.ArgErr()
 				}
 				for _, answer := range args {
-					tmpl, err := gotmpl.New("answer").Parse(answer)
+					tmpl, err := gotmpl.New("answer").Funcs(funcMap).Parse(answer)
 					if err != nil {
 						return handler, c.Errf("could not compile template: %s, %v", c.Val(), err)
 					}
@@ -94,7 +131,7 @@ func templateParse(c *caddy.Controller) (handler Handler, err error) {
 					return handler, c.ArgErr()
 				}
 				for _, additional := range args {
-					tmpl, err := gotmpl.New("additional").Parse(additional)
+					tmpl, err := gotmpl.New("additional").Funcs(funcMap).Parse(additional)
 					if err != nil {
 						return handler, c.Errf("could not compile template: %s, %v\n", c.Val(), err)
 					}
@@ -107,7 +144,7 @@ func templateParse(c *caddy.Controller) (handler Handler, err error) {
 					return handler, c.ArgErr()
 				}
 				for _, authority := range args {
-					tmpl, err := gotmpl.New("authority").Parse(authority)
+					tmpl, err := gotmpl.New("authority").Funcs(funcMap).Parse(authority)
 					if err != nil {
 						return handler, c.Errf("could not compile template: %s, %v\n", c.Val(), err)
 					}
@@ -124,6 +161,31 @@ func templateParse(c *caddy.Controller) (handler Handler, err error) {
 				}
 				t.rcode = rcode
 
+			case "data":
+				if !c.NextArg() {
+					return handler, c.ArgErr()
+				}
+				t.data = &dataFile{path: c.Val()}
+				if c.NextArg() {
+					return handler, c.ArgErr()
+				}
+				if err := t.data.read(); err != nil {
+					return handler, c.Errf("unable to read data %q: %s", t.data.path, err)
+				}
+
+			case "reload":
+				if !c.NextArg() {
+					return handler, c.Errf("reload needs a duration (zero seconds to disable)")
+				}
+				reload, err := time.ParseDuration(c.Val())
+				if err != nil {
+					return handler, c.Errf("invalid duration for reload '%s'", c.Val())
+				}
+				if reload < 0 {
+					return handler, c.Errf("invalid negative duration for reload '%s'", c.Val())
+				}
+				t.reload = reload
+
 			case "fallthrough":
 				t.fall.SetZonesFromArgs(c.RemainingArgs())
 
@@ -144,3 +206,6 @@ func templateParse(c *caddy.Controller) (handler Handler, err error) {
 
 	return
 }
+
+// defaultReload is the default interval at which the data files are checked for changes.
+const defaultReload = 5 * time.Second
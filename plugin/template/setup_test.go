@@ -84,6 +84,36 @@ func TestSetupParse(t *testing.T) {
 			}`,
 			true,
 		},
+		{
+			`template ANY ANY {
+				answer "{{ nosuchfunc .Name }}"
+			}`,
+			true,
+		},
+		{
+			`template ANY ANY {
+				data
+			}`,
+			true,
+		},
+		{
+			`template ANY ANY {
+				data /nonexistent/hosts.csv
+			}`,
+			true,
+		},
+		{
+			`template ANY ANY {
+				reload
+			}`,
+			true,
+		},
+		{
+			`template ANY ANY {
+				reload -1s
+			}`,
+			true,
+		},
 		// examples
 		{`template ANY ANY (?P<x>`, false},
 		{
@@ -141,6 +171,14 @@ func TestSetupParse(t *testing.T) {
 				}`,
 			false,
 		},
+		{
+			`template IN A example {
+				match ^(?P<ip>[0-9-]*)[.]ip[.]example[.]$
+				answer "{{ .Name }} 60 IN A {{ dashedToIP .Group.ip }}"
+				reload 10s
+			}`,
+			false,
+		},
 	}
 	for i, test := range tests {
 		c := caddy.NewTestController("dns", test.inputFileRules)
@@ -6,6 +6,7 @@ import (
 	"regexp"
 	"strconv"
 	gotmpl "text/template"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/metadata"
@@ -36,6 +37,8 @@ type template struct {
 	qtype      uint16
 	fall       fall.F
 	upstream   *upstream.Upstream
+	data       *dataFile
+	reload     time.Duration
 }
 
 type templateData struct {
@@ -49,6 +52,7 @@ type templateData struct {
 	Message  *dns.Msg
 	Question *dns.Question
 	Remote   string
+	Data     map[string]interface{}
 	md       map[string]metadata.Func
 }
 
@@ -98,6 +102,9 @@ func (h Handler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 			if err != nil {
 				return dns.RcodeServerFailure, err
 			}
+			if rr == nil {
+				continue
+			}
 			msg.Answer = append(msg.Answer, rr)
 			if template.upstream != nil && (state.QType() == dns.TypeA || state.QType() == dns.TypeAAAA) && rr.Header().Rrtype == dns.TypeCNAME {
 				up, _ := template.upstream.Lookup(ctx, state, rr.(*dns.CNAME).Target, state.QType())
@@ -109,6 +116,9 @@ func (h Handler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 			if err != nil {
 				return dns.RcodeServerFailure, err
 			}
+			if rr == nil {
+				continue
+			}
 			msg.Extra = append(msg.Extra, rr)
 		}
 		for _, authority := range template.authority {
@@ -116,6 +126,9 @@ func (h Handler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 			if err != nil {
 				return dns.RcodeServerFailure, err
 			}
+			if rr == nil {
+				continue
+			}
 			msg.Ns = append(msg.Ns, rr)
 		}
 
@@ -129,6 +142,8 @@ func (h Handler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 // Name implements the plugin.Handler interface.
 func (h Handler) Name() string { return "template" }
 
+// executeRRTemplate returns the resource record of the template, or nil if the template is empty, e.g.
+// because the entry in the data it uses doesn't exist.
 func executeRRTemplate(server, section string, template *gotmpl.Template, data *templateData) (dns.RR, error) {
 	buffer := &bytes.Buffer{}
 	err := template.Execute(buffer, data)
@@ -147,6 +162,9 @@ func executeRRTemplate(server, section string, template *gotmpl.Template, data *
 func (t template) match(ctx context.Context, state request.Request) (*templateData, bool, bool) {
 	q := state.Req.Question[0]
 	data := &templateData{md: metadata.ValueFuncs(ctx), Remote: state.IP()}
+	if t.data != nil {
+		data.Data = t.data.get()
+	}
 
 	zone := plugin.Zones(t.zones).Matches(state.Name())
 	if zone == "" {
@@ -0,0 +1,69 @@
+package tunnel
+
+import (
+	"sort"
+	"sync"
+)
+
+// hitters tracks the domains with the most suspicious queries, with the Space-Saving algorithm: it holds at most
+// size counters, and a domain that isn't tracked replaces the one with the lowest count, inheriting that count.
+// The count of a domain is never underestimated, and overestimated by at most the count it inherited.
+type hitters struct {
+	sync.Mutex
+	size   int
+	counts map[string]*hitter
+}
+
+type hitter struct {
+	domain string
+	count  uint64
+	err    uint64 // the count inherited from the domain it replaced
+}
+
+func newHitters(size int) *hitters {
+	return &hitters{size: size, counts: make(map[string]*hitter, size)}
+}
+
+// add counts a suspicious query for domain. It returns the new count of domain, and the domain it replaced, if
+// any. The caller must hold the lock of h.
+func (h *hitters) add(domain string) (uint64, string) {
+	if x, ok := h.counts[domain]; ok {
+		x.count++
+		return x.count, ""
+	}
+	if len(h.counts) < h.size {
+		h.counts[domain] = &hitter{domain: domain, count: 1}
+		return 1, ""
+	}
+
+	var min *hitter
+	for _, x := range h.counts {
+		if min == nil || x.count < min.count {
+			min = x
+		}
+	}
+	delete(h.counts, min.domain)
+	evicted := min.domain
+	min.domain, min.err = domain, min.count
+	min.count++
+	h.counts[domain] = min
+	return min.count, evicted
+}
+
+// top returns the tracked domains, by descending count.
+func (h *hitters) top() []hitter {
+	h.Lock()
+	top := make([]hitter, 0, len(h.counts))
+	for _, x := range h.counts {
+		top = append(top, *x)
+	}
+	h.Unlock()
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].count != top[j].count {
+			return top[i].count > top[j].count
+		}
+		return top[i].domain < top[j].domain
+	})
+	return top
+}
@@ -0,0 +1,26 @@
+package tunnel
+
+import "testing"
+
+func TestHitters(t *testing.T) {
+	h := newHitters(2)
+	for _, d := range []string{"a.com", "a.com", "a.com", "b.com", "b.com"} {
+		h.add(d)
+	}
+
+	count, evicted := h.add("c.com")
+	if count != 3 || evicted != "b.com" {
+		t.Errorf("Expected c.com to replace b.com with count 3, got %d and %q", count, evicted)
+	}
+
+	top := h.top()
+	if len(top) != 2 {
+		t.Fatalf("Expected 2 heavy hitters, got %d", len(top))
+	}
+	if top[0].domain != "a.com" || top[0].count != 3 || top[0].err != 0 {
+		t.Errorf("Unexpected first heavy hitter %+v", top[0])
+	}
+	if top[1].domain != "c.com" || top[1].count != 3 || top[1].err != 2 {
+		t.Errorf("Unexpected second heavy hitter %+v", top[1])
+	}
+}
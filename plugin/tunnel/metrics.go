@@ -0,0 +1,33 @@
+package tunnel
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// suspiciousCount is the number of queries with a score at or above the threshold.
+	suspiciousCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "suspicious_requests_total",
+		Help:      "Counter of requests with a score at or above the threshold.",
+	}, []string{"server"})
+	// blockCount is the number of suspicious queries that are refused.
+	blockCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "blocked_requests_total",
+		Help:      "Counter of suspicious requests that are refused.",
+	}, []string{"server"})
+	// heavyHitters is the estimated number of suspicious queries for the registered domains with the most
+	// suspicious queries.
+	heavyHitters = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "heavy_hitters",
+		Help:      "Estimated number of suspicious requests for the registered domains with the most suspicious requests.",
+	}, []string{"server", "domain"})
+)
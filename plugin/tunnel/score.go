@@ -0,0 +1,75 @@
+package tunnel
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// The ranges over which the entropy and length of a subdomain are scaled to a signal between 0 and 1. Host names
+// chosen by people rarely have an entropy above 2.5 bits per character, while base32 and base64 encoded data
+// comes close to 5 and 6 bits.
+const (
+	entropyLow  = 2.5
+	entropyHigh = 4.5
+	lengthLow   = 20
+	lengthHigh  = 100
+)
+
+// entropy returns the Shannon entropy, in bits per character, of the characters of s that aren't dots.
+func entropy(s string) float64 {
+	var counts [256]int
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '.' {
+			continue
+		}
+		counts[s[i]]++
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	e := 0.0
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / float64(n)
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+// scale scales v from [low, high] to [0, 1].
+func scale(v, low, high float64) float64 {
+	switch {
+	case v <= low:
+		return 0
+	case v >= high:
+		return 1
+	}
+	return (v - low) / (high - low)
+}
+
+// subdomains holds the unique subdomains a client queried under a registered domain, within a window.
+type subdomains struct {
+	sync.Mutex
+	start time.Time
+	seen  map[uint64]struct{}
+}
+
+// add adds the subdomain with hash h, seen at now, and returns the number of unique subdomains in the window. At
+// most max subdomains are held.
+func (s *subdomains) add(h uint64, now time.Time, window time.Duration, max int) int {
+	s.Lock()
+	defer s.Unlock()
+	if now.Sub(s.start) > window {
+		s.start = now
+		s.seen = make(map[uint64]struct{})
+	}
+	if len(s.seen) < max {
+		s.seen[h] = struct{}{}
+	}
+	return len(s.seen)
+}
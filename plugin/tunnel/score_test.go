@@ -0,0 +1,51 @@
+package tunnel
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEntropy(t *testing.T) {
+	tests := []struct {
+		s       string
+		entropy float64
+	}{
+		{"", 0},
+		{"aaaa", 0},
+		{"ab.ab", 1},
+		{"abcd", 2},
+		{"0123456789abcdef", 4},
+	}
+	for i, tc := range tests {
+		if e := entropy(tc.s); math.Abs(e-tc.entropy) > 1e-9 {
+			t.Errorf("Test %d: expected entropy %f for %q, got %f", i, tc.entropy, tc.s, e)
+		}
+	}
+}
+
+func TestScale(t *testing.T) {
+	if s := scale(1, 2, 4); s != 0 {
+		t.Errorf("Expected 0, got %f", s)
+	}
+	if s := scale(3, 2, 4); s != 0.5 {
+		t.Errorf("Expected 0.5, got %f", s)
+	}
+	if s := scale(5, 2, 4); s != 1 {
+		t.Errorf("Expected 1, got %f", s)
+	}
+}
+
+func TestSubdomains(t *testing.T) {
+	s := &subdomains{}
+	now := time.Now()
+	for i := uint64(0); i < 5; i++ {
+		s.add(i, now, time.Minute, 3)
+	}
+	if n := s.add(1, now, time.Minute, 3); n != 3 {
+		t.Errorf("Expected at most 3 subdomains, got %d", n)
+	}
+	if n := s.add(1, now.Add(2*time.Minute), time.Minute, 3); n != 1 {
+		t.Errorf("Expected 1 subdomain in a new window, got %d", n)
+	}
+}
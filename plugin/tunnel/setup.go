@@ -0,0 +1,198 @@
+package tunnel
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+
+	"github.com/miekg/dns"
+)
+
+const pluginName = "tunnel"
+
+var log = clog.NewWithPlugin(pluginName)
+
+func init() { plugin.Register(pluginName, setup) }
+
+func setup(c *caddy.Controller) error {
+	t, err := parse(c)
+	if err != nil {
+		return plugin.Error(pluginName, err)
+	}
+
+	c.OnShutdown(t.OnShutdown)
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		t.Next = next
+		return t
+	})
+
+	return nil
+}
+
+// The defaults of the settings.
+const (
+	defaultThreshold = 2.0
+	defaultCount     = 50
+	defaultWindow    = time.Minute
+	defaultClients   = 10000
+	defaultTop       = 10
+)
+
+func parse(c *caddy.Controller) (*Tunnel, error) {
+	t := &Tunnel{
+		threshold: defaultThreshold,
+		entropy:   1,
+		length:    1,
+		qtype:     1,
+		unique:    1,
+		qtypes:    map[uint16]struct{}{dns.TypeTXT: {}, dns.TypeNULL: {}},
+		count:     defaultCount,
+		window:    defaultWindow,
+		action:    actionLog,
+		top:       defaultTop,
+		hitters:   make(map[string]*hitters),
+		now:       time.Now,
+	}
+	clients := defaultClients
+
+	i := 0
+	for c.Next() {
+		if i > 0 {
+			return nil, plugin.ErrOnce
+		}
+		i++
+
+		t.Zones = plugin.OriginsFromArgsOrServerBlock(c.RemainingArgs(), c.ServerBlockKeys)
+
+		for c.NextBlock() {
+			switch c.Val() {
+			case "threshold":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				f, err := parseWeight(args[0])
+				if err != nil {
+					return nil, c.Errf("invalid threshold '%s'", args[0])
+				}
+				t.threshold = f
+			case "entropy", "length":
+				property := c.Val()
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				f, err := parseWeight(args[0])
+				if err != nil {
+					return nil, c.Errf("invalid weight '%s' for %s", args[0], property)
+				}
+				if property == "entropy" {
+					t.entropy = f
+				} else {
+					t.length = f
+				}
+			case "qtype":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				f, err := parseWeight(args[0])
+				if err != nil {
+					return nil, c.Errf("invalid weight '%s' for qtype", args[0])
+				}
+				t.qtype = f
+				if len(args) > 1 {
+					t.qtypes = make(map[uint16]struct{})
+				}
+				for _, a := range args[1:] {
+					qtype, ok := dns.StringToType[strings.ToUpper(a)]
+					if !ok {
+						return nil, c.Errf("invalid query type '%s'", a)
+					}
+					t.qtypes[qtype] = struct{}{}
+				}
+			case "unique":
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 3 {
+					return nil, c.ArgErr()
+				}
+				f, err := parseWeight(args[0])
+				if err != nil {
+					return nil, c.Errf("invalid weight '%s' for unique", args[0])
+				}
+				t.unique = f
+				if len(args) > 1 {
+					n, err := strconv.Atoi(args[1])
+					if err != nil || n <= 0 {
+						return nil, c.Errf("invalid count '%s' for unique", args[1])
+					}
+					t.count = n
+				}
+				if len(args) > 2 {
+					d, err := time.ParseDuration(args[2])
+					if err != nil || d <= 0 {
+						return nil, c.Errf("invalid window '%s' for unique", args[2])
+					}
+					t.window = d
+				}
+			case "action":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				t.action = 0
+				for _, a := range args {
+					switch a {
+					case "log":
+						t.action |= actionLog
+					case "tag":
+						t.action |= actionTag
+					case "block":
+						t.action |= actionBlock
+					default:
+						return nil, c.Errf("unknown action '%s'; expect 'log', 'tag' or 'block'", a)
+					}
+				}
+			case "clients", "top":
+				property := c.Val()
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				n, err := strconv.Atoi(args[0])
+				if err != nil || n <= 0 {
+					return nil, c.Errf("invalid number '%s' for %s", args[0], property)
+				}
+				if property == "clients" {
+					clients = n
+				} else {
+					t.top = n
+				}
+			default:
+				return nil, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+	}
+
+	t.clients = cache.NewWithPolicy(clients, 0, cache.LRU)
+	return t, nil
+}
+
+// parseWeight parses a non-negative weight or threshold.
+func parseWeight(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if !(f >= 0) { // also catches NaN
+		return 0, strconv.ErrRange
+	}
+	return f, nil
+}
@@ -0,0 +1,100 @@
+package tunnel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+	}{
+		{`tunnel`, false},
+		{`tunnel example.org {
+			threshold 1.5
+			entropy 2
+			length 0.5
+			qtype 1 TXT NULL ANY
+			unique 1 100 30s
+			action log tag block
+			clients 1000
+			top 20
+		}`, false},
+		{`tunnel {
+			qtype 0
+		}`, false},
+		// fails
+		{`tunnel {
+			threshold
+		}`, true},
+		{`tunnel {
+			threshold -1
+		}`, true},
+		{`tunnel {
+			entropy NaN
+		}`, true},
+		{`tunnel {
+			qtype 1 FOO
+		}`, true},
+		{`tunnel {
+			unique 1 0
+		}`, true},
+		{`tunnel {
+			unique 1 10 1x
+		}`, true},
+		{`tunnel {
+			action drop
+		}`, true},
+		{`tunnel {
+			clients 0
+		}`, true},
+		{`tunnel {
+			blah
+		}`, true},
+		{`tunnel
+		tunnel`, true},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		_, err := parse(c)
+		if tc.shouldErr && err == nil {
+			t.Errorf("Test %d: expected error but got none for input %s", i, tc.input)
+		}
+		if !tc.shouldErr && err != nil {
+			t.Errorf("Test %d: expected no error but got %s for input %s", i, err, tc.input)
+		}
+	}
+}
+
+func TestSetupSettings(t *testing.T) {
+	tn, err := parse(caddy.NewTestController("dns", `tunnel example.org {
+		qtype 0.5 TXT ANY
+		unique 2 100 30s
+		action tag block
+		top 20
+	}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if len(tn.Zones) != 1 || tn.Zones[0] != "example.org." {
+		t.Errorf("Expected zones [example.org.], got %v", tn.Zones)
+	}
+	if _, ok := tn.qtypes[dns.TypeNULL]; ok || len(tn.qtypes) != 2 {
+		t.Errorf("Expected the query types TXT and ANY, got %v", tn.qtypes)
+	}
+	if tn.qtype != 0.5 || tn.unique != 2 || tn.count != 100 || tn.window != 30*time.Second {
+		t.Errorf("Unexpected settings %+v", tn)
+	}
+	if tn.action != actionTag|actionBlock {
+		t.Errorf("Expected actions tag and block, got %d", tn.action)
+	}
+	if tn.top != 20 || tn.threshold != defaultThreshold {
+		t.Errorf("Unexpected settings %+v", tn)
+	}
+}
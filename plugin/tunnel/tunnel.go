@@ -0,0 +1,200 @@
+// Package tunnel implements a plugin that detects DNS tunneling.
+package tunnel
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Tunnel scores queries for DNS tunneling, and logs, tags or blocks the queries with a score above its threshold.
+type Tunnel struct {
+	Next plugin.Handler
+
+	Zones     []string
+	threshold float64
+
+	// The weights of the signals.
+	entropy float64
+	length  float64
+	qtype   float64
+	unique  float64
+
+	qtypes  map[uint16]struct{} // the unusual query types
+	count   int                 // the number of unique subdomains per window that scores 1
+	window  time.Duration
+	action  action
+	clients *cache.Cache // the subdomains per client and registered domain
+	cmu     sync.Mutex   // makes looking up and adding to clients atomic
+
+	top     int // the number of heavy hitters tracked per server
+	mu      sync.Mutex
+	hitters map[string]*hitters // per server
+
+	now func() time.Time
+}
+
+// action is a set of things that are done with a suspicious query.
+type action int
+
+const (
+	// actionLog logs the query.
+	actionLog action = 1 << iota
+	// actionTag sets the metadata of the query.
+	actionTag
+	// actionBlock answers with REFUSED.
+	actionBlock
+)
+
+// ServeDNS implements the plugin.Handler interface.
+func (t *Tunnel) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	if plugin.Zones(t.Zones).Matches(state.Name()) == "" {
+		return plugin.NextOrFailure(t.Name(), t.Next, ctx, w, r)
+	}
+
+	score, domain := t.score(state)
+	suspicious := score >= t.threshold
+	if t.action&actionTag != 0 {
+		if v, ok := ctx.Value(verdictKey{}).(*verdict); ok {
+			v.score, v.domain, v.suspicious = score, domain, suspicious
+		}
+	}
+	if !suspicious {
+		return plugin.NextOrFailure(t.Name(), t.Next, ctx, w, r)
+	}
+
+	server := metrics.WithServer(ctx)
+	suspiciousCount.WithLabelValues(server).Inc()
+	t.hit(server, domain)
+
+	if t.action&actionLog != 0 {
+		log.Infof("Suspicious query %s %s from %s for %s, score %.2f", state.Name(), state.Type(), state.IP(), domain, score)
+	}
+	if t.action&actionBlock != 0 {
+		blockCount.WithLabelValues(server).Inc()
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	}
+	return plugin.NextOrFailure(t.Name(), t.Next, ctx, w, r)
+}
+
+// score returns the score of the query in state, and the registered domain of its name.
+func (t *Tunnel) score(state request.Request) (float64, string) {
+	name := strings.TrimSuffix(state.Name(), ".")
+	domain, err := publicsuffix.EffectiveTLDPlusOne(name)
+	sub := ""
+	if err != nil {
+		// The name is a public suffix, or not a valid domain name.
+		domain = name
+	} else if len(name) > len(domain) {
+		sub = name[:len(name)-len(domain)-1]
+	}
+
+	score := 0.0
+	if _, ok := t.qtypes[state.QType()]; ok {
+		score += t.qtype
+	}
+	if sub == "" {
+		return score, domain
+	}
+
+	score += t.entropy * scale(entropy(sub), entropyLow, entropyHigh)
+	score += t.length * scale(float64(len(sub)), lengthLow, lengthHigh)
+
+	if t.unique > 0 {
+		key := cache.Hash([]byte(state.IP() + "/" + domain))
+		t.cmu.Lock()
+		s, ok := t.clients.Get(key)
+		if !ok {
+			s = &subdomains{}
+			t.clients.Add(key, s)
+		}
+		t.cmu.Unlock()
+		n := s.(*subdomains).add(cache.Hash([]byte(sub)), t.now(), t.window, t.count)
+		score += t.unique * scale(float64(n), 0, float64(t.count))
+	}
+	return score, domain
+}
+
+// hit counts a suspicious query for domain, and updates the heavy hitters metric of server.
+func (t *Tunnel) hit(server, domain string) {
+	t.mu.Lock()
+	h, ok := t.hitters[server]
+	if !ok {
+		h = newHitters(t.top)
+		t.hitters[server] = h
+	}
+	t.mu.Unlock()
+
+	// The metric is updated under the lock of h, so concurrent hits can't leave behind the label of an evicted
+	// domain, or set a count out of order.
+	h.Lock()
+	defer h.Unlock()
+	count, evicted := h.add(domain)
+	if evicted != "" {
+		heavyHitters.DeleteLabelValues(server, evicted)
+	}
+	heavyHitters.WithLabelValues(server, domain).Set(float64(count))
+}
+
+// OnShutdown removes the heavy hitters of t from the metrics.
+func (t *Tunnel) OnShutdown() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for server, h := range t.hitters {
+		for _, x := range h.top() {
+			heavyHitters.DeleteLabelValues(server, x.domain)
+		}
+	}
+	t.hitters = make(map[string]*hitters)
+	return nil
+}
+
+// verdict holds the score of a query, for the metadata.
+type verdict struct {
+	score      float64
+	domain     string
+	suspicious bool
+}
+
+type verdictKey struct{}
+
+// Metadata implements the metadata.Provider interface.
+func (t *Tunnel) Metadata(ctx context.Context, state request.Request) context.Context {
+	if t.action&actionTag == 0 {
+		return ctx
+	}
+	v := &verdict{score: -1}
+	ctx = context.WithValue(ctx, verdictKey{}, v)
+	metadata.SetValueFunc(ctx, "tunnel/score", func() string {
+		if v.score < 0 {
+			return ""
+		}
+		return strconv.FormatFloat(v.score, 'f', 2, 64)
+	})
+	metadata.SetValueFunc(ctx, "tunnel/domain", func() string { return v.domain })
+	metadata.SetValueFunc(ctx, "tunnel/suspicious", func() string {
+		if v.score < 0 {
+			return ""
+		}
+		return strconv.FormatBool(v.suspicious)
+	})
+	return ctx
+}
+
+// Name implements the Handler interface.
+func (t *Tunnel) Name() string { return "tunnel" }
@@ -0,0 +1,155 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// encoded is a subdomain that looks like encoded data.
+const encoded = "k3j5h2g7f9d8s1a4z6x0c3v5b7n9m2q4w6e8r1t3y5u7i9o0p2l4k6j8h1g3f5d"
+
+func TestTunnel(t *testing.T) {
+	tn, err := parse(caddy.NewTestController("dns", `tunnel example.org {
+		action tag block
+	}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	tn.Next = test.NextHandler(dns.RcodeSuccess, nil)
+
+	tests := []struct {
+		qname      string
+		qtype      uint16
+		rcode      int
+		suspicious string
+		domain     string
+	}{
+		{"www.example.org.", dns.TypeA, dns.RcodeSuccess, "false", "example.org"},
+		{"example.org.", dns.TypeTXT, dns.RcodeSuccess, "false", "example.org"},
+		{encoded + ".example.org.", dns.TypeA, dns.RcodeSuccess, "false", "example.org"},
+		{encoded + ".example.org.", dns.TypeTXT, dns.RcodeRefused, "true", "example.org"},
+		{encoded + ".t.example.org.", dns.TypeNULL, dns.RcodeRefused, "true", "example.org"},
+		{encoded + ".example.net.", dns.TypeTXT, dns.RcodeSuccess, "", ""},
+	}
+
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, tc.qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		ctx := metadata.ContextWithMetadata(context.Background())
+		ctx = tn.Metadata(ctx, request.Request{W: rec, Req: m})
+
+		if _, err := tn.ServeDNS(ctx, rec, m); err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		if rec.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %d, got %d", i, tc.rcode, rec.Rcode)
+		}
+		if s := metadata.ValueFunc(ctx, "tunnel/suspicious")(); s != tc.suspicious {
+			t.Errorf("Test %d: expected suspicious %q, got %q (score %s)", i, tc.suspicious, s, metadata.ValueFunc(ctx, "tunnel/score")())
+		}
+		if d := metadata.ValueFunc(ctx, "tunnel/domain")(); d != tc.domain {
+			t.Errorf("Test %d: expected domain %q, got %q", i, tc.domain, d)
+		}
+	}
+
+	if hits := testutil.ToFloat64(heavyHitters.WithLabelValues("", "example.org")); hits != 2 {
+		t.Errorf("Expected 2 suspicious requests for example.org, got %f", hits)
+	}
+	tn.OnShutdown()
+	if n := testutil.CollectAndCount(heavyHitters); n != 0 {
+		t.Errorf("Expected no heavy hitters after shutdown, got %d", n)
+	}
+}
+
+func TestTunnelUnique(t *testing.T) {
+	tn, err := parse(caddy.NewTestController("dns", `tunnel {
+		entropy 0
+		length 0
+		unique 2 3 1m
+		action tag
+	}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	tn.Zones = []string{"."}
+	tn.Next = test.NextHandler(dns.RcodeSuccess, nil)
+
+	tests := []struct {
+		qname      string
+		remote     string
+		suspicious string
+	}{
+		{"a.example.org.", "10.0.0.1", "false"},
+		{"b.example.org.", "10.0.0.1", "false"},
+		{"b.example.org.", "10.0.0.1", "false"},
+		{"a.example.org.", "10.0.0.2", "false"},
+		{"c.example.org.", "10.0.0.1", "true"},
+		{"d.example.co.uk.", "10.0.0.1", "false"},
+	}
+
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{RemoteIP: tc.remote})
+		ctx := metadata.ContextWithMetadata(context.Background())
+		ctx = tn.Metadata(ctx, request.Request{W: rec, Req: m})
+
+		if _, err := tn.ServeDNS(ctx, rec, m); err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		if rec.Rcode != dns.RcodeSuccess {
+			t.Errorf("Test %d: expected rcode %d, got %d", i, dns.RcodeSuccess, rec.Rcode)
+		}
+		if s := metadata.ValueFunc(ctx, "tunnel/suspicious")(); s != tc.suspicious {
+			t.Errorf("Test %d: expected suspicious %q, got %q (score %s)", i, tc.suspicious, s, metadata.ValueFunc(ctx, "tunnel/score")())
+		}
+	}
+}
+
+func TestTunnelHitConcurrent(t *testing.T) {
+	tn, err := parse(caddy.NewTestController("dns", `tunnel {
+		top 1
+	}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	defer tn.OnShutdown()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				tn.hit("concurrent", fmt.Sprintf("%d.example.org", (i+j)%4))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	top := tn.hitters["concurrent"].top()
+	if len(top) != 1 {
+		t.Fatalf("Expected 1 heavy hitter, got %d", len(top))
+	}
+	if hits := testutil.ToFloat64(heavyHitters.WithLabelValues("concurrent", top[0].domain)); hits != float64(top[0].count) {
+		t.Errorf("Expected %d suspicious requests for %s, got %f", top[0].count, top[0].domain, hits)
+	}
+	for i := 0; i < 4; i++ {
+		d := fmt.Sprintf("%d.example.org", i)
+		if d != top[0].domain && heavyHitters.DeleteLabelValues("concurrent", d) {
+			t.Errorf("Expected no metric for the evicted %s", d)
+		}
+	}
+}
@@ -19,3 +19,23 @@ func (s *ScrubWriter) WriteMsg(m *dns.Msg) error {
 	state.Scrub(m)
 	return s.ResponseWriter.WriteMsg(m)
 }
+
+// WireWriter is implemented by ResponseWriters that can write an already packed reply, so a plugin that has the
+// reply in wire format doesn't need to build a dns.Msg for it. A ResponseWriter that wraps another one should only
+// implement it when it doesn't need to see or modify the reply as a dns.Msg.
+type WireWriter interface {
+	// WriteWire writes the packed reply buf, which has rcode. It returns false, without writing anything, when
+	// the reply must be written with WriteMsg instead.
+	WriteWire(buf []byte, rcode int) (bool, error)
+}
+
+// WriteWire implements the WireWriter interface. The reply buf must already be tailored to the request, as
+// SizeAndDo does; it is only written when it fits the client's buffer, otherwise it must be truncated with WriteMsg.
+func (s *ScrubWriter) WriteWire(buf []byte, rcode int) (bool, error) {
+	state := Request{Req: s.req, W: s.ResponseWriter}
+	if len(buf) > state.Size() {
+		return false, nil
+	}
+	_, err := s.ResponseWriter.Write(buf)
+	return true, err
+}
@@ -155,3 +155,65 @@ func TestLookupCacheWithoutEdns(t *testing.T) {
 	}
 	t.Fatalf("Expected empty additional section, got %v", resp.Extra)
 }
+
+func TestLookupCacheShared(t *testing.T) {
+	name, rm, err := test.TempFile(".", exampleOrg)
+	if err != nil {
+		t.Fatalf("Failed to create zone: %s", err)
+	}
+	defer rm()
+
+	corefile := `example.org:0 {
+		file ` + name + `
+	}`
+
+	auth, udp, _, err := CoreDNSServerAndPorts(corefile)
+	if err != nil {
+		t.Fatalf("Could not get CoreDNS serving instance: %s", err)
+	}
+
+	// Two server blocks on different addresses, sharing one cache.
+	corefile = `example.org:0 {
+		bind 127.0.0.1
+		forward . ` + udp + `
+		cache {
+			name shared
+		}
+	}
+	example.org:0 {
+		bind 127.0.0.2
+		forward . ` + udp + `
+		cache {
+			name shared
+		}
+	}`
+
+	i, err := CoreDNSServer(corefile)
+	if err != nil {
+		auth.Stop()
+		t.Fatalf("Could not get CoreDNS serving instance: %s", err)
+	}
+	defer i.Stop()
+	udp1, _ := CoreDNSServerPorts(i, 0)
+	udp2, _ := CoreDNSServerPorts(i, 1)
+	if udp1 == "" || udp2 == "" {
+		auth.Stop()
+		t.Fatalf("Expected two servers, got %q and %q", udp1, udp2)
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	if _, err := dns.Exchange(m, udp1); err != nil {
+		t.Fatalf("Expected to receive reply, but didn't: %s", err)
+	}
+
+	// With the backend gone, the other server block answers from the shared cache.
+	auth.Stop()
+	resp, err := dns.Exchange(m, udp2)
+	if err != nil {
+		t.Fatalf("Expected to receive reply, but didn't: %s", err)
+	}
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 2 {
+		t.Errorf("Expected cached answer with 2 records, got %s", resp)
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/miekg/dns"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func TestGrpc(t *testing.T) {
@@ -55,3 +56,137 @@ func TestGrpc(t *testing.T) {
 		t.Errorf("Expected 2 RRs in additional section, but got %d", len(d.Extra))
 	}
 }
+
+func TestGrpcStream(t *testing.T) {
+	corefile := `grpc://.:0 {
+		whoami
+	}`
+
+	g, _, tcp, err := CoreDNSServerAndPorts(corefile)
+	if err != nil {
+		t.Fatalf("Could not get CoreDNS serving instance: %s", err)
+	}
+	defer g.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, tcp, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err)
+	}
+	defer conn.Close()
+
+	stream, err := pb.NewDnsServiceClient(conn).Stream(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err)
+	}
+
+	const n = 10
+	for i := 0; i < n; i++ {
+		m := new(dns.Msg)
+		m.SetQuestion("whoami.example.org.", dns.TypeA)
+		m.Id = uint16(i)
+		msg, _ := m.Pack()
+		if err := stream.Send(&pb.DnsPacket{Msg: msg}); err != nil {
+			t.Fatalf("Expected no error but got: %s", err)
+		}
+	}
+	stream.CloseSend()
+
+	seen := map[uint16]bool{}
+	for i := 0; i < n; i++ {
+		reply, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Expected no error but got: %s", err)
+		}
+		d := new(dns.Msg)
+		if err := d.Unpack(reply.Msg); err != nil {
+			t.Fatalf("Expected no error but got: %s", err)
+		}
+		if d.Rcode != dns.RcodeSuccess {
+			t.Errorf("Expected success but got %d", d.Rcode)
+		}
+		seen[d.Id] = true
+	}
+	if len(seen) != n {
+		t.Errorf("Expected %d different replies, got %d", n, len(seen))
+	}
+}
+
+func TestGrpcStreamServfail(t *testing.T) {
+	corefile := `grpc://.:0 {
+		whoami
+	}`
+
+	g, _, tcp, err := CoreDNSServerAndPorts(corefile)
+	if err != nil {
+		t.Fatalf("Could not get CoreDNS serving instance: %s", err)
+	}
+	defer g.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, tcp, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err)
+	}
+	defer conn.Close()
+
+	stream, err := pb.NewDnsServiceClient(conn).Stream(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err)
+	}
+
+	// A header that announces a question that isn't there can't be unpacked.
+	m := new(dns.Msg)
+	m.SetQuestion("whoami.example.org.", dns.TypeA)
+	m.Id = 4242
+	msg, _ := m.Pack()
+	if err := stream.Send(&pb.DnsPacket{Msg: msg[:12]}); err != nil {
+		t.Fatalf("Expected no error but got: %s", err)
+	}
+	stream.CloseSend()
+
+	reply, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Expected a reply but got: %s", err)
+	}
+	d := new(dns.Msg)
+	if err := d.Unpack(reply.Msg); err != nil {
+		t.Fatalf("Expected no error but got: %s", err)
+	}
+	if d.Rcode != dns.RcodeServerFailure || d.Id != m.Id {
+		t.Errorf("Expected SERVFAIL with ID %d, got %s with ID %d", m.Id, dns.RcodeToString[d.Rcode], d.Id)
+	}
+}
+
+func TestGrpcHealth(t *testing.T) {
+	corefile := `grpc://.:0 {
+		whoami
+	}`
+
+	g, _, tcp, err := CoreDNSServerAndPorts(corefile)
+	if err != nil {
+		t.Fatalf("Could not get CoreDNS serving instance: %s", err)
+	}
+	defer g.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, tcp, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err)
+	}
+	defer conn.Close()
+
+	client := healthpb.NewHealthClient(conn)
+	for _, service := range []string{"", "coredns.dns.DnsService"} {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("Expected no error for service %q but got: %s", service, err)
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("Expected SERVING for service %q, got %s", service, resp.Status)
+		}
+	}
+}